@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/andreitelteu/exim-pilot/internal/audit"
+	"github.com/andreitelteu/exim-pilot/internal/config"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// handleAudit runs an "audit" subcommand against the configured database
+func handleAudit(configPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing audit command (verify, checkpoint, verify-checkpoint)")
+	}
+
+	cfg, err := config.LoadFromFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := database.MigrateUp(db); err != nil {
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	auditService := audit.NewService(database.NewRepository(db))
+	auditService.SetCheckpointKey(cfg.Security.AuditSigningKey)
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "verify":
+		return verifyAuditLog(auditService)
+	case "checkpoint":
+		return createAuditCheckpoint(auditService, args)
+	case "verify-checkpoint":
+		return verifyAuditCheckpoint(auditService, args)
+	default:
+		return fmt.Errorf("unknown audit command: %s", command)
+	}
+}
+
+func verifyAuditLog(auditService *audit.Service) error {
+	ctx := context.Background()
+
+	report, err := auditService.ValidateAuditIntegrity(ctx)
+	if err != nil {
+		return err
+	}
+
+	result := "success"
+	if !report.Valid {
+		result = "failure"
+	}
+	auditService.LogAction(ctx, audit.ActionAuditVerify, nil, cliAuditContext(), &audit.AuditDetails{
+		Parameters: map[string]interface{}{"checked_entries": report.CheckedEntries},
+		Result:     result,
+	})
+
+	fmt.Printf("Checked entries:  %d\n", report.CheckedEntries)
+	if report.UnsealedEntries > 0 {
+		fmt.Printf("Unsealed entries: %d (written before hash chaining)\n", report.UnsealedEntries)
+	}
+	if report.CheckedEntries > 0 {
+		fmt.Printf("Chain:            entries %d to %d\n", report.FirstEntryID, report.LastEntryID)
+		fmt.Printf("Head hash:        %s\n", report.LastHash)
+	}
+
+	if !report.Valid {
+		link := report.BrokenLink
+		fmt.Printf("BROKEN at entry %d: %s\n", link.EntryID, link.Reason)
+		if link.Expected != "" {
+			fmt.Printf("  expected %s\n  found    %s\n", link.Expected, link.Actual)
+		}
+		return fmt.Errorf("audit log integrity check failed")
+	}
+
+	fmt.Println("Audit log integrity verified")
+	return nil
+}
+
+func createAuditCheckpoint(auditService *audit.Service, args []string) error {
+	fs := flag.NewFlagSet("audit checkpoint", flag.ExitOnError)
+	output := fs.String("o", "", "Write the checkpoint to FILE instead of stdout")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	checkpoint, err := auditService.CreateCheckpoint(ctx)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	auditService.LogAction(ctx, audit.ActionAuditCheckpoint, nil, cliAuditContext(), &audit.AuditDetails{
+		Parameters: map[string]interface{}{"entry_id": checkpoint.EntryID, "entry_hash": checkpoint.EntryHash},
+		Result:     "success",
+	})
+
+	if *output == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	if err := os.WriteFile(*output, data, 0600); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	fmt.Printf("Checkpoint for entry %d written to %s\n", checkpoint.EntryID, *output)
+	return nil
+}
+
+func verifyAuditCheckpoint(auditService *audit.Service, args []string) error {
+	fs := flag.NewFlagSet("audit verify-checkpoint", flag.ExitOnError)
+	file := fs.String("f", "", "Checkpoint file (required)")
+	fs.Parse(args)
+
+	if *file == "" {
+		return fmt.Errorf("-f is required")
+	}
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var checkpoint audit.Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return fmt.Errorf("invalid checkpoint file: %w", err)
+	}
+
+	if err := auditService.VerifyCheckpoint(context.Background(), &checkpoint); err != nil {
+		return err
+	}
+
+	fmt.Printf("Checkpoint for entry %d (%s) is valid\n", checkpoint.EntryID, checkpoint.CreatedAt.Format("2006-01-02 15:04:05"))
+	return nil
+}
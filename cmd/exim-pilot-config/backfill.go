@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/andreitelteu/exim-pilot/internal/config"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+	"github.com/andreitelteu/exim-pilot/internal/logprocessor"
+)
+
+// handleBackfill imports the rotated, optionally compressed, Exim log series
+func handleBackfill(configPath string, args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	dir := flags.String("dir", "", "Directory holding the rotated logs (default: exim.log_rotation_dir)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadFromFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := database.MigrateUp(db); err != nil {
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	serviceConfig := logprocessor.DefaultServiceConfig()
+	serviceConfig.LogPaths = cfg.Exim.LogPaths
+	serviceConfig.LogRotationDir = cfg.Exim.LogRotationDir
+	logService := logprocessor.NewService(database.NewRepository(db), serviceConfig)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	job, err := logService.RunBackfill(ctx, *dir, func(file *logprocessor.BackfillFile) {
+		switch file.Status {
+		case logprocessor.BackfillRunning:
+			fmt.Printf("%s ...\n", file.Path)
+		case logprocessor.BackfillFailed:
+			fmt.Printf("  failed: %s\n", file.Error)
+		default:
+			fmt.Printf("  %s: %d lines, %d inserted, %d already stored\n",
+				file.Status, file.LinesRead, file.Inserted, file.Duplicates)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(job.Files) == 0 {
+		fmt.Printf("No rotated logs found in %s\n", job.Directory)
+		return nil
+	}
+
+	var inserted, duplicates int64
+	for _, file := range job.Files {
+		inserted += file.Inserted
+		duplicates += file.Duplicates
+	}
+	fmt.Printf("Backfill %s: %d files, %d entries inserted, %d already stored\n",
+		job.Status, len(job.Files), inserted, duplicates)
+
+	if job.Status != logprocessor.BackfillCompleted {
+		return fmt.Errorf("backfill %s", job.Status)
+	}
+	return nil
+}
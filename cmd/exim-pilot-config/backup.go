@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/andreitelteu/exim-pilot/internal/config"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// handleBackup runs a "backup" subcommand against the configured backup directory
+func handleBackup(configPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing backup command (create, list, verify)")
+	}
+
+	cfg, err := config.LoadFromFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "create":
+		return createBackup(cfg)
+	case "list":
+		return listBackups(cfg)
+	case "verify":
+		return verifyBackup(cfg, args)
+	default:
+		return fmt.Errorf("unknown backup command: %s", command)
+	}
+}
+
+func createBackup(cfg *config.Config) error {
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	service := database.NewBackupService(db, cfg.Database.BackupPath, cfg.Database.BackupKeep)
+	backup, err := service.Backup(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Backup written and verified: %s (%d bytes)\n", backup.Path, backup.Size)
+	return nil
+}
+
+func listBackups(cfg *config.Config) error {
+	backups, err := database.ListBackups(cfg.Database.BackupPath)
+	if err != nil {
+		return err
+	}
+
+	if len(backups) == 0 {
+		fmt.Printf("No backups in %s\n", cfg.Database.BackupPath)
+		return nil
+	}
+
+	fmt.Printf("%-36s %-20s %12s\n", "NAME", "CREATED (UTC)", "SIZE")
+	for _, backup := range backups {
+		fmt.Printf("%-36s %-20s %12d\n", backup.Name, backup.CreatedAt.Format("2006-01-02 15:04:05"), backup.Size)
+	}
+	return nil
+}
+
+func verifyBackup(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("backup verify", flag.ContinueOnError)
+	name := flags.String("name", "", "Backup file name in backup_path (default: newest)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	backup, err := findBackup(cfg, *name, "")
+	if err != nil {
+		return err
+	}
+	if err := database.VerifyBackup(context.Background(), backup); err != nil {
+		return err
+	}
+
+	fmt.Printf("Backup is intact: %s\n", backup)
+	return nil
+}
+
+// handleRestore replaces the configured database with a backup. Exim Pilot must be
+// stopped while it runs.
+func handleRestore(configPath string, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	name := flags.String("name", "", "Backup file name in backup_path (default: newest)")
+	file := flags.String("file", "", "Path of a backup outside backup_path")
+	force := flags.Bool("force", false, "Do not ask for confirmation")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadFromFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	backup, err := findBackup(cfg, *name, *file)
+	if err != nil {
+		return err
+	}
+
+	if !*force {
+		fmt.Printf("Replace %s with %s?\n", cfg.Database.Path, backup)
+		fmt.Println("Exim Pilot must be stopped before restoring.")
+		fmt.Print("Continue? [y/N]: ")
+		var response string
+		fmt.Scanln(&response)
+		if response != "y" && response != "Y" {
+			fmt.Println("Restore cancelled")
+			return nil
+		}
+	}
+
+	preRestore, err := database.RestoreBackup(context.Background(), backup, cfg.Database.Path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Database restored from %s\n", backup)
+	if preRestore != "" {
+		fmt.Printf("The previous database was kept as %s\n", preRestore)
+	}
+	fmt.Println("Pending migrations run when Exim Pilot starts.")
+	return nil
+}
+
+// findBackup resolves the backup to use: an explicit file, a name in the backup
+// directory, or the newest backup there
+func findBackup(cfg *config.Config, name, file string) (string, error) {
+	if file != "" {
+		if name != "" {
+			return "", fmt.Errorf("use either -name or -file")
+		}
+		return file, nil
+	}
+
+	backups, err := database.ListBackups(cfg.Database.BackupPath)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		if len(backups) == 0 {
+			return "", fmt.Errorf("no backups in %s", cfg.Database.BackupPath)
+		}
+		return backups[0].Path, nil
+	}
+
+	for _, backup := range backups {
+		if backup.Name == filepath.Base(name) {
+			return backup.Path, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", database.ErrBackupNotFound, name)
+}
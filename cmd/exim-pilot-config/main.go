@@ -49,6 +49,35 @@ func main() {
 		return
 	}
 
+	// Handle subcommands
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "users":
+			if err := handleUsers(*configPath, flag.Args()[1:]); err != nil {
+				log.Fatalf("User command failed: %v", err)
+			}
+		case "audit":
+			if err := handleAudit(*configPath, flag.Args()[1:]); err != nil {
+				log.Fatalf("Audit command failed: %v", err)
+			}
+		case "backfill":
+			if err := handleBackfill(*configPath, flag.Args()[1:]); err != nil {
+				log.Fatalf("Backfill failed: %v", err)
+			}
+		case "backup":
+			if err := handleBackup(*configPath, flag.Args()[1:]); err != nil {
+				log.Fatalf("Backup command failed: %v", err)
+			}
+		case "restore":
+			if err := handleRestore(*configPath, flag.Args()[1:]); err != nil {
+				log.Fatalf("Restore failed: %v", err)
+			}
+		default:
+			log.Fatalf("Unknown command: %s", flag.Arg(0))
+		}
+		return
+	}
+
 	// Handle migrate command
 	if *migrate != "" {
 		if err := handleMigration(*configPath, *migrate, *version); err != nil {
@@ -66,6 +95,11 @@ func showHelp() {
 	fmt.Println()
 	fmt.Println("Usage:")
 	fmt.Println("  exim-pilot-config [options]")
+	fmt.Println("  exim-pilot-config [options] users <command> [arguments]")
+	fmt.Println("  exim-pilot-config [options] audit <command> [arguments]")
+	fmt.Println("  exim-pilot-config [options] backfill [-dir DIR]")
+	fmt.Println("  exim-pilot-config [options] backup <command> [arguments]")
+	fmt.Println("  exim-pilot-config [options] restore [-name NAME | -file PATH] [-force]")
 	fmt.Println()
 	fmt.Println("Options:")
 	fmt.Println("  -config string")
@@ -81,6 +115,35 @@ func showHelp() {
 	fmt.Println("  -help")
 	fmt.Println("        Show this help message")
 	fmt.Println()
+	fmt.Println("User commands:")
+	fmt.Println("  users list [-all]")
+	fmt.Println("  users create -username NAME -role ROLE [-email EMAIL] [-full-name NAME] [-password PASS]")
+	fmt.Println("  users set-role -username NAME -role ROLE")
+	fmt.Println("  users reset-password -username NAME [-password PASS]")
+	fmt.Println("  users disable -username NAME")
+	fmt.Println("  users enable -username NAME")
+	fmt.Println("  users revoke-sessions -username NAME")
+	fmt.Println("  users reset-mfa -username NAME")
+	fmt.Println("  users link -username NAME -source ldap|header")
+	fmt.Println("  Roles: admin, operator, viewer, auditor")
+	fmt.Println()
+	fmt.Println("Audit commands:")
+	fmt.Println("  audit verify")
+	fmt.Println("  audit checkpoint [-o FILE]")
+	fmt.Println("  audit verify-checkpoint -f FILE")
+	fmt.Println()
+	fmt.Println("Backfill:")
+	fmt.Println("  backfill [-dir DIR]")
+	fmt.Println("        Import rotated logs (plain, .gz, .bz2, .xz) oldest to newest. Safe to re-run.")
+	fmt.Println()
+	fmt.Println("Backup commands:")
+	fmt.Println("  backup create")
+	fmt.Println("        Back up the database to database.backup_path while Exim Pilot runs")
+	fmt.Println("  backup list")
+	fmt.Println("  backup verify [-name NAME]")
+	fmt.Println("  restore [-name NAME | -file PATH] [-force]")
+	fmt.Println("        Replace the database with a backup (default: newest). Stop Exim Pilot first.")
+	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  # Generate default configuration")
 	fmt.Println("  exim-pilot-config -generate -config /opt/exim-pilot/config/config.yaml")
@@ -96,6 +159,9 @@ func showHelp() {
 	fmt.Println()
 	fmt.Println("  # Migrate to specific version")
 	fmt.Println("  exim-pilot-config -migrate up -version 2 -config /opt/exim-pilot/config/config.yaml")
+	fmt.Println()
+	fmt.Println("  # Create an operator account")
+	fmt.Println("  exim-pilot-config -config /opt/exim-pilot/config/config.yaml users create -username alice -role operator")
 }
 
 func generateConfig(configPath string) error {
@@ -221,6 +287,14 @@ func validateConfig(configPath string) error {
 		} else {
 			fmt.Printf("! TLS key file not found: %s\n", cfg.Server.TLSKeyFile)
 		}
+
+		if cfg.Server.TLSClientCAFile != "" {
+			if _, err := os.Stat(cfg.Server.TLSClientCAFile); err == nil {
+				fmt.Printf("✓ TLS client CA file found: %s\n", cfg.Server.TLSClientCAFile)
+			} else {
+				fmt.Printf("! TLS client CA file not found: %s\n", cfg.Server.TLSClientCAFile)
+			}
+		}
 	}
 
 	fmt.Println()
@@ -236,24 +310,9 @@ func handleMigration(configPath, command string, targetVersion int) error {
 		return fmt.Errorf("failed to load configuration: %w", err)
 	}
 
-	// Create database config
-	dbConfig := &database.Config{
-		Path:            cfg.Database.Path,
-		MaxOpenConns:    cfg.Database.MaxOpenConns,
-		MaxIdleConns:    cfg.Database.MaxIdleConns,
-		ConnMaxLifetime: cfg.GetDatabaseConnMaxLifetime(),
-	}
-
-	// Ensure database directory exists
-	dbDir := filepath.Dir(cfg.Database.Path)
-	if err := os.MkdirAll(dbDir, 0755); err != nil {
-		return fmt.Errorf("failed to create database directory: %w", err)
-	}
-
-	// Connect to database
-	db, err := database.Connect(dbConfig)
+	db, err := openDatabase(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
+		return err
 	}
 	defer db.Close()
 
@@ -284,6 +343,31 @@ func handleMigration(configPath, command string, targetVersion int) error {
 	}
 }
 
+// openDatabase connects to the database named in the configuration
+func openDatabase(cfg *config.Config) (*database.DB, error) {
+	// Create database config
+	dbConfig := &database.Config{
+		Path:            cfg.Database.Path,
+		MaxOpenConns:    cfg.Database.MaxOpenConns,
+		MaxIdleConns:    cfg.Database.MaxIdleConns,
+		ConnMaxLifetime: cfg.GetDatabaseConnMaxLifetime(),
+	}
+
+	// Ensure database directory exists
+	dbDir := filepath.Dir(cfg.Database.Path)
+	if err := os.MkdirAll(dbDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	// Connect to database
+	db, err := database.Connect(dbConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	return db, nil
+}
+
 func showMigrationStatus(db *database.DB) error {
 	// Get migration status
 	records, err := database.GetMigrationStatus(db)
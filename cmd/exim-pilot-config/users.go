@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/andreitelteu/exim-pilot/internal/audit"
+	"github.com/andreitelteu/exim-pilot/internal/auth"
+	"github.com/andreitelteu/exim-pilot/internal/config"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// handleUsers runs a "users" subcommand against the configured database
+func handleUsers(configPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing users command (list, create, set-role, reset-password, disable, enable, revoke-sessions, reset-mfa, link)")
+	}
+
+	cfg, err := config.LoadFromFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := database.MigrateUp(db); err != nil {
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	authService := auth.NewService(db)
+	authService.SetPasswordPolicy(cfg.Auth.PasswordMinLen, cfg.Auth.RequireStrongPw)
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "list":
+		return listUsers(authService, args)
+	case "create":
+		return createUser(authService, args)
+	case "set-role":
+		return setUserRole(authService, args)
+	case "reset-password":
+		return resetUserPassword(authService, args)
+	case "disable":
+		return setUserActive(authService, args, false)
+	case "enable":
+		return setUserActive(authService, args, true)
+	case "revoke-sessions":
+		return revokeUserSessions(authService, args)
+	case "reset-mfa":
+		return resetUserMFA(authService, args)
+	case "link":
+		return linkExternalUser(authService, args)
+	default:
+		return fmt.Errorf("unknown users command: %s", command)
+	}
+}
+
+// cliAuditContext attributes CLI changes to the local operating system user
+func cliAuditContext() *audit.AuditContext {
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	return &audit.AuditContext{
+		UserID:    "cli:" + name,
+		IPAddress: "local",
+		UserAgent: "exim-pilot-config",
+	}
+}
+
+func listUsers(authService *auth.Service, args []string) error {
+	fs := flag.NewFlagSet("users list", flag.ExitOnError)
+	all := fs.Bool("all", false, "Include disabled users")
+	fs.Parse(args)
+
+	users, err := authService.ListUsers(*all)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSERNAME\tROLE\tACTIVE\tEMAIL\tLAST LOGIN")
+	for _, u := range users {
+		lastLogin := "never"
+		if u.LastLoginAt != nil {
+			lastLogin = u.LastLoginAt.Format("2006-01-02 15:04:05")
+		}
+		email := ""
+		if u.Email != nil {
+			email = *u.Email
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%t\t%s\t%s\n", u.ID, u.Username, u.Role, u.IsActive, email, lastLogin)
+	}
+	return w.Flush()
+}
+
+func createUser(authService *auth.Service, args []string) error {
+	fs := flag.NewFlagSet("users create", flag.ExitOnError)
+	username := fs.String("username", "", "Username (required)")
+	role := fs.String("role", auth.RoleViewer, "Role: "+strings.Join(auth.Roles(), ", "))
+	email := fs.String("email", "", "Email address")
+	fullName := fs.String("full-name", "", "Full name")
+	password := fs.String("password", "", "Password (prompted when omitted)")
+	fs.Parse(args)
+
+	if *username == "" {
+		return fmt.Errorf("-username is required")
+	}
+
+	pass, err := passwordFromFlagOrPrompt(*password)
+	if err != nil {
+		return err
+	}
+
+	created, err := authService.CreateUser(*username, pass, *email, *fullName, *role, cliAuditContext())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created user %s (id %d) with role %s\n", created.Username, created.ID, created.Role)
+	return nil
+}
+
+func setUserRole(authService *auth.Service, args []string) error {
+	fs := flag.NewFlagSet("users set-role", flag.ExitOnError)
+	username := fs.String("username", "", "Username (required)")
+	role := fs.String("role", "", "Role: "+strings.Join(auth.Roles(), ", "))
+	fs.Parse(args)
+
+	target, err := lookupUser(authService, *username)
+	if err != nil {
+		return err
+	}
+
+	updated, err := authService.SetUserRole(context.Background(), target.ID, *role, cliAuditContext())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("User %s now has role %s\n", updated.Username, updated.Role)
+	return nil
+}
+
+func resetUserPassword(authService *auth.Service, args []string) error {
+	fs := flag.NewFlagSet("users reset-password", flag.ExitOnError)
+	username := fs.String("username", "", "Username (required)")
+	password := fs.String("password", "", "New password (prompted when omitted)")
+	fs.Parse(args)
+
+	target, err := lookupUser(authService, *username)
+	if err != nil {
+		return err
+	}
+
+	pass, err := passwordFromFlagOrPrompt(*password)
+	if err != nil {
+		return err
+	}
+
+	if err := authService.ResetPassword(target.ID, pass, cliAuditContext()); err != nil {
+		return err
+	}
+
+	fmt.Printf("Password for %s reset; existing sessions revoked\n", target.Username)
+	return nil
+}
+
+func setUserActive(authService *auth.Service, args []string, active bool) error {
+	name := "users disable"
+	if active {
+		name = "users enable"
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	username := fs.String("username", "", "Username (required)")
+	fs.Parse(args)
+
+	target, err := lookupUser(authService, *username)
+	if err != nil {
+		return err
+	}
+
+	if _, err := authService.SetUserActive(target.ID, active, cliAuditContext()); err != nil {
+		return err
+	}
+
+	if active {
+		fmt.Printf("User %s enabled\n", target.Username)
+	} else {
+		fmt.Printf("User %s disabled; existing sessions revoked\n", target.Username)
+	}
+	return nil
+}
+
+func revokeUserSessions(authService *auth.Service, args []string) error {
+	fs := flag.NewFlagSet("users revoke-sessions", flag.ExitOnError)
+	username := fs.String("username", "", "Username (required)")
+	fs.Parse(args)
+
+	target, err := lookupUser(authService, *username)
+	if err != nil {
+		return err
+	}
+
+	if err := authService.RevokeSessions(target.ID, cliAuditContext()); err != nil {
+		return err
+	}
+
+	fmt.Printf("Sessions for %s revoked\n", target.Username)
+	return nil
+}
+
+func resetUserMFA(authService *auth.Service, args []string) error {
+	fs := flag.NewFlagSet("users reset-mfa", flag.ExitOnError)
+	username := fs.String("username", "", "Username (required)")
+	fs.Parse(args)
+
+	target, err := lookupUser(authService, *username)
+	if err != nil {
+		return err
+	}
+
+	if err := authService.ResetTOTP(target.ID, cliAuditContext()); err != nil {
+		return err
+	}
+
+	fmt.Printf("Two-factor authentication for %s removed; existing sessions revoked\n", target.Username)
+	return nil
+}
+
+func linkExternalUser(authService *auth.Service, args []string) error {
+	fs := flag.NewFlagSet("users link", flag.ExitOnError)
+	username := fs.String("username", "", "Username (required)")
+	source := fs.String("source", "", "External source: "+auth.SourceLDAP+" or "+auth.SourceTrustedHeader)
+	fs.Parse(args)
+
+	target, err := lookupUser(authService, *username)
+	if err != nil {
+		return err
+	}
+
+	if _, err := authService.LinkExternalUser(target.ID, *source, cliAuditContext()); err != nil {
+		return err
+	}
+
+	fmt.Printf("User %s now logs in through %s; local password disabled and sessions revoked\n", target.Username, *source)
+	return nil
+}
+
+func lookupUser(authService *auth.Service, username string) (*database.User, error) {
+	if username == "" {
+		return nil, fmt.Errorf("-username is required")
+	}
+
+	target, err := authService.GetUserByUsername(username)
+	if err != nil {
+		return nil, fmt.Errorf("user %s not found", username)
+	}
+	return target, nil
+}
+
+// passwordFromFlagOrPrompt returns the flag value, or reads a password line from stdin
+func passwordFromFlagOrPrompt(password string) (string, error) {
+	if password != "" {
+		return password, nil
+	}
+
+	fmt.Print("Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
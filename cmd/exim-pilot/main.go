@@ -12,11 +12,14 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/andreitelteu/exim-pilot/internal/alerting"
 	"github.com/andreitelteu/exim-pilot/internal/api"
 	"github.com/andreitelteu/exim-pilot/internal/auth"
 	"github.com/andreitelteu/exim-pilot/internal/config"
 	"github.com/andreitelteu/exim-pilot/internal/database"
+	"github.com/andreitelteu/exim-pilot/internal/logmonitor"
 	"github.com/andreitelteu/exim-pilot/internal/logprocessor"
+	"github.com/andreitelteu/exim-pilot/internal/metrics"
 	"github.com/andreitelteu/exim-pilot/internal/queue"
 	"github.com/andreitelteu/exim-pilot/web"
 )
@@ -100,9 +103,12 @@ func main() {
 
 	// Initialize queue service
 	queueService := queue.NewService(cfg.Exim.BinaryPath, db)
+	queueService.SetSpoolDir(cfg.Exim.SpoolDir)
 
 	// Initialize log processing service
 	logConfig := logprocessor.DefaultServiceConfig()
+	logConfig.LogPaths = cfg.Exim.LogPaths
+	logConfig.LogRotationDir = cfg.Exim.LogRotationDir
 	logService := logprocessor.NewService(repository, logConfig)
 
 	// Start log service
@@ -116,6 +122,49 @@ func main() {
 		log.Printf("Warning: Failed to initialize default user: %v", err)
 	}
 
+	authenticators, trustedHeaderAuth, err := buildAuthenticators(db, cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure authentication: %v", err)
+	}
+
+	// The log monitor is started once the server can broadcast what it reads
+	logMonitor, err := logmonitor.NewLogMonitor(logmonitor.Config{
+		LogPaths:   cfg.Exim.LogPaths,
+		Repository: repository,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create log monitor: %v", err)
+	}
+	logMonitor.SetLogProcessor(logService)
+
+	var exporter *metrics.Exporter
+	if cfg.Metrics.Enabled {
+		exporter, err = metrics.NewExporter(metrics.Config{
+			Queue:           queueService,
+			Logs:            logMonitor,
+			DatabasePath:    cfg.Database.Path,
+			MaxDomains:      cfg.Metrics.MaxDomains,
+			Token:           cfg.Metrics.Token,
+			AllowedNetworks: cfg.Metrics.AllowedNetworks,
+		})
+		if err != nil {
+			log.Fatalf("Failed to configure metrics: %v", err)
+		}
+	}
+
+	var backupService *database.BackupService
+	if cfg.Database.BackupPath != "" {
+		backupService = database.NewBackupService(db, cfg.Database.BackupPath, cfg.Database.BackupKeep)
+	}
+
+	var alertEngine *alerting.Engine
+	if cfg.Alerting.Enabled {
+		alertEngine, err = buildAlerting(db, cfg, queueService)
+		if err != nil {
+			log.Fatalf("Failed to configure alerting: %v", err)
+		}
+	}
+
 	// Create API config from main config
 	apiConfig := &api.Config{
 		Port:           cfg.Server.Port,
@@ -125,6 +174,42 @@ func main() {
 		IdleTimeout:    cfg.Server.IdleTimeout,
 		AllowedOrigins: cfg.Server.AllowedOrigins,
 		LogRequests:    cfg.Server.LogRequests,
+
+		TLSEnabled:             cfg.Server.TLSEnabled,
+		TLSCertFile:            cfg.Server.TLSCertFile,
+		TLSKeyFile:             cfg.Server.TLSKeyFile,
+		TLSRedirectPort:        cfg.Server.TLSRedirectPort,
+		TLSClientCAFile:        cfg.Server.TLSClientCAFile,
+		RequireTokenClientCert: cfg.Server.TLSRequireTokenClientCert,
+
+		ContentRedaction: cfg.Security.ContentRedaction,
+		AuditSigningKey:  cfg.Security.AuditSigningKey,
+		EximConfigFile:   cfg.Exim.ConfigFile,
+		TrustedProxies:   cfg.Security.TrustedProxies,
+
+		MaxLoginAttempts: cfg.Security.MaxLoginAttempts,
+		LoginLockoutTime: cfg.GetLoginLockoutTime(),
+
+		CSRFProtection: cfg.Security.CSRFProtection,
+		SecureCookies:  cfg.Security.SecureCookies,
+		CookieSameSite: cfg.Security.CookieSameSite,
+
+		PasswordMinLength:     cfg.Auth.PasswordMinLen,
+		RequireStrongPassword: cfg.Auth.RequireStrongPw,
+		TOTPIssuer:            cfg.Auth.TOTPIssuer,
+		RequireTOTPForMutate:  cfg.Auth.RequireTOTPForMutate,
+		Authenticators:        authenticators,
+		TrustedHeaderAuth:     trustedHeaderAuth,
+
+		AlertEngine: alertEngine,
+		Backups:     backupService,
+
+		Metrics:     exporter,
+		MetricsPath: cfg.Metrics.Path,
+	}
+
+	if cfg.Security.SecureCookies && !cfg.Server.TLSEnabled {
+		log.Println("Warning: secure_cookies is enabled without TLS, browsers only keep the session cookie when the server is reached over HTTPS (for example through a TLS-terminating proxy)")
 	}
 
 	// Initialize API server
@@ -135,7 +220,43 @@ func main() {
 		if wsService := server.GetWebSocketService(); wsService != nil {
 			wsService.BroadcastLogEntry(entry)
 		}
+		if exporter != nil {
+			exporter.ObserveLogEntry(entry)
+		}
 	})
+	if exporter != nil {
+		exporter.SetClientCounter(server.GetWebSocketService().GetHub())
+	}
+
+	// Follow the Exim logs and feed new lines through the log service. Read offsets are
+	// stored with the entries, so a restart resumes where the previous run stopped.
+	if err := logMonitor.Start(); err != nil {
+		log.Printf("Warning: Live log ingestion disabled: %v", err)
+	} else {
+		defer logMonitor.Stop()
+	}
+
+	// Record the queue size for /queue/history
+	snapshotCtx, stopSnapshots := context.WithCancel(context.Background())
+	defer stopSnapshots()
+	if cfg.Exim.QueueSnapshotInterval > 0 {
+		go queueService.StartPeriodicSnapshots(snapshotCtx, time.Duration(cfg.Exim.QueueSnapshotInterval)*time.Second)
+	}
+
+	// Back up the database on schedule; stopped with the snapshot context
+	if backupService != nil && cfg.Database.BackupEnabled {
+		go backupService.ScheduleBackups(snapshotCtx, cfg.GetBackupInterval())
+	}
+
+	// Evaluate alert rules; notifications are also pushed to WebSocket clients
+	if alertEngine != nil {
+		alertEngine.SetBroadcaster(server.GetWebSocketService().BroadcastSystemAlert)
+		if err := alertEngine.Start(); err != nil {
+			log.Printf("Warning: Alerting disabled: %v", err)
+		} else {
+			defer alertEngine.Stop()
+		}
+	}
 
 	// Wait for interrupt signal to gracefully shutdown
 	quit := make(chan os.Signal, 1)
@@ -170,6 +291,21 @@ func main() {
 		}
 	}()
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	// SIGHUP reloads the TLS certificate, for renewal hooks that signal the process
+	if cfg.Server.TLSEnabled {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := server.ReloadTLS(); err != nil {
+					log.Printf("Failed to reload TLS certificate: %v", err)
+				} else {
+					log.Println("Reloaded TLS certificate")
+				}
+			}
+		}()
+	}
 	<-quit
 
 	log.Println("Shutting down server...")
@@ -248,7 +384,7 @@ func initializeDefaultUser(db *database.DB, cfg *config.Config) error {
 		log.Println("Warning: Using fallback default password 'admin123' for admin user. Please change it after first login.")
 	}
 
-	_, err = authService.CreateUser(cfg.Auth.DefaultUsername, password, "admin@localhost", "Administrator")
+	_, err = authService.CreateUserWithoutPasswordPolicy(cfg.Auth.DefaultUsername, password, "admin@localhost", "Administrator", auth.RoleAdmin)
 	if err != nil {
 		return fmt.Errorf("failed to create default admin user: %w", err)
 	}
@@ -260,3 +396,135 @@ func initializeDefaultUser(db *database.DB, cfg *config.Config) error {
 
 	return nil
 }
+
+// buildAuthenticators creates the login backends named in auth.backends and the optional
+// trusted header authenticator
+func buildAuthenticators(db *database.DB, cfg *config.Config) ([]auth.Authenticator, *auth.TrustedHeaderAuthenticator, error) {
+	var authenticators []auth.Authenticator
+	for _, backend := range cfg.Auth.Backends {
+		switch backend {
+		case "local":
+			authenticators = append(authenticators, auth.NewLocalAuthenticator(db))
+		case "ldap":
+			ldapCfg := cfg.Auth.LDAP
+			ldapAuth, err := auth.NewLDAPAuthenticator(auth.LDAPConfig{
+				URL:                ldapCfg.URL,
+				StartTLS:           ldapCfg.StartTLS,
+				InsecureSkipVerify: ldapCfg.InsecureSkipVerify,
+				Timeout:            time.Duration(ldapCfg.Timeout) * time.Second,
+				BindDN:             ldapCfg.BindDN,
+				BindPassword:       ldapCfg.BindPassword,
+				UserDNTemplate:     ldapCfg.UserDNTemplate,
+				UserBaseDN:         ldapCfg.UserBaseDN,
+				UserFilter:         ldapCfg.UserFilter,
+				EmailAttribute:     ldapCfg.EmailAttribute,
+				NameAttribute:      ldapCfg.NameAttribute,
+				GroupBaseDN:        ldapCfg.GroupBaseDN,
+				GroupFilter:        ldapCfg.GroupFilter,
+				GroupAttribute:     ldapCfg.GroupAttribute,
+				MemberOfAttribute:  ldapCfg.MemberOfAttribute,
+				GroupRoles:         ldapCfg.GroupRoles,
+				DefaultRole:        ldapCfg.DefaultRole,
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			authenticators = append(authenticators, ldapAuth)
+		default:
+			return nil, nil, fmt.Errorf("unknown auth backend: %s", backend)
+		}
+	}
+
+	headerCfg := cfg.Auth.TrustedHeader
+	if !headerCfg.Enabled {
+		return authenticators, nil, nil
+	}
+
+	trustedHeaderAuth, err := auth.NewTrustedHeaderAuthenticator(auth.TrustedHeaderConfig{
+		UserHeader:     headerCfg.UserHeader,
+		GroupsHeader:   headerCfg.GroupsHeader,
+		EmailHeader:    headerCfg.EmailHeader,
+		NameHeader:     headerCfg.NameHeader,
+		TrustedProxies: cfg.Security.TrustedProxies,
+		GroupRoles:     headerCfg.GroupRoles,
+		DefaultRole:    headerCfg.DefaultRole,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return authenticators, trustedHeaderAuth, nil
+}
+
+// buildAlerting creates the alerting engine with the notifiers and rules from the
+// configuration file
+func buildAlerting(db *database.DB, cfg *config.Config, queueService *queue.Service) (*alerting.Engine, error) {
+	var notifiers []alerting.Notifier
+	for _, n := range cfg.Alerting.Notifiers {
+		var notifier alerting.Notifier
+		var err error
+
+		switch n.Type {
+		case "email":
+			notifier, err = alerting.NewEmailNotifier(alerting.EmailConfig{
+				Name: n.Name,
+				Host: n.SMTPHost,
+				Port: n.SMTPPort,
+				From: n.From,
+				To:   n.To,
+			})
+		case "webhook":
+			notifier, err = alerting.NewWebhookNotifier(alerting.WebhookConfig{
+				Name:    n.Name,
+				URL:     n.URL,
+				Headers: n.Headers,
+			})
+		case "slack":
+			notifier, err = alerting.NewSlackNotifier(alerting.SlackConfig{
+				Name:     n.Name,
+				URL:      n.URL,
+				Channel:  n.Channel,
+				Username: n.Username,
+			})
+		case "syslog":
+			notifier, err = alerting.NewSyslogNotifier(alerting.SyslogConfig{
+				Name:    n.Name,
+				Network: n.Network,
+				Address: n.Address,
+				Tag:     n.Tag,
+			})
+		default:
+			return nil, fmt.Errorf("unknown notifier type %q for notifier %q", n.Type, n.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, notifier)
+	}
+
+	var rules []database.AlertRule
+	for _, r := range cfg.Alerting.Rules {
+		rules = append(rules, database.AlertRule{
+			Name:           r.Name,
+			Description:    r.Description,
+			Metric:         r.Metric,
+			Event:          r.Event,
+			Window:         r.Window,
+			Operator:       r.Operator,
+			Threshold:      r.Threshold,
+			For:            r.For,
+			Cooldown:       r.Cooldown,
+			RepeatInterval: r.RepeatInterval,
+			Severity:       r.Severity,
+			Notifiers:      r.Notifiers,
+			SendResolved:   r.SendResolved == nil || *r.SendResolved,
+			Enabled:        r.Enabled == nil || *r.Enabled,
+		})
+	}
+
+	return alerting.NewEngine(db, queueService, alerting.Config{
+		Interval:  time.Duration(cfg.Alerting.EvaluationInterval) * time.Second,
+		Rules:     rules,
+		Notifiers: notifiers,
+	})
+}
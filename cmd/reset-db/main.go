@@ -67,7 +67,7 @@ func main() {
 		fmt.Println("Using default password 'admin123' for admin user")
 	}
 
-	user, err := authService.CreateUser("admin", defaultPassword, "admin@localhost", "Administrator")
+	user, err := authService.CreateUserWithoutPasswordPolicy("admin", defaultPassword, "admin@localhost", "Administrator", auth.RoleAdmin)
 	if err != nil {
 		log.Fatalf("Failed to create default admin user: %v", err)
 	}
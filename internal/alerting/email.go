@@ -0,0 +1,118 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EmailConfig configures delivery of alerts through a local SMTP relay, normally the
+// Exim instance being monitored
+type EmailConfig struct {
+	Name string
+	Host string // default localhost
+	Port int    // default 25
+	From string
+	To   []string
+}
+
+// EmailNotifier sends alerts as plain-text email. The relay is trusted as local, so no
+// authentication or STARTTLS is attempted.
+type EmailNotifier struct {
+	config EmailConfig
+}
+
+// NewEmailNotifier creates an email notifier
+func NewEmailNotifier(config EmailConfig) (*EmailNotifier, error) {
+	if config.Host == "" {
+		config.Host = "localhost"
+	}
+	if config.Port == 0 {
+		config.Port = 25
+	}
+	if config.From == "" {
+		return nil, fmt.Errorf("email notifier %s: from address is required", config.Name)
+	}
+	if len(config.To) == 0 {
+		return nil, fmt.Errorf("email notifier %s: at least one recipient is required", config.Name)
+	}
+	for _, address := range append([]string{config.From}, config.To...) {
+		if strings.ContainsAny(address, "\r\n<>") {
+			return nil, fmt.Errorf("email notifier %s: invalid address %q", config.Name, address)
+		}
+	}
+
+	return &EmailNotifier{config: config}, nil
+}
+
+// Name returns the notifier name
+func (n *EmailNotifier) Name() string {
+	return n.config.Name
+}
+
+// Notify sends the notification to every configured recipient
+func (n *EmailNotifier) Notify(ctx context.Context, notification *Notification) error {
+	addr := net.JoinHostPort(n.config.Host, strconv.Itoa(n.config.Port))
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, n.config.Host)
+	if err != nil {
+		return fmt.Errorf("SMTP greeting from %s failed: %w", addr, err)
+	}
+	defer client.Close()
+
+	if err := client.Mail(n.config.From); err != nil {
+		return fmt.Errorf("MAIL FROM rejected: %w", err)
+	}
+	for _, to := range n.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("RCPT TO %s rejected: %w", to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("DATA rejected: %w", err)
+	}
+	if _, err := w.Write(n.message(notification)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("message rejected: %w", err)
+	}
+
+	return client.Quit()
+}
+
+// message builds the RFC 5322 message for a notification
+func (n *EmailNotifier) message(notification *Notification) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(n.config.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "Exim Pilot "+notification.Title()))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("Auto-Submitted: auto-generated\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(notification.Text(), "\n", "\r\n"))
+
+	return b.Bytes()
+}
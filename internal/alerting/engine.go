@@ -0,0 +1,580 @@
+package alerting
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/audit"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// Alert severities
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Audit actions for alert rule changes made through the API
+const (
+	ActionAlertRuleCreate audit.ActionType = "alert_rule_create"
+	ActionAlertRuleUpdate audit.ActionType = "alert_rule_update"
+	ActionAlertRuleDelete audit.ActionType = "alert_rule_delete"
+)
+
+// notifyTimeout bounds each notifier so a dead destination cannot stall evaluation
+const notifyTimeout = 15 * time.Second
+
+var (
+	ErrRuleNotFound     = errors.New("alert rule not found")
+	ErrRuleExists       = errors.New("an alert rule with this name already exists")
+	ErrRuleReadOnly     = errors.New("alert rule is defined in the configuration file")
+	ErrInvalidRule      = errors.New("invalid alert rule")
+	ErrNotifierNotFound = errors.New("notifier not found")
+)
+
+var ruleNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// Config configures the alerting engine
+type Config struct {
+	Interval  time.Duration        // time between evaluations, default one minute
+	Rules     []database.AlertRule // rules from the configuration file
+	Notifiers []Notifier
+}
+
+// Engine evaluates alert rules on an interval, records alerts in the database and sends
+// notifications when they fire, repeat and resolve
+type Engine struct {
+	queue        QueueHealthSource
+	rulesRepo    *database.AlertRuleRepository
+	historyRepo  *database.AlertHistoryRepository
+	logsRepo     *database.LogEntryRepository
+	attemptsRepo *database.LoginAttemptRepository
+	auditRepo    *database.AuditLogRepository
+	audit        *audit.Service
+
+	interval    time.Duration
+	configRules []database.AlertRule
+	notifiers   []Notifier
+	broadcast   func(alert interface{})
+	now         func() time.Time
+
+	mu     sync.Mutex // serializes evaluations
+	states map[string]*ruleState
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// ruleState tracks a rule between evaluations
+type ruleState struct {
+	pendingSince time.Time            // when the condition started to hold, zero if it does not
+	alert        *database.AlertEvent // the open alert while firing
+	resolvedAt   time.Time            // when the last alert resolved, for the cooldown
+}
+
+// NewEngine creates an alerting engine. Configured rules are validated against the
+// configured notifiers.
+func NewEngine(db *database.DB, queueSource QueueHealthSource, config Config) (*Engine, error) {
+	e := &Engine{
+		queue:        queueSource,
+		rulesRepo:    database.NewAlertRuleRepository(db),
+		historyRepo:  database.NewAlertHistoryRepository(db),
+		logsRepo:     database.NewLogEntryRepository(db),
+		attemptsRepo: database.NewLoginAttemptRepository(db),
+		auditRepo:    database.NewAuditLogRepository(db),
+		audit:        audit.NewService(database.NewRepository(db)),
+		interval:     config.Interval,
+		notifiers:    config.Notifiers,
+		now:          time.Now,
+		states:       make(map[string]*ruleState),
+	}
+	if e.interval <= 0 {
+		e.interval = time.Minute
+	}
+
+	seen := make(map[string]bool)
+	for _, notifier := range config.Notifiers {
+		if notifier.Name() == "" || seen[notifier.Name()] {
+			return nil, fmt.Errorf("notifier names must be unique and not empty: %q", notifier.Name())
+		}
+		seen[notifier.Name()] = true
+	}
+
+	names := make(map[string]bool)
+	for _, rule := range config.Rules {
+		rule.Source = database.AlertRuleSourceConfig
+		if err := e.validateRule(&rule); err != nil {
+			return nil, err
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("%w: duplicate rule name %s", ErrInvalidRule, rule.Name)
+		}
+		names[rule.Name] = true
+		e.configRules = append(e.configRules, rule)
+	}
+
+	return e, nil
+}
+
+// SetBroadcaster sets a function that receives every notification, such as
+// websocket.Service.BroadcastSystemAlert
+func (e *Engine) SetBroadcaster(broadcast func(alert interface{})) {
+	e.broadcast = broadcast
+}
+
+// Start restores alerts left firing by a previous run and evaluates the rules on the
+// configured interval until Stop is called
+func (e *Engine) Start() error {
+	if e.cancel != nil {
+		return nil
+	}
+
+	firing, err := e.historyRepo.ListFiring()
+	if err != nil {
+		return fmt.Errorf("failed to load firing alerts: %w", err)
+	}
+	e.mu.Lock()
+	for i := range firing {
+		e.states[firing[i].RuleName] = &ruleState{pendingSince: firing[i].FiredAt, alert: &firing[i]}
+	}
+	e.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.done = make(chan struct{})
+
+	go func() {
+		defer close(e.done)
+
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				e.Evaluate(ctx)
+			}
+		}
+	}()
+
+	log.Printf("Alerting started with %d configured rules and %d notifiers", len(e.configRules), len(e.notifiers))
+	return nil
+}
+
+// Stop stops evaluating rules. Firing alerts stay open and are picked up on the next Start.
+func (e *Engine) Stop() {
+	if e.cancel == nil {
+		return
+	}
+	e.cancel()
+	<-e.done
+	e.cancel = nil
+}
+
+// Rules returns the configured rules followed by those created through the API
+func (e *Engine) Rules() ([]database.AlertRule, error) {
+	stored, err := e.rulesRepo.List()
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]database.AlertRule, 0, len(e.configRules)+len(stored))
+	rules = append(rules, e.configRules...)
+	return append(rules, stored...), nil
+}
+
+// Rule returns the rule with the given name
+func (e *Engine) Rule(name string) (*database.AlertRule, error) {
+	if rule := e.configRule(name); rule != nil {
+		configured := *rule
+		return &configured, nil
+	}
+
+	rule, err := e.rulesRepo.GetByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if rule == nil {
+		return nil, ErrRuleNotFound
+	}
+	return rule, nil
+}
+
+// CreateRule validates and stores a new rule
+func (e *Engine) CreateRule(rule *database.AlertRule, auditCtx *audit.AuditContext) error {
+	if err := e.validateRule(rule); err != nil {
+		return err
+	}
+	if e.configRule(rule.Name) != nil {
+		return ErrRuleExists
+	}
+	existing, err := e.rulesRepo.GetByName(rule.Name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrRuleExists
+	}
+
+	rule.CreatedBy = &auditCtx.UserID
+	if err := e.rulesRepo.Create(rule); err != nil {
+		return err
+	}
+
+	e.logRuleChange(ActionAlertRuleCreate, rule.Name, auditCtx, nil, rule)
+	return nil
+}
+
+// UpdateRule validates and replaces a rule created through the API
+func (e *Engine) UpdateRule(rule *database.AlertRule, auditCtx *audit.AuditContext) error {
+	if e.configRule(rule.Name) != nil {
+		return ErrRuleReadOnly
+	}
+	if err := e.validateRule(rule); err != nil {
+		return err
+	}
+
+	previous, err := e.rulesRepo.GetByName(rule.Name)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		return ErrRuleNotFound
+	}
+
+	if err := e.rulesRepo.Update(rule); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRuleNotFound
+		}
+		return err
+	}
+	rule.ID = previous.ID
+	rule.CreatedBy = previous.CreatedBy
+	rule.CreatedAt = previous.CreatedAt
+
+	e.logRuleChange(ActionAlertRuleUpdate, rule.Name, auditCtx, previous, rule)
+	return nil
+}
+
+// DeleteRule removes a rule created through the API. An alert it has firing is
+// resolved on the next evaluation.
+func (e *Engine) DeleteRule(name string, auditCtx *audit.AuditContext) error {
+	if e.configRule(name) != nil {
+		return ErrRuleReadOnly
+	}
+
+	previous, err := e.rulesRepo.GetByName(name)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		return ErrRuleNotFound
+	}
+
+	if err := e.rulesRepo.Delete(name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRuleNotFound
+		}
+		return err
+	}
+
+	e.logRuleChange(ActionAlertRuleDelete, name, auditCtx, previous, nil)
+	return nil
+}
+
+// History returns stored alerts, newest first
+func (e *Engine) History(limit, offset int, ruleName, status string, since *time.Time) ([]database.AlertEvent, error) {
+	return e.historyRepo.List(limit, offset, ruleName, status, since)
+}
+
+// Notifiers returns the names of the configured notifiers
+func (e *Engine) Notifiers() []string {
+	names := make([]string, 0, len(e.notifiers))
+	for _, notifier := range e.notifiers {
+		names = append(names, notifier.Name())
+	}
+	return names
+}
+
+// TestNotifier sends a test notification through one notifier
+func (e *Engine) TestNotifier(ctx context.Context, name string) error {
+	for _, notifier := range e.notifiers {
+		if notifier.Name() != name {
+			continue
+		}
+
+		now := e.now()
+		notification := &Notification{
+			Alert: database.AlertEvent{
+				RuleName: "test",
+				Severity: SeverityInfo,
+				Status:   database.AlertStatusFiring,
+				Message:  "This is a test notification from Exim Pilot",
+				FiredAt:  now,
+			},
+			Test: true,
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+		defer cancel()
+		return notifier.Notify(ctx, notification)
+	}
+
+	return ErrNotifierNotFound
+}
+
+// Evaluate checks every enabled rule once
+func (e *Engine) Evaluate(ctx context.Context) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rules, err := e.Rules()
+	if err != nil {
+		log.Printf("Failed to load alert rules: %v", err)
+		return
+	}
+
+	now := e.now()
+	current := &sample{now: now, queue: e.queue, logs: e.logsRepo, attempts: e.attemptsRepo, audit: e.auditRepo}
+	active := make(map[string]bool)
+
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.Enabled {
+			continue
+		}
+		active[rule.Name] = true
+
+		value, err := current.value(rule)
+		if err != nil {
+			log.Printf("Failed to evaluate alert rule %s: %v", rule.Name, err)
+			continue
+		}
+
+		e.evaluateRule(ctx, rule, value, now)
+	}
+
+	// Alerts of rules that were deleted or disabled are closed without notification
+	for name, state := range e.states {
+		if active[name] {
+			continue
+		}
+		if state.alert != nil {
+			state.alert.Status = database.AlertStatusResolved
+			state.alert.ResolvedAt = &now
+			state.alert.Message += " (rule removed or disabled)"
+			if err := e.historyRepo.Update(state.alert); err != nil {
+				log.Printf("Failed to close alert %d: %v", state.alert.ID, err)
+			}
+		}
+		delete(e.states, name)
+	}
+}
+
+// evaluateRule moves one rule through pending, firing and resolved
+func (e *Engine) evaluateRule(ctx context.Context, rule *database.AlertRule, value float64, now time.Time) {
+	state := e.states[rule.Name]
+	if state == nil {
+		state = &ruleState{}
+		e.states[rule.Name] = state
+	}
+
+	breached := compare(rule.Operator, value, rule.Threshold)
+
+	switch {
+	case breached && state.alert != nil:
+		state.alert.Value = value
+		state.alert.Message = describe(rule, value)
+		if rule.RepeatInterval > 0 && (state.alert.LastNotifiedAt == nil ||
+			now.Sub(*state.alert.LastNotifiedAt) >= time.Duration(rule.RepeatInterval)*time.Second) {
+			e.notify(ctx, rule, state.alert, true, now)
+		}
+		if err := e.historyRepo.Update(state.alert); err != nil {
+			log.Printf("Failed to update alert %d: %v", state.alert.ID, err)
+		}
+
+	case breached:
+		if state.pendingSince.IsZero() {
+			state.pendingSince = now
+		}
+		if now.Sub(state.pendingSince) < time.Duration(rule.For)*time.Second {
+			return
+		}
+		if !state.resolvedAt.IsZero() && now.Sub(state.resolvedAt) < time.Duration(rule.Cooldown)*time.Second {
+			return
+		}
+
+		alert := &database.AlertEvent{
+			RuleName:  rule.Name,
+			Metric:    rule.Metric,
+			Severity:  rule.Severity,
+			Status:    database.AlertStatusFiring,
+			Value:     value,
+			Threshold: rule.Threshold,
+			Operator:  rule.Operator,
+			Message:   describe(rule, value),
+			FiredAt:   now,
+		}
+		e.notify(ctx, rule, alert, false, now)
+		if err := e.historyRepo.Create(alert); err != nil {
+			log.Printf("Failed to record alert for rule %s: %v", rule.Name, err)
+		}
+		state.alert = alert
+		log.Printf("Alert %s firing: %s", rule.Name, alert.Message)
+
+	case state.alert != nil:
+		alert := state.alert
+		alert.Status = database.AlertStatusResolved
+		alert.ResolvedAt = &now
+		alert.Value = value
+		alert.Message = describe(rule, value)
+		if rule.SendResolved {
+			e.notify(ctx, rule, alert, false, now)
+		}
+		if err := e.historyRepo.Update(alert); err != nil {
+			log.Printf("Failed to resolve alert %d: %v", alert.ID, err)
+		}
+		log.Printf("Alert %s resolved: %s", rule.Name, alert.Message)
+
+		state.alert = nil
+		state.pendingSince = time.Time{}
+		state.resolvedAt = now
+
+	default:
+		state.pendingSince = time.Time{}
+	}
+}
+
+// notify sends a notification to the rule's notifiers and records the outcome on the alert
+func (e *Engine) notify(ctx context.Context, rule *database.AlertRule, alert *database.AlertEvent, reminder bool, now time.Time) {
+	notification := &Notification{Alert: *alert, Rule: *rule, Reminder: reminder}
+
+	if e.broadcast != nil {
+		e.broadcast(notification)
+	}
+
+	var failures []string
+	for _, notifier := range e.notifiers {
+		if len(rule.Notifiers) > 0 && !contains(rule.Notifiers, notifier.Name()) {
+			continue
+		}
+
+		notifyCtx, cancel := context.WithTimeout(ctx, notifyTimeout)
+		err := notifier.Notify(notifyCtx, notification)
+		cancel()
+		if err != nil {
+			log.Printf("Notifier %s failed for alert %s: %v", notifier.Name(), rule.Name, err)
+			failures = append(failures, notifier.Name()+": "+err.Error())
+		}
+	}
+
+	alert.LastNotifiedAt = &now
+	alert.Notifications++
+	alert.LastError = nil
+	if len(failures) > 0 {
+		lastError := strings.Join(failures, "; ")
+		alert.LastError = &lastError
+	}
+}
+
+// validateRule checks a rule and fills in defaults
+func (e *Engine) validateRule(rule *database.AlertRule) error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w %q: %s", ErrInvalidRule, rule.Name, fmt.Sprintf(format, args...))
+	}
+
+	if !ruleNameRegex.MatchString(rule.Name) {
+		return invalid("name must be 1-64 letters, digits, '.', '_' or '-'")
+	}
+	if _, ok := metricLabels[rule.Metric]; !ok {
+		return invalid("unknown metric %q", rule.Metric)
+	}
+	if rule.Event != "" && rule.Metric != MetricLogEventRate {
+		return invalid("event only applies to %s", MetricLogEventRate)
+	}
+
+	switch rule.Operator {
+	case ">", ">=", "<", "<=":
+	default:
+		return invalid("operator must be one of >, >=, < or <=")
+	}
+
+	if rule.Window < 0 || rule.For < 0 || rule.Cooldown < 0 || rule.RepeatInterval < 0 {
+		return invalid("window, for, cooldown and repeat_interval cannot be negative")
+	}
+	if IsWindowMetric(rule.Metric) && rule.Window == 0 {
+		rule.Window = DefaultWindow
+	}
+
+	switch rule.Severity {
+	case "":
+		rule.Severity = SeverityWarning
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return invalid("severity must be info, warning or critical")
+	}
+
+	for _, name := range rule.Notifiers {
+		if !contains(e.Notifiers(), name) {
+			return invalid("unknown notifier %q", name)
+		}
+	}
+
+	return nil
+}
+
+func (e *Engine) configRule(name string) *database.AlertRule {
+	for i := range e.configRules {
+		if e.configRules[i].Name == name {
+			return &e.configRules[i]
+		}
+	}
+	return nil
+}
+
+func (e *Engine) logRuleChange(action audit.ActionType, name string, auditCtx *audit.AuditContext, previous, next interface{}) {
+	details := &audit.AuditDetails{
+		Operation:     string(action),
+		Parameters:    map[string]interface{}{"rule": name},
+		Result:        "success",
+		PreviousValue: previous,
+		NewValue:      next,
+	}
+
+	if err := e.audit.LogAction(context.Background(), action, nil, auditCtx, details); err != nil {
+		log.Printf("Warning: failed to audit %s for alert rule %s: %v", action, name, err)
+	}
+}
+
+// compare applies a rule operator
+func compare(operator string, value, threshold float64) bool {
+	switch operator {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,296 @@
+package alerting
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/audit"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+	"github.com/andreitelteu/exim-pilot/internal/queue"
+)
+
+type fakeQueue struct {
+	deferred int
+}
+
+func (q *fakeQueue) GetQueueHealth() (*queue.QueueHealth, error) {
+	return &queue.QueueHealth{TotalMessages: q.deferred, DeferredMessages: q.deferred}, nil
+}
+
+type recordingNotifier struct {
+	name string
+
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func (n *recordingNotifier) Name() string { return n.name }
+
+func (n *recordingNotifier) Notify(ctx context.Context, notification *Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, *notification)
+	return nil
+}
+
+// titles returns the titles of the notifications sent so far and forgets them
+func (n *recordingNotifier) titles() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var titles []string
+	for _, notification := range n.sent {
+		titles = append(titles, notification.Title())
+	}
+	n.sent = nil
+	return titles
+}
+
+func newTestDB(t *testing.T) *database.DB {
+	t.Helper()
+
+	db, err := database.Connect(&database.Config{
+		Path:            filepath.Join(t.TempDir(), "alerting.db"),
+		MaxOpenConns:    1,
+		MaxIdleConns:    1,
+		ConnMaxLifetime: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := database.MigrateUp(db); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	return db
+}
+
+func newTestEngine(t *testing.T, db *database.DB, source QueueHealthSource, notifier Notifier, rules ...database.AlertRule) (*Engine, *time.Time) {
+	t.Helper()
+
+	engine, err := NewEngine(db, source, Config{Rules: rules, Notifiers: []Notifier{notifier}})
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.Local)
+	engine.now = func() time.Time { return now }
+	return engine, &now
+}
+
+func expectTitles(t *testing.T, notifier *recordingNotifier, want ...string) {
+	t.Helper()
+
+	got := notifier.titles()
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("Expected notifications %q, got %q", want, got)
+	}
+}
+
+func TestEngineFiresAfterDurationAndResolves(t *testing.T) {
+	db := newTestDB(t)
+	source := &fakeQueue{deferred: 50}
+	notifier := &recordingNotifier{name: "ops"}
+	rule := database.AlertRule{
+		Name: "backlog", Metric: MetricQueueDeferred, Operator: ">", Threshold: 10,
+		For: 120, Cooldown: 600, Severity: SeverityCritical, SendResolved: true, Enabled: true,
+	}
+	engine, now := newTestEngine(t, db, source, notifier, rule)
+	ctx := context.Background()
+	step := func(d time.Duration) {
+		*now = now.Add(d)
+		engine.Evaluate(ctx)
+	}
+
+	// The condition must hold for two minutes
+	step(0)
+	step(time.Minute)
+	expectTitles(t, notifier)
+	step(time.Minute)
+	expectTitles(t, notifier, "[FIRING] critical: backlog")
+
+	source.deferred = 5
+	step(time.Minute)
+	expectTitles(t, notifier, "[RESOLVED] critical: backlog")
+
+	// Within the cooldown the rule does not fire again, however long the condition holds
+	source.deferred = 50
+	step(time.Minute)
+	step(3 * time.Minute)
+	expectTitles(t, notifier)
+	step(7 * time.Minute)
+	expectTitles(t, notifier, "[FIRING] critical: backlog")
+
+	history, err := engine.History(10, 0, "backlog", "", nil)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(history) != 2 || history[0].Status != database.AlertStatusFiring || history[1].Status != database.AlertStatusResolved {
+		t.Fatalf("Expected a firing and a resolved alert, got %+v", history)
+	}
+	if history[1].ResolvedAt == nil || history[1].Notifications != 2 || history[1].Value != 5 {
+		t.Errorf("Unexpected resolved alert %+v", history[1])
+	}
+
+	// A restarted engine picks up the open alert and resolves it
+	restarted, later := newTestEngine(t, db, source, notifier, rule)
+	*later = *now
+	if err := restarted.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer restarted.Stop()
+	source.deferred = 0
+	*later = later.Add(time.Minute)
+	restarted.Evaluate(ctx)
+	expectTitles(t, notifier, "[RESOLVED] critical: backlog")
+}
+
+func TestEngineRepeatsAndClosesRemovedRules(t *testing.T) {
+	db := newTestDB(t)
+	source := &fakeQueue{deferred: 50}
+	notifier := &recordingNotifier{name: "ops"}
+	engine, now := newTestEngine(t, db, source, notifier)
+	ctx := context.Background()
+	auditCtx := &audit.AuditContext{UserID: "1"}
+
+	rule := &database.AlertRule{
+		Name: "api-backlog", Metric: MetricQueueTotal, Operator: ">=", Threshold: 50,
+		RepeatInterval: 600, Enabled: true,
+	}
+	if err := engine.CreateRule(rule, auditCtx); err != nil {
+		t.Fatalf("CreateRule failed: %v", err)
+	}
+	if rule.Severity != SeverityWarning {
+		t.Errorf("Expected default severity warning, got %q", rule.Severity)
+	}
+	if err := engine.CreateRule(rule, auditCtx); err != ErrRuleExists {
+		t.Errorf("Expected ErrRuleExists, got %v", err)
+	}
+
+	engine.Evaluate(ctx)
+	expectTitles(t, notifier, "[FIRING] warning: api-backlog")
+	*now = now.Add(5 * time.Minute)
+	engine.Evaluate(ctx)
+	expectTitles(t, notifier)
+	*now = now.Add(5 * time.Minute)
+	engine.Evaluate(ctx)
+	expectTitles(t, notifier, "[STILL FIRING] warning: api-backlog")
+
+	// Deleting the rule closes its alert without notifying
+	if err := engine.DeleteRule("api-backlog", auditCtx); err != nil {
+		t.Fatalf("DeleteRule failed: %v", err)
+	}
+	engine.Evaluate(ctx)
+	expectTitles(t, notifier)
+
+	history, err := engine.History(10, 0, "", database.AlertStatusFiring, nil)
+	if err != nil || len(history) != 0 {
+		t.Fatalf("Expected no firing alerts, got %+v (%v)", history, err)
+	}
+}
+
+func TestEngineLogMetrics(t *testing.T) {
+	db := newTestDB(t)
+	notifier := &recordingNotifier{name: "ops"}
+	engine, now := newTestEngine(t, db, nil, notifier,
+		database.AlertRule{Name: "bounces", Metric: MetricBounceRatio, Operator: ">", Threshold: 0.2, Window: 600, Enabled: true},
+		database.AlertRule{Name: "panic", Metric: MetricPanicEntries, Operator: ">", Threshold: 0, Severity: SeverityCritical, Enabled: true},
+		database.AlertRule{Name: "rejects", Metric: MetricLogEventRate, Event: database.EventReject, Operator: ">", Threshold: 1, Window: 60, Enabled: true},
+	)
+
+	logs := database.NewLogEntryRepository(db)
+	add := func(ago time.Duration, logType, event string) {
+		entry := &database.LogEntry{Timestamp: logTime(now.Add(-ago)), LogType: logType, Event: event, RawLine: event}
+		if err := logs.Create(entry); err != nil {
+			t.Fatalf("Failed to create log entry: %v", err)
+		}
+	}
+
+	// One bounce in four delivery outcomes, a reject rate of one per minute, and an old
+	// panic entry outside the default five minute window
+	add(time.Minute, database.LogTypeMain, database.EventBounce)
+	for i := 0; i < 3; i++ {
+		add(2*time.Minute, database.LogTypeMain, database.EventDelivery)
+	}
+	add(30*time.Second, database.LogTypeReject, database.EventReject)
+	add(time.Hour, database.LogTypePanic, database.EventPanic)
+
+	engine.Evaluate(context.Background())
+	expectTitles(t, notifier, "[FIRING] warning: bounces")
+
+	add(time.Minute, database.LogTypePanic, database.EventPanic)
+	add(10*time.Second, database.LogTypeReject, database.EventReject)
+	engine.Evaluate(context.Background())
+	expectTitles(t, notifier, "[FIRING] critical: panic", "[FIRING] warning: rejects")
+
+	history, err := engine.History(10, 0, "bounces", "", nil)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("Expected one bounce alert, got %+v (%v)", history, err)
+	}
+	if want := "Bounce ratio over 10m0s is 25.0% (threshold > 20.0%)"; history[0].Message != want {
+		t.Errorf("Message = %q, want %q", history[0].Message, want)
+	}
+}
+
+func TestEngineLoginFailures(t *testing.T) {
+	db := newTestDB(t)
+	notifier := &recordingNotifier{name: "ops"}
+	engine, now := newTestEngine(t, db, nil, notifier,
+		database.AlertRule{Name: "brute-force", Metric: MetricLoginFailures, Operator: ">=", Threshold: 3, Window: 600, Enabled: true},
+	)
+
+	attempts := database.NewLoginAttemptRepository(db)
+	add := func(ago time.Duration, success bool) {
+		attempt := &database.LoginAttempt{Username: "admin", IPAddress: "192.0.2.1", Success: success, Timestamp: now.Add(-ago)}
+		if err := attempts.Create(attempt); err != nil {
+			t.Fatalf("Failed to create login attempt: %v", err)
+		}
+	}
+
+	// Successes and failures outside the window do not count
+	add(time.Minute, false)
+	add(time.Minute, false)
+	add(time.Minute, true)
+	add(time.Hour, false)
+	engine.Evaluate(context.Background())
+	expectTitles(t, notifier)
+
+	add(30*time.Second, false)
+	engine.Evaluate(context.Background())
+	expectTitles(t, notifier, "[FIRING] warning: brute-force")
+}
+
+func TestEngineRejectsInvalidRules(t *testing.T) {
+	db := newTestDB(t)
+	notifier := &recordingNotifier{name: "ops"}
+	engine, _ := newTestEngine(t, db, nil, notifier)
+	auditCtx := &audit.AuditContext{UserID: "1"}
+
+	invalid := []database.AlertRule{
+		{Name: "", Metric: MetricQueueTotal, Operator: ">"},
+		{Name: "bad metric", Metric: MetricQueueTotal, Operator: ">"},
+		{Name: "metric", Metric: "load", Operator: ">"},
+		{Name: "operator", Metric: MetricQueueTotal, Operator: "=="},
+		{Name: "event", Metric: MetricQueueTotal, Operator: ">", Event: "defer"},
+		{Name: "notifier", Metric: MetricQueueTotal, Operator: ">", Notifiers: []string{"pager"}},
+		{Name: "severity", Metric: MetricQueueTotal, Operator: ">", Severity: "fatal"},
+	}
+	for _, rule := range invalid {
+		if err := engine.CreateRule(&rule, auditCtx); err == nil {
+			t.Errorf("Expected rule %q to be rejected", rule.Name)
+		}
+	}
+
+	if _, err := NewEngine(db, nil, Config{
+		Rules:     []database.AlertRule{{Name: "cfg", Metric: MetricQueueTotal, Operator: ">", Notifiers: []string{"pager"}}},
+		Notifiers: []Notifier{notifier},
+	}); err == nil {
+		t.Error("Expected a configured rule with an unknown notifier to be rejected")
+	}
+}
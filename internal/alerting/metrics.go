@@ -0,0 +1,171 @@
+package alerting
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/audit"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+	"github.com/andreitelteu/exim-pilot/internal/queue"
+)
+
+// Metrics alert rules can watch
+const (
+	MetricQueueTotal     = "queue_total"      // messages in the queue
+	MetricQueueDeferred  = "queue_deferred"   // deferred messages in the queue
+	MetricQueueFrozen    = "queue_frozen"     // frozen messages in the queue
+	MetricQueueOldestAge = "queue_oldest_age" // age of the oldest queued message, in seconds
+	MetricLogEventRate   = "log_event_rate"   // log events per minute over the rule window
+	MetricBounceRatio    = "bounce_ratio"     // bounces / (deliveries + bounces) over the rule window
+	MetricPanicEntries   = "panic_entries"    // panic log entries within the rule window
+	MetricLoginFailures  = "login_failures"   // failed login attempts within the rule window
+	MetricLoginLockouts  = "login_lockouts"   // IP addresses and usernames locked out within the rule window
+)
+
+// DefaultWindow is the look-back of log metrics for rules that do not set one
+const DefaultWindow = 300
+
+var metricLabels = map[string]string{
+	MetricQueueTotal:     "Queued messages",
+	MetricQueueDeferred:  "Deferred messages",
+	MetricQueueFrozen:    "Frozen messages",
+	MetricQueueOldestAge: "Oldest queued message age",
+	MetricLogEventRate:   "Log events per minute",
+	MetricBounceRatio:    "Bounce ratio",
+	MetricPanicEntries:   "Panic log entries",
+	MetricLoginFailures:  "Failed logins",
+	MetricLoginLockouts:  "Login lockouts",
+}
+
+// IsWindowMetric reports whether the metric is computed over the rule window
+func IsWindowMetric(metric string) bool {
+	switch metric {
+	case MetricLogEventRate, MetricBounceRatio, MetricPanicEntries, MetricLoginFailures, MetricLoginLockouts:
+		return true
+	}
+	return false
+}
+
+// QueueHealthSource provides the current queue size. queue.Service implements it.
+type QueueHealthSource interface {
+	GetQueueHealth() (*queue.QueueHealth, error)
+}
+
+// sample computes metric values for one evaluation. Queue health is read at most once
+// however many rules use it, since listing the queue runs exim.
+type sample struct {
+	now       time.Time
+	queue     QueueHealthSource
+	logs      *database.LogEntryRepository
+	attempts  *database.LoginAttemptRepository
+	audit     *database.AuditLogRepository
+	health    *queue.QueueHealth
+	healthErr error
+	read      bool
+}
+
+func (s *sample) queueHealth() (*queue.QueueHealth, error) {
+	if !s.read {
+		s.read = true
+		if s.queue == nil {
+			s.healthErr = fmt.Errorf("queue metrics are not available")
+		} else {
+			s.health, s.healthErr = s.queue.GetQueueHealth()
+		}
+	}
+	return s.health, s.healthErr
+}
+
+// value returns the current value of the rule's metric
+func (s *sample) value(rule *database.AlertRule) (float64, error) {
+	switch rule.Metric {
+	case MetricQueueTotal, MetricQueueDeferred, MetricQueueFrozen, MetricQueueOldestAge:
+		health, err := s.queueHealth()
+		if err != nil {
+			return 0, err
+		}
+		switch rule.Metric {
+		case MetricQueueTotal:
+			return float64(health.TotalMessages), nil
+		case MetricQueueDeferred:
+			return float64(health.DeferredMessages), nil
+		case MetricQueueFrozen:
+			return float64(health.FrozenMessages), nil
+		default:
+			return health.OldestMessageAge.Seconds(), nil
+		}
+	}
+
+	switch rule.Metric {
+	case MetricLoginFailures:
+		count, err := s.attempts.CountFailuresSince(s.now.Add(-time.Duration(rule.Window) * time.Second))
+		return float64(count), err
+	case MetricLoginLockouts:
+		start := s.now.Add(-time.Duration(rule.Window) * time.Second)
+		count, err := s.audit.Count(database.AuditLogFilter{Action: string(audit.ActionLoginLockout), StartTime: &start})
+		return float64(count), err
+	}
+
+	since := logTime(s.now.Add(-time.Duration(rule.Window) * time.Second))
+
+	switch rule.Metric {
+	case MetricLogEventRate:
+		count, err := s.logs.CountSince("", rule.Event, since)
+		if err != nil {
+			return 0, err
+		}
+		return float64(count) / (float64(rule.Window) / 60), nil
+	case MetricBounceRatio:
+		bounces, err := s.logs.CountSince(database.LogTypeMain, database.EventBounce, since)
+		if err != nil {
+			return 0, err
+		}
+		deliveries, err := s.logs.CountSince(database.LogTypeMain, database.EventDelivery, since)
+		if err != nil {
+			return 0, err
+		}
+		if bounces+deliveries == 0 {
+			return 0, nil
+		}
+		return float64(bounces) / float64(bounces+deliveries), nil
+	case MetricPanicEntries:
+		count, err := s.logs.CountSince(database.LogTypePanic, "", since)
+		return float64(count), err
+	}
+
+	return 0, fmt.Errorf("unknown metric %q", rule.Metric)
+}
+
+// logTime converts t to the form log entry timestamps are stored in. Exim writes local
+// time without a zone and the parser stores it as if it were UTC.
+func logTime(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// formatValue formats a metric value for alert messages
+func formatValue(metric string, value float64) string {
+	switch metric {
+	case MetricBounceRatio:
+		return fmt.Sprintf("%.1f%%", value*100)
+	case MetricQueueOldestAge:
+		return (time.Duration(value) * time.Second).String()
+	case MetricLogEventRate:
+		return fmt.Sprintf("%.1f", value)
+	}
+	return fmt.Sprintf("%g", value)
+}
+
+// describe returns the alert message for a rule at the given value
+func describe(rule *database.AlertRule, value float64) string {
+	label := metricLabels[rule.Metric]
+	if rule.Metric == MetricLogEventRate && rule.Event != "" {
+		label = fmt.Sprintf("%s events per minute", rule.Event)
+	}
+	if IsWindowMetric(rule.Metric) {
+		label += fmt.Sprintf(" over %s", time.Duration(rule.Window)*time.Second)
+	}
+
+	return fmt.Sprintf("%s is %s (threshold %s %s)", label, formatValue(rule.Metric, value),
+		rule.Operator, formatValue(rule.Metric, rule.Threshold))
+}
@@ -0,0 +1,65 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// Notifier delivers alert notifications to one destination
+type Notifier interface {
+	// Name identifies the notifier in alert rules
+	Name() string
+	// Notify sends the notification, giving up when ctx is done
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// Notification is an alert firing, still firing after the repeat interval, or resolved
+type Notification struct {
+	Alert    database.AlertEvent `json:"alert"`
+	Rule     database.AlertRule  `json:"rule"`
+	Reminder bool                `json:"reminder"`
+	Test     bool                `json:"test,omitempty"`
+}
+
+// Resolved reports whether the notification announces that the alert has resolved
+func (n *Notification) Resolved() bool {
+	return n.Alert.Status == database.AlertStatusResolved
+}
+
+// Title returns a one-line summary such as "[FIRING] critical: queue-backlog"
+func (n *Notification) Title() string {
+	status := "FIRING"
+	switch {
+	case n.Test:
+		status = "TEST"
+	case n.Resolved():
+		status = "RESOLVED"
+	case n.Reminder:
+		status = "STILL FIRING"
+	}
+	return fmt.Sprintf("[%s] %s: %s", status, n.Alert.Severity, n.Alert.RuleName)
+}
+
+// Text returns the plain-text body used by the email and syslog notifiers
+func (n *Notification) Text() string {
+	var b strings.Builder
+
+	b.WriteString(n.Alert.Message + "\n\n")
+	if n.Rule.Description != "" {
+		b.WriteString(n.Rule.Description + "\n\n")
+	}
+
+	fmt.Fprintf(&b, "Rule:     %s\n", n.Alert.RuleName)
+	fmt.Fprintf(&b, "Severity: %s\n", n.Alert.Severity)
+	fmt.Fprintf(&b, "Fired at: %s\n", n.Alert.FiredAt.Format(time.RFC1123Z))
+	if n.Alert.ResolvedAt != nil {
+		fmt.Fprintf(&b, "Resolved: %s (after %s)\n", n.Alert.ResolvedAt.Format(time.RFC1123Z),
+			n.Alert.ResolvedAt.Sub(n.Alert.FiredAt).Round(time.Second))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,178 @@
+package alerting
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+func testNotification(status string) *Notification {
+	fired := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	notification := &Notification{
+		Alert: database.AlertEvent{
+			RuleName: "backlog",
+			Metric:   MetricQueueDeferred,
+			Severity: SeverityCritical,
+			Status:   status,
+			Message:  "Deferred messages is 150 (threshold > 100)",
+			FiredAt:  fired,
+		},
+		Rule: database.AlertRule{Name: "backlog", Description: "Remote sites are refusing mail"},
+	}
+	if status == database.AlertStatusResolved {
+		resolved := fired.Add(90 * time.Minute)
+		notification.Alert.ResolvedAt = &resolved
+	}
+	return notification
+}
+
+func TestWebhookNotifiers(t *testing.T) {
+	var bodies []map[string]interface{}
+	var authHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		authHeader = r.Header.Get("Authorization")
+		if r.URL.Path == "/fail" {
+			http.Error(w, "no such hook", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	webhook, err := NewWebhookNotifier(WebhookConfig{Name: "hook", URL: server.URL, Headers: map[string]string{"Authorization": "Bearer secret"}})
+	if err != nil {
+		t.Fatalf("NewWebhookNotifier failed: %v", err)
+	}
+	if err := webhook.Notify(context.Background(), testNotification(database.AlertStatusFiring)); err != nil {
+		t.Fatalf("Webhook Notify failed: %v", err)
+	}
+	if bodies[0]["status"] != "firing" || bodies[0]["title"] != "[FIRING] critical: backlog" || authHeader != "Bearer secret" {
+		t.Errorf("Unexpected webhook request %v (Authorization %q)", bodies[0], authHeader)
+	}
+	if alert, _ := bodies[0]["alert"].(map[string]interface{}); alert["rule_name"] != "backlog" {
+		t.Errorf("Expected the alert in the payload, got %v", bodies[0]["alert"])
+	}
+
+	slack, err := NewSlackNotifier(SlackConfig{Name: "chat", URL: server.URL, Channel: "#mail"})
+	if err != nil {
+		t.Fatalf("NewSlackNotifier failed: %v", err)
+	}
+	if err := slack.Notify(context.Background(), testNotification(database.AlertStatusResolved)); err != nil {
+		t.Fatalf("Slack Notify failed: %v", err)
+	}
+	attachments, _ := bodies[1]["attachments"].([]interface{})
+	if bodies[1]["text"] != "*[RESOLVED] critical: backlog*" || bodies[1]["channel"] != "#mail" || len(attachments) != 1 {
+		t.Errorf("Unexpected Slack message %v", bodies[1])
+	}
+
+	failing, _ := NewWebhookNotifier(WebhookConfig{Name: "hook", URL: server.URL + "/fail"})
+	if err := failing.Notify(context.Background(), testNotification(database.AlertStatusFiring)); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected a 404 error, got %v", err)
+	}
+
+	if _, err := NewSlackNotifier(SlackConfig{Name: "chat", URL: "file:///etc/passwd"}); err == nil {
+		t.Error("Expected a non-HTTP URL to be rejected")
+	}
+}
+
+// fakeSMTPServer accepts one message and returns its commands and data
+func fakeSMTPServer(t *testing.T) (string, int, <-chan string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var transcript strings.Builder
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		reply("220 localhost ESMTP")
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			transcript.WriteString(line)
+
+			switch {
+			case inData && line == ".\r\n":
+				inData = false
+				reply("250 OK")
+			case inData:
+			case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(line, "DATA"):
+				inData = true
+				reply("354 go ahead")
+			case strings.HasPrefix(line, "QUIT"):
+				reply("221 bye")
+				received <- transcript.String()
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+		received <- transcript.String()
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, received
+}
+
+func TestEmailNotifier(t *testing.T) {
+	host, port, received := fakeSMTPServer(t)
+
+	notifier, err := NewEmailNotifier(EmailConfig{Name: "mail", Host: host, Port: port, From: "pilot@example.com", To: []string{"ops@example.com", "oncall@example.com"}})
+	if err != nil {
+		t.Fatalf("NewEmailNotifier failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := notifier.Notify(ctx, testNotification(database.AlertStatusResolved)); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	transcript := <-received
+	for _, want := range []string{
+		"MAIL FROM:<pilot@example.com>",
+		"RCPT TO:<ops@example.com>",
+		"RCPT TO:<oncall@example.com>",
+		"Subject: Exim Pilot [RESOLVED] critical: backlog",
+		"Auto-Submitted: auto-generated",
+		"Deferred messages is 150 (threshold > 100)",
+		"Resolved: Mon, 15 Jan 2024 11:30:00 +0000 (after 1h30m0s)",
+	} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("Expected %q in SMTP transcript:\n%s", want, transcript)
+		}
+	}
+
+	if _, err := NewEmailNotifier(EmailConfig{Name: "mail", From: "a@example.com", To: []string{"b@example.com>\r\nRCPT TO:<c@example.com"}}); err == nil {
+		t.Error("Expected an address with a line break to be rejected")
+	}
+	if _, err := NewEmailNotifier(EmailConfig{Name: "mail", Port: port, From: "a@example.com"}); err == nil {
+		t.Error("Expected a notifier without recipients to be rejected")
+	}
+}
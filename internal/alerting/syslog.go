@@ -0,0 +1,69 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"log/syslog"
+	"sync"
+)
+
+// SyslogConfig configures a notifier that writes alerts to syslog. An empty network
+// and address use the local syslog daemon.
+type SyslogConfig struct {
+	Name    string
+	Network string // udp, tcp or empty for local
+	Address string // host:port
+	Tag     string // default exim-pilot
+}
+
+// SyslogNotifier writes each notification as one syslog line in the daemon facility,
+// at a priority matching the alert severity
+type SyslogNotifier struct {
+	config SyslogConfig
+
+	mu     sync.Mutex
+	writer *syslog.Writer
+}
+
+// NewSyslogNotifier creates a syslog notifier. The connection is opened on first use.
+func NewSyslogNotifier(config SyslogConfig) (*SyslogNotifier, error) {
+	if (config.Network == "") != (config.Address == "") {
+		return nil, fmt.Errorf("syslog notifier %s: network and address must be set together", config.Name)
+	}
+	if config.Tag == "" {
+		config.Tag = "exim-pilot"
+	}
+	return &SyslogNotifier{config: config}, nil
+}
+
+// Name returns the notifier name
+func (n *SyslogNotifier) Name() string {
+	return n.config.Name
+}
+
+// Notify writes the notification to syslog
+func (n *SyslogNotifier) Notify(ctx context.Context, notification *Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.writer == nil {
+		writer, err := syslog.Dial(n.config.Network, n.config.Address, syslog.LOG_DAEMON|syslog.LOG_WARNING, n.config.Tag)
+		if err != nil {
+			return fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		n.writer = writer
+	}
+
+	line := notification.Title() + ": " + notification.Alert.Message
+
+	switch {
+	case notification.Resolved() || notification.Test:
+		return n.writer.Notice(line)
+	case notification.Alert.Severity == SeverityCritical:
+		return n.writer.Crit(line)
+	case notification.Alert.Severity == SeverityWarning:
+		return n.writer.Warning(line)
+	default:
+		return n.writer.Info(line)
+	}
+}
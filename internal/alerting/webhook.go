@@ -0,0 +1,167 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// WebhookConfig configures a notifier that POSTs JSON to a URL
+type WebhookConfig struct {
+	Name    string
+	URL     string
+	Headers map[string]string // extra request headers, such as Authorization
+}
+
+// WebhookNotifier POSTs each notification as JSON
+type WebhookNotifier struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+// WebhookPayload is the body sent by the generic webhook notifier
+type WebhookPayload struct {
+	Status string `json:"status"` // firing or resolved
+	Title  string `json:"title"`
+	Notification
+}
+
+// NewWebhookNotifier creates a generic webhook notifier
+func NewWebhookNotifier(config WebhookConfig) (*WebhookNotifier, error) {
+	if err := validateWebhookURL(config.Name, config.URL); err != nil {
+		return nil, err
+	}
+	return &WebhookNotifier{config: config, client: &http.Client{}}, nil
+}
+
+// Name returns the notifier name
+func (n *WebhookNotifier) Name() string {
+	return n.config.Name
+}
+
+// Notify POSTs the notification
+func (n *WebhookNotifier) Notify(ctx context.Context, notification *Notification) error {
+	payload := WebhookPayload{
+		Status:       notification.Alert.Status,
+		Title:        notification.Title(),
+		Notification: *notification,
+	}
+	return postJSON(ctx, n.client, n.config.URL, n.config.Headers, payload)
+}
+
+// SlackConfig configures a notifier for Slack incoming webhooks and compatible services
+// such as Mattermost and Rocket.Chat
+type SlackConfig struct {
+	Name     string
+	URL      string
+	Channel  string // overrides the webhook's default channel where supported
+	Username string
+}
+
+// SlackNotifier posts notifications to a Slack-compatible incoming webhook
+type SlackNotifier struct {
+	config SlackConfig
+	client *http.Client
+}
+
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Channel     string            `json:"channel,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+type slackAttachment struct {
+	Color    string `json:"color"`
+	Text     string `json:"text"`
+	Fallback string `json:"fallback"`
+}
+
+// NewSlackNotifier creates a Slack-compatible webhook notifier
+func NewSlackNotifier(config SlackConfig) (*SlackNotifier, error) {
+	if err := validateWebhookURL(config.Name, config.URL); err != nil {
+		return nil, err
+	}
+	return &SlackNotifier{config: config, client: &http.Client{}}, nil
+}
+
+// Name returns the notifier name
+func (n *SlackNotifier) Name() string {
+	return n.config.Name
+}
+
+// Notify posts the notification with a colour matching its severity
+func (n *SlackNotifier) Notify(ctx context.Context, notification *Notification) error {
+	color := "#2eb886"
+	if !notification.Resolved() {
+		switch notification.Alert.Severity {
+		case SeverityCritical:
+			color = "#d00000"
+		case SeverityWarning:
+			color = "#daa038"
+		default:
+			color = "#439fe0"
+		}
+	}
+
+	text := notification.Alert.Message
+	if notification.Rule.Description != "" {
+		text += "\n" + notification.Rule.Description
+	}
+
+	message := slackMessage{
+		Text:     "*" + notification.Title() + "*",
+		Channel:  n.config.Channel,
+		Username: n.config.Username,
+		Attachments: []slackAttachment{{
+			Color:    color,
+			Text:     text,
+			Fallback: notification.Title() + ": " + notification.Alert.Message,
+		}},
+	}
+	return postJSON(ctx, n.client, n.config.URL, nil, message)
+}
+
+func validateWebhookURL(name, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("notifier %s: url must be an http or https URL", name)
+	}
+	return nil
+}
+
+// postJSON POSTs body as JSON and fails on any non-2xx response
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Exim-Pilot")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, bytes.TrimSpace(snippet))
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/alerting"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// AlertHandlers contains handlers for alert rules and alert history
+type AlertHandlers struct {
+	engine *alerting.Engine
+}
+
+// NewAlertHandlers creates a new alert handlers instance
+func NewAlertHandlers(engine *alerting.Engine) *AlertHandlers {
+	return &AlertHandlers{engine: engine}
+}
+
+// alertRuleRequest is the body of POST /api/v1/alerts/rules and PUT /api/v1/alerts/rules/{name}
+type alertRuleRequest struct {
+	Name           string   `json:"name"`
+	Description    string   `json:"description"`
+	Metric         string   `json:"metric"`
+	Event          string   `json:"event"`
+	Window         int      `json:"window"`
+	Operator       string   `json:"operator"`
+	Threshold      float64  `json:"threshold"`
+	For            int      `json:"for"`
+	Cooldown       int      `json:"cooldown"`
+	RepeatInterval int      `json:"repeat_interval"`
+	Severity       string   `json:"severity"`
+	Notifiers      []string `json:"notifiers"`
+	SendResolved   *bool    `json:"send_resolved"` // default true
+	Enabled        *bool    `json:"enabled"`       // default true
+}
+
+func (req *alertRuleRequest) rule() *database.AlertRule {
+	return &database.AlertRule{
+		Name:           req.Name,
+		Description:    req.Description,
+		Metric:         req.Metric,
+		Event:          req.Event,
+		Window:         req.Window,
+		Operator:       req.Operator,
+		Threshold:      req.Threshold,
+		For:            req.For,
+		Cooldown:       req.Cooldown,
+		RepeatInterval: req.RepeatInterval,
+		Severity:       req.Severity,
+		Notifiers:      req.Notifiers,
+		SendResolved:   req.SendResolved == nil || *req.SendResolved,
+		Enabled:        req.Enabled == nil || *req.Enabled,
+	}
+}
+
+// handleListAlertRules handles GET /api/v1/alerts/rules - List configured and API-defined rules
+func (h *AlertHandlers) handleListAlertRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.engine.Rules()
+	if err != nil {
+		WriteInternalErrorResponse(w, "Failed to list alert rules")
+		return
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{
+		"rules":     rules,
+		"notifiers": h.engine.Notifiers(),
+	})
+}
+
+// handleGetAlertRule handles GET /api/v1/alerts/rules/{name} - Get one rule
+func (h *AlertHandlers) handleGetAlertRule(w http.ResponseWriter, r *http.Request) {
+	rule, err := h.engine.Rule(GetPathParam(r, "name"))
+	if err != nil {
+		h.writeRuleError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, rule)
+}
+
+// handleCreateAlertRule handles POST /api/v1/alerts/rules - Create a rule
+func (h *AlertHandlers) handleCreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	var req alertRuleRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		WriteBadRequestResponse(w, "Invalid request body: "+err.Error())
+		return
+	}
+
+	rule := req.rule()
+	if err := h.engine.CreateRule(rule, newAuditContext(r)); err != nil {
+		h.writeRuleError(w, err)
+		return
+	}
+
+	WriteJSONResponse(w, http.StatusCreated, APIResponse{Success: true, Data: rule})
+}
+
+// handleUpdateAlertRule handles PUT /api/v1/alerts/rules/{name} - Replace a rule
+func (h *AlertHandlers) handleUpdateAlertRule(w http.ResponseWriter, r *http.Request) {
+	var req alertRuleRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		WriteBadRequestResponse(w, "Invalid request body: "+err.Error())
+		return
+	}
+
+	// Rules are identified by name, which cannot be changed
+	req.Name = GetPathParam(r, "name")
+
+	rule := req.rule()
+	if err := h.engine.UpdateRule(rule, newAuditContext(r)); err != nil {
+		h.writeRuleError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, rule)
+}
+
+// handleDeleteAlertRule handles DELETE /api/v1/alerts/rules/{name} - Delete a rule
+func (h *AlertHandlers) handleDeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	name := GetPathParam(r, "name")
+	if err := h.engine.DeleteRule(name, newAuditContext(r)); err != nil {
+		h.writeRuleError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{"deleted": name})
+}
+
+// handleAlertHistory handles GET /api/v1/alerts - Stored alerts, newest first
+func (h *AlertHandlers) handleAlertHistory(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(GetQueryParam(r, "limit", "100"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+	if limit > 1000 {
+		limit = 1000
+	}
+	offset, err := strconv.Atoi(GetQueryParam(r, "offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	status := GetQueryParam(r, "status", "")
+	if status != "" && status != database.AlertStatusFiring && status != database.AlertStatusResolved {
+		WriteBadRequestResponse(w, "Status must be firing or resolved")
+		return
+	}
+
+	var since *time.Time
+	if sinceStr := GetQueryParam(r, "since", ""); sinceStr != "" {
+		parsed, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			WriteBadRequestResponse(w, "Invalid since time, expected RFC3339")
+			return
+		}
+		since = &parsed
+	}
+
+	alerts, err := h.engine.History(limit, offset, GetQueryParam(r, "rule", ""), status, since)
+	if err != nil {
+		WriteInternalErrorResponse(w, "Failed to retrieve alert history")
+		return
+	}
+	if alerts == nil {
+		alerts = []database.AlertEvent{}
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{
+		"alerts": alerts,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// handleTestNotifier handles POST /api/v1/alerts/notifiers/{name}/test - Send a test notification
+func (h *AlertHandlers) handleTestNotifier(w http.ResponseWriter, r *http.Request) {
+	name := GetPathParam(r, "name")
+	if err := h.engine.TestNotifier(r.Context(), name); err != nil {
+		if errors.Is(err, alerting.ErrNotifierNotFound) {
+			WriteNotFoundResponse(w, "Notifier not found")
+			return
+		}
+		WriteErrorResponse(w, http.StatusBadGateway, "Test notification failed: "+err.Error())
+		return
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{"notifier": name, "sent": true})
+}
+
+func (h *AlertHandlers) writeRuleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, alerting.ErrInvalidRule):
+		WriteBadRequestResponse(w, err.Error())
+	case errors.Is(err, alerting.ErrRuleNotFound):
+		WriteNotFoundResponse(w, "Alert rule not found")
+	case errors.Is(err, alerting.ErrRuleExists):
+		WriteErrorResponse(w, http.StatusConflict, err.Error())
+	case errors.Is(err, alerting.ErrRuleReadOnly):
+		WriteErrorResponse(w, http.StatusConflict, "Alert rule is defined in the configuration file and cannot be changed through the API")
+	default:
+		WriteInternalErrorResponse(w, "Failed to save alert rule")
+	}
+}
@@ -0,0 +1,315 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/audit"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// AuditHandlers contains handlers for audit log endpoints
+type AuditHandlers struct {
+	auditService *audit.Service
+}
+
+// NewAuditHandlers creates a new audit handlers instance
+func NewAuditHandlers(auditService *audit.Service) *AuditHandlers {
+	return &AuditHandlers{
+		auditService: auditService,
+	}
+}
+
+// auditExportFlushInterval is the number of exported rows between flushes
+const auditExportFlushInterval = 100
+
+// auditEntryResponse is an audit log entry as returned by the query and export endpoints
+type auditEntryResponse struct {
+	database.AuditLog
+	Success bool `json:"success"`
+}
+
+// handleAuditList handles GET /api/v1/audit - Query the audit trail
+func (h *AuditHandlers) handleAuditList(w http.ResponseWriter, r *http.Request) {
+	page, perPage, err := GetPaginationParams(r)
+	if err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	filters, err := parseAuditFilters(r)
+	if err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+	filters.Limit = perPage
+	filters.Offset = (page - 1) * perPage
+
+	total, err := h.auditService.CountAuditTrail(r.Context(), filters)
+	if err != nil {
+		WriteInternalErrorResponse(w, "Failed to query audit log: "+err.Error())
+		return
+	}
+
+	entries, err := h.auditService.GetAuditTrail(r.Context(), filters)
+	if err != nil {
+		WriteInternalErrorResponse(w, "Failed to query audit log: "+err.Error())
+		return
+	}
+
+	response := make([]auditEntryResponse, len(entries))
+	for i, entry := range entries {
+		response[i] = auditEntryResponse{AuditLog: *entry, Success: audit.EntrySucceeded(entry)}
+	}
+
+	WriteSuccessResponseWithMeta(w, response, CalculatePagination(page, perPage, total))
+}
+
+// handleAuditExport handles GET /api/v1/audit/export - Stream the filtered audit trail as CSV or JSON Lines
+func (h *AuditHandlers) handleAuditExport(w http.ResponseWriter, r *http.Request) {
+	format := GetQueryParam(r, "format", "jsonl")
+	if format != "csv" && format != "jsonl" {
+		WriteBadRequestResponse(w, "Invalid format. Supported formats: csv, jsonl")
+		return
+	}
+
+	filters, err := parseAuditFilters(r)
+	if err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	// Record the export before it runs so it appears in its own output
+	h.logAuditAction(r, audit.ActionAuditExport, "success", map[string]interface{}{
+		"format": format,
+		"query":  r.URL.RawQuery,
+	})
+
+	controller := http.NewResponseController(w)
+	filename := fmt.Sprintf("audit-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+
+	var csvWriter *csv.Writer
+	var jsonEncoder *json.Encoder
+	started := false
+	rows := 0
+
+	err = h.auditService.StreamAuditTrail(r.Context(), filters, func(entry *database.AuditLog) error {
+		if !started {
+			started = true
+			startAuditExport(w, format, filename)
+			if format == "csv" {
+				csvWriter = csv.NewWriter(w)
+				csvWriter.Write(auditCSVHeader)
+			} else {
+				jsonEncoder = json.NewEncoder(w)
+			}
+		}
+
+		success := audit.EntrySucceeded(entry)
+		var err error
+		if csvWriter != nil {
+			err = csvWriter.Write(auditCSVRecord(entry, success))
+		} else {
+			err = jsonEncoder.Encode(auditEntryResponse{AuditLog: *entry, Success: success})
+		}
+		if err != nil {
+			return err
+		}
+
+		rows++
+		if rows%auditExportFlushInterval == 0 {
+			if csvWriter != nil {
+				csvWriter.Flush()
+			}
+			controller.Flush()
+		}
+		return nil
+	})
+
+	if err != nil && !started {
+		WriteInternalErrorResponse(w, "Failed to export audit log: "+err.Error())
+		return
+	}
+	if err != nil {
+		// Headers are already sent, so the client sees a truncated file
+		log.Printf("Audit export aborted after %d rows: %v", rows, err)
+		return
+	}
+
+	if !started {
+		startAuditExport(w, format, filename)
+		if format == "csv" {
+			csvWriter = csv.NewWriter(w)
+			csvWriter.Write(auditCSVHeader)
+		}
+	}
+	if csvWriter != nil {
+		csvWriter.Flush()
+	}
+}
+
+// auditCSVHeader lists the columns of the CSV export
+var auditCSVHeader = []string{
+	"id", "timestamp", "action", "user_id", "ip_address", "message_id", "success", "details", "entry_hash",
+}
+
+func auditCSVRecord(entry *database.AuditLog, success bool) []string {
+	value := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+
+	return []string{
+		strconv.FormatInt(entry.ID, 10),
+		entry.Timestamp.UTC().Format(time.RFC3339Nano),
+		entry.Action,
+		csvTextCell(value(entry.UserID)),
+		csvTextCell(value(entry.IPAddress)),
+		csvTextCell(value(entry.MessageID)),
+		strconv.FormatBool(success),
+		csvTextCell(value(entry.Details)),
+		value(entry.EntryHash),
+	}
+}
+
+// csvTextCell prefixes text that a spreadsheet would evaluate as a formula with a quote
+func csvTextCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// startAuditExport writes the response headers of an export download
+func startAuditExport(w http.ResponseWriter, format, filename string) {
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	w.WriteHeader(http.StatusOK)
+}
+
+// parseAuditFilters reads the audit query filters from the query string
+func parseAuditFilters(r *http.Request) (*audit.AuditFilters, error) {
+	filters := &audit.AuditFilters{}
+
+	optional := func(name string) *string {
+		if value := GetQueryParam(r, name, ""); value != "" {
+			return &value
+		}
+		return nil
+	}
+	filters.UserID = optional("user_id")
+	filters.Action = optional("action")
+	filters.MessageID = optional("message_id")
+	filters.IPAddress = optional("ip_address")
+
+	for name, target := range map[string]**time.Time{"start_time": &filters.StartTime, "end_time": &filters.EndTime} {
+		if value := GetQueryParam(r, name, ""); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s, expected RFC 3339: %s", name, value)
+			}
+			*target = &t
+		}
+	}
+
+	if value := GetQueryParam(r, "success", ""); value != "" {
+		success, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid success value: %s", value)
+		}
+		filters.Success = &success
+	}
+
+	return filters, nil
+}
+
+// handleAuditIntegrity handles GET /api/v1/audit/integrity - Verify the audit log hash chain
+func (h *AuditHandlers) handleAuditIntegrity(w http.ResponseWriter, r *http.Request) {
+	report, err := h.auditService.ValidateAuditIntegrity(r.Context())
+	if err != nil {
+		WriteInternalErrorResponse(w, "Failed to verify audit log: "+err.Error())
+		return
+	}
+
+	result := "success"
+	if !report.Valid {
+		result = "failure"
+	}
+	h.logAuditAction(r, audit.ActionAuditVerify, result, map[string]interface{}{
+		"checked_entries": report.CheckedEntries,
+		"valid":           report.Valid,
+	})
+
+	WriteSuccessResponse(w, report)
+}
+
+// handleCreateCheckpoint handles POST /api/v1/audit/checkpoints - Export a signed checkpoint of the chain head
+func (h *AuditHandlers) handleCreateCheckpoint(w http.ResponseWriter, r *http.Request) {
+	checkpoint, err := h.auditService.CreateCheckpoint(r.Context())
+	if errors.Is(err, audit.ErrNoSigningKey) {
+		WriteErrorResponse(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusConflict, "Failed to create checkpoint: "+err.Error())
+		return
+	}
+
+	h.logAuditAction(r, audit.ActionAuditCheckpoint, "success", map[string]interface{}{
+		"entry_id":   checkpoint.EntryID,
+		"entry_hash": checkpoint.EntryHash,
+	})
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=audit-checkpoint-%d.json", checkpoint.EntryID))
+	WriteSuccessResponse(w, checkpoint)
+}
+
+// handleVerifyCheckpoint handles POST /api/v1/audit/checkpoints/verify - Check a previously exported checkpoint
+func (h *AuditHandlers) handleVerifyCheckpoint(w http.ResponseWriter, r *http.Request) {
+	var checkpoint audit.Checkpoint
+	if err := ParseJSONBody(r, &checkpoint); err != nil {
+		WriteBadRequestResponse(w, "Invalid request body: "+err.Error())
+		return
+	}
+
+	err := h.auditService.VerifyCheckpoint(r.Context(), &checkpoint)
+	if errors.Is(err, audit.ErrNoSigningKey) {
+		WriteErrorResponse(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	response := map[string]interface{}{
+		"valid":      err == nil,
+		"checkpoint": checkpoint,
+	}
+	if err != nil {
+		response["error"] = err.Error()
+	}
+
+	WriteSuccessResponse(w, response)
+}
+
+// logAuditAction records an integrity operation in the audit log itself
+func (h *AuditHandlers) logAuditAction(r *http.Request, action audit.ActionType, result string, parameters map[string]interface{}) {
+	details := &audit.AuditDetails{
+		ResourcePath: r.URL.Path,
+		Parameters:   parameters,
+		Result:       result,
+	}
+	if err := h.auditService.LogAction(r.Context(), action, nil, newAuditContext(r), details); err != nil {
+		log.Printf("Failed to log audit action: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+func TestAuditCSVRecordEscapesFormulas(t *testing.T) {
+	userID := "=HYPERLINK(\"http://evil.example\")"
+	ipAddress := "192.0.2.1"
+	details := "@SUM(1+1)"
+	entry := &database.AuditLog{
+		ID:        7,
+		Timestamp: time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+		Action:    "user_login",
+		UserID:    &userID,
+		IPAddress: &ipAddress,
+		Details:   &details,
+	}
+
+	record := auditCSVRecord(entry, true)
+	if record[3] != "'"+userID || record[7] != "'"+details {
+		t.Errorf("Expected formulas to be quoted, got %q and %q", record[3], record[7])
+	}
+	if record[4] != ipAddress || record[5] != "" {
+		t.Errorf("Expected plain values to be kept, got %q and %q", record[4], record[5])
+	}
+
+	for _, cell := range []string{"+1", "-1", "=1", "@a"} {
+		if got := csvTextCell(cell); got != "'"+cell {
+			t.Errorf("csvTextCell(%q) = %q", cell, got)
+		}
+	}
+	if got := csvTextCell(`{"success":true}`); got != `{"success":true}` {
+		t.Errorf("csvTextCell changed JSON details to %q", got)
+	}
+}
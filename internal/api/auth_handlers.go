@@ -2,7 +2,12 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/andreitelteu/exim-pilot/internal/auth"
@@ -11,13 +16,19 @@ import (
 
 // AuthHandlers handles authentication-related HTTP requests
 type AuthHandlers struct {
-	authService *auth.Service
+	authService    *auth.Service
+	trustedProxies []*net.IPNet
+	cookies        cookiePolicy
 }
 
-// NewAuthHandlers creates a new auth handlers instance
-func NewAuthHandlers(authService *auth.Service) *AuthHandlers {
+// NewAuthHandlers creates a new auth handlers instance. Forwarded client addresses are
+// only believed from trustedProxies, see loginClientIP, and the session cookie is set
+// with the attributes of cookies.
+func NewAuthHandlers(authService *auth.Service, trustedProxies []*net.IPNet, cookies cookiePolicy) *AuthHandlers {
 	return &AuthHandlers{
-		authService: authService,
+		authService:    authService,
+		trustedProxies: trustedProxies,
+		cookies:        cookies,
 	}
 }
 
@@ -44,11 +55,14 @@ func (h *AuthHandlers) handleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get client IP and user agent
-	ipAddress := getClientIP(r)
+	ipAddress := h.loginClientIP(r)
 	userAgent := r.UserAgent()
 
 	// Attempt login
 	loginResp, err := h.authService.Login(loginReq.Username, loginReq.Password, ipAddress, userAgent)
+	if writeLoginThrottled(w, err) {
+		return
+	}
 	if err != nil {
 		response := APIResponse{
 			Success: false,
@@ -58,17 +72,93 @@ func (h *AuthHandlers) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set session cookie
-	cookie := &http.Cookie{
-		Name:     "session_id",
-		Value:    loginResp.SessionID,
-		Expires:  loginResp.ExpiresAt,
-		HttpOnly: true,
-		Secure:   r.TLS != nil, // Only secure if HTTPS
-		SameSite: http.SameSiteStrictMode,
-		Path:     "/",
+	// Password accepted, the client must now send the second factor to /auth/login/mfa
+	if loginResp.MFARequired {
+		response := APIResponse{
+			Success: true,
+			Data: map[string]interface{}{
+				"mfa_required": true,
+				"mfa_token":    loginResp.MFAToken,
+				"expires_at":   loginResp.ExpiresAt,
+			},
+		}
+		WriteJSONResponse(w, http.StatusOK, response)
+		return
+	}
+
+	h.writeSession(w, r, loginResp)
+}
+
+// handleLoginMFA completes a two-step login with a TOTP or recovery code
+func (h *AuthHandlers) handleLoginMFA(w http.ResponseWriter, r *http.Request) {
+	var mfaReq database.MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&mfaReq); err != nil {
+		response := APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		}
+		WriteJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	if mfaReq.MFAToken == "" || mfaReq.Code == "" {
+		response := APIResponse{
+			Success: false,
+			Error:   "MFA token and code are required",
+		}
+		WriteJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	loginResp, err := h.authService.CompleteMFALogin(mfaReq.MFAToken, mfaReq.Code, h.loginClientIP(r), r.UserAgent())
+	if writeLoginThrottled(w, err) {
+		return
+	}
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		}
+		WriteJSONResponse(w, http.StatusUnauthorized, response)
+		return
+	}
+
+	h.writeSession(w, r, loginResp)
+}
+
+// writeLoginThrottled writes a 429 response with Retry-After when a login was refused for
+// too many failed attempts, and reports whether it did
+func writeLoginThrottled(w http.ResponseWriter, err error) bool {
+	var throttled *auth.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return false
 	}
-	http.SetCookie(w, cookie)
+
+	seconds := int(math.Ceil(throttled.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	response := APIResponse{
+		Success: false,
+		Error:   throttled.Error(),
+		Data: map[string]interface{}{
+			"retry_after": seconds,
+			"locked":      throttled.Locked,
+		},
+	}
+	WriteJSONResponse(w, http.StatusTooManyRequests, response)
+	return true
+}
+
+// writeSession sets the session cookie and writes the logged-in user with the session's
+// CSRF token. A session the browser held before logging in is ended, so the session ID
+// changes on every login.
+func (h *AuthHandlers) writeSession(w http.ResponseWriter, r *http.Request, loginResp *database.LoginResponse) {
+	if previous, err := r.Cookie(sessionCookieName); err == nil && previous.Value != loginResp.SessionID {
+		h.authService.EndSession(previous.Value)
+	}
+
+	// Set session cookie
+	http.SetCookie(w, h.cookies.sessionCookie(r, loginResp.SessionID, loginResp.ExpiresAt))
+	w.Header().Set(csrfHeader, loginResp.CSRFToken)
 
 	// Return user info (without password hash)
 	response := APIResponse{
@@ -76,6 +166,7 @@ func (h *AuthHandlers) handleLogin(w http.ResponseWriter, r *http.Request) {
 		Data: map[string]interface{}{
 			"user":       loginResp.User,
 			"expires_at": loginResp.ExpiresAt,
+			"csrf_token": loginResp.CSRFToken,
 		},
 	}
 	WriteJSONResponse(w, http.StatusOK, response)
@@ -84,7 +175,7 @@ func (h *AuthHandlers) handleLogin(w http.ResponseWriter, r *http.Request) {
 // handleLogout handles user logout
 func (h *AuthHandlers) handleLogout(w http.ResponseWriter, r *http.Request) {
 	// Get session ID from cookie
-	cookie, err := r.Cookie("session_id")
+	cookie, err := r.Cookie(sessionCookieName)
 	if err != nil {
 		response := APIResponse{
 			Success: false,
@@ -108,16 +199,7 @@ func (h *AuthHandlers) handleLogout(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Clear session cookie
-	clearCookie := &http.Cookie{
-		Name:     "session_id",
-		Value:    "",
-		Expires:  time.Unix(0, 0),
-		HttpOnly: true,
-		Secure:   r.TLS != nil,
-		SameSite: http.SameSiteStrictMode,
-		Path:     "/",
-	}
-	http.SetCookie(w, clearCookie)
+	http.SetCookie(w, h.cookies.sessionCookie(r, "", time.Unix(0, 0)))
 
 	response := APIResponse{
 		Success: true,
@@ -126,7 +208,8 @@ func (h *AuthHandlers) handleLogout(w http.ResponseWriter, r *http.Request) {
 	WriteJSONResponse(w, http.StatusOK, response)
 }
 
-// handleMe returns current user information
+// handleMe returns current user information, with the session's CSRF token in the
+// X-CSRF-Token header
 func (h *AuthHandlers) handleMe(w http.ResponseWriter, r *http.Request) {
 	user, ok := GetUserFromContext(r.Context())
 	if !ok {
@@ -138,6 +221,15 @@ func (h *AuthHandlers) handleMe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Pages loaded with an existing session pick up its CSRF token here
+	if _, isToken := GetAPITokenFromContext(r.Context()); !isToken {
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			if token, err := h.authService.SessionCSRFToken(cookie.Value); err == nil {
+				w.Header().Set(csrfHeader, token)
+			}
+		}
+	}
+
 	response := APIResponse{
 		Success: true,
 		Data:    user,
@@ -145,6 +237,43 @@ func (h *AuthHandlers) handleMe(w http.ResponseWriter, r *http.Request) {
 	WriteJSONResponse(w, http.StatusOK, response)
 }
 
+// loginClientIP returns the address login attempts are counted against, without a port.
+// X-Forwarded-For and X-Real-IP are only believed from a trusted proxy, or from the
+// loopback interface when none are configured, so that clients cannot dodge the per-IP
+// limit by sending them. Of the forwarded addresses, the last one not belonging to a
+// trusted proxy is the client.
+func (h *AuthHandlers) loginClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	trusted := func(addr string) bool {
+		if len(h.trustedProxies) == 0 {
+			ip := net.ParseIP(addr)
+			return ip != nil && ip.IsLoopback()
+		}
+		return auth.ContainsAddress(h.trustedProxies, addr)
+	}
+	if !trusted(host) {
+		return host
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop != "" && (i == 0 || !trusted(hop)) {
+				return hop
+			}
+		}
+	}
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); xri != "" {
+		return xri
+	}
+	return host
+}
+
 // getClientIP extracts the client IP address from the request
 func getClientIP(r *http.Request) string {
 	// Check X-Forwarded-For header first (for proxies)
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andreitelteu/exim-pilot/internal/auth"
+)
+
+func TestLoginClientIP(t *testing.T) {
+	proxies, err := auth.ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		proxies    bool
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"direct client", true, "192.0.2.1:5000", "", "192.0.2.1"},
+		{"spoofed header", true, "192.0.2.1:5000", "198.51.100.1", "192.0.2.1"},
+		{"trusted proxy", true, "10.0.0.2:5000", "198.51.100.1", "198.51.100.1"},
+		{"client prepends an address", true, "10.0.0.2:5000", "203.0.113.5, 198.51.100.1", "198.51.100.1"},
+		{"chain of proxies", true, "10.0.0.2:5000", "198.51.100.1, 10.0.0.3", "198.51.100.1"},
+		{"loopback without configured proxies", false, "127.0.0.1:5000", "198.51.100.1", "198.51.100.1"},
+		{"remote without configured proxies", false, "192.0.2.1:5000", "198.51.100.1", "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewAuthHandlers(nil, nil, cookiePolicy{})
+			if tt.proxies {
+				h.trustedProxies = proxies
+			}
+
+			r := httptest.NewRequest("POST", "/api/v1/auth/login", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+
+			if got := h.loginClientIP(r); got != tt.want {
+				t.Errorf("loginClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
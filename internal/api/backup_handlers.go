@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// BackupHandlers contains handlers for database backups
+type BackupHandlers struct {
+	service *database.BackupService
+}
+
+// NewBackupHandlers creates a new backup handlers instance
+func NewBackupHandlers(service *database.BackupService) *BackupHandlers {
+	return &BackupHandlers{service: service}
+}
+
+// handleListBackups handles GET /api/v1/backups - List database backups, newest first
+func (h *BackupHandlers) handleListBackups(w http.ResponseWriter, r *http.Request) {
+	backups, err := h.service.List()
+	if err != nil {
+		WriteInternalErrorResponse(w, "Failed to list backups")
+		return
+	}
+	if backups == nil {
+		backups = []database.BackupInfo{}
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{
+		"backups":   backups,
+		"directory": h.service.Dir(),
+	})
+}
+
+// handleCreateBackup handles POST /api/v1/backups - Back up the database now
+func (h *BackupHandlers) handleCreateBackup(w http.ResponseWriter, r *http.Request) {
+	backup, err := h.service.Backup(r.Context())
+	if err != nil {
+		WriteInternalErrorResponse(w, "Failed to back up database: "+err.Error())
+		return
+	}
+
+	WriteJSONResponse(w, http.StatusCreated, APIResponse{Success: true, Data: backup})
+}
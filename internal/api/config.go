@@ -4,6 +4,12 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/alerting"
+	"github.com/andreitelteu/exim-pilot/internal/auth"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+	"github.com/andreitelteu/exim-pilot/internal/metrics"
 )
 
 // Config holds the API server configuration
@@ -15,6 +21,78 @@ type Config struct {
 	IdleTimeout    int // seconds
 	AllowedOrigins []string
 	LogRequests    bool
+
+	// HTTPS. The certificate is reloaded when its files change or on ReloadTLS.
+	TLSEnabled  bool
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// TLSRedirectPort, when set, redirects plain HTTP on this port to HTTPS
+	TLSRedirectPort int
+
+	// TLSClientCAFile lets clients present certificates signed by these CAs.
+	// RequireTokenClientCert refuses API tokens from clients without one.
+	TLSClientCAFile        string
+	RequireTokenClientCert bool
+
+	// ContentRedaction masks addresses and card numbers in message content previews
+	ContentRedaction bool
+
+	// EximConfigFile is the Exim configuration whose retry rules predict retry schedules
+	EximConfigFile string
+
+	// Password policy applied when passwords are set through user management
+	PasswordMinLength     int
+	RequireStrongPassword bool
+
+	// Failed logins allowed per IP address and per username before logins from them are
+	// refused for LoginLockoutTime. Zero values use the defaults.
+	MaxLoginAttempts int
+	LoginLockoutTime time.Duration
+
+	// CSRFProtection requires the session's CSRF token in X-CSRF-Token on state-changing
+	// requests authenticated by the session cookie, and a same-origin Origin or Referer on
+	// those authenticated by trusted headers. API token requests are exempt.
+	CSRFProtection bool
+
+	// SecureCookies marks the session cookie Secure even when the server itself is not
+	// serving HTTPS, as behind a TLS-terminating proxy. CookieSameSite is strict, lax or
+	// none.
+	SecureCookies  bool
+	CookieSameSite string
+
+	// TrustedProxies lists the IPs or CIDR ranges whose X-Forwarded-For and X-Real-IP
+	// headers are believed when counting login attempts per IP address. When empty, only
+	// a proxy on the loopback interface is trusted.
+	TrustedProxies []string
+
+	// TOTPIssuer is the name authenticator apps show for enrolled accounts
+	TOTPIssuer string
+
+	// RequireTOTPForMutate refuses queue-changing requests from sessions of users
+	// without two-factor authentication
+	RequireTOTPForMutate bool
+
+	// AuditSigningKey signs exported audit log checkpoints
+	AuditSigningKey string
+
+	// Authenticators are consulted in order by the login endpoint. Empty means local
+	// accounts only.
+	Authenticators []auth.Authenticator
+
+	// TrustedHeaderAuth, when set, authenticates requests from a reverse proxy by headers
+	TrustedHeaderAuth *auth.TrustedHeaderAuthenticator
+
+	// AlertEngine serves the /alerts endpoints. Nil when alerting is disabled.
+	AlertEngine *alerting.Engine
+
+	// Backups serves the /backups endpoints. Nil when no backup directory is configured.
+	Backups *database.BackupService
+
+	// Metrics is served at MetricsPath with its own access checks, and records API
+	// request latencies. Nil when the metrics endpoint is disabled.
+	Metrics     *metrics.Exporter
+	MetricsPath string
 }
 
 // NewConfig creates a new configuration with defaults
@@ -27,6 +105,16 @@ func NewConfig() *Config {
 		IdleTimeout:    60,
 		AllowedOrigins: []string{"*"}, // In production, specify exact origins
 		LogRequests:    true,
+
+		ContentRedaction: true,
+
+		PasswordMinLength:     8,
+		RequireStrongPassword: true,
+
+		TOTPIssuer: "Exim Pilot",
+
+		CSRFProtection: true,
+		CookieSameSite: "strict",
 	}
 }
 
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// sessionCookieName is the cookie holding the session ID of browser logins
+	sessionCookieName = "session_id"
+
+	// csrfHeader carries the session's CSRF token on state-changing requests. The token
+	// is returned in the same header by the login and /auth/me endpoints.
+	csrfHeader = "X-CSRF-Token"
+)
+
+// cookiePolicy holds the attributes of the session cookie. The cookie is always HttpOnly
+// since scripts never need to read it.
+type cookiePolicy struct {
+	secure   bool
+	sameSite http.SameSite
+}
+
+// newCookiePolicy reads the cookie attributes from the configuration. SameSite=None is
+// only accepted by browsers on secure cookies, so it implies Secure.
+func newCookiePolicy(config *Config) cookiePolicy {
+	policy := cookiePolicy{secure: config.SecureCookies, sameSite: http.SameSiteStrictMode}
+	switch strings.ToLower(config.CookieSameSite) {
+	case "lax":
+		policy.sameSite = http.SameSiteLaxMode
+	case "none":
+		policy.sameSite = http.SameSiteNoneMode
+		policy.secure = true
+	}
+	return policy
+}
+
+// sessionCookie returns the session cookie with the configured attributes. Cookies set
+// over HTTPS are always secure.
+func (p cookiePolicy) sessionCookie(r *http.Request, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    value,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   p.secure || r.TLS != nil,
+		SameSite: p.sameSite,
+		Path:     "/",
+	}
+}
+
+// isSafeMethod reports whether a request method does not change state and so needs no
+// CSRF protection
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// sameOriginRequest reports whether a browser request comes from this server or one of
+// the explicitly allowed origins, judged by its Origin header or else its Referer.
+// Requests with neither are not from browsers and are allowed. Unlike the WebSocket
+// origin check, a "*" in the allowed origins does not count.
+func (s *Server) sameOriginRequest(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		referer := r.Header.Get("Referer")
+		if referer == "" {
+			return true
+		}
+		u, err := url.Parse(referer)
+		if err != nil {
+			return false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+
+	for _, allowedOrigin := range s.config.AllowedOrigins {
+		if allowedOrigin != "*" && strings.EqualFold(allowedOrigin, origin) {
+			return true
+		}
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
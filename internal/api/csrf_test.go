@@ -0,0 +1,148 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// login posts the viewer's credentials, presenting the given session cookie if any, and
+// returns the new session cookie and CSRF token
+func login(t *testing.T, h *AuthHandlers, previous *http.Cookie) (*http.Cookie, string) {
+	t.Helper()
+
+	r := httptest.NewRequest("POST", "/api/v1/auth/login", strings.NewReader(`{"username":"viewer","password":"Secret123!"}`))
+	if previous != nil {
+		r.AddCookie(previous)
+	}
+	w := httptest.NewRecorder()
+	h.handleLogin(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Login failed with %d: %s", w.Code, w.Body.String())
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookieName {
+		t.Fatalf("Expected the session cookie, got %v", cookies)
+	}
+	token := w.Header().Get(csrfHeader)
+	if token == "" || !strings.Contains(w.Body.String(), token) {
+		t.Fatalf("Expected the CSRF token in the header and body, got %q", token)
+	}
+	return cookies[0], token
+}
+
+func TestCSRFProtection(t *testing.T) {
+	server, apiToken := newEventTestServer(t)
+	server.config.SecureCookies = true
+	authHandlers := NewAuthHandlers(server.authService, nil, newCookiePolicy(server.config))
+
+	handler := server.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	send := func(method string, cookie *http.Cookie, header, value string) int {
+		r := httptest.NewRequest(method, "/api/v1/queue/bulk", nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	cookie, token := login(t, authHandlers, nil)
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteStrictMode {
+		t.Errorf("Unexpected session cookie attributes %+v", cookie)
+	}
+
+	if code := send("GET", cookie, "", ""); code != http.StatusNoContent {
+		t.Errorf("GET without a token returned %d", code)
+	}
+	if code := send("POST", cookie, "", ""); code != http.StatusForbidden {
+		t.Errorf("POST without a token returned %d", code)
+	}
+	if code := send("DELETE", cookie, csrfHeader, "forged"); code != http.StatusForbidden {
+		t.Errorf("DELETE with a wrong token returned %d", code)
+	}
+	if code := send("POST", cookie, csrfHeader, token); code != http.StatusNoContent {
+		t.Errorf("POST with the token returned %d", code)
+	}
+	if code := send("POST", nil, "Authorization", "Bearer "+apiToken); code != http.StatusNoContent {
+		t.Errorf("POST with an API token returned %d", code)
+	}
+
+	// Logging in again replaces the session and its token
+	rotated, rotatedToken := login(t, authHandlers, cookie)
+	if rotated.Value == cookie.Value || rotatedToken == token {
+		t.Fatal("Expected a new session ID and CSRF token on login")
+	}
+	if code := send("GET", cookie, "", ""); code != http.StatusUnauthorized {
+		t.Errorf("Previous session still works, got %d", code)
+	}
+	if code := send("POST", rotated, csrfHeader, token); code != http.StatusForbidden {
+		t.Errorf("POST with the previous token returned %d", code)
+	}
+
+	server.config.CSRFProtection = false
+	if code := send("POST", rotated, "", ""); code != http.StatusNoContent {
+		t.Errorf("POST with CSRF protection disabled returned %d", code)
+	}
+}
+
+func TestNewCookiePolicy(t *testing.T) {
+	tests := []struct {
+		sameSite string
+		secure   bool
+		want     cookiePolicy
+	}{
+		{"", false, cookiePolicy{sameSite: http.SameSiteStrictMode}},
+		{"strict", true, cookiePolicy{secure: true, sameSite: http.SameSiteStrictMode}},
+		{"lax", false, cookiePolicy{sameSite: http.SameSiteLaxMode}},
+		{"none", false, cookiePolicy{secure: true, sameSite: http.SameSiteNoneMode}},
+	}
+
+	for _, tt := range tests {
+		config := &Config{CookieSameSite: tt.sameSite, SecureCookies: tt.secure}
+		if got := newCookiePolicy(config); got != tt.want {
+			t.Errorf("newCookiePolicy(%q, %v) = %+v, want %+v", tt.sameSite, tt.secure, got, tt.want)
+		}
+	}
+}
+
+func TestSameOriginRequest(t *testing.T) {
+	server := &Server{config: &Config{AllowedOrigins: []string{"*", "https://admin.example.com"}}}
+
+	tests := []struct {
+		name    string
+		origin  string
+		referer string
+		want    bool
+	}{
+		{"no origin or referer", "", "", true},
+		{"same origin", "https://mail.example.com", "", true},
+		{"allowed origin", "https://admin.example.com", "", true},
+		{"foreign origin", "https://evil.example.net", "", false},
+		{"same origin referer", "", "https://mail.example.com/queue", true},
+		{"foreign referer", "", "https://evil.example.net/page", false},
+		{"null origin", "null", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "https://mail.example.com/api/v1/queue/bulk", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+			if got := server.sameOriginRequest(r); got != tt.want {
+				t.Errorf("sameOriginRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
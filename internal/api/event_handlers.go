@@ -0,0 +1,259 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/websocket"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// eventStreamPrefix is the path of the Server-Sent Events endpoints
+	eventStreamPrefix = "/api/v1/events/"
+
+	// Comment lines sent on idle streams so proxies do not time them out
+	eventStreamKeepAlive = 30 * time.Second
+
+	// How often the credentials of an event stream are checked again
+	eventStreamRevalidatePeriod = time.Minute
+)
+
+// feedAuthorizer limits a stream's authorizer to the one topic an event stream serves,
+// so broadcasts of other message types are not delivered to it
+type feedAuthorizer struct {
+	*streamAuthorizer
+	topic string
+}
+
+// Authorize reports whether topic is the stream's topic and the user may receive it
+func (a feedAuthorizer) Authorize(topic string) bool {
+	return topic == a.topic && a.streamAuthorizer.Authorize(topic)
+}
+
+// streamEnvelope is the part of a hub message needed to write it as an event
+type streamEnvelope struct {
+	Type string          `json:"type"`
+	ID   uint64          `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+// handleLogEvents handles GET /api/v1/events/logs - Stream new log entries, with the
+// log tail filter taken from the query and resume from Last-Event-ID
+func (s *Server) handleLogEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := logFilterFromQuery(r.URL.Query())
+	if err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	var lastEventID *uint64
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			WriteBadRequestResponse(w, "Invalid Last-Event-ID")
+			return
+		}
+		lastEventID = &id
+	}
+
+	s.serveEvents(w, r, websocket.LogTailEndpoint, func(feed *websocket.Feed) error {
+		return feed.SubscribeLogTail(filter, lastEventID)
+	})
+}
+
+// handleMessageEvents handles GET /api/v1/events/messages/{id} - Stream updates of one message
+func (s *Server) handleMessageEvents(w http.ResponseWriter, r *http.Request) {
+	endpoint := websocket.MessageUpdatesPrefix + mux.Vars(r)["id"] + websocket.MessageUpdatesSuffix
+	s.serveEvents(w, r, endpoint, func(feed *websocket.Feed) error {
+		return feed.Subscribe(endpoint)
+	})
+}
+
+// handleBroadcastEvents returns a handler streaming the broadcasts of one message type:
+// queue updates, dashboard metrics or system alerts
+func (s *Server) handleBroadcastEvents(messageType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.serveEvents(w, r, messageType, nil)
+	}
+}
+
+// serveEvents authenticates the request and streams the hub messages of topic as
+// Server-Sent Events until the client goes away or its credentials are no longer valid
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request, topic string, subscribe func(*websocket.Feed) error) {
+	authorizer, ok := s.authenticateStream(w, r)
+	if !ok {
+		return
+	}
+	if !authorizer.Authorize(topic) {
+		WriteForbiddenResponse(w, "Forbidden: your permissions do not include this event stream")
+		return
+	}
+
+	feed := s.websocketService.GetHub().OpenFeed(feedAuthorizer{streamAuthorizer: authorizer, topic: topic})
+	defer feed.Close()
+
+	if subscribe != nil {
+		if err := subscribe(feed); err != nil {
+			WriteBadRequestResponse(w, err.Error())
+			return
+		}
+	}
+
+	// Streams outlive the server's write timeout
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear the write deadline of an event stream: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	controller.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	revalidate := time.NewTicker(eventStreamRevalidatePeriod)
+	defer revalidate.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case message, ok := <-feed.Messages():
+			if !ok {
+				// Dropped by the hub for falling behind; clients reconnect with Last-Event-ID
+				return
+			}
+			if err := writeStreamMessage(w, topic, message); err != nil {
+				return
+			}
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+
+		case <-revalidate.C:
+			if err := authorizer.Revalidate(); err != nil || !authorizer.Authorize(topic) {
+				writeEvent(w, "error", 0, []byte(`{"error":"authentication expired"}`))
+				controller.Flush()
+				return
+			}
+			continue
+		}
+
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeStreamMessage writes a hub message as events. Log entries are named log_entry and
+// carry their ID, message updates are named message_update and broadcasts keep their
+// message type. A resume that missed entries is reported as a gap event.
+func writeStreamMessage(w http.ResponseWriter, topic string, message []byte) error {
+	var envelope streamEnvelope
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		log.Printf("Failed to decode event stream message: %v", err)
+		return nil
+	}
+
+	switch envelope.Type {
+	case "subscribed":
+		var status struct {
+			Gap bool `json:"gap"`
+		}
+		if json.Unmarshal(envelope.Data, &status) == nil && status.Gap {
+			return writeEvent(w, "gap", 0, envelope.Data)
+		}
+		return nil
+
+	case "replay":
+		var events []struct {
+			ID   uint64          `json:"id"`
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(envelope.Data, &events); err != nil {
+			log.Printf("Failed to decode replayed events: %v", err)
+			return nil
+		}
+		for _, event := range events {
+			if err := writeEvent(w, "log_entry", event.ID, event.Data); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "subscription_update":
+		if topic == websocket.LogTailEndpoint {
+			return writeEvent(w, "log_entry", envelope.ID, envelope.Data)
+		}
+		return writeEvent(w, "message_update", 0, envelope.Data)
+
+	default:
+		return writeEvent(w, envelope.Type, envelope.ID, envelope.Data)
+	}
+}
+
+// writeEvent writes one event. JSON data never contains newlines, so it fits on one data line.
+func writeEvent(w http.ResponseWriter, name string, id uint64, data []byte) error {
+	var b strings.Builder
+	if id > 0 {
+		fmt.Fprintf(&b, "id: %d\n", id)
+	}
+	fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", name, data)
+	_, err := fmt.Fprint(w, b.String())
+	return err
+}
+
+// logFilterFromQuery reads a log tail filter from query parameters named like the
+// fields of websocket.LogFilter. log_type and event may be repeated or comma separated,
+// keyword may be repeated.
+func logFilterFromQuery(query url.Values) (*websocket.LogFilter, error) {
+	filter := &websocket.LogFilter{
+		LogTypes:  queryList(query, "log_type"),
+		Events:    queryList(query, "event"),
+		MessageID: query.Get("message_id"),
+		Sender:    query.Get("sender"),
+		Recipient: query.Get("recipient"),
+		Host:      query.Get("host"),
+		Keywords:  query["keyword"],
+		Regex:     query.Get("regex"),
+	}
+
+	if value := query.Get("pid"); value != "" {
+		pid, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || pid <= 0 {
+			return nil, fmt.Errorf("invalid pid: %s", value)
+		}
+		filter.PID = &pid
+	}
+
+	return filter, nil
+}
+
+// queryList returns the values of a repeated or comma separated query parameter
+func queryList(query url.Values, key string) []string {
+	var values []string
+	for _, value := range query[key] {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+	return values
+}
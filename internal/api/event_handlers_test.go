@@ -0,0 +1,166 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/auth"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+	"github.com/andreitelteu/exim-pilot/internal/websocket"
+)
+
+// newEventTestServer returns a server with a running hub and an API token of a viewer
+// limited to log:read
+func newEventTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+
+	db, err := database.Connect(&database.Config{
+		Path:            filepath.Join(t.TempDir(), "events.db"),
+		MaxOpenConns:    1,
+		MaxIdleConns:    1,
+		ConnMaxLifetime: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.MigrateUp(db); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	server := &Server{
+		config:           NewConfig(),
+		authService:      auth.NewService(db),
+		websocketService: websocket.NewService(),
+	}
+	server.websocketService.Start()
+
+	user, err := server.authService.CreateUser("viewer", "Secret123!", "", "", auth.RoleViewer, nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	secret, _, err := server.authService.CreateAPIToken(user.ID, "tail", []string{string(auth.PermissionLogRead)}, time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+	return server, secret
+}
+
+// readEvents reads events from a stream until count log entries arrived, returning
+// each event as its raw lines
+func readEvents(t *testing.T, scanner *bufio.Scanner, count int) []string {
+	t.Helper()
+
+	var events []string
+	var current []string
+	for len(events) < count && scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(current) > 0 {
+				events = append(events, strings.Join(current, "\n"))
+			}
+			current = nil
+			continue
+		}
+		current = append(current, line)
+	}
+	if len(events) < count {
+		t.Fatalf("Expected %d events, got %v (%v)", count, events, scanner.Err())
+	}
+	return events
+}
+
+func TestLogEvents(t *testing.T) {
+	server, secret := newEventTestServer(t)
+	ts := httptest.NewServer(http.HandlerFunc(server.handleLogEvents))
+	// Registered first so it runs after the streams opened below are cancelled
+	t.Cleanup(ts.Close)
+
+	open := func(query url.Values, lastEventID string) *http.Response {
+		t.Helper()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"?"+query.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	if resp, err := http.Get(ts.URL); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without credentials, got %v, %v", resp, err)
+	}
+	if resp := open(url.Values{"regex": {"("}}, ""); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for an invalid filter, got %d", resp.StatusCode)
+	}
+
+	resp := open(url.Values{"log_type": {"reject"}}, "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	hub := server.websocketService.GetHub()
+	hub.BroadcastLogEntry(&database.LogEntry{LogType: database.LogTypeMain, RawLine: "main 1"})
+	hub.BroadcastLogEntry(&database.LogEntry{LogType: database.LogTypeReject, RawLine: "reject 2"})
+
+	events := readEvents(t, bufio.NewScanner(resp.Body), 1)
+	if !strings.HasPrefix(events[0], "id: 2\nevent: log_entry\ndata: {") || !strings.Contains(events[0], `"raw_line":"reject 2"`) {
+		t.Fatalf("Unexpected event %q", events[0])
+	}
+
+	// A reconnecting client gets the entries it missed
+	hub.BroadcastLogEntry(&database.LogEntry{LogType: database.LogTypeReject, RawLine: "reject 3"})
+
+	resumed := open(url.Values{"log_type": {"reject"}}, "2")
+	events = readEvents(t, bufio.NewScanner(resumed.Body), 1)
+	if !strings.HasPrefix(events[0], "id: 3\nevent: log_entry\n") {
+		t.Fatalf("Expected event 3 to be replayed, got %q", events[0])
+	}
+}
+
+func TestBroadcastEventsRequirePermission(t *testing.T) {
+	server, secret := newEventTestServer(t)
+	ts := httptest.NewServer(server.handleBroadcastEvents(websocket.QueueUpdateMessage))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Authorization", "Bearer "+secret)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a token without queue:read to be refused, got %d", resp.StatusCode)
+	}
+}
+
+func TestLogFilterFromQuery(t *testing.T) {
+	query, _ := url.ParseQuery("log_type=main,reject&event=defer&event=bounce&keyword=a&keyword=b&sender=*@example.com&pid=42")
+	filter, err := logFilterFromQuery(query)
+	if err != nil {
+		t.Fatalf("logFilterFromQuery failed: %v", err)
+	}
+	if len(filter.LogTypes) != 2 || len(filter.Events) != 2 || len(filter.Keywords) != 2 ||
+		filter.Sender != "*@example.com" || filter.PID == nil || *filter.PID != 42 {
+		t.Errorf("Unexpected filter %+v", filter)
+	}
+
+	if _, err := logFilterFromQuery(url.Values{"pid": {"x"}}); err == nil {
+		t.Error("Expected an invalid pid to be rejected")
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -76,6 +77,15 @@ func (h *LogHandlers) handleLogsList(w http.ResponseWriter, r *http.Request) {
 		criteria.Host = host
 	}
 
+	if pidStr := GetQueryParam(r, "pid", ""); pidStr != "" {
+		pid, err := strconv.ParseInt(pidStr, 10, 64)
+		if err != nil || pid <= 0 {
+			WriteBadRequestResponse(w, "Invalid pid")
+			return
+		}
+		criteria.PID = &pid
+	}
+
 	if errorCode := GetQueryParam(r, "error_code", ""); errorCode != "" {
 		criteria.ErrorCode = errorCode
 	}
@@ -380,6 +390,55 @@ func (h *LogHandlers) handleTriggerCorrelation(w http.ResponseWriter, r *http.Re
 	})
 }
 
+// handleStartBackfill handles POST /api/v1/logs/backfill - Import the rotated log series in the background
+func (h *LogHandlers) handleStartBackfill(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Directory string `json:"directory"`
+	}
+
+	if r.ContentLength != 0 {
+		if err := ParseJSONBody(r, &request); err != nil {
+			WriteBadRequestResponse(w, "Invalid JSON: "+err.Error())
+			return
+		}
+	}
+
+	job, err := h.logService.StartBackfill(request.Directory)
+	if errors.Is(err, logprocessor.ErrBackfillRunning) {
+		WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		WriteBadRequestResponse(w, "Failed to start backfill: "+err.Error())
+		return
+	}
+
+	WriteJSONResponse(w, http.StatusAccepted, APIResponse{Success: true, Data: job})
+}
+
+// handleBackfillStatus handles GET /api/v1/logs/backfill - Get the progress of the current or last backfill
+func (h *LogHandlers) handleBackfillStatus(w http.ResponseWriter, r *http.Request) {
+	job := h.logService.BackfillStatus()
+	if job == nil {
+		WriteNotFoundResponse(w, "No backfill has been run")
+		return
+	}
+
+	WriteSuccessResponse(w, job)
+}
+
+// handleCancelBackfill handles DELETE /api/v1/logs/backfill - Stop the running backfill
+func (h *LogHandlers) handleCancelBackfill(w http.ResponseWriter, r *http.Request) {
+	if !h.logService.CancelBackfill() {
+		WriteErrorResponse(w, http.StatusConflict, "No backfill is running")
+		return
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{
+		"message": "Backfill cancellation requested",
+	})
+}
+
 // handleExportLogs handles GET /api/v1/logs/export - Export logs in various formats
 func (h *LogHandlers) handleExportLogs(w http.ResponseWriter, r *http.Request) {
 	// Get export format
@@ -2,14 +2,17 @@ package api
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/andreitelteu/exim-pilot/internal/database"
 	"github.com/andreitelteu/exim-pilot/internal/logprocessor"
+	"github.com/andreitelteu/exim-pilot/internal/parser"
 	"github.com/andreitelteu/exim-pilot/internal/queue"
 )
 
@@ -22,9 +25,22 @@ type MessageTraceHandlers struct {
 }
 
 // NewMessageTraceHandlers creates a new message trace handlers instance
-func NewMessageTraceHandlers(repository *database.Repository, queueService *queue.Service, logService *logprocessor.Service) *MessageTraceHandlers {
+func NewMessageTraceHandlers(repository *database.Repository, queueService *queue.Service, logService *logprocessor.Service, contentRedaction bool, eximConfigFile string) *MessageTraceHandlers {
 	traceRepo := database.NewMessageTraceRepository(repository.GetDB())
 
+	// Message content previews are read from the spool through the queue service
+	if queueService != nil {
+		traceRepo.SetMessageSource(queueService)
+	}
+	contentOptions := database.DefaultContentPreviewOptions()
+	contentOptions.ContentRedaction = contentRedaction
+	traceRepo.SetContentPreviewOptions(contentOptions)
+
+	// Retry schedules follow the retry rules of the Exim configuration
+	if eximConfigFile != "" {
+		traceRepo.SetRetryPolicy(parser.NewRetryConfigFile(eximConfigFile))
+	}
+
 	return &MessageTraceHandlers{
 		traceRepository: traceRepo,
 		queueService:    queueService,
@@ -180,8 +196,25 @@ func (h *MessageTraceHandlers) handleRetrySchedule(w http.ResponseWriter, r *htt
 		}
 	}
 
+	// Next attempt and bounce deadline of each deferred recipient
+	recipients := []map[string]interface{}{}
+	for _, status := range trace.Recipients {
+		if status.Status != database.RecipientStatusDeferred {
+			continue
+		}
+		recipients = append(recipients, map[string]interface{}{
+			"recipient":       status.Recipient,
+			"attempt_count":   status.AttemptCount,
+			"last_attempt_at": status.LastAttemptAt,
+			"next_retry_at":   status.NextRetryAt,
+			"bounce_deadline": status.BounceDeadline,
+			"retry_rule":      status.RetryRule,
+		})
+	}
+
 	response := map[string]interface{}{
 		"message_id":     messageID,
+		"recipients":     recipients,
 		"retry_schedule": trace.RetrySchedule,
 		"queue_info":     queueRetryInfo,
 		"deferred_count": trace.Summary.DeferredCount,
@@ -332,7 +365,7 @@ func (h *MessageTraceHandlers) getRelatedLogEntries(messageID, recipient string,
 	endTime := attemptTime.Add(5 * time.Minute)
 
 	query := `
-		SELECT id, timestamp, message_id, log_type, event, host, sender, recipients, size, status, error_code, error_text, raw_line, created_at
+		SELECT ` + database.LogEntryColumns + `
 		FROM log_entries 
 		WHERE message_id = ? AND timestamp BETWEEN ? AND ?
 		ORDER BY timestamp`
@@ -346,7 +379,7 @@ func (h *MessageTraceHandlers) getRelatedLogEntries(messageID, recipient string,
 	var entries []database.LogEntry
 	for rows.Next() {
 		var entry database.LogEntry
-		err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.MessageID, &entry.LogType, &entry.Event, &entry.Host, &entry.Sender, &entry.RecipientsDB, &entry.Size, &entry.Status, &entry.ErrorCode, &entry.ErrorText, &entry.RawLine, &entry.CreatedAt)
+		err := rows.Scan(entry.ScanFields()...)
 		if err != nil {
 			return nil, err
 		}
@@ -446,10 +479,19 @@ func (h *MessageTraceHandlers) handleMessageContent(w http.ResponseWriter, r *ht
 		return
 	}
 
+	if err := h.validateMessageID(messageID); err != nil {
+		WriteBadRequestResponse(w, "Invalid message ID format: "+err.Error())
+		return
+	}
+
 	// Get message content
 	content, err := h.traceRepository.GetMessageContent(messageID)
 	if err != nil {
-		WriteInternalErrorResponse(w, "Failed to get message content: "+err.Error())
+		if errors.Is(err, os.ErrNotExist) {
+			WriteNotFoundResponse(w, "Message not found in queue")
+		} else {
+			WriteInternalErrorResponse(w, "Failed to get message content: "+err.Error())
+		}
 		return
 	}
 
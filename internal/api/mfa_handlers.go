@@ -0,0 +1,151 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// mfaCodeRequest carries a TOTP or recovery code
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// handleMFAStatus handles GET /api/v1/auth/mfa - Second-factor status of the current user
+func (h *AuthHandlers) handleMFAStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := h.authService.GetMFAStatus(user.ID)
+	if err != nil {
+		WriteInternalErrorResponse(w, "Failed to get two-factor status: "+err.Error())
+		return
+	}
+
+	WriteSuccessResponse(w, status)
+}
+
+// handleTOTPEnroll handles POST /api/v1/auth/mfa/totp - Start TOTP enrollment.
+// The returned provisioning URI is meant to be rendered as a QR code.
+func (h *AuthHandlers) handleTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	enrollment, err := h.authService.BeginTOTPEnrollment(user.ID)
+	if err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	WriteSuccessResponse(w, enrollment)
+}
+
+// handleTOTPConfirm handles POST /api/v1/auth/mfa/totp/confirm - Enable TOTP with a first code
+func (h *AuthHandlers) handleTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req mfaCodeRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	codes, err := h.authService.ConfirmTOTPEnrollment(user.ID, req.Code, newAuditContext(r))
+	if err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{
+		"recovery_codes": codes,
+		"message":        "Two-factor authentication enabled. Store the recovery codes somewhere safe, they are shown only once",
+	})
+}
+
+// handleTOTPDisable handles DELETE /api/v1/auth/mfa/totp - Disable TOTP with a current code
+func (h *AuthHandlers) handleTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req mfaCodeRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	if err := h.authService.DisableTOTP(user.ID, req.Code, newAuditContext(r)); err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// handleRecoveryCodes handles POST /api/v1/auth/mfa/recovery-codes - Replace recovery codes
+func (h *AuthHandlers) handleRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req mfaCodeRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	codes, err := h.authService.RegenerateRecoveryCodes(user.ID, req.Code, newAuditContext(r))
+	if err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
+
+// sessionUser returns the user of a cookie-authenticated request. Second-factor settings
+// belong to interactive logins, so API tokens cannot change them.
+func (h *AuthHandlers) sessionUser(w http.ResponseWriter, r *http.Request) (*database.User, bool) {
+	if _, usingToken := GetAPITokenFromContext(r.Context()); usingToken {
+		WriteForbiddenResponse(w, "Two-factor settings cannot be changed with an API token")
+		return nil, false
+	}
+
+	user, ok := GetUserFromContext(r.Context())
+	if !ok {
+		WriteUnauthorizedResponse(w, "Authentication required")
+		return nil, false
+	}
+
+	return user, true
+}
+
+// handleResetUserMFA handles DELETE /api/v1/users/{id}/mfa - Remove a user's second factor
+func (h *UserHandlers) handleResetUserMFA(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.parseUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.authService.ResetTOTP(userID, newAuditContext(r)); err != nil {
+		h.writeUserError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{
+		"message": "Two-factor authentication reset and sessions revoked",
+	})
+}
@@ -10,8 +10,10 @@ import (
 	"time"
 
 	"github.com/andreitelteu/exim-pilot/internal/audit"
+	"github.com/andreitelteu/exim-pilot/internal/auth"
 	"github.com/andreitelteu/exim-pilot/internal/database"
 	"github.com/andreitelteu/exim-pilot/internal/validation"
+	"github.com/gorilla/mux"
 )
 
 // loggingMiddleware logs all HTTP requests
@@ -42,6 +44,32 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// metricsMiddleware records the latency of each request by route template
+func (s *Server) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || strings.HasPrefix(r.URL.Path, eventStreamPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		start := time.Now()
+		lrw := &loggingResponseWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+		next.ServeHTTP(lrw, r)
+
+		s.config.Metrics.ObserveHTTPRequest(r.Method, route, lrw.statusCode, time.Since(start))
+	})
+}
+
 // errorHandlingMiddleware provides centralized error handling
 func (s *Server) errorHandlingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -71,8 +99,40 @@ func (s *Server) authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// API tokens are presented as bearer tokens instead of a session cookie
+		if authorization := r.Header.Get("Authorization"); authorization != "" {
+			s.authenticateAPIToken(w, r, authorization, next)
+			return
+		}
+
+		// Behind an authenticating reverse proxy the user comes from trusted headers
+		if s.config.TrustedHeaderAuth != nil {
+			identity, err := s.config.TrustedHeaderAuth.Identify(r.RemoteAddr, r.Header)
+			if err != nil {
+				WriteForbiddenResponse(w, "Your account is not authorized to use this application")
+				return
+			}
+			if identity != nil {
+				// The proxy authenticates browsers by its own cookie, so cross-site requests
+				// would carry the headers too
+				if s.config.CSRFProtection && !isSafeMethod(r.Method) && !s.sameOriginRequest(r) {
+					WriteForbiddenResponse(w, "Cross-site request refused")
+					return
+				}
+
+				user, err := s.authService.ResolveIdentity(identity)
+				if err != nil {
+					log.Printf("Trusted header authentication for %s failed: %v", identity.Username, err)
+					WriteForbiddenResponse(w, "Your account is not authorized to use this application")
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(SetUserInContext(r.Context(), user)))
+				return
+			}
+		}
+
 		// Get session ID from cookie
-		cookie, err := r.Cookie("session_id")
+		cookie, err := r.Cookie(sessionCookieName)
 		if err != nil {
 			response := APIResponse{
 				Success: false,
@@ -93,6 +153,14 @@ func (s *Server) authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Browsers attach the cookie to cross-site requests as well, so state-changing
+		// requests must prove they come from our pages with the session's CSRF token
+		if s.config.CSRFProtection && !isSafeMethod(r.Method) &&
+			!s.authService.ValidateCSRFToken(cookie.Value, r.Header.Get(csrfHeader)) {
+			WriteForbiddenResponse(w, "Invalid or missing CSRF token")
+			return
+		}
+
 		// Add user to request context
 		ctx := r.Context()
 		ctx = SetUserInContext(ctx, user)
@@ -102,6 +170,76 @@ func (s *Server) authMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// authenticateAPIToken validates an Authorization header and serves the request as the token owner
+func (s *Server) authenticateAPIToken(w http.ResponseWriter, r *http.Request, authorization string, next http.Handler) {
+	scheme, secret, found := strings.Cut(authorization, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(secret) == "" {
+		WriteUnauthorizedResponse(w, "Invalid authorization header, expected 'Bearer <token>'")
+		return
+	}
+
+	// API tokens can be limited to clients holding a certificate from the client CA
+	if s.config.RequireTokenClientCert && !hasVerifiedClientCertificate(r) {
+		WriteUnauthorizedResponse(w, "API tokens require a client certificate")
+		return
+	}
+
+	user, token, err := s.authService.ValidateAPIToken(strings.TrimSpace(secret), getClientIPFromRequest(r))
+	if err != nil {
+		WriteUnauthorizedResponse(w, "Invalid API token")
+		return
+	}
+
+	ctx := SetUserInContext(r.Context(), user)
+	ctx = SetAPITokenInContext(ctx, token)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// requirePermission wraps a handler so that it is only reachable by users whose role
+// grants the permission. It must run behind authMiddleware.
+func (s *Server) requirePermission(permission auth.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			WriteUnauthorizedResponse(w, "Authentication required")
+			return
+		}
+
+		if !auth.HasPermission(user.Role, permission) {
+			auditCtx := newAuditContext(r)
+			errorMsg := fmt.Sprintf("role %q lacks permission %q", user.Role, permission)
+			if err := s.auditService.LogSystemAccess(r.Context(), r.Method+" "+r.URL.Path, auditCtx, false, errorMsg); err != nil {
+				log.Printf("Failed to log denied access: %v", err)
+			}
+
+			WriteForbiddenResponse(w, fmt.Sprintf("Forbidden: your role '%s' does not have the '%s' permission required for this action", user.Role, permission))
+			return
+		}
+
+		// Optionally insist on a second factor before interactive users may change the queue
+		if permission == auth.PermissionQueueMutate && s.config.RequireTOTPForMutate {
+			if _, usingToken := GetAPITokenFromContext(r.Context()); !usingToken && !s.authService.IsTOTPEnabled(user.ID) {
+				WriteForbiddenResponse(w, "Forbidden: enable two-factor authentication to perform this action")
+				return
+			}
+		}
+
+		// Tokens are limited to their scopes on top of the owner's role
+		if token, ok := GetAPITokenFromContext(r.Context()); ok && !auth.TokenHasScope(token, permission) {
+			auditCtx := newAuditContext(r)
+			errorMsg := fmt.Sprintf("API token %d lacks scope %q", token.ID, permission)
+			if err := s.auditService.LogSystemAccess(r.Context(), r.Method+" "+r.URL.Path, auditCtx, false, errorMsg); err != nil {
+				log.Printf("Failed to log denied access: %v", err)
+			}
+
+			WriteForbiddenResponse(w, fmt.Sprintf("Forbidden: this API token does not have the '%s' scope required for this action", permission))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
 // loggingResponseWriter wraps http.ResponseWriter to capture status code
 type loggingResponseWriter struct {
 	http.ResponseWriter
@@ -122,10 +260,18 @@ func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
 	return lrw.ResponseWriter.Write(b)
 }
 
+// Unwrap exposes the underlying writer so http.ResponseController can flush streamed responses
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
 // Context utilities for user authentication
 type contextKey string
 
-const userContextKey contextKey = "user"
+const (
+	userContextKey     contextKey = "user"
+	apiTokenContextKey contextKey = "api_token"
+)
 
 // SetUserInContext adds a user to the request context
 func SetUserInContext(ctx context.Context, user *database.User) context.Context {
@@ -138,6 +284,17 @@ func GetUserFromContext(ctx context.Context) (*database.User, bool) {
 	return user, ok
 }
 
+// SetAPITokenInContext records the API token that authenticated the request
+func SetAPITokenInContext(ctx context.Context, token *database.APIToken) context.Context {
+	return context.WithValue(ctx, apiTokenContextKey, token)
+}
+
+// GetAPITokenFromContext retrieves the API token that authenticated the request, if any
+func GetAPITokenFromContext(ctx context.Context) (*database.APIToken, bool) {
+	token, ok := ctx.Value(apiTokenContextKey).(*database.APIToken)
+	return token, ok
+}
+
 // contentTypeMiddleware ensures proper content-type headers are set
 func (s *Server) contentTypeMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -181,10 +338,9 @@ func (s *Server) validationMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// auditMiddleware logs all administrative actions
+// auditMiddleware logs message access and changes under the authenticated user or API
+// token. It must run behind authMiddleware.
 func (s *Server) auditMiddleware(next http.Handler) http.Handler {
-	auditService := audit.NewService(s.repository)
-
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Skip audit logging for read-only operations
 		if r.Method == "GET" && !s.isAuditableEndpoint(r.URL.Path) {
@@ -192,20 +348,8 @@ func (s *Server) auditMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Get user context
-		user, ok := GetUserFromContext(r.Context())
-		if !ok {
-			// If no user context, still proceed but log as anonymous
-			user = &database.User{ID: 0}
-		}
-
 		// Create audit context
-		auditCtx := &audit.AuditContext{
-			UserID:    getUserIDString(user),
-			IPAddress: getClientIPFromRequest(r),
-			UserAgent: r.UserAgent(),
-			RequestID: generateRequestID(),
-		}
+		auditCtx := newAuditContext(r)
 
 		// Determine action type based on request
 		action := s.determineAuditAction(r)
@@ -222,11 +366,11 @@ func (s *Server) auditMiddleware(next http.Handler) http.Handler {
 
 			// Extract message ID if present in path
 			var messageID *string
-			if mid := extractMessageIDFromPath(r.URL.Path); mid != "" {
+			if mid := routeMessageID(r); mid != "" {
 				messageID = &mid
 			}
 
-			err := auditService.LogAction(r.Context(), action, messageID, auditCtx, details)
+			err := s.auditService.LogAction(r.Context(), action, messageID, auditCtx, details)
 			if err != nil {
 				log.Printf("Failed to log audit action: %v", err)
 			}
@@ -247,6 +391,11 @@ func (s *Server) securityHeadersMiddleware(next http.Handler) http.Handler {
 		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
 		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'")
 
+		// Browsers that reached us over HTTPS keep using it
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", "max-age=31536000")
+		}
+
 		// Remove server information
 		w.Header().Set("Server", "Exim-Pilot")
 
@@ -277,7 +426,7 @@ func (s *Server) validateCommonParams(r *http.Request, validator *validation.Ser
 	}
 
 	// Validate message ID in path if present
-	if messageID := extractMessageIDFromPath(r.URL.Path); messageID != "" {
+	if messageID := routeMessageID(r); messageID != "" {
 		if err := validator.ValidateMessageID(messageID); err != nil {
 			return err
 		}
@@ -290,7 +439,6 @@ func (s *Server) isAuditableEndpoint(path string) bool {
 	// Define endpoints that should be audited even for GET requests
 	auditableGETEndpoints := []string{
 		"/api/v1/messages/",
-		"/api/v1/queue/",
 	}
 
 	for _, endpoint := range auditableGETEndpoints {
@@ -306,32 +454,8 @@ func (s *Server) determineAuditAction(r *http.Request) audit.ActionType {
 	path := r.URL.Path
 	method := r.Method
 
-	// Queue operations
-	if contains(path, "/api/v1/queue/") {
-		if contains(path, "/deliver") && method == "POST" {
-			return audit.ActionQueueDeliver
-		}
-		if contains(path, "/freeze") && method == "POST" {
-			return audit.ActionQueueFreeze
-		}
-		if contains(path, "/thaw") && method == "POST" {
-			return audit.ActionQueueThaw
-		}
-		if method == "DELETE" {
-			return audit.ActionQueueDelete
-		}
-		if contains(path, "/bulk") && method == "POST" {
-			return audit.ActionBulkDeliver // Will be refined based on operation
-		}
-	}
-
-	// Authentication operations
-	if contains(path, "/api/v1/auth/login") && method == "POST" {
-		return audit.ActionLogin
-	}
-	if contains(path, "/api/v1/auth/logout") && method == "POST" {
-		return audit.ActionLogout
-	}
+	// Queue operations, logins and logouts are audited with their outcome by the queue
+	// manager and the auth service, so only message operations are recorded here
 
 	// Message operations
 	if contains(path, "/api/v1/messages/") {
@@ -363,6 +487,26 @@ func (s *Server) determineAuditAction(r *http.Request) audit.ActionType {
 
 // Helper utility functions
 
+// newAuditContext builds an audit context for the authenticated user of a request
+func newAuditContext(r *http.Request) *audit.AuditContext {
+	return &audit.AuditContext{
+		UserID:    auditUserID(r),
+		IPAddress: getClientIPFromRequest(r),
+		UserAgent: r.UserAgent(),
+		RequestID: generateRequestID(),
+	}
+}
+
+// auditUserID returns the identity recorded for the request: the API token when one was
+// used, otherwise the session user
+func auditUserID(r *http.Request) string {
+	if token, ok := GetAPITokenFromContext(r.Context()); ok {
+		return auth.TokenAuditUserID(token)
+	}
+	user, _ := GetUserFromContext(r.Context())
+	return getUserIDString(user)
+}
+
 func getUserIDString(user *database.User) string {
 	if user == nil {
 		return "anonymous"
@@ -396,15 +540,13 @@ func generateRequestID() string {
 	return fmt.Sprintf("req_%d", time.Now().UnixNano())
 }
 
-func extractMessageIDFromPath(path string) string {
-	// Extract message ID from paths like /api/v1/queue/{id} or /api/v1/messages/{id}
-	parts := strings.Split(path, "/")
-	for i, part := range parts {
-		if (part == "queue" || part == "messages") && i+1 < len(parts) {
-			return parts[i+1]
-		}
+// routeMessageID returns the message ID of queue and message routes such as
+// /api/v1/queue/{id} or /api/v1/messages/{id}/trace
+func routeMessageID(r *http.Request) string {
+	if !contains(r.URL.Path, "/queue/") && !contains(r.URL.Path, "/messages/") {
+		return ""
 	}
-	return ""
+	return mux.Vars(r)["id"]
 }
 
 func getIntParam(param string, defaultValue int) (int, error) {
@@ -1,12 +1,13 @@
 package api
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/andreitelteu/exim-pilot/internal/database"
 	"github.com/andreitelteu/exim-pilot/internal/queue"
 	"github.com/andreitelteu/exim-pilot/internal/validation"
 	"github.com/andreitelteu/exim-pilot/internal/websocket"
@@ -380,13 +381,9 @@ func (h *QueueHandlers) handleQueueBulk(w http.ResponseWriter, r *http.Request)
 
 // Helper methods
 
-// getUserID extracts user ID from request context
+// getUserID extracts the audited identity from request context
 func (h *QueueHandlers) getUserID(r *http.Request) string {
-	user, ok := GetUserFromContext(r.Context())
-	if !ok {
-		return "anonymous"
-	}
-	return fmt.Sprintf("%d", user.ID)
+	return auditUserID(r)
 }
 
 // getClientIP extracts client IP address from request
@@ -424,6 +421,99 @@ func (h *QueueHandlers) handleQueueHealth(w http.ResponseWriter, r *http.Request
 	WriteSuccessResponse(w, health)
 }
 
+// handleQueueSizeHistory handles GET /api/v1/queue/history - Queue size over time from recorded snapshots
+func (h *QueueHandlers) handleQueueSizeHistory(w http.ResponseWriter, r *http.Request) {
+	end := time.Now()
+	if endStr := GetQueryParam(r, "end", ""); endStr != "" {
+		parsed, err := time.Parse(time.RFC3339, endStr)
+		if err != nil {
+			WriteBadRequestResponse(w, "Invalid end time, expected RFC3339")
+			return
+		}
+		end = parsed
+	}
+
+	start := end.Add(-24 * time.Hour)
+	if startStr := GetQueryParam(r, "start", ""); startStr != "" {
+		parsed, err := time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			WriteBadRequestResponse(w, "Invalid start time, expected RFC3339")
+			return
+		}
+		start = parsed
+	}
+
+	if !end.After(start) {
+		WriteBadRequestResponse(w, "End time must be after start time")
+		return
+	}
+
+	points, err := strconv.Atoi(GetQueryParam(r, "points", strconv.Itoa(database.DefaultQueueHistoryPoints)))
+	if err != nil || points <= 0 {
+		WriteBadRequestResponse(w, "Invalid points parameter")
+		return
+	}
+	if points > 2000 {
+		points = 2000
+	}
+
+	history, err := h.queueService.GetQueueHistory(start, end, points)
+	if err != nil {
+		WriteInternalErrorResponse(w, "Failed to retrieve queue history")
+		return
+	}
+
+	WriteSuccessResponse(w, history)
+}
+
+// handleRetryState handles GET /api/v1/queue/retry-state - Hosts, domains and addresses in retry back-off
+func (h *QueueHandlers) handleRetryState(w http.ResponseWriter, r *http.Request) {
+	activeOnly := GetQueryParam(r, "active", "false") == "true"
+
+	state, err := h.queueService.GetRetryState(activeOnly)
+	if err != nil {
+		WriteInternalErrorResponse(w, "Failed to read Exim hints databases: "+err.Error())
+		return
+	}
+
+	WriteSuccessResponse(w, state)
+}
+
+// handleClearRetryRecord handles POST /api/v1/queue/retry-state/clear - Delete a retry record like exim_fixdb
+func (h *QueueHandlers) handleClearRetryRecord(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Key string `json:"key"`
+	}
+	if err := ParseJSONBody(r, &request); err != nil {
+		WriteBadRequestResponse(w, "Invalid request body: "+err.Error())
+		return
+	}
+	if request.Key == "" {
+		WriteBadRequestResponse(w, "Retry record key is required")
+		return
+	}
+
+	result, err := h.queueService.ClearRetryRecord(request.Key, h.getUserID(r), h.getClientIP(r))
+	if errors.Is(err, queue.ErrRetryRecordNotFound) {
+		WriteNotFoundResponse(w, "Retry record not found")
+		return
+	}
+	if err != nil {
+		WriteInternalErrorResponse(w, "Failed to clear retry record: "+err.Error())
+		return
+	}
+
+	if h.wsService != nil {
+		h.wsService.BroadcastQueueUpdate(map[string]interface{}{
+			"action":    "retry_clear",
+			"key":       request.Key,
+			"status":    "success",
+			"timestamp": time.Now().UTC(),
+		})
+	}
+	WriteSuccessResponse(w, result)
+}
+
 // handleQueueStatistics handles GET /api/v1/queue/statistics - Get detailed queue statistics
 func (h *QueueHandlers) handleQueueStatistics(w http.ResponseWriter, r *http.Request) {
 	stats, err := h.queueService.GetQueueStatistics()
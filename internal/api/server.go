@@ -3,10 +3,12 @@ package api
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/andreitelteu/exim-pilot/internal/audit"
 	"github.com/andreitelteu/exim-pilot/internal/auth"
 	"github.com/andreitelteu/exim-pilot/internal/database"
 	"github.com/andreitelteu/exim-pilot/internal/logprocessor"
@@ -26,7 +28,15 @@ type Server struct {
 	logService       *logprocessor.Service
 	repository       *database.Repository
 	authService      *auth.Service
+	auditService     *audit.Service
 	websocketService *websocket.Service
+
+	// Proxies whose forwarded client addresses are believed, see Config.TrustedProxies
+	trustedProxies []*net.IPNet
+
+	// Set when serving HTTPS
+	certificates   *certificateReloader
+	redirectServer *http.Server
 }
 
 // NewServer creates a new API server instance
@@ -43,39 +53,60 @@ func NewServer(config *Config, queueService *queue.Service, logService *logproce
 		logService:       logService,
 		repository:       repository,
 		authService:      auth.NewService(db),
+		auditService:     audit.NewService(repository),
 		websocketService: websocket.NewService(),
 	}
 
-	s.setupRoutes()     // Setup routes first
-	s.setupMiddleware() // Apply middleware after
+	s.authService.SetPasswordPolicy(config.PasswordMinLength, config.RequireStrongPassword)
+	s.authService.SetTOTPIssuer(config.TOTPIssuer)
+	s.authService.SetLoginLimits(config.MaxLoginAttempts, config.LoginLockoutTime)
+	s.authService.SetAuthenticators(config.Authenticators...)
+	s.auditService.SetCheckpointKey(config.AuditSigningKey)
+
+	trustedProxies, err := auth.ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		log.Printf("Ignoring trusted proxies for login throttling: %v", err)
+	}
+	s.trustedProxies = trustedProxies
+
+	s.setupRoutes()
 
 	return s
 }
 
-// setupMiddleware configures all middleware for the server
-func (s *Server) setupMiddleware() {
+// setupMiddleware configures the middleware of the routers serving the API. Middleware
+// only runs for routes registered on the router it is attached to or below it, so it is
+// attached to the /api/v1 subrouter rather than to a separate /api one.
+func (s *Server) setupMiddleware(api, protected *mux.Router) {
 	// Create CORS middleware for API routes only
 	corsHandler := handlers.CORS(
 		handlers.AllowedOrigins(s.config.AllowedOrigins),
 		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Authorization", "X-Requested-With"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Authorization", "X-Requested-With", csrfHeader}),
+		handlers.ExposedHeaders([]string{csrfHeader}),
 		handlers.AllowCredentials(),
 	)
 
-	// Apply middleware only to API routes, not WebSocket routes
-	apiRouter := s.router.PathPrefix("/api").Subrouter()
+	// Request latencies of all matched routes; WebSocket connections and event streams are long-lived and skipped
+	if s.config.Metrics != nil {
+		s.router.Use(s.metricsMiddleware)
+	}
+	s.router.Use(s.securityHeadersMiddleware)
 
-	apiRouter.Use(corsHandler)
-	apiRouter.Use(s.securityHeadersMiddleware)
+	// The remaining middleware applies to API routes only, not the WebSocket or metrics routes
+	api.Use(corsHandler)
 
 	if s.config.LogRequests {
-		apiRouter.Use(s.loggingMiddleware)
+		api.Use(s.loggingMiddleware)
 	}
 
-	apiRouter.Use(s.errorHandlingMiddleware)
-	apiRouter.Use(s.contentTypeMiddleware)
-	apiRouter.Use(s.validationMiddleware)
-	apiRouter.Use(s.auditMiddleware)
+	api.Use(s.errorHandlingMiddleware)
+	api.Use(s.contentTypeMiddleware)
+	api.Use(s.validationMiddleware)
+
+	// Audited actions are recorded under the user or API token authMiddleware resolved
+	protected.Use(s.authMiddleware)
+	protected.Use(s.auditMiddleware)
 }
 
 // setupRoutes configures all API routes
@@ -83,44 +114,119 @@ func (s *Server) setupRoutes() {
 	// WebSocket endpoint - registered directly without middleware
 	s.router.HandleFunc("/ws", s.handleWebSocket).Methods("GET")
 
+	// Prometheus metrics, checked by network and token instead of the session login
+	if s.config.Metrics != nil {
+		s.router.Handle(s.config.MetricsPath, s.config.Metrics).Methods("GET")
+	}
+
 	// API v1 routes
 	api := s.router.PathPrefix("/api/v1").Subrouter()
 
 	// Health check endpoint (no auth required)
 	api.HandleFunc("/health", s.handleHealth).Methods("GET")
 
+	// Server-Sent Events mirroring the WebSocket feeds. Like /ws they authenticate
+	// themselves so credentials can be checked again while a stream lasts.
+	api.HandleFunc("/events/logs", s.handleLogEvents).Methods("GET")
+	api.HandleFunc("/events/messages/{id}", s.handleMessageEvents).Methods("GET")
+	api.HandleFunc("/events/queue", s.handleBroadcastEvents(websocket.QueueUpdateMessage)).Methods("GET")
+	api.HandleFunc("/events/dashboard", s.handleBroadcastEvents(websocket.DashboardUpdateMessage)).Methods("GET")
+	api.HandleFunc("/events/alerts", s.handleBroadcastEvents(websocket.SystemAlertMessage)).Methods("GET")
+
 	// Authentication routes (no auth required for login)
-	authHandlers := NewAuthHandlers(s.authService)
+	authHandlers := NewAuthHandlers(s.authService, s.trustedProxies, newCookiePolicy(s.config))
 	api.HandleFunc("/auth/login", authHandlers.handleLogin).Methods("POST")
+	api.HandleFunc("/auth/login/mfa", authHandlers.handleLoginMFA).Methods("POST")
 
 	// Protected routes - apply auth middleware
 	protected := api.PathPrefix("").Subrouter()
-	protected.Use(s.authMiddleware)
+	s.setupMiddleware(api, protected)
 
 	// Auth routes that require authentication
 	protected.HandleFunc("/auth/logout", authHandlers.handleLogout).Methods("POST")
 	protected.HandleFunc("/auth/me", authHandlers.handleMe).Methods("GET")
 
+	// Two-factor authentication for the current user
+	protected.HandleFunc("/auth/mfa", authHandlers.handleMFAStatus).Methods("GET")
+	protected.HandleFunc("/auth/mfa/totp", authHandlers.handleTOTPEnroll).Methods("POST")
+	protected.HandleFunc("/auth/mfa/totp/confirm", authHandlers.handleTOTPConfirm).Methods("POST")
+	protected.HandleFunc("/auth/mfa/totp", authHandlers.handleTOTPDisable).Methods("DELETE")
+	protected.HandleFunc("/auth/mfa/recovery-codes", authHandlers.handleRecoveryCodes).Methods("POST")
+
+	// User management routes - Admin only
+	userHandlers := NewUserHandlers(s.authService)
+	protected.HandleFunc("/users", s.requirePermission(auth.PermissionAdmin, userHandlers.handleListUsers)).Methods("GET")
+	protected.HandleFunc("/users", s.requirePermission(auth.PermissionAdmin, userHandlers.handleCreateUser)).Methods("POST")
+	protected.HandleFunc("/users/{id}", s.requirePermission(auth.PermissionAdmin, userHandlers.handleGetUser)).Methods("GET")
+	protected.HandleFunc("/users/{id}", s.requirePermission(auth.PermissionAdmin, userHandlers.handleUpdateUser)).Methods("PUT")
+	protected.HandleFunc("/users/{id}", s.requirePermission(auth.PermissionAdmin, userHandlers.handleDisableUser)).Methods("DELETE")
+	protected.HandleFunc("/users/{id}/enable", s.requirePermission(auth.PermissionAdmin, userHandlers.handleEnableUser)).Methods("POST")
+	protected.HandleFunc("/users/{id}/role", s.requirePermission(auth.PermissionAdmin, userHandlers.handleSetUserRole)).Methods("POST")
+	protected.HandleFunc("/users/{id}/link", s.requirePermission(auth.PermissionAdmin, userHandlers.handleLinkExternalUser)).Methods("POST")
+	protected.HandleFunc("/users/{id}/password", s.requirePermission(auth.PermissionAdmin, userHandlers.handleResetPassword)).Methods("POST")
+	protected.HandleFunc("/users/{id}/sessions", s.requirePermission(auth.PermissionAdmin, userHandlers.handleRevokeSessions)).Methods("DELETE")
+	protected.HandleFunc("/users/{id}/mfa", s.requirePermission(auth.PermissionAdmin, userHandlers.handleResetUserMFA)).Methods("DELETE")
+	protected.HandleFunc("/auth/lockouts", s.requirePermission(auth.PermissionAdmin, userHandlers.handleListLockouts)).Methods("GET")
+	protected.HandleFunc("/auth/lockouts", s.requirePermission(auth.PermissionAdmin, userHandlers.handleClearLockout)).Methods("DELETE")
+
+	// API token routes; every authenticated user manages their own tokens
+	tokenHandlers := NewTokenHandlers(s.authService)
+	protected.HandleFunc("/tokens", tokenHandlers.handleListTokens).Methods("GET")
+	protected.HandleFunc("/tokens", tokenHandlers.handleCreateToken).Methods("POST")
+	protected.HandleFunc("/tokens/{id}", tokenHandlers.handleRevokeToken).Methods("DELETE")
+
+	// Audit log routes
+	auditHandlers := NewAuditHandlers(s.auditService)
+	protected.HandleFunc("/audit", s.requirePermission(auth.PermissionAuditRead, auditHandlers.handleAuditList)).Methods("GET")
+	protected.HandleFunc("/audit/export", s.requirePermission(auth.PermissionAuditRead, auditHandlers.handleAuditExport)).Methods("GET")
+	protected.HandleFunc("/audit/integrity", s.requirePermission(auth.PermissionAuditRead, auditHandlers.handleAuditIntegrity)).Methods("GET")
+	protected.HandleFunc("/audit/checkpoints", s.requirePermission(auth.PermissionAuditRead, auditHandlers.handleCreateCheckpoint)).Methods("POST")
+	protected.HandleFunc("/audit/checkpoints/verify", s.requirePermission(auth.PermissionAuditRead, auditHandlers.handleVerifyCheckpoint)).Methods("POST")
+
+	// Alert rules and history
+	if s.config.AlertEngine != nil {
+		alertHandlers := NewAlertHandlers(s.config.AlertEngine)
+
+		protected.HandleFunc("/alerts", s.requirePermission(auth.PermissionQueueRead, alertHandlers.handleAlertHistory)).Methods("GET")
+		protected.HandleFunc("/alerts/rules", s.requirePermission(auth.PermissionQueueRead, alertHandlers.handleListAlertRules)).Methods("GET")
+		protected.HandleFunc("/alerts/rules", s.requirePermission(auth.PermissionAdmin, alertHandlers.handleCreateAlertRule)).Methods("POST")
+		protected.HandleFunc("/alerts/rules/{name}", s.requirePermission(auth.PermissionQueueRead, alertHandlers.handleGetAlertRule)).Methods("GET")
+		protected.HandleFunc("/alerts/rules/{name}", s.requirePermission(auth.PermissionAdmin, alertHandlers.handleUpdateAlertRule)).Methods("PUT")
+		protected.HandleFunc("/alerts/rules/{name}", s.requirePermission(auth.PermissionAdmin, alertHandlers.handleDeleteAlertRule)).Methods("DELETE")
+		protected.HandleFunc("/alerts/notifiers/{name}/test", s.requirePermission(auth.PermissionAdmin, alertHandlers.handleTestNotifier)).Methods("POST")
+	}
+
+	// Database backups; restoring needs the server stopped and is done with exim-pilot-config
+	if s.config.Backups != nil {
+		backupHandlers := NewBackupHandlers(s.config.Backups)
+
+		protected.HandleFunc("/backups", s.requirePermission(auth.PermissionAdmin, backupHandlers.handleListBackups)).Methods("GET")
+		protected.HandleFunc("/backups", s.requirePermission(auth.PermissionAdmin, backupHandlers.handleCreateBackup)).Methods("POST")
+	}
+
 	// Queue management routes (Task 5.2) - Protected
 	if s.queueService != nil {
 		queueHandlers := NewQueueHandlers(s.queueService, s.websocketService)
 
 		// Queue listing and search
-		protected.HandleFunc("/queue", queueHandlers.handleQueueList).Methods("GET")
-		protected.HandleFunc("/queue/search", queueHandlers.handleQueueSearch).Methods("POST")
-		protected.HandleFunc("/queue/health", queueHandlers.handleQueueHealth).Methods("GET")
-		protected.HandleFunc("/queue/statistics", queueHandlers.handleQueueStatistics).Methods("GET")
+		protected.HandleFunc("/queue", s.requirePermission(auth.PermissionQueueRead, queueHandlers.handleQueueList)).Methods("GET")
+		protected.HandleFunc("/queue/search", s.requirePermission(auth.PermissionQueueRead, queueHandlers.handleQueueSearch)).Methods("POST")
+		protected.HandleFunc("/queue/health", s.requirePermission(auth.PermissionQueueRead, queueHandlers.handleQueueHealth)).Methods("GET")
+		protected.HandleFunc("/queue/statistics", s.requirePermission(auth.PermissionQueueRead, queueHandlers.handleQueueStatistics)).Methods("GET")
+		protected.HandleFunc("/queue/history", s.requirePermission(auth.PermissionQueueRead, queueHandlers.handleQueueSizeHistory)).Methods("GET")
+		protected.HandleFunc("/queue/retry-state", s.requirePermission(auth.PermissionQueueRead, queueHandlers.handleRetryState)).Methods("GET")
+		protected.HandleFunc("/queue/retry-state/clear", s.requirePermission(auth.PermissionQueueMutate, queueHandlers.handleClearRetryRecord)).Methods("POST")
 
 		// Individual message operations
-		protected.HandleFunc("/queue/{id}", queueHandlers.handleQueueDetails).Methods("GET")
-		protected.HandleFunc("/queue/{id}/deliver", queueHandlers.handleQueueDeliver).Methods("POST")
-		protected.HandleFunc("/queue/{id}/freeze", queueHandlers.handleQueueFreeze).Methods("POST")
-		protected.HandleFunc("/queue/{id}/thaw", queueHandlers.handleQueueThaw).Methods("POST")
-		protected.HandleFunc("/queue/{id}", queueHandlers.handleQueueDelete).Methods("DELETE")
-		protected.HandleFunc("/queue/{id}/history", queueHandlers.handleQueueHistory).Methods("GET")
+		protected.HandleFunc("/queue/{id}", s.requirePermission(auth.PermissionQueueRead, queueHandlers.handleQueueDetails)).Methods("GET")
+		protected.HandleFunc("/queue/{id}/deliver", s.requirePermission(auth.PermissionQueueMutate, queueHandlers.handleQueueDeliver)).Methods("POST")
+		protected.HandleFunc("/queue/{id}/freeze", s.requirePermission(auth.PermissionQueueMutate, queueHandlers.handleQueueFreeze)).Methods("POST")
+		protected.HandleFunc("/queue/{id}/thaw", s.requirePermission(auth.PermissionQueueMutate, queueHandlers.handleQueueThaw)).Methods("POST")
+		protected.HandleFunc("/queue/{id}", s.requirePermission(auth.PermissionQueueMutate, queueHandlers.handleQueueDelete)).Methods("DELETE")
+		protected.HandleFunc("/queue/{id}/history", s.requirePermission(auth.PermissionQueueRead, queueHandlers.handleQueueHistory)).Methods("GET")
 
 		// Bulk operations
-		protected.HandleFunc("/queue/bulk", queueHandlers.handleQueueBulk).Methods("POST")
+		protected.HandleFunc("/queue/bulk", s.requirePermission(auth.PermissionQueueMutate, queueHandlers.handleQueueBulk)).Methods("POST")
 	}
 
 	// Log and monitoring routes (Task 5.3) - Protected
@@ -128,23 +234,26 @@ func (s *Server) setupRoutes() {
 		logHandlers := NewLogHandlers(s.logService, s.websocketService)
 
 		// Basic log endpoints
-		protected.HandleFunc("/logs", logHandlers.handleLogsList).Methods("GET")
-		protected.HandleFunc("/logs/search", logHandlers.handleLogsSearch).Methods("POST")
-		protected.HandleFunc("/logs/tail", logHandlers.handleLogsTail).Methods("GET")
-		protected.HandleFunc("/logs/export", logHandlers.handleExportLogs).Methods("GET")
-		protected.HandleFunc("/logs/statistics", logHandlers.handleLogStatistics).Methods("GET")
+		protected.HandleFunc("/logs", s.requirePermission(auth.PermissionLogRead, logHandlers.handleLogsList)).Methods("GET")
+		protected.HandleFunc("/logs/search", s.requirePermission(auth.PermissionLogRead, logHandlers.handleLogsSearch)).Methods("POST")
+		protected.HandleFunc("/logs/tail", s.requirePermission(auth.PermissionLogRead, logHandlers.handleLogsTail)).Methods("GET")
+		protected.HandleFunc("/logs/export", s.requirePermission(auth.PermissionLogRead, logHandlers.handleExportLogs)).Methods("GET")
+		protected.HandleFunc("/logs/statistics", s.requirePermission(auth.PermissionLogRead, logHandlers.handleLogStatistics)).Methods("GET")
 
 		// Message-specific log endpoints
-		protected.HandleFunc("/logs/messages/{id}/history", logHandlers.handleMessageHistory).Methods("GET")
-		protected.HandleFunc("/logs/messages/{id}/correlation", logHandlers.handleMessageCorrelation).Methods("GET")
-		protected.HandleFunc("/logs/messages/{id}/similar", logHandlers.handleSimilarMessages).Methods("GET")
+		protected.HandleFunc("/logs/messages/{id}/history", s.requirePermission(auth.PermissionLogRead, logHandlers.handleMessageHistory)).Methods("GET")
+		protected.HandleFunc("/logs/messages/{id}/correlation", s.requirePermission(auth.PermissionLogRead, logHandlers.handleMessageCorrelation)).Methods("GET")
+		protected.HandleFunc("/logs/messages/{id}/similar", s.requirePermission(auth.PermissionLogRead, logHandlers.handleSimilarMessages)).Methods("GET")
 
 		// Service management endpoints
-		protected.HandleFunc("/logs/service/status", logHandlers.handleServiceStatus).Methods("GET")
-		protected.HandleFunc("/logs/correlation/trigger", logHandlers.handleTriggerCorrelation).Methods("POST")
+		protected.HandleFunc("/logs/service/status", s.requirePermission(auth.PermissionLogRead, logHandlers.handleServiceStatus)).Methods("GET")
+		protected.HandleFunc("/logs/correlation/trigger", s.requirePermission(auth.PermissionAdmin, logHandlers.handleTriggerCorrelation)).Methods("POST")
+		protected.HandleFunc("/logs/backfill", s.requirePermission(auth.PermissionAdmin, logHandlers.handleStartBackfill)).Methods("POST")
+		protected.HandleFunc("/logs/backfill", s.requirePermission(auth.PermissionAdmin, logHandlers.handleBackfillStatus)).Methods("GET")
+		protected.HandleFunc("/logs/backfill", s.requirePermission(auth.PermissionAdmin, logHandlers.handleCancelBackfill)).Methods("DELETE")
 
 		// Dashboard endpoint
-		protected.HandleFunc("/dashboard", logHandlers.handleDashboard).Methods("GET")
+		protected.HandleFunc("/dashboard", s.requirePermission(auth.PermissionLogRead, logHandlers.handleDashboard)).Methods("GET")
 	}
 
 	// Reporting routes (Task 5.4) - Protected
@@ -152,78 +261,78 @@ func (s *Server) setupRoutes() {
 		reportsHandlers := NewReportsHandlers(s.logService, s.queueService, s.repository)
 
 		// Core reporting endpoints
-		protected.HandleFunc("/reports/deliverability", reportsHandlers.handleDeliverabilityReport).Methods("GET")
-		protected.HandleFunc("/reports/volume", reportsHandlers.handleVolumeReport).Methods("GET")
-		protected.HandleFunc("/reports/failures", reportsHandlers.handleFailureReport).Methods("GET")
+		protected.HandleFunc("/reports/deliverability", s.requirePermission(auth.PermissionLogRead, reportsHandlers.handleDeliverabilityReport)).Methods("GET")
+		protected.HandleFunc("/reports/volume", s.requirePermission(auth.PermissionLogRead, reportsHandlers.handleVolumeReport)).Methods("GET")
+		protected.HandleFunc("/reports/failures", s.requirePermission(auth.PermissionLogRead, reportsHandlers.handleFailureReport)).Methods("GET")
 
 		// Message tracing (legacy endpoint)
-		protected.HandleFunc("/messages/{id}/trace", reportsHandlers.handleMessageTrace).Methods("GET")
+		protected.HandleFunc("/messages/{id}/trace", s.requirePermission(auth.PermissionLogRead, reportsHandlers.handleMessageTrace)).Methods("GET")
 
 		// Additional reporting endpoints
-		protected.HandleFunc("/reports/top-senders", reportsHandlers.handleTopSenders).Methods("GET")
-		protected.HandleFunc("/reports/top-recipients", reportsHandlers.handleTopRecipients).Methods("GET")
-		protected.HandleFunc("/reports/domains", reportsHandlers.handleDomainAnalysis).Methods("GET")
-		protected.HandleFunc("/reports/weekly-overview", reportsHandlers.handleWeeklyOverview).Methods("GET")
+		protected.HandleFunc("/reports/top-senders", s.requirePermission(auth.PermissionLogRead, reportsHandlers.handleTopSenders)).Methods("GET")
+		protected.HandleFunc("/reports/top-recipients", s.requirePermission(auth.PermissionLogRead, reportsHandlers.handleTopRecipients)).Methods("GET")
+		protected.HandleFunc("/reports/domains", s.requirePermission(auth.PermissionLogRead, reportsHandlers.handleDomainAnalysis)).Methods("GET")
+		protected.HandleFunc("/reports/weekly-overview", s.requirePermission(auth.PermissionLogRead, reportsHandlers.handleWeeklyOverview)).Methods("GET")
 	}
 
 	// Enhanced Message Tracing routes (Task 11.1) - Protected
 	if s.repository != nil {
-		messageTraceHandlers := NewMessageTraceHandlers(s.repository, s.queueService, s.logService)
+		messageTraceHandlers := NewMessageTraceHandlers(s.repository, s.queueService, s.logService, s.config.ContentRedaction, s.config.EximConfigFile)
 
 		// Enhanced message delivery tracing (Task 11.1)
-		protected.HandleFunc("/messages/{id}/delivery-trace", messageTraceHandlers.handleMessageDeliveryTrace).Methods("GET")
-		protected.HandleFunc("/messages/{id}/recipients/{recipient}/history", messageTraceHandlers.handleRecipientDeliveryHistory).Methods("GET")
-		protected.HandleFunc("/messages/{id}/timeline", messageTraceHandlers.handleDeliveryTimeline).Methods("GET")
-		protected.HandleFunc("/messages/{id}/retry-schedule", messageTraceHandlers.handleRetrySchedule).Methods("GET")
-		protected.HandleFunc("/messages/{id}/delivery-stats", messageTraceHandlers.handleMessageDeliveryStats).Methods("GET")
+		protected.HandleFunc("/messages/{id}/delivery-trace", s.requirePermission(auth.PermissionLogRead, messageTraceHandlers.handleMessageDeliveryTrace)).Methods("GET")
+		protected.HandleFunc("/messages/{id}/recipients/{recipient}/history", s.requirePermission(auth.PermissionLogRead, messageTraceHandlers.handleRecipientDeliveryHistory)).Methods("GET")
+		protected.HandleFunc("/messages/{id}/timeline", s.requirePermission(auth.PermissionLogRead, messageTraceHandlers.handleDeliveryTimeline)).Methods("GET")
+		protected.HandleFunc("/messages/{id}/retry-schedule", s.requirePermission(auth.PermissionLogRead, messageTraceHandlers.handleRetrySchedule)).Methods("GET")
+		protected.HandleFunc("/messages/{id}/delivery-stats", s.requirePermission(auth.PermissionLogRead, messageTraceHandlers.handleMessageDeliveryStats)).Methods("GET")
 
 		// Delivery attempt details
-		protected.HandleFunc("/delivery-attempts/{id}", messageTraceHandlers.handleDeliveryAttemptDetails).Methods("GET")
+		protected.HandleFunc("/delivery-attempts/{id}", s.requirePermission(auth.PermissionLogRead, messageTraceHandlers.handleDeliveryAttemptDetails)).Methods("GET")
 
 		// Troubleshooting and notes functionality (Task 11.2)
-		protected.HandleFunc("/messages/{id}/threaded-timeline", messageTraceHandlers.handleThreadedTimeline).Methods("GET")
-		protected.HandleFunc("/messages/{id}/content", messageTraceHandlers.handleMessageContent).Methods("GET")
+		protected.HandleFunc("/messages/{id}/threaded-timeline", s.requirePermission(auth.PermissionLogRead, messageTraceHandlers.handleThreadedTimeline)).Methods("GET")
+		protected.HandleFunc("/messages/{id}/content", s.requirePermission(auth.PermissionContentRead, messageTraceHandlers.handleMessageContent)).Methods("GET")
 
 		// Message notes
-		protected.HandleFunc("/messages/{id}/notes", messageTraceHandlers.handleMessageNotes).Methods("GET")
-		protected.HandleFunc("/messages/{id}/notes", messageTraceHandlers.handleCreateMessageNote).Methods("POST")
-		protected.HandleFunc("/messages/{id}/notes/{noteId}", messageTraceHandlers.handleUpdateMessageNote).Methods("PUT")
-		protected.HandleFunc("/messages/{id}/notes/{noteId}", messageTraceHandlers.handleDeleteMessageNote).Methods("DELETE")
+		protected.HandleFunc("/messages/{id}/notes", s.requirePermission(auth.PermissionLogRead, messageTraceHandlers.handleMessageNotes)).Methods("GET")
+		protected.HandleFunc("/messages/{id}/notes", s.requirePermission(auth.PermissionQueueMutate, messageTraceHandlers.handleCreateMessageNote)).Methods("POST")
+		protected.HandleFunc("/messages/{id}/notes/{noteId}", s.requirePermission(auth.PermissionQueueMutate, messageTraceHandlers.handleUpdateMessageNote)).Methods("PUT")
+		protected.HandleFunc("/messages/{id}/notes/{noteId}", s.requirePermission(auth.PermissionQueueMutate, messageTraceHandlers.handleDeleteMessageNote)).Methods("DELETE")
 
 		// Message tags
-		protected.HandleFunc("/messages/{id}/tags", messageTraceHandlers.handleMessageTags).Methods("GET")
-		protected.HandleFunc("/messages/{id}/tags", messageTraceHandlers.handleCreateMessageTag).Methods("POST")
-		protected.HandleFunc("/messages/{id}/tags/{tagId}", messageTraceHandlers.handleDeleteMessageTag).Methods("DELETE")
+		protected.HandleFunc("/messages/{id}/tags", s.requirePermission(auth.PermissionLogRead, messageTraceHandlers.handleMessageTags)).Methods("GET")
+		protected.HandleFunc("/messages/{id}/tags", s.requirePermission(auth.PermissionQueueMutate, messageTraceHandlers.handleCreateMessageTag)).Methods("POST")
+		protected.HandleFunc("/messages/{id}/tags/{tagId}", s.requirePermission(auth.PermissionQueueMutate, messageTraceHandlers.handleDeleteMessageTag)).Methods("DELETE")
 
 		// Popular tags
-		protected.HandleFunc("/tags/popular", messageTraceHandlers.handlePopularTags).Methods("GET")
+		protected.HandleFunc("/tags/popular", s.requirePermission(auth.PermissionLogRead, messageTraceHandlers.handlePopularTags)).Methods("GET")
 	}
 
 	// Performance and Optimization routes (Task 13.1) - Protected
 	performanceHandlers := NewPerformanceHandlers(s.repository.GetDB())
 
 	// Database performance endpoints
-	protected.HandleFunc("/performance/database/stats", performanceHandlers.handleDatabaseStats).Methods("GET")
-	protected.HandleFunc("/performance/database/optimize", performanceHandlers.handleOptimizeDatabase).Methods("POST")
-	protected.HandleFunc("/performance/database/query-hints", performanceHandlers.handleQueryOptimizationHints).Methods("GET")
+	protected.HandleFunc("/performance/database/stats", s.requirePermission(auth.PermissionLogRead, performanceHandlers.handleDatabaseStats)).Methods("GET")
+	protected.HandleFunc("/performance/database/optimize", s.requirePermission(auth.PermissionAdmin, performanceHandlers.handleOptimizeDatabase)).Methods("POST")
+	protected.HandleFunc("/performance/database/query-hints", s.requirePermission(auth.PermissionLogRead, performanceHandlers.handleQueryOptimizationHints)).Methods("GET")
 
 	// Data retention endpoints
-	protected.HandleFunc("/performance/retention/status", performanceHandlers.handleRetentionStatus).Methods("GET")
-	protected.HandleFunc("/performance/retention/cleanup", performanceHandlers.handleCleanupExpiredData).Methods("POST")
+	protected.HandleFunc("/performance/retention/status", s.requirePermission(auth.PermissionLogRead, performanceHandlers.handleRetentionStatus)).Methods("GET")
+	protected.HandleFunc("/performance/retention/cleanup", s.requirePermission(auth.PermissionAdmin, performanceHandlers.handleCleanupExpiredData)).Methods("POST")
 
 	// General performance endpoints
-	protected.HandleFunc("/performance/metrics", performanceHandlers.handlePerformanceMetrics).Methods("GET")
-	protected.HandleFunc("/performance/cache/stats", performanceHandlers.handleCacheStats).Methods("GET")
-	protected.HandleFunc("/performance/memory/stats", performanceHandlers.handleMemoryStats).Methods("GET")
+	protected.HandleFunc("/performance/metrics", s.requirePermission(auth.PermissionLogRead, performanceHandlers.handlePerformanceMetrics)).Methods("GET")
+	protected.HandleFunc("/performance/cache/stats", s.requirePermission(auth.PermissionLogRead, performanceHandlers.handleCacheStats)).Methods("GET")
+	protected.HandleFunc("/performance/memory/stats", s.requirePermission(auth.PermissionLogRead, performanceHandlers.handleMemoryStats)).Methods("GET")
 
 	// Batch optimization
-	protected.HandleFunc("/performance/batch/optimize", performanceHandlers.handleBatchOptimization).Methods("POST")
+	protected.HandleFunc("/performance/batch/optimize", s.requirePermission(auth.PermissionAdmin, performanceHandlers.handleBatchOptimization)).Methods("POST")
 
 	// Performance configuration
-	protected.HandleFunc("/performance/config", performanceHandlers.handlePerformanceConfig).Methods("GET", "POST")
+	protected.HandleFunc("/performance/config", s.requirePermission(auth.PermissionAdmin, performanceHandlers.handlePerformanceConfig)).Methods("GET", "POST")
 
 	// Performance testing
-	protected.HandleFunc("/performance/test", performanceHandlers.handlePerformanceTest).Methods("POST")
+	protected.HandleFunc("/performance/test", s.requirePermission(auth.PermissionAdmin, performanceHandlers.handlePerformanceTest)).Methods("POST")
 
 	// Setup static file serving for embedded frontend
 	s.setupStaticRoutes()
@@ -261,8 +370,40 @@ func (s *Server) Start() error {
 		IdleTimeout:  time.Duration(s.config.IdleTimeout) * time.Second,
 	}
 
-	log.Printf("Starting API server on %s", s.config.GetAddress())
-	return s.httpServer.ListenAndServe()
+	var err error
+	if s.config.TLSEnabled {
+		err = s.serveTLS()
+	} else {
+		log.Printf("Starting API server on %s", s.config.GetAddress())
+		err = s.httpServer.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// serveTLS serves HTTPS with a certificate that is reloaded when its files change, and
+// optionally redirects plain HTTP to it
+func (s *Server) serveTLS() error {
+	tlsConfig, err := s.buildTLSConfig()
+	if err != nil {
+		return err
+	}
+	s.httpServer.TLSConfig = tlsConfig
+
+	if err := s.certificates.watch(); err != nil {
+		log.Printf("Warning: TLS certificate changes will only be picked up on SIGHUP: %v", err)
+	}
+
+	if s.config.TLSRedirectPort != 0 {
+		if err := s.startRedirectServer(); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Starting API server on %s with TLS", s.config.GetAddress())
+	return s.httpServer.ListenAndServeTLS("", "")
 }
 
 // Stop gracefully stops the HTTP server
@@ -274,6 +415,8 @@ func (s *Server) Stop(ctx context.Context) error {
 		log.Printf("Error stopping WebSocket service: %v", err)
 	}
 
+	s.stopTLS(ctx)
+
 	return s.httpServer.Shutdown(ctx)
 }
 
@@ -291,28 +434,21 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	WriteJSONResponse(w, http.StatusOK, response)
 }
 
-// handleWebSocket handles WebSocket connections with manual CORS handling
+// handleWebSocket upgrades authenticated requests from allowed origins to WebSocket
+// connections. /ws is registered outside authMiddleware, so the handshake is
+// authenticated here.
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// Handle CORS for WebSocket connections manually
-	origin := r.Header.Get("Origin")
-	if origin != "" {
-		// Check if origin is allowed
-		allowed := false
-		for _, allowedOrigin := range s.config.AllowedOrigins {
-			if allowedOrigin == "*" || allowedOrigin == origin {
-				allowed = true
-				break
-			}
-		}
+	if !s.webSocketOriginAllowed(r) {
+		WriteForbiddenResponse(w, "Origin not allowed")
+		return
+	}
 
-		if allowed {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
-		}
+	authorizer, ok := s.authenticateStream(w, r)
+	if !ok {
+		return
 	}
 
-	// Delegate to WebSocket service
-	s.websocketService.GetHub().ServeWS(w, r)
+	s.websocketService.GetHub().ServeWS(w, r, authorizer)
 }
 
 // GetWebSocketService returns the WebSocket service for broadcasting updates
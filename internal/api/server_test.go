@@ -0,0 +1,93 @@
+package api
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/auth"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// newRoutedTestServer returns a server with its routes and middleware set up over a
+// migrated database
+func newRoutedTestServer(t *testing.T) (*Server, *database.DB) {
+	t.Helper()
+
+	db, err := database.Connect(&database.Config{
+		Path:            filepath.Join(t.TempDir(), "server.db"),
+		MaxOpenConns:    1,
+		MaxIdleConns:    1,
+		ConnMaxLifetime: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.MigrateUp(db); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	return NewServer(NewConfig(), nil, nil, database.NewRepository(db), db), db
+}
+
+func TestAuditMiddlewareRecordsAPIToken(t *testing.T) {
+	server, db := newRoutedTestServer(t)
+
+	user, err := server.authService.CreateUser("reader", "Secret123!", "", "", auth.RoleViewer, nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	secret, token, err := server.authService.CreateAPIToken(user.ID, "monitor", []string{string(auth.PermissionLogRead)}, time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/api/v1/messages/1a2B3c-000001-AB/timeline", nil)
+	r.Header.Set("Authorization", "Bearer "+secret)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	entries, err := database.NewAuditLogRepository(db).List(10, 0, "message_view", "")
+	if err != nil {
+		t.Fatalf("Failed to list audit entries: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected one message_view entry, got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry.UserID == nil || *entry.UserID != auth.TokenAuditUserID(token) {
+		t.Errorf("Expected the entry under %q, got %v", auth.TokenAuditUserID(token), entry.UserID)
+	}
+	if entry.MessageID == nil || *entry.MessageID != "1a2B3c-000001-AB" {
+		t.Errorf("Expected the message ID on the entry, got %v", entry.MessageID)
+	}
+}
+
+func TestSecurityHeadersOnServedRoutes(t *testing.T) {
+	server, _ := newRoutedTestServer(t)
+
+	r := httptest.NewRequest("GET", "/api/v1/health", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, r)
+	if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("Expected security headers on the health route, got %v", w.Header())
+	}
+	if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "" {
+		t.Errorf("Expected no HSTS over plain HTTP, got %q", hsts)
+	}
+
+	r = httptest.NewRequest("GET", "/api/v1/health", nil)
+	r.TLS = &tls.ConnectionState{}
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, r)
+	if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "max-age=31536000" {
+		t.Errorf("Expected HSTS over TLS, got %q", hsts)
+	}
+}
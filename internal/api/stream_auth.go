@@ -0,0 +1,174 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/andreitelteu/exim-pilot/internal/auth"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+	"github.com/andreitelteu/exim-pilot/internal/websocket"
+)
+
+// streamAuthorizer authorizes what one WebSocket connection or event stream receives
+// against the permissions of its user and, for API tokens, the token's scopes
+type streamAuthorizer struct {
+	// revalidate repeats the handshake's authentication
+	revalidate func() (*database.User, *database.APIToken, error)
+
+	mu    sync.RWMutex
+	user  *database.User
+	token *database.APIToken
+}
+
+// Authorize reports whether the connection may subscribe to an endpoint or receive a
+// broadcast message type
+func (a *streamAuthorizer) Authorize(topic string) bool {
+	permission, ok := webSocketTopicPermission(topic)
+	if !ok {
+		return false
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !auth.HasPermission(a.user.Role, permission) {
+		return false
+	}
+	return a.token == nil || auth.TokenHasScope(a.token, permission)
+}
+
+// Revalidate checks the credentials again and picks up changes to the user's role
+func (a *streamAuthorizer) Revalidate() error {
+	user, token, err := a.revalidate()
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.user, a.token = user, token
+	a.mu.Unlock()
+	return nil
+}
+
+// webSocketTopicPermission returns the permission required by a WebSocket endpoint or
+// broadcast message type, matching the REST endpoints serving the same data. Unknown
+// topics are refused.
+func webSocketTopicPermission(topic string) (auth.Permission, bool) {
+	switch topic {
+	case websocket.LogTailEndpoint, websocket.DashboardUpdateMessage:
+		return auth.PermissionLogRead, true
+	case websocket.QueueUpdateMessage, websocket.SystemAlertMessage:
+		return auth.PermissionQueueRead, true
+	}
+
+	if len(topic) > len(websocket.MessageUpdatesPrefix)+len(websocket.MessageUpdatesSuffix) &&
+		strings.HasPrefix(topic, websocket.MessageUpdatesPrefix) && strings.HasSuffix(topic, websocket.MessageUpdatesSuffix) {
+		return auth.PermissionQueueRead, true
+	}
+	return "", false
+}
+
+// webSocketOriginAllowed reports whether the handshake may proceed from its origin.
+// Browsers send cookies with cross-site WebSocket handshakes, so a browser origin must be
+// this server or one of the explicitly allowed origins. The "*" wildcard only opens CORS
+// and is ignored here. Clients that send no Origin are not browsers.
+func (s *Server) webSocketOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	for _, allowedOrigin := range s.config.AllowedOrigins {
+		if allowedOrigin != "*" && strings.EqualFold(allowedOrigin, origin) {
+			return true
+		}
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// authenticateStream authenticates a WebSocket handshake or event stream request the
+// same ways authMiddleware authenticates requests, keeping the credentials so they can
+// be checked again while the stream lasts. On failure the response has been written and
+// it returns false.
+func (s *Server) authenticateStream(w http.ResponseWriter, r *http.Request) (*streamAuthorizer, bool) {
+	authorizer := &streamAuthorizer{}
+
+	switch {
+	case r.Header.Get("Authorization") != "":
+		scheme, secret, found := strings.Cut(r.Header.Get("Authorization"), " ")
+		secret = strings.TrimSpace(secret)
+		if !found || !strings.EqualFold(scheme, "Bearer") || secret == "" {
+			WriteUnauthorizedResponse(w, "Invalid authorization header, expected 'Bearer <token>'")
+			return nil, false
+		}
+		if s.config.RequireTokenClientCert && !hasVerifiedClientCertificate(r) {
+			WriteUnauthorizedResponse(w, "API tokens require a client certificate")
+			return nil, false
+		}
+
+		clientIP := getClientIPFromRequest(r)
+		authorizer.revalidate = func() (*database.User, *database.APIToken, error) {
+			return s.authService.ValidateAPIToken(secret, clientIP)
+		}
+		if err := authorizer.Revalidate(); err != nil {
+			WriteUnauthorizedResponse(w, "Invalid API token")
+			return nil, false
+		}
+		return authorizer, true
+
+	case s.config.TrustedHeaderAuth != nil:
+		identity, err := s.config.TrustedHeaderAuth.Identify(r.RemoteAddr, r.Header)
+		if err != nil {
+			WriteForbiddenResponse(w, "Your account is not authorized to use this application")
+			return nil, false
+		}
+		if identity == nil {
+			break
+		}
+
+		user, err := s.authService.ResolveIdentity(identity)
+		if err != nil {
+			log.Printf("Trusted header authentication for %s failed: %v", identity.Username, err)
+			WriteForbiddenResponse(w, "Your account is not authorized to use this application")
+			return nil, false
+		}
+
+		// The proxy vouches for the user only during the handshake, so later checks make
+		// sure the account is still active
+		authorizer.user = user
+		authorizer.revalidate = func() (*database.User, *database.APIToken, error) {
+			current, err := s.authService.GetUser(user.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !current.IsActive {
+				return nil, nil, fmt.Errorf("user %s has been disabled", current.Username)
+			}
+			return current, nil, nil
+		}
+		return authorizer, true
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		WriteUnauthorizedResponse(w, "Authentication required")
+		return nil, false
+	}
+
+	sessionID := cookie.Value
+	authorizer.revalidate = func() (*database.User, *database.APIToken, error) {
+		user, err := s.authService.ValidateSession(sessionID)
+		return user, nil, err
+	}
+	if err := authorizer.Revalidate(); err != nil {
+		WriteUnauthorizedResponse(w, "Invalid session")
+		return nil, false
+	}
+	return authorizer, true
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andreitelteu/exim-pilot/internal/auth"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+	"github.com/andreitelteu/exim-pilot/internal/websocket"
+)
+
+func TestStreamAuthorizer(t *testing.T) {
+	messageUpdates := websocket.MessageUpdatesPrefix + "1rABCD-123456-78" + websocket.MessageUpdatesSuffix
+
+	user := &database.User{ID: 1, Username: "viewer", Role: auth.RoleViewer}
+	var token *database.APIToken
+	var revalidateErr error
+	authorizer := &streamAuthorizer{
+		revalidate: func() (*database.User, *database.APIToken, error) {
+			return user, token, revalidateErr
+		},
+	}
+	if err := authorizer.Revalidate(); err != nil {
+		t.Fatalf("Revalidate failed: %v", err)
+	}
+
+	for topic, want := range map[string]bool{
+		websocket.LogTailEndpoint:    true,
+		websocket.QueueUpdateMessage: true,
+		messageUpdates:               true,
+		"/api/v1/messages/updates":   false,
+		"/api/v1/audit":              false,
+	} {
+		if got := authorizer.Authorize(topic); got != want {
+			t.Errorf("Authorize(%q) = %v, want %v", topic, got, want)
+		}
+	}
+
+	// Tokens are limited to their scopes, and a revalidation picks up the new scopes
+	token = &database.APIToken{ID: 1, Scopes: []string{string(auth.PermissionQueueRead)}}
+	if err := authorizer.Revalidate(); err != nil {
+		t.Fatalf("Revalidate failed: %v", err)
+	}
+	if authorizer.Authorize(websocket.LogTailEndpoint) {
+		t.Error("Expected a token without log:read to be refused the log tail")
+	}
+	if !authorizer.Authorize(messageUpdates) {
+		t.Error("Expected a token with queue:read to receive message updates")
+	}
+
+	revalidateErr = errors.New("session expired")
+	if err := authorizer.Revalidate(); err == nil {
+		t.Error("Expected Revalidate to report the expired session")
+	}
+}
+
+func TestWebSocketOriginAllowed(t *testing.T) {
+	server := &Server{config: &Config{AllowedOrigins: []string{"https://admin.example.com"}}}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"https://admin.example.com", true},
+		{"https://mail.example.com", true}, // the server itself
+		{"https://evil.example.net", false},
+		{"null", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "https://mail.example.com/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := server.webSocketOriginAllowed(r); got != tt.want {
+			t.Errorf("webSocketOriginAllowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+	// The default configuration allows any CORS origin but no foreign WebSocket origin
+	server = &Server{config: NewConfig()}
+	r := httptest.NewRequest("GET", "https://mail.example.com/ws", nil)
+	r.Header.Set("Origin", "https://evil.example.net")
+	if server.webSocketOriginAllowed(r) {
+		t.Error("Expected the default configuration to reject a foreign origin")
+	}
+	r.Header.Set("Origin", "https://mail.example.com")
+	if !server.webSocketOriginAllowed(r) {
+		t.Error("Expected the default configuration to allow the server's own origin")
+	}
+}
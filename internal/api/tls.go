@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// certReloadDelay lets certificate renewals that rewrite the certificate and the key
+// one after the other finish before the pair is loaded
+const certReloadDelay = time.Second
+
+// certificateReloader serves the current certificate and key pair and replaces it when
+// the files change, so renewed certificates are used without a restart
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+// newCertificateReloader loads the certificate and key pair. The files are not watched
+// until watch is called.
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	r := &certificateReloader{
+		certFile: filepath.Clean(certFile),
+		keyFile:  filepath.Clean(keyFile),
+		done:     make(chan struct{}),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the certificate and key pair again. On failure the previous pair stays
+// in use.
+func (r *certificateReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// watch reloads the pair when either file is written or replaced. The directories are
+// watched because tools such as certbot replace the files or the symlinks to them.
+func (r *certificateReloader) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create certificate watcher: %w", err)
+	}
+
+	for _, dir := range []string{filepath.Dir(r.certFile), filepath.Dir(r.keyFile)} {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
+	r.watcher = watcher
+
+	go func() {
+		var reload <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Name == r.certFile || event.Name == r.keyFile {
+					reload = time.After(certReloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Certificate watcher error: %v", err)
+			case <-reload:
+				reload = nil
+				if err := r.Reload(); err != nil {
+					log.Printf("Keeping the current TLS certificate: %v", err)
+				} else {
+					log.Printf("Reloaded TLS certificate from %s", r.certFile)
+				}
+			case <-r.done:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops watching the files
+func (r *certificateReloader) Close() {
+	close(r.done)
+	if r.watcher != nil {
+		r.watcher.Close()
+	}
+}
+
+// buildTLSConfig creates the TLS settings of the HTTPS server. With a client CA file,
+// clients may present a certificate, which authMiddleware requires from API token
+// clients when RequireTokenClientCert is set.
+func (s *Server) buildTLSConfig() (*tls.Config, error) {
+	reloader, err := newCertificateReloader(s.config.TLSCertFile, s.config.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if s.config.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(s.config.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS client CA file %s", s.config.TLSClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	s.certificates = reloader
+	return tlsConfig, nil
+}
+
+// ReloadTLS reads the certificate and key files again, for SIGHUP. It does nothing
+// when the server does not serve HTTPS.
+func (s *Server) ReloadTLS() error {
+	if s.certificates == nil {
+		return nil
+	}
+	return s.certificates.Reload()
+}
+
+// startRedirectServer listens on the redirect port and sends plain HTTP requests to
+// the HTTPS address. Listening happens before returning so a busy port is reported.
+func (s *Server) startRedirectServer() error {
+	address := net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.TLSRedirectPort))
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen for HTTP redirects on %s: %w", address, err)
+	}
+
+	s.redirectServer = &http.Server{
+		Handler:      httpsRedirectHandler(s.config.Port),
+		ReadTimeout:  time.Duration(s.config.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(s.config.WriteTimeout) * time.Second,
+		IdleTimeout:  time.Duration(s.config.IdleTimeout) * time.Second,
+	}
+
+	log.Printf("Redirecting HTTP on %s to HTTPS", address)
+	go func() {
+		if err := s.redirectServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP redirect server failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// httpsRedirectHandler redirects every request to the same host and path on the HTTPS
+// port. Requests other than GET and HEAD use 308 so clients repeat the method and body.
+func httpsRedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			// No port in the Host header
+			host = strings.Trim(r.Host, "[]")
+		}
+		if host == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		code := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+}
+
+// hasVerifiedClientCertificate reports whether the request came with a client
+// certificate signed by one of the configured client CAs
+func hasVerifiedClientCertificate(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+// stopTLS stops the redirect server and the certificate watcher
+func (s *Server) stopTLS(ctx context.Context) {
+	if s.redirectServer != nil {
+		if err := s.redirectServer.Shutdown(ctx); err != nil {
+			log.Printf("Error stopping HTTP redirect server: %v", err)
+		}
+	}
+	if s.certificates != nil {
+		s.certificates.Close()
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate and key for commonName
+func writeCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+}
+
+func servedCommonName(t *testing.T, r *certificateReloader) string {
+	t.Helper()
+
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCertificate(t, certFile, keyFile, "first")
+
+	reloader, err := newCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertificateReloader failed: %v", err)
+	}
+	defer reloader.Close()
+	if err := reloader.watch(); err != nil {
+		t.Fatalf("watch failed: %v", err)
+	}
+
+	if name := servedCommonName(t, reloader); name != "first" {
+		t.Fatalf("Expected the first certificate, got %q", name)
+	}
+
+	// A broken pair keeps the current certificate
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	if err := reloader.Reload(); err == nil {
+		t.Error("Expected reloading a broken key to fail")
+	}
+	if name := servedCommonName(t, reloader); name != "first" {
+		t.Errorf("Expected the first certificate after a failed reload, got %q", name)
+	}
+
+	// Renewed files are picked up by the watcher
+	writeCertificate(t, certFile, keyFile, "second")
+	deadline := time.Now().Add(5 * time.Second)
+	for servedCommonName(t, reloader) != "second" {
+		if time.Now().After(deadline) {
+			t.Fatal("Renewed certificate was not loaded")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	tests := []struct {
+		method   string
+		host     string
+		target   string
+		port     int
+		wantCode int
+		wantURL  string
+	}{
+		{http.MethodGet, "mail.example.com:8080", "/queue?status=frozen", 8443, http.StatusMovedPermanently, "https://mail.example.com:8443/queue?status=frozen"},
+		{http.MethodGet, "mail.example.com", "/", 443, http.StatusMovedPermanently, "https://mail.example.com/"},
+		{http.MethodPost, "[::1]:80", "/api/v1/auth/login", 443, http.StatusPermanentRedirect, "https://[::1]/api/v1/auth/login"},
+		{http.MethodGet, "[::1]", "/", 8443, http.StatusMovedPermanently, "https://[::1]:8443/"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		httpsRedirectHandler(tt.port).ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantCode || rec.Header().Get("Location") != tt.wantURL {
+			t.Errorf("%s %s%s: got %d %q, want %d %q", tt.method, tt.host, tt.target, rec.Code, rec.Header().Get("Location"), tt.wantCode, tt.wantURL)
+		}
+	}
+}
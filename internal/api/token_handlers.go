@@ -0,0 +1,153 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/auth"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// TokenHandlers contains handlers for API token management endpoints
+type TokenHandlers struct {
+	authService *auth.Service
+}
+
+// NewTokenHandlers creates a new token handlers instance
+func NewTokenHandlers(authService *auth.Service) *TokenHandlers {
+	return &TokenHandlers{
+		authService: authService,
+	}
+}
+
+// createTokenRequest is the body of POST /api/v1/tokens. ExpiresAt takes precedence
+// over ExpiresInDays; with neither the default lifetime applies.
+type createTokenRequest struct {
+	Name          string     `json:"name"`
+	Scopes        []string   `json:"scopes"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	ExpiresInDays int        `json:"expires_in_days"`
+}
+
+// handleListTokens handles GET /api/v1/tokens - List the caller's tokens, or all tokens for admins with all=true
+func (h *TokenHandlers) handleListTokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := GetUserFromContext(r.Context())
+	if !ok {
+		WriteUnauthorizedResponse(w, "Authentication required")
+		return
+	}
+
+	ownerID := user.ID
+	if GetQueryParam(r, "all", "false") == "true" {
+		if !h.canManageAllTokens(r, user) {
+			WriteForbiddenResponse(w, "Forbidden: listing all tokens requires the 'admin' permission")
+			return
+		}
+		ownerID = 0
+	}
+
+	tokens, err := h.authService.ListAPITokens(ownerID)
+	if err != nil {
+		WriteInternalErrorResponse(w, "Failed to list API tokens: "+err.Error())
+		return
+	}
+
+	if tokens == nil {
+		tokens = []database.APIToken{}
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{
+		"tokens": tokens,
+		"scopes": auth.PermissionsForRole(user.Role),
+	})
+}
+
+// handleCreateToken handles POST /api/v1/tokens - Issue a token for the caller
+func (h *TokenHandlers) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := GetUserFromContext(r.Context())
+	if !ok {
+		WriteUnauthorizedResponse(w, "Authentication required")
+		return
+	}
+
+	// A leaked token must not be able to mint replacements for itself
+	if _, usingToken := GetAPITokenFromContext(r.Context()); usingToken {
+		WriteForbiddenResponse(w, "API tokens cannot be used to create other tokens")
+		return
+	}
+
+	var req createTokenRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	var expiresAt time.Time
+	switch {
+	case req.ExpiresAt != nil:
+		expiresAt = *req.ExpiresAt
+	case req.ExpiresInDays < 0:
+		WriteBadRequestResponse(w, "expires_in_days must be positive")
+		return
+	case req.ExpiresInDays > 0:
+		expiresAt = time.Now().Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+	}
+
+	secret, token, err := h.authService.CreateAPIToken(user.ID, req.Name, req.Scopes, expiresAt, newAuditContext(r))
+	if err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	WriteJSONResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"token":   secret,
+			"details": token,
+			"message": "Store this token now, it cannot be shown again",
+		},
+	})
+}
+
+// handleRevokeToken handles DELETE /api/v1/tokens/{id} - Revoke one of the caller's tokens,
+// or any token for admins
+func (h *TokenHandlers) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := GetUserFromContext(r.Context())
+	if !ok {
+		WriteUnauthorizedResponse(w, "Authentication required")
+		return
+	}
+
+	tokenID, err := strconv.ParseInt(GetPathParam(r, "id"), 10, 64)
+	if err != nil || tokenID <= 0 {
+		WriteBadRequestResponse(w, "Invalid token ID")
+		return
+	}
+
+	token, err := h.authService.GetAPIToken(tokenID)
+	if err != nil || (token.UserID != user.ID && !h.canManageAllTokens(r, user)) {
+		WriteNotFoundResponse(w, "API token not found")
+		return
+	}
+
+	if err := h.authService.RevokeAPIToken(tokenID, newAuditContext(r)); err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{
+		"message": "API token revoked",
+	})
+}
+
+// canManageAllTokens reports whether the caller may see and revoke other users' tokens
+func (h *TokenHandlers) canManageAllTokens(r *http.Request, user *database.User) bool {
+	if !auth.HasPermission(user.Role, auth.PermissionAdmin) {
+		return false
+	}
+	if token, ok := GetAPITokenFromContext(r.Context()); ok {
+		return auth.TokenHasScope(token, auth.PermissionAdmin)
+	}
+	return true
+}
@@ -0,0 +1,358 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andreitelteu/exim-pilot/internal/auth"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// UserHandlers contains handlers for user management endpoints
+type UserHandlers struct {
+	authService *auth.Service
+}
+
+// NewUserHandlers creates a new user handlers instance
+func NewUserHandlers(authService *auth.Service) *UserHandlers {
+	return &UserHandlers{
+		authService: authService,
+	}
+}
+
+// createUserRequest is the body of POST /api/v1/users
+type createUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email"`
+	FullName string `json:"full_name"`
+	Role     string `json:"role"`
+}
+
+// updateUserRequest is the body of PUT /api/v1/users/{id}; omitted fields are left unchanged
+type updateUserRequest struct {
+	Email    *string `json:"email"`
+	FullName *string `json:"full_name"`
+	Role     *string `json:"role"`
+	IsActive *bool   `json:"is_active"`
+}
+
+// handleListUsers handles GET /api/v1/users - List users
+func (h *UserHandlers) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	includeInactive := GetQueryParam(r, "include_inactive", "true") == "true"
+
+	users, err := h.authService.ListUsers(includeInactive)
+	if err != nil {
+		WriteInternalErrorResponse(w, "Failed to list users: "+err.Error())
+		return
+	}
+
+	if users == nil {
+		users = []database.User{}
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{
+		"users": users,
+		"roles": auth.Roles(),
+	})
+}
+
+// handleCreateUser handles POST /api/v1/users - Create a user
+func (h *UserHandlers) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req createUserRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	if strings.TrimSpace(req.Username) == "" || req.Password == "" {
+		WriteBadRequestResponse(w, "Username and password are required")
+		return
+	}
+
+	if req.Role == "" {
+		req.Role = auth.RoleViewer
+	}
+
+	if _, err := h.authService.GetUserByUsername(req.Username); err == nil {
+		WriteErrorResponse(w, http.StatusConflict, "Username already exists")
+		return
+	}
+
+	user, err := h.authService.CreateUser(req.Username, req.Password, req.Email, req.FullName, req.Role, newAuditContext(r))
+	if err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	WriteJSONResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Data:    user,
+	})
+}
+
+// handleGetUser handles GET /api/v1/users/{id} - Get a single user
+func (h *UserHandlers) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.parseUserID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.authService.GetUser(userID)
+	if err != nil {
+		WriteNotFoundResponse(w, "User not found")
+		return
+	}
+
+	WriteSuccessResponse(w, user)
+}
+
+// handleUpdateUser handles PUT /api/v1/users/{id} - Update profile, role or status
+func (h *UserHandlers) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.parseUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req updateUserRequest
+	if err := ParseJSONBody(r, &req); err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	if (req.Role != nil || (req.IsActive != nil && !*req.IsActive)) && h.isCurrentUser(r, userID) {
+		WriteBadRequestResponse(w, "You cannot change your own role or disable your own account")
+		return
+	}
+
+	auditCtx := newAuditContext(r)
+
+	user, err := h.authService.GetUser(userID)
+	if err != nil {
+		WriteNotFoundResponse(w, "User not found")
+		return
+	}
+
+	if req.Email != nil || req.FullName != nil {
+		if user, err = h.authService.UpdateUserProfile(userID, req.Email, req.FullName, auditCtx); err != nil {
+			h.writeUserError(w, err)
+			return
+		}
+	}
+
+	if req.Role != nil && *req.Role != user.Role {
+		if user, err = h.authService.SetUserRole(r.Context(), userID, *req.Role, auditCtx); err != nil {
+			h.writeUserError(w, err)
+			return
+		}
+	}
+
+	if req.IsActive != nil && *req.IsActive != user.IsActive {
+		if user, err = h.authService.SetUserActive(userID, *req.IsActive, auditCtx); err != nil {
+			h.writeUserError(w, err)
+			return
+		}
+	}
+
+	WriteSuccessResponse(w, user)
+}
+
+// handleDisableUser handles DELETE /api/v1/users/{id} - Disable a user and revoke their sessions.
+// Users are never removed so that audit records keep pointing at a known account.
+func (h *UserHandlers) handleDisableUser(w http.ResponseWriter, r *http.Request) {
+	h.setActive(w, r, false)
+}
+
+// handleEnableUser handles POST /api/v1/users/{id}/enable - Re-enable a disabled user
+func (h *UserHandlers) handleEnableUser(w http.ResponseWriter, r *http.Request) {
+	h.setActive(w, r, true)
+}
+
+// handleSetUserRole handles POST /api/v1/users/{id}/role - Change a user's role
+func (h *UserHandlers) handleSetUserRole(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.parseUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := ParseJSONBody(r, &req); err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	if h.isCurrentUser(r, userID) {
+		WriteBadRequestResponse(w, "You cannot change your own role")
+		return
+	}
+
+	user, err := h.authService.SetUserRole(r.Context(), userID, req.Role, newAuditContext(r))
+	if err != nil {
+		h.writeUserError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, user)
+}
+
+// handleLinkExternalUser handles POST /api/v1/users/{id}/link - Let an external identity
+// source log in to an existing account
+func (h *UserHandlers) handleLinkExternalUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.parseUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Source string `json:"source"`
+	}
+	if err := ParseJSONBody(r, &req); err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	if h.isCurrentUser(r, userID) {
+		WriteBadRequestResponse(w, "You cannot link your own account")
+		return
+	}
+
+	user, err := h.authService.LinkExternalUser(userID, req.Source, newAuditContext(r))
+	if err != nil {
+		h.writeUserError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, user)
+}
+
+// handleResetPassword handles POST /api/v1/users/{id}/password - Reset a user's password
+func (h *UserHandlers) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.parseUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := ParseJSONBody(r, &req); err != nil {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+
+	if err := h.authService.ResetPassword(userID, req.Password, newAuditContext(r)); err != nil {
+		h.writeUserError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{
+		"message": "Password reset and sessions revoked",
+	})
+}
+
+// handleRevokeSessions handles DELETE /api/v1/users/{id}/sessions - Log a user out everywhere
+func (h *UserHandlers) handleRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.parseUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.authService.RevokeSessions(userID, newAuditContext(r)); err != nil {
+		h.writeUserError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{
+		"message": "Sessions revoked",
+	})
+}
+
+// handleListLockouts handles GET /api/v1/auth/lockouts - List IP addresses and usernames
+// whose logins are delayed or locked out after failed attempts
+func (h *UserHandlers) handleListLockouts(w http.ResponseWriter, r *http.Request) {
+	lockouts, err := h.authService.ListLockouts()
+	if err != nil {
+		WriteInternalErrorResponse(w, "Failed to list lockouts: "+err.Error())
+		return
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{
+		"lockouts": lockouts,
+	})
+}
+
+// handleClearLockout handles DELETE /api/v1/auth/lockouts?type=ip|username&key=... - Lift
+// a lockout by clearing the failed attempts of an IP address or username
+func (h *UserHandlers) handleClearLockout(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("type")
+	key := strings.TrimSpace(r.URL.Query().Get("key"))
+	if key == "" {
+		WriteBadRequestResponse(w, "key is required")
+		return
+	}
+
+	cleared, err := h.authService.ClearLockout(kind, key, newAuditContext(r))
+	if errors.Is(err, auth.ErrInvalidLockoutType) {
+		WriteBadRequestResponse(w, err.Error())
+		return
+	}
+	if err != nil {
+		WriteInternalErrorResponse(w, "Failed to clear lockout: "+err.Error())
+		return
+	}
+
+	WriteSuccessResponse(w, map[string]interface{}{
+		"message": "Lockout cleared",
+		"cleared": cleared,
+	})
+}
+
+// setActive enables or disables the user named in the path
+func (h *UserHandlers) setActive(w http.ResponseWriter, r *http.Request, active bool) {
+	userID, ok := h.parseUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if !active && h.isCurrentUser(r, userID) {
+		WriteBadRequestResponse(w, "You cannot disable your own account")
+		return
+	}
+
+	user, err := h.authService.SetUserActive(userID, active, newAuditContext(r))
+	if err != nil {
+		h.writeUserError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, user)
+}
+
+// parseUserID reads the numeric user ID from the path, writing a 400 response on failure
+func (h *UserHandlers) parseUserID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, err := strconv.ParseInt(GetPathParam(r, "id"), 10, 64)
+	if err != nil || userID <= 0 {
+		WriteBadRequestResponse(w, "Invalid user ID")
+		return 0, false
+	}
+	return userID, true
+}
+
+// isCurrentUser reports whether the authenticated user is the given user
+func (h *UserHandlers) isCurrentUser(r *http.Request, userID int64) bool {
+	user, ok := GetUserFromContext(r.Context())
+	return ok && user.ID == userID
+}
+
+// writeUserError maps user management errors to HTTP responses
+func (h *UserHandlers) writeUserError(w http.ResponseWriter, err error) {
+	if strings.Contains(err.Error(), "not found") {
+		WriteNotFoundResponse(w, "User not found")
+		return
+	}
+	WriteBadRequestResponse(w, err.Error())
+}
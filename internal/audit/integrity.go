@@ -0,0 +1,225 @@
+package audit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+const (
+	// integrityBatchSize is the number of entries read per query while walking the chain
+	integrityBatchSize = 1000
+
+	// CheckpointAlgorithm identifies how checkpoints are signed
+	CheckpointAlgorithm = "HMAC-SHA256"
+)
+
+var (
+	// ErrNoSigningKey is returned when checkpoints are requested without a signing key
+	ErrNoSigningKey = errors.New("audit checkpoint signing key is not configured")
+
+	// ErrInvalidSignature is returned for checkpoints not signed with the configured key
+	ErrInvalidSignature = errors.New("audit checkpoint signature is invalid")
+)
+
+// IntegrityReport is the result of walking the audit log hash chain
+type IntegrityReport struct {
+	Valid bool `json:"valid"`
+
+	// CheckedEntries counts sealed entries whose hashes were verified
+	CheckedEntries int64 `json:"checked_entries"`
+
+	// UnsealedEntries counts entries written before the log was hash-chained
+	UnsealedEntries int64 `json:"unsealed_entries"`
+
+	FirstEntryID int64  `json:"first_entry_id,omitempty"`
+	LastEntryID  int64  `json:"last_entry_id,omitempty"`
+	LastHash     string `json:"last_hash,omitempty"`
+
+	// BrokenLink describes the first entry that fails verification
+	BrokenLink *BrokenLink `json:"broken_link,omitempty"`
+
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// BrokenLink identifies where the chain fails verification
+type BrokenLink struct {
+	EntryID  int64  `json:"entry_id"`
+	Reason   string `json:"reason"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// Checkpoint is a signed statement of the chain head at a point in time. Kept outside the
+// database, it detects truncation of the newest entries, which the chain alone cannot.
+type Checkpoint struct {
+	EntryID   int64     `json:"entry_id"`
+	EntryHash string    `json:"entry_hash"`
+	Entries   int64     `json:"entries"`
+	CreatedAt time.Time `json:"created_at"`
+	Algorithm string    `json:"algorithm"`
+	Signature string    `json:"signature"`
+}
+
+// SetCheckpointKey sets the key used to sign and verify checkpoints
+func (s *Service) SetCheckpointKey(key string) {
+	s.checkpointKey = []byte(key)
+}
+
+// ValidateAuditIntegrity walks the audit log hash chain and reports the first broken link.
+// The oldest sealed entry anchors the chain, since retention cleanup removes older ones.
+// Only entries up to the one recorded when the chain was introduced may be unsealed.
+func (s *Service) ValidateAuditIntegrity(ctx context.Context) (*IntegrityReport, error) {
+	repo := s.auditLogRepository()
+	report := &IntegrityReport{CheckedAt: time.Now().UTC()}
+
+	lastUnsealedID, err := repo.LastUnsealedID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit chain state: %w", err)
+	}
+
+	var afterID int64
+	var prevHash string
+	sealed := false
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		entries, err := repo.ListChain(afterID, integrityBatchSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+
+		for i := range entries {
+			entry := &entries[i]
+			afterID = entry.ID
+
+			if entry.EntryHash == nil || entry.PrevHash == nil {
+				if entry.ID <= lastUnsealedID {
+					report.UnsealedEntries++
+					continue
+				}
+				report.BrokenLink = &BrokenLink{EntryID: entry.ID, Reason: "entry is not sealed"}
+				return report, nil
+			}
+
+			if !sealed {
+				sealed = true
+				report.FirstEntryID = entry.ID
+			} else if *entry.PrevHash != prevHash {
+				report.BrokenLink = &BrokenLink{
+					EntryID:  entry.ID,
+					Reason:   "previous hash does not match the preceding entry",
+					Expected: prevHash,
+					Actual:   *entry.PrevHash,
+				}
+				return report, nil
+			}
+
+			if expected := database.AuditEntryHash(entry, *entry.PrevHash); expected != *entry.EntryHash {
+				report.BrokenLink = &BrokenLink{
+					EntryID:  entry.ID,
+					Reason:   "entry content does not match its hash",
+					Expected: expected,
+					Actual:   *entry.EntryHash,
+				}
+				return report, nil
+			}
+
+			prevHash = *entry.EntryHash
+			report.CheckedEntries++
+			report.LastEntryID = entry.ID
+			report.LastHash = prevHash
+		}
+
+		if len(entries) < integrityBatchSize {
+			break
+		}
+	}
+
+	report.Valid = true
+	return report, nil
+}
+
+// CreateCheckpoint verifies the chain and returns a signed checkpoint of its head
+func (s *Service) CreateCheckpoint(ctx context.Context) (*Checkpoint, error) {
+	if len(s.checkpointKey) == 0 {
+		return nil, ErrNoSigningKey
+	}
+
+	report, err := s.ValidateAuditIntegrity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !report.Valid {
+		return nil, fmt.Errorf("audit log integrity check failed at entry %d: %s", report.BrokenLink.EntryID, report.BrokenLink.Reason)
+	}
+	if report.CheckedEntries == 0 {
+		return nil, fmt.Errorf("audit log has no sealed entries")
+	}
+
+	checkpoint := &Checkpoint{
+		EntryID:   report.LastEntryID,
+		EntryHash: report.LastHash,
+		Entries:   report.CheckedEntries,
+		CreatedAt: report.CheckedAt,
+		Algorithm: CheckpointAlgorithm,
+	}
+	checkpoint.Signature = s.signCheckpoint(checkpoint)
+
+	return checkpoint, nil
+}
+
+// VerifyCheckpoint checks the checkpoint signature and that the entry it names is still
+// present with the same hash
+func (s *Service) VerifyCheckpoint(ctx context.Context, checkpoint *Checkpoint) error {
+	if len(s.checkpointKey) == 0 {
+		return ErrNoSigningKey
+	}
+	if checkpoint.Algorithm != CheckpointAlgorithm {
+		return fmt.Errorf("unsupported checkpoint algorithm %q", checkpoint.Algorithm)
+	}
+
+	expected, err := hex.DecodeString(s.signCheckpoint(checkpoint))
+	if err != nil {
+		return err
+	}
+	actual, err := hex.DecodeString(checkpoint.Signature)
+	if err != nil || !hmac.Equal(expected, actual) {
+		return ErrInvalidSignature
+	}
+
+	entry, err := s.auditLogRepository().GetByID(checkpoint.EntryID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("audit entry %d from the checkpoint no longer exists", checkpoint.EntryID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read audit entry: %w", err)
+	}
+	if entry.EntryHash == nil || *entry.EntryHash != checkpoint.EntryHash {
+		return fmt.Errorf("audit entry %d does not match the checkpoint", checkpoint.EntryID)
+	}
+
+	return nil
+}
+
+// signCheckpoint returns the hex HMAC over the checkpoint fields
+func (s *Service) signCheckpoint(checkpoint *Checkpoint) string {
+	mac := hmac.New(sha256.New, s.checkpointKey)
+	mac.Write([]byte("exim-pilot-audit-checkpoint\n" +
+		strconv.FormatInt(checkpoint.EntryID, 10) + "\n" +
+		checkpoint.EntryHash + "\n" +
+		strconv.FormatInt(checkpoint.Entries, 10) + "\n" +
+		checkpoint.CreatedAt.UTC().Format(time.RFC3339Nano)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
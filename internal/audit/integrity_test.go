@@ -0,0 +1,158 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+func newTestAuditService(t *testing.T) (*Service, *database.DB) {
+	t.Helper()
+
+	db, err := database.Connect(&database.Config{
+		Path:            filepath.Join(t.TempDir(), "audit.db"),
+		MaxOpenConns:    1,
+		MaxIdleConns:    1,
+		ConnMaxLifetime: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := database.MigrateUp(db); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	service := NewService(database.NewRepository(db))
+	service.SetCheckpointKey("test-signing-key")
+	return service, db
+}
+
+func logTestActions(t *testing.T, service *Service, n int) {
+	t.Helper()
+
+	auditCtx := &AuditContext{UserID: "1", IPAddress: "127.0.0.1"}
+	for i := 0; i < n; i++ {
+		messageID := "1ABC23-DEF456-GH"
+		if err := service.LogQueueOperation(context.Background(), "freeze", messageID, auditCtx, true, ""); err != nil {
+			t.Fatalf("LogQueueOperation failed: %v", err)
+		}
+	}
+}
+
+func TestValidateAuditIntegrity(t *testing.T) {
+	service, db := newTestAuditService(t)
+	ctx := context.Background()
+
+	// An entry written before the chain existed is reported but does not break it
+	if _, err := db.Exec("INSERT INTO audit_log (timestamp, action) VALUES (?, 'legacy')", time.Now()); err != nil {
+		t.Fatalf("Failed to insert legacy entry: %v", err)
+	}
+	if _, err := db.Exec("UPDATE audit_chain_state SET last_unsealed_id = 1"); err != nil {
+		t.Fatalf("Failed to record legacy entry: %v", err)
+	}
+
+	logTestActions(t, service, 5)
+
+	report, err := service.ValidateAuditIntegrity(ctx)
+	if err != nil {
+		t.Fatalf("ValidateAuditIntegrity failed: %v", err)
+	}
+	if !report.Valid || report.CheckedEntries != 5 || report.UnsealedEntries != 1 {
+		t.Fatalf("Unexpected report for intact chain: %+v", report)
+	}
+
+	// Editing an entry breaks its own hash
+	if _, err := db.Exec("UPDATE audit_log SET user_id = 'someone-else' WHERE id = 4"); err != nil {
+		t.Fatalf("Failed to tamper with entry: %v", err)
+	}
+	report, err = service.ValidateAuditIntegrity(ctx)
+	if err != nil {
+		t.Fatalf("ValidateAuditIntegrity failed: %v", err)
+	}
+	if report.Valid || report.BrokenLink == nil || report.BrokenLink.EntryID != 4 {
+		t.Fatalf("Expected broken link at entry 4, got %+v", report.BrokenLink)
+	}
+	if _, err := db.Exec("UPDATE audit_log SET user_id = '1' WHERE id = 4"); err != nil {
+		t.Fatalf("Failed to restore entry: %v", err)
+	}
+
+	// Deleting an entry breaks the link of its successor
+	if _, err := db.Exec("DELETE FROM audit_log WHERE id = 3"); err != nil {
+		t.Fatalf("Failed to delete entry: %v", err)
+	}
+	report, err = service.ValidateAuditIntegrity(ctx)
+	if err != nil {
+		t.Fatalf("ValidateAuditIntegrity failed: %v", err)
+	}
+	if report.Valid || report.BrokenLink == nil || report.BrokenLink.EntryID != 4 {
+		t.Fatalf("Expected broken link at entry 4 after deletion, got %+v", report.BrokenLink)
+	}
+}
+
+func TestValidateAuditIntegrityUnsealedPrefix(t *testing.T) {
+	service, db := newTestAuditService(t)
+	ctx := context.Background()
+
+	logTestActions(t, service, 4)
+
+	// Clearing the hashes of the oldest entries does not pass them off as legacy ones
+	if _, err := db.Exec("UPDATE audit_log SET prev_hash = NULL, entry_hash = NULL, user_id = 'someone-else' WHERE id <= 2"); err != nil {
+		t.Fatalf("Failed to tamper with entries: %v", err)
+	}
+	report, err := service.ValidateAuditIntegrity(ctx)
+	if err != nil {
+		t.Fatalf("ValidateAuditIntegrity failed: %v", err)
+	}
+	if report.Valid || report.UnsealedEntries != 0 || report.BrokenLink == nil || report.BrokenLink.EntryID != 1 {
+		t.Fatalf("Expected broken link at entry 1, got %+v", report)
+	}
+	if report.BrokenLink.Reason != "entry is not sealed" {
+		t.Errorf("Unexpected reason %q", report.BrokenLink.Reason)
+	}
+}
+
+func TestAuditCheckpoint(t *testing.T) {
+	service, db := newTestAuditService(t)
+	ctx := context.Background()
+
+	logTestActions(t, service, 3)
+
+	checkpoint, err := service.CreateCheckpoint(ctx)
+	if err != nil {
+		t.Fatalf("CreateCheckpoint failed: %v", err)
+	}
+	if checkpoint.EntryID != 3 || checkpoint.Entries != 3 || checkpoint.Signature == "" {
+		t.Fatalf("Unexpected checkpoint: %+v", checkpoint)
+	}
+
+	// New entries do not invalidate an earlier checkpoint
+	logTestActions(t, service, 2)
+	if err := service.VerifyCheckpoint(ctx, checkpoint); err != nil {
+		t.Fatalf("VerifyCheckpoint failed: %v", err)
+	}
+
+	forged := *checkpoint
+	forged.EntryID = 5
+	if err := service.VerifyCheckpoint(ctx, &forged); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected forged checkpoint to be rejected, got %v", err)
+	}
+
+	// Truncating the log past the checkpoint is detected
+	if _, err := db.Exec("DELETE FROM audit_log WHERE id >= 3"); err != nil {
+		t.Fatalf("Failed to truncate audit log: %v", err)
+	}
+	if err := service.VerifyCheckpoint(ctx, checkpoint); err == nil {
+		t.Error("Expected checkpoint verification to fail after truncation")
+	}
+
+	service.SetCheckpointKey("")
+	if _, err := service.CreateCheckpoint(ctx); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("Expected ErrNoSigningKey, got %v", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/andreitelteu/exim-pilot/internal/database"
@@ -12,7 +13,8 @@ import (
 
 // Service handles audit logging for all administrative actions
 type Service struct {
-	repository *database.Repository
+	repository    *database.Repository
+	checkpointKey []byte
 }
 
 // NewService creates a new audit service instance
@@ -37,8 +39,10 @@ const (
 	ActionBulkDelete   ActionType = "bulk_delete"
 
 	// Authentication actions
-	ActionLogin  ActionType = "login"
-	ActionLogout ActionType = "logout"
+	ActionLogin         ActionType = "login"
+	ActionLogout        ActionType = "logout"
+	ActionLoginLockout  ActionType = "login_lockout"
+	ActionLockoutsClear ActionType = "login_lockouts_clear"
 
 	// Message operations
 	ActionMessageView    ActionType = "message_view"
@@ -49,6 +53,31 @@ const (
 	ActionTagCreate      ActionType = "tag_create"
 	ActionTagDelete      ActionType = "tag_delete"
 
+	// User management
+	ActionUserCreate         ActionType = "user_create"
+	ActionUserUpdate         ActionType = "user_update"
+	ActionUserRoleChange     ActionType = "user_role_change"
+	ActionUserDisable        ActionType = "user_disable"
+	ActionUserEnable         ActionType = "user_enable"
+	ActionUserPasswordReset  ActionType = "user_password_reset"
+	ActionUserSessionsRevoke ActionType = "user_sessions_revoke"
+	ActionUserLink           ActionType = "user_link_external"
+
+	// Two-factor authentication
+	ActionMFAEnable        ActionType = "mfa_enable"
+	ActionMFADisable       ActionType = "mfa_disable"
+	ActionMFAReset         ActionType = "mfa_reset"
+	ActionMFARecoveryCodes ActionType = "mfa_recovery_codes_regenerate"
+
+	// API tokens
+	ActionAPITokenCreate ActionType = "api_token_create"
+	ActionAPITokenRevoke ActionType = "api_token_revoke"
+
+	// Audit log integrity
+	ActionAuditVerify     ActionType = "audit_verify"
+	ActionAuditCheckpoint ActionType = "audit_checkpoint"
+	ActionAuditExport     ActionType = "audit_export"
+
 	// System operations
 	ActionConfigChange ActionType = "config_change"
 	ActionSystemAccess ActionType = "system_access"
@@ -241,9 +270,54 @@ func (s *Service) LogSystemAccess(ctx context.Context, resourcePath string, audi
 	return s.LogAction(ctx, ActionSystemAccess, nil, auditCtx, details)
 }
 
-// GetAuditTrail retrieves audit log entries with filtering
+// GetAuditTrail retrieves audit log entries with filtering, newest first
 func (s *Service) GetAuditTrail(ctx context.Context, filters *AuditFilters) ([]*database.AuditLog, error) {
-	return s.repository.GetAuditLogs(filters)
+	limit := filters.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	entries, err := s.auditLogRepository().Query(filters.toDatabase(), limit, filters.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*database.AuditLog, len(entries))
+	for i := range entries {
+		result[i] = &entries[i]
+	}
+	return result, nil
+}
+
+// CountAuditTrail returns the number of entries matching the filters, ignoring Limit and Offset
+func (s *Service) CountAuditTrail(ctx context.Context, filters *AuditFilters) (int, error) {
+	return s.auditLogRepository().Count(filters.toDatabase())
+}
+
+// StreamAuditTrail calls fn for every matching entry, oldest first, ignoring Limit and Offset
+func (s *Service) StreamAuditTrail(ctx context.Context, filters *AuditFilters, fn func(*database.AuditLog) error) error {
+	return s.auditLogRepository().Each(ctx, filters.toDatabase(), fn)
+}
+
+// EntrySucceeded reports whether an audit entry records a successful action, using the
+// same rule as the Success filter
+func EntrySucceeded(entry *database.AuditLog) bool {
+	if strings.HasSuffix(entry.Action, "_failed") {
+		return false
+	}
+	if entry.Details != nil {
+		var details struct {
+			Result string `json:"result"`
+		}
+		if json.Unmarshal([]byte(*entry.Details), &details) == nil && details.Result == "failure" {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Service) auditLogRepository() *database.AuditLogRepository {
+	return database.NewAuditLogRepository(s.repository.GetDB())
 }
 
 // AuditFilters contains filtering options for audit log retrieval
@@ -254,10 +328,31 @@ type AuditFilters struct {
 	Action    *string
 	MessageID *string
 	IPAddress *string
+	Success   *bool
 	Limit     int
 	Offset    int
 }
 
+// toDatabase converts the filters to a repository filter
+func (f *AuditFilters) toDatabase() database.AuditLogFilter {
+	value := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+
+	return database.AuditLogFilter{
+		UserID:    value(f.UserID),
+		Action:    value(f.Action),
+		MessageID: value(f.MessageID),
+		IPAddress: value(f.IPAddress),
+		StartTime: f.StartTime,
+		EndTime:   f.EndTime,
+		Success:   f.Success,
+	}
+}
+
 // logToSystemLog writes audit events to system log for redundancy
 func (s *Service) logToSystemLog(action ActionType, messageID *string, auditCtx *AuditContext, details *AuditDetails) {
 	logEntry := fmt.Sprintf("AUDIT: action=%s user=%s ip=%s", action, auditCtx.UserID, auditCtx.IPAddress)
@@ -276,11 +371,3 @@ func (s *Service) logToSystemLog(action ActionType, messageID *string, auditCtx
 
 	log.Printf(logEntry)
 }
-
-// ValidateAuditIntegrity performs basic integrity checks on audit logs
-func (s *Service) ValidateAuditIntegrity(ctx context.Context) error {
-	// Check for gaps in audit log sequence
-	// Check for suspicious patterns
-	// This is a placeholder for more sophisticated integrity checks
-	return nil
-}
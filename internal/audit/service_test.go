@@ -1,8 +1,11 @@
 package audit
 
 import (
+	"context"
 	"testing"
 	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
 )
 
 func TestAuditServiceLogic(t *testing.T) {
@@ -107,3 +110,66 @@ func TestAuditDetails(t *testing.T) {
 		t.Error("Parameters not set correctly")
 	}
 }
+
+func TestGetAuditTrailFilters(t *testing.T) {
+	service, _ := newTestAuditService(t)
+	ctx := context.Background()
+
+	admin := &AuditContext{UserID: "1", IPAddress: "10.0.0.1"}
+	operator := &AuditContext{UserID: "2", IPAddress: "10.0.0.2"}
+
+	service.LogQueueOperation(ctx, "freeze", "1ABC23-DEF456-GH", admin, true, "")
+	service.LogQueueOperation(ctx, "delete", "1ABC23-DEF456-GH", operator, false, "spool file missing")
+	service.LogQueueOperation(ctx, "thaw", "2XYZ23-DEF456-GH", operator, true, "")
+
+	count := func(filters *AuditFilters) int {
+		t.Helper()
+		total, err := service.CountAuditTrail(ctx, filters)
+		if err != nil {
+			t.Fatalf("CountAuditTrail failed: %v", err)
+		}
+		return total
+	}
+
+	userID := "2"
+	messageID := "1ABC23-DEF456-GH"
+	ip := "10.0.0.1"
+	failed := false
+	future := time.Now().Add(time.Hour)
+
+	if n := count(&AuditFilters{UserID: &userID}); n != 2 {
+		t.Errorf("Expected 2 entries for user 2, got %d", n)
+	}
+	if n := count(&AuditFilters{MessageID: &messageID}); n != 2 {
+		t.Errorf("Expected 2 entries for message, got %d", n)
+	}
+	if n := count(&AuditFilters{IPAddress: &ip}); n != 1 {
+		t.Errorf("Expected 1 entry for IP, got %d", n)
+	}
+	if n := count(&AuditFilters{Success: &failed}); n != 1 {
+		t.Errorf("Expected 1 failed entry, got %d", n)
+	}
+	if n := count(&AuditFilters{StartTime: &future}); n != 0 {
+		t.Errorf("Expected no entries after start time, got %d", n)
+	}
+
+	entries, err := service.GetAuditTrail(ctx, &AuditFilters{UserID: &userID, Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatalf("GetAuditTrail failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Action != string(ActionQueueDelete) || EntrySucceeded(entries[0]) {
+		t.Fatalf("Expected the failed delete on the second page, got %+v", entries)
+	}
+
+	var streamed []int64
+	err = service.StreamAuditTrail(ctx, &AuditFilters{}, func(entry *database.AuditLog) error {
+		streamed = append(streamed, entry.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamAuditTrail failed: %v", err)
+	}
+	if len(streamed) != 3 || streamed[0] != 1 || streamed[2] != 3 {
+		t.Errorf("Expected entries streamed oldest first, got %v", streamed)
+	}
+}
@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/andreitelteu/exim-pilot/internal/audit"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrUnknownUser means the authenticator does not know the user, so the next
+	// configured authenticator is tried
+	ErrUnknownUser = errors.New("unknown user")
+
+	// ErrInvalidCredentials means the user is known but the password is wrong
+	ErrInvalidCredentials = errors.New("invalid credentials")
+
+	// ErrNoRole means an external user authenticated but none of their groups maps to a role
+	ErrNoRole = errors.New("no role mapped for user")
+
+	// ErrAccountNotLinked means an external identity names an existing account that was
+	// neither provisioned by nor linked to its source
+	ErrAccountNotLinked = errors.New("account is not linked to the external identity source")
+)
+
+// Sources of external identities
+const (
+	SourceLDAP          = "ldap"
+	SourceTrustedHeader = "header"
+)
+
+// externalPasswordPrefix marks password hashes of users provisioned by an external
+// authenticator. No password matches it, and the local authenticator skips these users.
+const externalPasswordPrefix = "external:"
+
+// rolePrecedence orders roles from most to least privileged, used when a user's groups
+// map to several roles
+var rolePrecedence = []string{RoleAdmin, RoleOperator, RoleAuditor, RoleViewer}
+
+// Identity is the result of a successful authentication
+type Identity struct {
+	Username string
+	Email    string
+	FullName string
+	Groups   []string
+
+	// Role is the role derived from external group membership. It is empty for local users.
+	Role string
+
+	// Source names the authenticator that verified the identity
+	Source string
+
+	// User is set when the identity belongs to an existing local account
+	User *database.User
+}
+
+// Authenticator verifies a username and password against an identity store
+type Authenticator interface {
+	Name() string
+	Authenticate(username, password string) (*Identity, error)
+}
+
+// LocalAuthenticator checks passwords stored in the users table
+type LocalAuthenticator struct {
+	userRepo *database.UserRepository
+}
+
+// NewLocalAuthenticator creates an authenticator backed by the users table
+func NewLocalAuthenticator(db *database.DB) *LocalAuthenticator {
+	return &LocalAuthenticator{userRepo: database.NewUserRepository(db)}
+}
+
+// Name returns the backend name
+func (a *LocalAuthenticator) Name() string {
+	return "local"
+}
+
+// Authenticate verifies a local password
+func (a *LocalAuthenticator) Authenticate(username, password string) (*Identity, error) {
+	user, err := a.userRepo.GetByUsername(username)
+	if err != nil || strings.HasPrefix(user.PasswordHash, externalPasswordPrefix) {
+		return nil, ErrUnknownUser
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return &Identity{Username: user.Username, Source: a.Name(), User: user}, ErrInvalidCredentials
+	}
+
+	return &Identity{Username: user.Username, Source: a.Name(), User: user}, nil
+}
+
+// SetAuthenticators replaces the password authenticators consulted by Login, in order.
+// With none configured only local accounts can log in.
+func (s *Service) SetAuthenticators(authenticators ...Authenticator) {
+	if len(authenticators) > 0 {
+		s.authenticators = authenticators
+	}
+}
+
+// authenticate runs the configured authenticators until one knows the user. A known user
+// with a wrong password stops the chain; the returned identity then describes that user.
+func (s *Service) authenticate(username, password string) (*Identity, error) {
+	for _, authenticator := range s.authenticators {
+		identity, err := authenticator.Authenticate(username, password)
+		if errors.Is(err, ErrUnknownUser) {
+			continue
+		}
+		if err != nil {
+			return identity, err
+		}
+		return identity, nil
+	}
+
+	return nil, ErrUnknownUser
+}
+
+// ResolveIdentity returns the local account for an authenticated identity. Users of
+// external authenticators are created on first login, and their role, email and name are
+// kept in sync with the identity store on later logins. An existing account is only used
+// when it was provisioned by the identity's source or linked to it with
+// LinkExternalUser, otherwise ErrAccountNotLinked is returned.
+func (s *Service) ResolveIdentity(identity *Identity) (*database.User, error) {
+	if identity.User != nil {
+		return identity.User, nil
+	}
+
+	if !IsValidRole(identity.Role) {
+		return nil, fmt.Errorf("%w: %s", ErrNoRole, identity.Username)
+	}
+
+	auditCtx := &audit.AuditContext{UserID: "system:" + identity.Source, IPAddress: "local"}
+
+	user, err := s.userRepo.GetByUsernameAnyStatus(identity.Username)
+	if err != nil {
+		user = &database.User{
+			Username:     identity.Username,
+			PasswordHash: externalPasswordPrefix + identity.Source,
+			Email:        &identity.Email,
+			FullName:     &identity.FullName,
+			Role:         identity.Role,
+			IsActive:     true,
+		}
+		if err := s.userRepo.Create(user); err != nil {
+			return nil, fmt.Errorf("failed to provision user: %w", err)
+		}
+
+		s.logUserChange(audit.ActionUserCreate, user, auditCtx, nil, map[string]interface{}{
+			"username": user.Username,
+			"role":     user.Role,
+			"source":   identity.Source,
+			"groups":   identity.Groups,
+		})
+		return s.userRepo.GetByID(user.ID)
+	}
+
+	// Whoever controls a directory entry or the proxy header must not be able to take
+	// over a local account of the same name, such as the bootstrap admin
+	if user.PasswordHash != externalPasswordPrefix+identity.Source {
+		return nil, fmt.Errorf("%w: %s", ErrAccountNotLinked, identity.Username)
+	}
+
+	if !user.IsActive {
+		return nil, fmt.Errorf("user account is disabled")
+	}
+
+	if user.Role != identity.Role {
+		if err := s.userRepo.UpdateRole(user.ID, identity.Role); err != nil {
+			return nil, err
+		}
+		s.logUserChange(audit.ActionUserRoleChange, user, auditCtx,
+			map[string]interface{}{"role": user.Role},
+			map[string]interface{}{"role": identity.Role, "source": identity.Source, "groups": identity.Groups})
+	}
+
+	var email, fullName *string
+	if identity.Email != "" && (user.Email == nil || *user.Email != identity.Email) {
+		email = &identity.Email
+	}
+	if identity.FullName != "" && (user.FullName == nil || *user.FullName != identity.FullName) {
+		fullName = &identity.FullName
+	}
+	if email != nil || fullName != nil {
+		if err := s.userRepo.UpdateProfile(user.ID, email, fullName); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.userRepo.GetByID(user.ID)
+}
+
+// LinkExternalUser lets an external source log in to an existing account. The account's
+// local password stops working and its sessions are revoked; from its next login its role,
+// email and name follow the external identity. Resetting the password makes the account
+// local again.
+func (s *Service) LinkExternalUser(userID int64, source string, auditCtx *audit.AuditContext) (*database.User, error) {
+	if source != SourceLDAP && source != SourceTrustedHeader {
+		return nil, fmt.Errorf("external source must be %s or %s", SourceLDAP, SourceTrustedHeader)
+	}
+
+	user, err := s.userRepo.GetByIDAnyStatus(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := "local"
+	if strings.HasPrefix(user.PasswordHash, externalPasswordPrefix) {
+		previous = strings.TrimPrefix(user.PasswordHash, externalPasswordPrefix)
+	}
+
+	if err := s.userRepo.UpdatePassword(userID, externalPasswordPrefix+source); err != nil {
+		return nil, err
+	}
+	if err := s.sessionRepo.DeleteByUserID(userID); err != nil {
+		return nil, err
+	}
+
+	s.logUserChange(audit.ActionUserLink, user, auditCtx,
+		map[string]interface{}{"source": previous},
+		map[string]interface{}{"source": source})
+
+	return s.userRepo.GetByIDAnyStatus(userID)
+}
+
+// roleForGroups maps group names to the most privileged configured role. Group names are
+// compared case-insensitively; mapping keys may be plain names or full DNs.
+func roleForGroups(groups []string, groupRoles map[string]string, defaultRole string) string {
+	granted := make(map[string]bool)
+	for group, role := range groupRoles {
+		for _, member := range groups {
+			if strings.EqualFold(strings.TrimSpace(group), strings.TrimSpace(member)) {
+				granted[role] = true
+			}
+		}
+	}
+
+	for _, role := range rolePrecedence {
+		if granted[role] {
+			return role
+		}
+	}
+
+	return defaultRole
+}
+
+// validateGroupRoles checks that a group mapping only names known roles
+func validateGroupRoles(groupRoles map[string]string, defaultRole string) error {
+	for group, role := range groupRoles {
+		if !IsValidRole(role) {
+			return fmt.Errorf("group %q maps to unknown role %q", group, role)
+		}
+	}
+	if defaultRole != "" && !IsValidRole(defaultRole) {
+		return fmt.Errorf("unknown default role %q", defaultRole)
+	}
+	return nil
+}
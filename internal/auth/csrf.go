@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+)
+
+// SessionCSRFToken returns the CSRF token of a session. Sessions created before tokens
+// were introduced are given one.
+func (s *Service) SessionCSRFToken(sessionID string) (string, error) {
+	session, err := s.sessionRepo.GetByID(sessionID)
+	if err != nil {
+		return "", fmt.Errorf("invalid session")
+	}
+	if session.CSRFToken != nil && *session.CSRFToken != "" {
+		return *session.CSRFToken, nil
+	}
+
+	token, err := generateSessionID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate CSRF token: %w", err)
+	}
+	if err := s.sessionRepo.SetCSRFToken(sessionID, token); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ValidateCSRFToken reports whether token is the CSRF token of the session
+func (s *Service) ValidateCSRFToken(sessionID, token string) bool {
+	if token == "" {
+		return false
+	}
+	session, err := s.sessionRepo.GetByID(sessionID)
+	if err != nil || session.CSRFToken == nil || *session.CSRFToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(*session.CSRFToken), []byte(token)) == 1
+}
+
+// EndSession deletes a session without recording a logout. The login endpoint uses it to
+// retire the session a browser presented before logging in, so every login starts with a
+// fresh session ID and the previous one stops working.
+func (s *Service) EndSession(sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	s.sessionRepo.Delete(sessionID)
+}
@@ -0,0 +1,46 @@
+package auth
+
+import "testing"
+
+func TestSessionCSRFToken(t *testing.T) {
+	service := newTestService(t)
+
+	if _, err := service.CreateUser("carol", "Secret123!", "", "", RoleViewer, nil); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	loginResp, err := service.Login("carol", "Secret123!", "192.0.2.3", "test")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	token, err := service.SessionCSRFToken(loginResp.SessionID)
+	if err != nil || token == "" || token != loginResp.CSRFToken {
+		t.Fatalf("Expected the login's CSRF token, got %q, %v", token, err)
+	}
+	if !service.ValidateCSRFToken(loginResp.SessionID, token) {
+		t.Error("Expected the session's token to be valid")
+	}
+	if service.ValidateCSRFToken(loginResp.SessionID, "") || service.ValidateCSRFToken("unknown", token) {
+		t.Error("Expected empty tokens and unknown sessions to be rejected")
+	}
+
+	// Sessions from before CSRF tokens get one on first use
+	if _, err := service.sessionRepo.GetDB().Exec("UPDATE sessions SET csrf_token = NULL"); err != nil {
+		t.Fatalf("Failed to clear the token: %v", err)
+	}
+	if service.ValidateCSRFToken(loginResp.SessionID, token) {
+		t.Error("Expected a session without a token to reject every token")
+	}
+	backfilled, err := service.SessionCSRFToken(loginResp.SessionID)
+	if err != nil || backfilled == "" || backfilled == token {
+		t.Fatalf("Expected a new token, got %q, %v", backfilled, err)
+	}
+	if !service.ValidateCSRFToken(loginResp.SessionID, backfilled) {
+		t.Error("Expected the new token to be valid")
+	}
+
+	service.EndSession(loginResp.SessionID)
+	if _, err := service.ValidateSession(loginResp.SessionID); err == nil {
+		t.Error("Expected the ended session to be invalid")
+	}
+}
@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPConfig configures LDAPAuthenticator. Users are located either with UserDNTemplate
+// or by searching UserBaseDN with UserFilter, optionally bound as BindDN.
+type LDAPConfig struct {
+	URL                string // ldap://host:389 or ldaps://host:636
+	StartTLS           bool
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+
+	BindDN       string
+	BindPassword string
+
+	UserDNTemplate string // e.g. "uid=%s,ou=people,dc=example,dc=com"
+	UserBaseDN     string
+	UserFilter     string // e.g. "(&(objectClass=person)(uid=%s))"
+	EmailAttribute string
+	NameAttribute  string
+
+	GroupBaseDN       string
+	GroupFilter       string // %s is replaced with the user DN, e.g. "(member=%s)"
+	GroupAttribute    string
+	MemberOfAttribute string
+
+	// GroupRoles maps group names or DNs to roles. DefaultRole applies to users in no
+	// mapped group; when empty such users are refused.
+	GroupRoles  map[string]string
+	DefaultRole string
+}
+
+// LDAPAuthenticator verifies passwords with an LDAP simple bind and derives the role from
+// group membership
+type LDAPAuthenticator struct {
+	config LDAPConfig
+}
+
+// NewLDAPAuthenticator validates the configuration and fills in defaults
+func NewLDAPAuthenticator(config LDAPConfig) (*LDAPAuthenticator, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("LDAP URL is required")
+	}
+	if _, err := ldapServerName(config.URL); err != nil {
+		return nil, err
+	}
+	if config.UserDNTemplate == "" && config.UserBaseDN == "" {
+		return nil, fmt.Errorf("LDAP requires either a user DN template or a user base DN")
+	}
+	if err := validateGroupRoles(config.GroupRoles, config.DefaultRole); err != nil {
+		return nil, fmt.Errorf("LDAP group mapping: %w", err)
+	}
+
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.UserFilter == "" {
+		config.UserFilter = "(uid=%s)"
+	}
+	if config.EmailAttribute == "" {
+		config.EmailAttribute = "mail"
+	}
+	if config.NameAttribute == "" {
+		config.NameAttribute = "cn"
+	}
+	if config.GroupFilter == "" {
+		config.GroupFilter = "(member=%s)"
+	}
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = "cn"
+	}
+	if config.MemberOfAttribute == "" {
+		config.MemberOfAttribute = "memberOf"
+	}
+
+	// Catch malformed filters at startup rather than on the first login
+	if _, err := ldap.CompileFilter(fmt.Sprintf(config.UserFilter, "user")); err != nil {
+		return nil, fmt.Errorf("invalid LDAP user filter: %w", err)
+	}
+	if _, err := ldap.CompileFilter(fmt.Sprintf(config.GroupFilter, "cn=user")); err != nil {
+		return nil, fmt.Errorf("invalid LDAP group filter: %w", err)
+	}
+
+	return &LDAPAuthenticator{config: config}, nil
+}
+
+// Name returns the backend name
+func (a *LDAPAuthenticator) Name() string {
+	return SourceLDAP
+}
+
+// Authenticate binds as the user and resolves their groups and role
+func (a *LDAPAuthenticator) Authenticate(username, password string) (*Identity, error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+	// An empty password would turn the bind into an unauthenticated bind, which most
+	// servers accept
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	attributes := []string{a.config.EmailAttribute, a.config.NameAttribute, a.config.MemberOfAttribute}
+
+	var entry *ldap.Entry
+	userDN := ""
+	if a.config.UserDNTemplate != "" {
+		userDN = fmt.Sprintf(a.config.UserDNTemplate, ldap.EscapeDN(username))
+	} else {
+		if err := a.bindService(conn); err != nil {
+			return nil, err
+		}
+
+		filter := fmt.Sprintf(a.config.UserFilter, ldap.EscapeFilter(username))
+		entries, err := a.search(conn, a.config.UserBaseDN, ldap.ScopeWholeSubtree, filter, attributes)
+		if err != nil {
+			return nil, fmt.Errorf("LDAP user search failed: %w", err)
+		}
+		if len(entries) == 0 {
+			return nil, ErrUnknownUser
+		}
+		if len(entries) > 1 {
+			return nil, fmt.Errorf("LDAP user search for %q matched %d entries", username, len(entries))
+		}
+		entry = entries[0]
+		userDN = entry.DN
+	}
+
+	if err := conn.Bind(userDN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return &Identity{Username: username, Source: a.Name()}, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("LDAP bind failed: %w", err)
+	}
+
+	if entry == nil {
+		entries, err := a.search(conn, userDN, ldap.ScopeBaseObject, "(objectClass=*)", attributes)
+		if err == nil && len(entries) == 1 {
+			entry = entries[0]
+		} else {
+			entry = &ldap.Entry{DN: userDN}
+		}
+	}
+
+	groups, err := a.groups(conn, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Username: username,
+		Email:    entry.GetEqualFoldAttributeValue(a.config.EmailAttribute),
+		FullName: entry.GetEqualFoldAttributeValue(a.config.NameAttribute),
+		Groups:   groups,
+		Role:     roleForGroups(groups, a.config.GroupRoles, a.config.DefaultRole),
+		Source:   a.Name(),
+	}
+
+	if identity.Role == "" {
+		return nil, fmt.Errorf("%w: %s is not in any mapped LDAP group", ErrNoRole, username)
+	}
+
+	return identity, nil
+}
+
+// groups collects the user's group names from memberOf and from a group search. Both the
+// DN and the value of its first RDN are returned so mappings can use either.
+func (a *LDAPAuthenticator) groups(conn *ldap.Conn, entry *ldap.Entry) ([]string, error) {
+	seen := make(map[string]bool)
+	var groups []string
+	add := func(name string) {
+		key := strings.ToLower(name)
+		if name != "" && !seen[key] {
+			seen[key] = true
+			groups = append(groups, name)
+		}
+	}
+
+	for _, dn := range entry.GetEqualFoldAttributeValues(a.config.MemberOfAttribute) {
+		add(dn)
+		add(firstRDNValue(dn))
+	}
+
+	if a.config.GroupBaseDN != "" {
+		if err := a.bindService(conn); err != nil {
+			return nil, err
+		}
+
+		filter := fmt.Sprintf(a.config.GroupFilter, ldap.EscapeFilter(entry.DN))
+		results, err := a.search(conn, a.config.GroupBaseDN, ldap.ScopeWholeSubtree, filter, []string{a.config.GroupAttribute})
+		if err != nil {
+			return nil, fmt.Errorf("LDAP group search failed: %w", err)
+		}
+
+		for _, group := range results {
+			add(group.DN)
+			for _, name := range group.GetEqualFoldAttributeValues(a.config.GroupAttribute) {
+				add(name)
+			}
+		}
+	}
+
+	return groups, nil
+}
+
+// bindService binds with the service account when one is configured
+func (a *LDAPAuthenticator) bindService(conn *ldap.Conn) error {
+	if a.config.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(a.config.BindDN, a.config.BindPassword); err != nil {
+		return fmt.Errorf("LDAP service account bind failed: %w", err)
+	}
+	return nil
+}
+
+// search runs a search without following referrals
+func (a *LDAPAuthenticator) search(conn *ldap.Conn, baseDN string, scope int, filter string, attributes []string) ([]*ldap.Entry, error) {
+	request := ldap.NewSearchRequest(baseDN, scope, ldap.NeverDerefAliases, 0,
+		int(a.config.Timeout/time.Second), false, filter, attributes, nil)
+
+	result, err := conn.Search(request)
+	if err != nil {
+		return nil, err
+	}
+	return result.Entries, nil
+}
+
+// dial connects to the server, upgrading to TLS as configured
+func (a *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	serverName, err := ldapServerName(a.config.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: a.config.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	conn, err := ldap.DialURL(a.config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.config.Timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
+	}
+	conn.SetTimeout(a.config.Timeout)
+
+	if a.config.StartTLS && !strings.HasPrefix(strings.ToLower(a.config.URL), "ldaps:") {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS failed: %w", err)
+		}
+	}
+
+	return conn, nil
+}
+
+// ldapServerName checks that the URL is an ldap:// or ldaps:// URL with a host and
+// returns the host name certificates are verified against
+func ldapServerName(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid LDAP URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "ldap", "ldaps":
+	default:
+		return "", fmt.Errorf("unsupported LDAP URL scheme %q", u.Scheme)
+	}
+
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("LDAP URL has no host")
+	}
+
+	return u.Hostname(), nil
+}
+
+// firstRDNValue returns "admins" for "cn=admins,ou=groups,dc=example,dc=com"
+func firstRDNValue(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return ""
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
@@ -0,0 +1,441 @@
+package auth
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeLDAPEntry is a directory entry served by fakeLDAPServer
+type fakeLDAPEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// fakeLDAPServer is an in-process stand-in for an LDAP directory. It answers simple
+// binds and searches with and, or, not, equality and presence filters.
+type fakeLDAPServer struct {
+	listener net.Listener
+	entries  []fakeLDAPEntry
+}
+
+func newFakeLDAPServer(t *testing.T, entries ...fakeLDAPEntry) *fakeLDAPServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &fakeLDAPServer{listener: listener, entries: entries}
+	go server.serve()
+	return server
+}
+
+func (s *fakeLDAPServer) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *fakeLDAPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeLDAPServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		message, err := ber.ReadPacket(conn)
+		if err != nil || len(message.Children) < 2 {
+			return
+		}
+		messageID := message.Children[0].Value
+		op := message.Children[1]
+
+		reply := func(response *ber.Packet) {
+			envelope := ber.NewSequence("LDAP message")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "message ID"))
+			envelope.AppendChild(response)
+			conn.Write(envelope.Bytes())
+		}
+		result := func(tag ber.Tag, code int64) *ber.Packet {
+			response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "result")
+			response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "result code"))
+			response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matched DN"))
+			response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnostic message"))
+			return response
+		}
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := int64(ldap.LDAPResultInvalidCredentials)
+			for _, entry := range s.entries {
+				if strings.EqualFold(entry.dn, berString(op.Children[1])) && entry.password != "" &&
+					entry.password == berString(op.Children[2]) {
+					code = ldap.LDAPResultSuccess
+				}
+			}
+			reply(result(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			baseDN := strings.ToLower(berString(op.Children[0]))
+			scope := op.Children[1].Value.(int64)
+			for _, entry := range s.entries {
+				dn := strings.ToLower(entry.dn)
+				if scope == ldap.ScopeBaseObject && dn != baseDN {
+					continue
+				}
+				if scope == ldap.ScopeWholeSubtree && !strings.HasSuffix(dn, baseDN) {
+					continue
+				}
+				if !fakeLDAPMatch(op.Children[6], entry) {
+					continue
+				}
+
+				response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "entry")
+				response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "DN"))
+				attributes := ber.NewSequence("attributes")
+				for name, values := range entry.attributes {
+					attribute := ber.NewSequence("attribute")
+					attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+					set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "values")
+					for _, value := range values {
+						set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "value"))
+					}
+					attribute.AppendChild(set)
+					attributes.AppendChild(attribute)
+				}
+				response.AppendChild(attributes)
+				reply(response)
+			}
+			reply(result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+// berString returns the contents of a primitive element
+func berString(packet *ber.Packet) string {
+	if packet.Data == nil {
+		return ""
+	}
+	return packet.Data.String()
+}
+
+func fakeLDAPMatch(filter *ber.Packet, entry fakeLDAPEntry) bool {
+	values := func(attribute string) []string {
+		for name, values := range entry.attributes {
+			if strings.EqualFold(name, attribute) {
+				return values
+			}
+		}
+		return nil
+	}
+
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !fakeLDAPMatch(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if fakeLDAPMatch(child, entry) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !fakeLDAPMatch(filter.Children[0], entry)
+	case ldap.FilterEqualityMatch:
+		for _, value := range values(berString(filter.Children[0])) {
+			if strings.EqualFold(value, berString(filter.Children[1])) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(values(berString(filter))) > 0
+	}
+	return false
+}
+
+func testDirectory() []fakeLDAPEntry {
+	return []fakeLDAPEntry{
+		{
+			dn:         "cn=reader,dc=example,dc=com",
+			password:   "reader-secret",
+			attributes: map[string][]string{"objectClass": {"person"}},
+		},
+		{
+			dn:       "uid=alice,ou=people,dc=example,dc=com",
+			password: "alice-secret",
+			attributes: map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"alice"},
+				"mail":        {"alice@example.com"},
+				"cn":          {"Alice Example"},
+				"memberOf":    {"cn=auditors,ou=groups,dc=example,dc=com"},
+			},
+		},
+		{
+			dn:       "uid=bob,ou=people,dc=example,dc=com",
+			password: "bob-secret",
+			attributes: map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"bob"},
+			},
+		},
+		{
+			dn: "cn=mail-ops,ou=groups,dc=example,dc=com",
+			attributes: map[string][]string{
+				"objectClass": {"groupOfNames"},
+				"cn":          {"mail-ops"},
+				"member":      {"uid=alice,ou=people,dc=example,dc=com"},
+			},
+		},
+	}
+}
+
+func TestLDAPLoginProvisionsUser(t *testing.T) {
+	server := newFakeLDAPServer(t, testDirectory()...)
+	service := newTestService(t)
+
+	ldapAuth, err := NewLDAPAuthenticator(LDAPConfig{
+		URL:          server.url(),
+		Timeout:      2 * time.Second,
+		BindDN:       "cn=reader,dc=example,dc=com",
+		BindPassword: "reader-secret",
+		UserBaseDN:   "ou=people,dc=example,dc=com",
+		UserFilter:   "(&(objectClass=person)(uid=%s))",
+		GroupBaseDN:  "ou=groups,dc=example,dc=com",
+		GroupRoles: map[string]string{
+			"auditors": RoleAuditor,
+			"mail-ops": RoleOperator,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewLDAPAuthenticator failed: %v", err)
+	}
+	service.SetAuthenticators(&LocalAuthenticator{userRepo: service.userRepo}, ldapAuth)
+
+	resp, err := service.Login("Alice", "alice-secret", "127.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("LDAP login failed: %v", err)
+	}
+	if resp.User.Username != "alice" || resp.User.Role != RoleOperator {
+		t.Fatalf("Expected alice with role %s, got %s with role %s", RoleOperator, resp.User.Username, resp.User.Role)
+	}
+	if resp.User.Email == nil || *resp.User.Email != "alice@example.com" {
+		t.Errorf("Expected email to be synced from LDAP, got %v", resp.User.Email)
+	}
+	if resp.SessionID == "" {
+		t.Error("Expected a session to be created")
+	}
+
+	// The provisioned account has no usable local password
+	if _, err := (&LocalAuthenticator{userRepo: service.userRepo}).Authenticate("alice", "external:ldap"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("Expected local authenticator to skip LDAP users, got %v", err)
+	}
+
+	if _, err := service.Login("alice", "wrong", "127.0.0.1", "test"); err == nil {
+		t.Error("Expected login with a wrong LDAP password to fail")
+	}
+
+	// bob exists in LDAP but is in no mapped group and there is no default role
+	if _, err := service.Login("bob", "bob-secret", "127.0.0.1", "test"); err == nil {
+		t.Error("Expected login of an unmapped LDAP user to fail")
+	}
+}
+
+func TestLDAPFallsThroughToLocalUsers(t *testing.T) {
+	server := newFakeLDAPServer(t, testDirectory()...)
+	service := newTestService(t)
+
+	ldapAuth, err := NewLDAPAuthenticator(LDAPConfig{
+		URL:            server.url(),
+		Timeout:        2 * time.Second,
+		UserDNTemplate: "uid=%s,ou=people,dc=example,dc=com",
+		DefaultRole:    RoleViewer,
+	})
+	if err != nil {
+		t.Fatalf("NewLDAPAuthenticator failed: %v", err)
+	}
+	service.SetAuthenticators(&LocalAuthenticator{userRepo: service.userRepo}, ldapAuth)
+
+	if _, err := service.CreateUser("localadmin", "Secret123!", "", "", RoleAdmin, nil); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	resp, err := service.Login("localadmin", "Secret123!", "127.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("Local login failed: %v", err)
+	}
+	if resp.User.Role != RoleAdmin {
+		t.Errorf("Expected local role to be kept, got %s", resp.User.Role)
+	}
+
+	// A template DN bind reads attributes with a base search
+	resp, err = service.Login("bob", "bob-secret", "127.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("LDAP login with DN template failed: %v", err)
+	}
+	if resp.User.Role != RoleViewer {
+		t.Errorf("Expected default role %s, got %s", RoleViewer, resp.User.Role)
+	}
+
+	if _, err := service.Login("carol", "anything", "127.0.0.1", "test"); err == nil {
+		t.Error("Expected login of an unknown user to fail")
+	}
+}
+
+func TestTrustedHeaderAuthenticator(t *testing.T) {
+	service := newTestService(t)
+
+	headerAuth, err := NewTrustedHeaderAuthenticator(TrustedHeaderConfig{
+		TrustedProxies: []string{"10.0.0.0/8", "127.0.0.1"},
+		GroupRoles:     map[string]string{"mail-admins": RoleAdmin},
+		DefaultRole:    RoleViewer,
+	})
+	if err != nil {
+		t.Fatalf("NewTrustedHeaderAuthenticator failed: %v", err)
+	}
+
+	header := http.Header{}
+	header.Set("X-Remote-User", "Dana")
+	header.Set("X-Remote-Groups", "staff, mail-admins")
+
+	identity, err := headerAuth.Identify("192.168.1.10:4000", header)
+	if err != nil || identity != nil {
+		t.Fatalf("Expected headers from an untrusted address to be ignored, got %v, %v", identity, err)
+	}
+
+	identity, err = headerAuth.Identify("10.1.2.3:4000", header)
+	if err != nil || identity == nil {
+		t.Fatalf("Expected identity from trusted proxy, got %v, %v", identity, err)
+	}
+	if identity.Username != "dana" || identity.Role != RoleAdmin {
+		t.Fatalf("Expected dana with role admin, got %s with role %s", identity.Username, identity.Role)
+	}
+
+	user, err := service.ResolveIdentity(identity)
+	if err != nil {
+		t.Fatalf("ResolveIdentity failed: %v", err)
+	}
+	if user.Role != RoleAdmin {
+		t.Errorf("Expected provisioned role admin, got %s", user.Role)
+	}
+
+	// Group changes at the proxy are reflected on the next request
+	header.Set("X-Remote-Groups", "staff")
+	identity, _ = headerAuth.Identify("127.0.0.1:4000", header)
+	user, err = service.ResolveIdentity(identity)
+	if err != nil {
+		t.Fatalf("ResolveIdentity failed: %v", err)
+	}
+	if user.Role != RoleViewer {
+		t.Errorf("Expected role to be synced to viewer, got %s", user.Role)
+	}
+
+	if _, err := NewTrustedHeaderAuthenticator(TrustedHeaderConfig{}); err == nil {
+		t.Error("Expected trusted header authenticator without proxies to be rejected")
+	}
+}
+
+func TestExternalLoginRequiresLinkedAccount(t *testing.T) {
+	service := newTestService(t)
+
+	admin, err := service.CreateUser("admin", "Secret123!", "", "", RoleAdmin, nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	erin, err := service.CreateUser("erin", "Secret123!", "", "", RoleOperator, nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	// A directory entry or proxy header naming a local account does not log in to it
+	for _, identity := range []*Identity{
+		{Username: "admin", Role: RoleViewer, Source: SourceTrustedHeader},
+		{Username: "admin", Role: RoleAdmin, Source: SourceLDAP},
+	} {
+		if _, err := service.ResolveIdentity(identity); !errors.Is(err, ErrAccountNotLinked) {
+			t.Errorf("Expected ErrAccountNotLinked for %s via %s, got %v", identity.Username, identity.Source, err)
+		}
+	}
+	if user, _ := service.GetUser(admin.ID); user.Role != RoleAdmin {
+		t.Errorf("Expected the local role to be untouched, got %s", user.Role)
+	}
+
+	if _, err := service.LinkExternalUser(erin.ID, "kerberos", nil); err == nil {
+		t.Error("Expected an unknown source to be rejected")
+	}
+	if _, err := service.LinkExternalUser(erin.ID, SourceLDAP, nil); err != nil {
+		t.Fatalf("LinkExternalUser failed: %v", err)
+	}
+
+	user, err := service.ResolveIdentity(&Identity{Username: "erin", Role: RoleViewer, Source: SourceLDAP})
+	if err != nil {
+		t.Fatalf("Expected the linked account to resolve, got %v", err)
+	}
+	if user.ID != erin.ID || user.Role != RoleViewer {
+		t.Errorf("Expected erin with the mapped role viewer, got %s with role %s", user.Username, user.Role)
+	}
+
+	// The link is to one source and replaces the local password
+	if _, err := service.ResolveIdentity(&Identity{Username: "erin", Role: RoleViewer, Source: SourceTrustedHeader}); !errors.Is(err, ErrAccountNotLinked) {
+		t.Errorf("Expected another source to be refused, got %v", err)
+	}
+	if _, err := service.Login("erin", "Secret123!", "127.0.0.1", "test"); err == nil {
+		t.Error("Expected the local password to stop working once linked")
+	}
+}
+
+func TestLDAPFilterEscaping(t *testing.T) {
+	server := newFakeLDAPServer(t, testDirectory()...)
+
+	config := LDAPConfig{
+		URL:         server.url(),
+		Timeout:     2 * time.Second,
+		UserBaseDN:  "ou=people,dc=example,dc=com",
+		UserFilter:  "(&(objectClass=person)(uid=%s))",
+		DefaultRole: RoleViewer,
+	}
+	ldapAuth, err := NewLDAPAuthenticator(config)
+	if err != nil {
+		t.Fatalf("NewLDAPAuthenticator failed: %v", err)
+	}
+
+	// Filter syntax in a username is matched literally instead of widening the search
+	for _, username := range []string{"*", "alice)(uid=*", "al*"} {
+		if _, err := ldapAuth.Authenticate(username, "alice-secret"); !errors.Is(err, ErrUnknownUser) {
+			t.Errorf("Expected %q to be an unknown user, got %v", username, err)
+		}
+	}
+
+	for _, invalid := range []string{"uid=%s", "(uid=%s", "(uid=\\4%s)"} {
+		config.UserFilter = invalid
+		if _, err := NewLDAPAuthenticator(config); err == nil {
+			t.Errorf("Expected user filter %q to be rejected", invalid)
+		}
+	}
+
+	if got := firstRDNValue("cn=smith\\, john,ou=groups,dc=example,dc=com"); got != "smith, john" {
+		t.Errorf("Unexpected first RDN value: %q", got)
+	}
+}
@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/audit"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// Defaults matching security.max_login_attempts and security.login_lockout_time
+const (
+	DefaultMaxLoginAttempts = 5
+	DefaultLoginLockoutTime = 15 * time.Minute
+)
+
+// Kinds of login lockouts
+const (
+	LockoutByIP       = "ip"
+	LockoutByUsername = "username"
+)
+
+// ErrInvalidLockoutType is returned when clearing a lockout of an unknown kind
+var ErrInvalidLockoutType = errors.New("lockout type must be ip or username")
+
+// LoginThrottledError is returned by Login and CompleteMFALogin when the IP address or
+// username has too many recent failed attempts. The attempt is refused without checking
+// the credentials and is not counted as another failure.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+
+	// Locked is set for a lockout after MaxLoginAttempts failures, as opposed to the
+	// short delay imposed after each earlier failure
+	Locked bool
+}
+
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("too many failed login attempts, try again in %s", e.RetryAfter.Round(time.Second))
+	}
+	return "too many failed login attempts, slow down"
+}
+
+// Lockout describes an IP address or username whose logins are currently refused
+type Lockout struct {
+	Type        string    `json:"type"`
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	RetryAt     time.Time `json:"retry_at"`
+	Locked      bool      `json:"locked"`
+}
+
+// SetLoginLimits sets how many failed attempts an IP address or username may make
+// within lockoutTime before logins from it are refused for lockoutTime. Zero values
+// keep the defaults.
+func (s *Service) SetLoginLimits(maxAttempts int, lockoutTime time.Duration) {
+	if maxAttempts > 0 {
+		s.maxLoginAttempts = maxAttempts
+	}
+	if lockoutTime > 0 {
+		s.loginLockoutTime = lockoutTime
+	}
+}
+
+// retryDelay returns how long after the last of a number of failures the next attempt is
+// allowed. The first failure is free, each later one doubles the delay from one second,
+// and MaxLoginAttempts failures lock the key out for the lockout time.
+func (s *Service) retryDelay(failures int) (time.Duration, bool) {
+	if failures >= s.maxLoginAttempts {
+		return s.loginLockoutTime, true
+	}
+	if failures < 2 {
+		return 0, false
+	}
+
+	delay := time.Second << (failures - 2)
+	if delay > s.loginLockoutTime {
+		delay = s.loginLockoutTime
+	}
+	return delay, false
+}
+
+// lockoutKeys returns the IP address and username a login is throttled by. Usernames
+// are compared case-insensitively.
+func lockoutKeys(username, ipAddress string) []Lockout {
+	return []Lockout{
+		{Type: LockoutByIP, Key: ipAddress},
+		{Type: LockoutByUsername, Key: normalizeLoginUsername(username)},
+	}
+}
+
+func normalizeLoginUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// lockoutColumn returns the login_attempts column of a lockout type
+func lockoutColumn(kind string) (string, error) {
+	switch kind {
+	case LockoutByIP:
+		return database.LoginAttemptsByIP, nil
+	case LockoutByUsername:
+		return database.LoginAttemptsByUsername, nil
+	}
+	return "", ErrInvalidLockoutType
+}
+
+// recentFailures counts the failed attempts within the lockout time of one key, or of
+// every key of the type when key is empty. A successful login resets the count of its
+// username but not of its IP address, so that one known account cannot be used to keep
+// guessing others from the same address.
+func (s *Service) recentFailures(kind, key string, now time.Time) ([]database.LoginFailureCount, error) {
+	column, err := lockoutColumn(kind)
+	if err != nil {
+		return nil, err
+	}
+	return s.attemptRepo.FailureCounts(column, key, now.Add(-s.loginLockoutTime), kind == LockoutByUsername)
+}
+
+// loginGates serializes logins that share an IP address or username. Without it,
+// concurrent attempts could all pass checkLoginThrottle before any of their failures is
+// recorded.
+type loginGates struct {
+	mu    sync.Mutex
+	gates map[string]*loginGate
+}
+
+type loginGate struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newLoginGates() *loginGates {
+	return &loginGates{gates: make(map[string]*loginGate)}
+}
+
+// lock waits until no other login holds any of the keys, takes them and returns the
+// function that releases them. Keys are taken in sorted order so that two logins never
+// wait on each other.
+func (g *loginGates) lock(keys []string) func() {
+	sort.Strings(keys)
+
+	var held []string
+	g.mu.Lock()
+	for i, key := range keys {
+		if i > 0 && key == keys[i-1] {
+			continue
+		}
+		gate := g.gates[key]
+		if gate == nil {
+			gate = &loginGate{}
+			g.gates[key] = gate
+		}
+		gate.refs++
+		held = append(held, key)
+	}
+	gates := make([]*loginGate, len(held))
+	for i, key := range held {
+		gates[i] = g.gates[key]
+	}
+	g.mu.Unlock()
+
+	for _, gate := range gates {
+		gate.mu.Lock()
+	}
+
+	return func() {
+		for i := len(gates) - 1; i >= 0; i-- {
+			gates[i].mu.Unlock()
+		}
+
+		g.mu.Lock()
+		for i, key := range held {
+			if gates[i].refs--; gates[i].refs == 0 {
+				delete(g.gates, key)
+			}
+		}
+		g.mu.Unlock()
+	}
+}
+
+// lockLogin serializes a login with every other login from the same IP address or for
+// the same username, from the throttle check until its outcome has been recorded
+func (s *Service) lockLogin(username, ipAddress string) func() {
+	var keys []string
+	for _, k := range lockoutKeys(username, ipAddress) {
+		if k.Key != "" {
+			keys = append(keys, k.Type+":"+k.Key)
+		}
+	}
+	return s.loginGates.lock(keys)
+}
+
+// checkLoginThrottle refuses a login while its IP address or username is delayed or
+// locked out. Errors reading the attempts are logged and do not block logins.
+func (s *Service) checkLoginThrottle(username, ipAddress string) error {
+	now := time.Now()
+	var throttled *LoginThrottledError
+
+	for _, k := range lockoutKeys(username, ipAddress) {
+		if k.Key == "" {
+			continue
+		}
+		counts, err := s.recentFailures(k.Type, k.Key, now)
+		if err != nil {
+			fmt.Printf("Warning: failed to check login lockout for %s %s: %v\n", k.Type, k.Key, err)
+			continue
+		}
+		if len(counts) == 0 {
+			continue
+		}
+
+		delay, locked := s.retryDelay(counts[0].Failures)
+		retryAfter := counts[0].LastFailure.Add(delay).Sub(now)
+		if retryAfter <= 0 {
+			continue
+		}
+		if throttled == nil || retryAfter > throttled.RetryAfter {
+			throttled = &LoginThrottledError{RetryAfter: retryAfter, Locked: locked}
+		}
+	}
+
+	if throttled != nil {
+		return throttled
+	}
+	return nil
+}
+
+// recordLoginFailure stores a failed attempt and records a login_lockout security event
+// when it brings the IP address or username to MaxLoginAttempts failures or more for the
+// first time
+func (s *Service) recordLoginFailure(username, ipAddress, userAgent string) {
+	keys := lockoutKeys(username, ipAddress)
+	previous := make([]int, len(keys))
+	for i, k := range keys {
+		if k.Key == "" {
+			continue
+		}
+		if counts, err := s.recentFailures(k.Type, k.Key, time.Now()); err == nil && len(counts) > 0 {
+			previous[i] = counts[0].Failures
+		}
+	}
+
+	s.recordLoginAttempt(username, ipAddress, userAgent, false)
+
+	now := time.Now()
+	for i, k := range keys {
+		if k.Key == "" || previous[i] >= s.maxLoginAttempts {
+			continue
+		}
+		counts, err := s.recentFailures(k.Type, k.Key, now)
+		if err != nil || len(counts) == 0 || counts[0].Failures < s.maxLoginAttempts {
+			continue
+		}
+
+		details := &audit.AuditDetails{
+			Operation: "lockout",
+			Parameters: map[string]interface{}{
+				"type":         k.Type,
+				"key":          k.Key,
+				"failures":     counts[0].Failures,
+				"locked_until": counts[0].LastFailure.Add(s.loginLockoutTime).UTC(),
+			},
+		}
+		auditCtx := &audit.AuditContext{UserID: "system", IPAddress: ipAddress, UserAgent: userAgent}
+		if err := s.audit.LogAction(context.Background(), audit.ActionLoginLockout, nil, auditCtx, details); err != nil {
+			log.Printf("Failed to audit the login lockout of %s %s: %v", k.Type, k.Key, err)
+		}
+	}
+}
+
+// ListLockouts returns the IP addresses and usernames whose logins are currently delayed
+// or locked out, most recent failure first
+func (s *Service) ListLockouts() ([]Lockout, error) {
+	now := time.Now()
+	lockouts := []Lockout{}
+
+	for _, kind := range []string{LockoutByIP, LockoutByUsername} {
+		counts, err := s.recentFailures(kind, "", now)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, count := range counts {
+			delay, locked := s.retryDelay(count.Failures)
+			retryAt := count.LastFailure.Add(delay)
+			if !retryAt.After(now) {
+				continue
+			}
+			lockouts = append(lockouts, Lockout{
+				Type:        kind,
+				Key:         count.Key,
+				Failures:    count.Failures,
+				LastFailure: count.LastFailure,
+				RetryAt:     retryAt,
+				Locked:      locked,
+			})
+		}
+	}
+
+	sort.SliceStable(lockouts, func(i, j int) bool {
+		return lockouts[i].LastFailure.After(lockouts[j].LastFailure)
+	})
+	return lockouts, nil
+}
+
+// ClearLockout lifts the lockout of an IP address or username by clearing its failed
+// attempts, and returns how many were cleared. Cleared attempts no longer count for the
+// other key either: clearing a username also forgives its failures from each address.
+// The attempts stay in login_attempts for later review.
+func (s *Service) ClearLockout(kind, key string, auditCtx *audit.AuditContext) (int64, error) {
+	column, err := lockoutColumn(kind)
+	if err != nil {
+		return 0, err
+	}
+	if kind == LockoutByUsername {
+		key = normalizeLoginUsername(key)
+	}
+	if key == "" {
+		return 0, fmt.Errorf("lockout key is required")
+	}
+
+	cleared, err := s.attemptRepo.ClearFailures(column, key)
+	if err != nil {
+		return 0, err
+	}
+
+	details := &audit.AuditDetails{
+		Operation: "clear",
+		Parameters: map[string]interface{}{
+			"type":    kind,
+			"key":     key,
+			"cleared": cleared,
+		},
+		Result: "success",
+	}
+	if err := s.audit.LogAction(context.Background(), audit.ActionLockoutsClear, nil, auditCtx, details); err != nil {
+		log.Printf("Failed to audit clearing the lockout of %s %s: %v", kind, key, err)
+	}
+
+	return cleared, nil
+}
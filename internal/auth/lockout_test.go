@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// backdateLoginAttempts moves the recorded attempts into the past so the progressive
+// delay after them has passed
+func backdateLoginAttempts(t *testing.T, service *Service, age time.Duration) {
+	t.Helper()
+	if _, err := service.attemptRepo.GetDB().Exec("UPDATE login_attempts SET timestamp = ?", time.Now().Add(-age)); err != nil {
+		t.Fatalf("Failed to backdate login attempts: %v", err)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	service := newTestService(t)
+	service.SetLoginLimits(3, time.Minute)
+
+	if _, err := service.CreateUser("alice", "Secret123!", "", "", RoleOperator, nil); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	// The first failure is free, the second one delays the next attempt
+	for i := 0; i < 2; i++ {
+		if _, err := service.Login("Alice", "wrong", "192.0.2.1", "test"); err == nil || errors.As(err, new(*LoginThrottledError)) {
+			t.Fatalf("Expected invalid credentials on attempt %d, got %v", i+1, err)
+		}
+	}
+	var throttled *LoginThrottledError
+	if _, err := service.Login("alice", "Secret123!", "192.0.2.1", "test"); !errors.As(err, &throttled) || throttled.Locked || throttled.RetryAfter > time.Second {
+		t.Fatalf("Expected a one second delay, got %v", err)
+	}
+
+	// The third failure locks out both the address and the username
+	backdateLoginAttempts(t, service, 10*time.Second)
+	if _, err := service.Login("alice", "wrong", "192.0.2.1", "test"); err == nil {
+		t.Fatal("Expected invalid credentials")
+	}
+	if _, err := service.Login("alice", "Secret123!", "192.0.2.1", "test"); !errors.As(err, &throttled) || !throttled.Locked || throttled.RetryAfter < 50*time.Second {
+		t.Fatalf("Expected a lockout, got %v", err)
+	}
+	if _, err := service.Login("alice", "Secret123!", "198.51.100.7", "test"); !errors.As(err, &throttled) || !throttled.Locked {
+		t.Fatalf("Expected the username to be locked out from other addresses, got %v", err)
+	}
+
+	events, err := service.auditRepo.Count(database.AuditLogFilter{Action: "login_lockout"})
+	if err != nil || events != 2 {
+		t.Errorf("Expected lockout events for the address and the username, got %d, %v", events, err)
+	}
+
+	lockouts, err := service.ListLockouts()
+	if err != nil {
+		t.Fatalf("ListLockouts failed: %v", err)
+	}
+	if len(lockouts) != 2 {
+		t.Fatalf("Expected 2 lockouts, got %+v", lockouts)
+	}
+	for _, lockout := range lockouts {
+		if !lockout.Locked || lockout.Failures != 3 {
+			t.Errorf("Unexpected lockout %+v", lockout)
+		}
+	}
+
+	// Clearing the username clears its attempts, which also counted for the address
+	cleared, err := service.ClearLockout(LockoutByUsername, "ALICE", systemAuditContext)
+	if err != nil || cleared != 3 {
+		t.Fatalf("Expected 3 attempts cleared, got %d, %v", cleared, err)
+	}
+	if _, err := service.Login("alice", "Secret123!", "192.0.2.1", "test"); err != nil {
+		t.Fatalf("Expected login after clearing the username, got %v", err)
+	}
+
+	// Guessing different usernames from one address locks out the address only
+	for _, username := range []string{"admin", "root", "postmaster"} {
+		backdateLoginAttempts(t, service, 10*time.Second)
+		if _, err := service.Login(username, "guess", "203.0.113.9", "test"); err == nil || errors.As(err, new(*LoginThrottledError)) {
+			t.Fatalf("Expected invalid credentials for %s, got %v", username, err)
+		}
+	}
+	if _, err := service.Login("alice", "Secret123!", "203.0.113.9", "test"); !errors.As(err, &throttled) || !throttled.Locked {
+		t.Fatalf("Expected the address to be locked out, got %v", err)
+	}
+	if _, err := service.Login("alice", "Secret123!", "192.0.2.1", "test"); err != nil {
+		t.Fatalf("Expected login from another address, got %v", err)
+	}
+
+	if _, err := service.ClearLockout(LockoutByIP, "203.0.113.9", systemAuditContext); err != nil {
+		t.Fatalf("ClearLockout failed: %v", err)
+	}
+	if _, err := service.Login("alice", "Secret123!", "203.0.113.9", "test"); err != nil {
+		t.Fatalf("Expected login after clearing the address, got %v", err)
+	}
+
+	if _, err := service.ClearLockout("session", "x", systemAuditContext); !errors.Is(err, ErrInvalidLockoutType) {
+		t.Errorf("Expected ErrInvalidLockoutType, got %v", err)
+	}
+}
+
+func TestConcurrentLoginLockout(t *testing.T) {
+	service := newTestService(t)
+	service.SetLoginLimits(2, time.Minute)
+
+	if _, err := service.CreateUser("carol", "Secret123!", "", "", RoleOperator, nil); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	// Parallel guesses must not all pass the throttle before any failure is recorded
+	const attempts = 10
+	errs := make([]error, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = service.Login("carol", "wrong", "192.0.2.3", "test")
+		}(i)
+	}
+	wg.Wait()
+
+	throttled := 0
+	for _, err := range errs {
+		if errors.As(err, new(*LoginThrottledError)) {
+			throttled++
+		}
+	}
+	if throttled != attempts-2 {
+		t.Errorf("Expected %d throttled attempts, got %d", attempts-2, throttled)
+	}
+
+	lockouts, err := service.ListLockouts()
+	if err != nil {
+		t.Fatalf("ListLockouts failed: %v", err)
+	}
+	if len(lockouts) != 2 {
+		t.Fatalf("Expected 2 lockouts, got %+v", lockouts)
+	}
+	for _, lockout := range lockouts {
+		if !lockout.Locked || lockout.Failures != 2 {
+			t.Errorf("Unexpected lockout %+v", lockout)
+		}
+	}
+
+	events, err := service.auditRepo.Count(database.AuditLogFilter{Action: "login_lockout"})
+	if err != nil || events != 2 {
+		t.Errorf("Expected one lockout event each for the address and the username, got %d, %v", events, err)
+	}
+}
+
+func TestLoginLockoutExpires(t *testing.T) {
+	service := newTestService(t)
+	service.SetLoginLimits(2, time.Minute)
+
+	if _, err := service.CreateUser("bob", "Secret123!", "", "", RoleViewer, nil); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		service.Login("bob", "wrong", "192.0.2.2", "test")
+	}
+	if _, err := service.Login("bob", "Secret123!", "192.0.2.2", "test"); err == nil {
+		t.Fatal("Expected a lockout")
+	}
+
+	backdateLoginAttempts(t, service, 2*time.Minute)
+	if _, err := service.Login("bob", "Secret123!", "192.0.2.2", "test"); err != nil {
+		t.Fatalf("Expected login once the lockout time has passed, got %v", err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	service := &Service{maxLoginAttempts: 6, loginLockoutTime: 5 * time.Second}
+
+	tests := []struct {
+		failures int
+		delay    time.Duration
+		locked   bool
+	}{
+		{0, 0, false},
+		{1, 0, false},
+		{2, time.Second, false},
+		{3, 2 * time.Second, false},
+		{4, 4 * time.Second, false},
+		{5, 5 * time.Second, false}, // capped at the lockout time
+		{6, 5 * time.Second, true},
+	}
+
+	for _, tt := range tests {
+		delay, locked := service.retryDelay(tt.failures)
+		if delay != tt.delay || locked != tt.locked {
+			t.Errorf("retryDelay(%d) = %s, %v, want %s, %v", tt.failures, delay, locked, tt.delay, tt.locked)
+		}
+	}
+}
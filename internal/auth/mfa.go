@@ -0,0 +1,412 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/audit"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+const (
+	// mfaChallengeLifetime is how long a password-verified login waits for its second factor
+	mfaChallengeLifetime = 5 * time.Minute
+
+	// maxMFAChallengeAttempts invalidates a pending login after this many wrong codes
+	maxMFAChallengeAttempts = 5
+
+	// recoveryCodeCount is the number of recovery codes issued at a time
+	recoveryCodeCount = 10
+
+	// DefaultTOTPIssuer is shown by authenticator apps next to the account name
+	DefaultTOTPIssuer = "Exim Pilot"
+)
+
+// recoveryCodeAlphabet avoids characters that are easily confused when read aloud or copied
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// mfaChallenge is a login that passed the password check and awaits a second factor
+type mfaChallenge struct {
+	userID    int64
+	expiresAt time.Time
+	attempts  int
+}
+
+// mfaChallenges holds pending two-step logins in memory. They are short-lived, so losing
+// them on restart only means the user has to enter their password again.
+type mfaChallenges struct {
+	mu      sync.Mutex
+	pending map[string]*mfaChallenge
+}
+
+// MFAStatus describes a user's second-factor enrollment
+type MFAStatus struct {
+	TOTPEnabled            bool       `json:"totp_enabled"`
+	TOTPEnabledAt          *time.Time `json:"totp_enabled_at,omitempty"`
+	EnrollmentPending      bool       `json:"enrollment_pending"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+}
+
+// TOTPEnrollment is returned when a user starts TOTP enrollment
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// SetTOTPIssuer sets the issuer name embedded in provisioning URIs
+func (s *Service) SetTOTPIssuer(issuer string) {
+	if issuer != "" {
+		s.totpIssuer = issuer
+	}
+}
+
+// IsTOTPEnabled reports whether the user has a confirmed TOTP enrollment
+func (s *Service) IsTOTPEnabled(userID int64) bool {
+	totp, err := s.mfaRepo.GetTOTP(userID)
+	return err == nil && totp.Enabled
+}
+
+// GetMFAStatus returns the second-factor enrollment state of a user
+func (s *Service) GetMFAStatus(userID int64) (*MFAStatus, error) {
+	status := &MFAStatus{}
+
+	totp, err := s.mfaRepo.GetTOTP(userID)
+	if err != nil {
+		return status, nil
+	}
+
+	status.TOTPEnabled = totp.Enabled
+	status.TOTPEnabledAt = totp.EnabledAt
+	status.EnrollmentPending = !totp.Enabled
+
+	if totp.Enabled {
+		if status.RecoveryCodesRemaining, err = s.mfaRepo.CountUnusedRecoveryCodes(userID); err != nil {
+			return nil, err
+		}
+	}
+
+	return status, nil
+}
+
+// BeginTOTPEnrollment generates a new secret for the user. TOTP stays disabled until
+// ConfirmTOTPEnrollment is called with a code from the authenticator app.
+func (s *Service) BeginTOTPEnrollment(userID int64) (*TOTPEnrollment, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+
+	if err := s.mfaRepo.SavePendingTOTP(user.ID, secret); err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: TOTPProvisioningURI(s.totpIssuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment enables TOTP once the user proves their app produces valid codes,
+// and returns the initial set of recovery codes
+func (s *Service) ConfirmTOTPEnrollment(userID int64, code string, auditCtx *audit.AuditContext) ([]string, error) {
+	totp, err := s.mfaRepo.GetTOTP(userID)
+	if err != nil {
+		return nil, fmt.Errorf("no TOTP enrollment in progress")
+	}
+	if totp.Enabled {
+		return nil, fmt.Errorf("TOTP is already enabled")
+	}
+
+	if err := s.verifyTOTP(totp, code); err != nil {
+		return nil, err
+	}
+
+	codes, err := s.issueRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.mfaRepo.EnableTOTP(userID); err != nil {
+		return nil, err
+	}
+
+	s.logMFAChange(audit.ActionMFAEnable, userID, auditCtx)
+	return codes, nil
+}
+
+// DisableTOTP removes the user's second factor after checking a current TOTP or recovery code
+func (s *Service) DisableTOTP(userID int64, code string, auditCtx *audit.AuditContext) error {
+	if err := s.VerifySecondFactor(userID, code); err != nil {
+		return err
+	}
+
+	if err := s.mfaRepo.DeleteTOTP(userID); err != nil {
+		return err
+	}
+
+	s.logMFAChange(audit.ActionMFADisable, userID, auditCtx)
+	return nil
+}
+
+// ResetTOTP removes a user's second factor without a code, for administrators helping a
+// user who lost their device. The user's sessions are revoked.
+func (s *Service) ResetTOTP(userID int64, auditCtx *audit.AuditContext) error {
+	if _, err := s.userRepo.GetByIDAnyStatus(userID); err != nil {
+		return err
+	}
+
+	if err := s.mfaRepo.DeleteTOTP(userID); err != nil {
+		return err
+	}
+
+	if err := s.sessionRepo.DeleteByUserID(userID); err != nil {
+		return err
+	}
+
+	s.logMFAChange(audit.ActionMFAReset, userID, auditCtx)
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a current code
+func (s *Service) RegenerateRecoveryCodes(userID int64, code string, auditCtx *audit.AuditContext) ([]string, error) {
+	if err := s.VerifySecondFactor(userID, code); err != nil {
+		return nil, err
+	}
+
+	codes, err := s.issueRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logMFAChange(audit.ActionMFARecoveryCodes, userID, auditCtx)
+	return codes, nil
+}
+
+// VerifySecondFactor accepts either a current TOTP code or an unused recovery code.
+// A recovery code is consumed by a successful check.
+func (s *Service) VerifySecondFactor(userID int64, code string) error {
+	totp, err := s.mfaRepo.GetTOTP(userID)
+	if err != nil || !totp.Enabled {
+		return fmt.Errorf("two-factor authentication is not enabled")
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		return s.verifyTOTP(totp, code)
+	}
+
+	used, err := s.mfaRepo.UseRecoveryCode(userID, hashRecoveryCode(userID, code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return fmt.Errorf("invalid authentication code")
+	}
+
+	return nil
+}
+
+// CompleteMFALogin finishes a two-step login started by Login. Failed codes are recorded
+// as failed login attempts and are throttled like passwords.
+func (s *Service) CompleteMFALogin(mfaToken, code, ipAddress, userAgent string) (*database.LoginResponse, error) {
+	challenge, err := s.challenges.get(mfaToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetByID(challenge.userID)
+	if err != nil {
+		s.challenges.remove(mfaToken)
+		return nil, fmt.Errorf("invalid credentials")
+	}
+
+	unlock := s.lockLogin(user.Username, ipAddress)
+	defer unlock()
+
+	if err := s.checkLoginThrottle(user.Username, ipAddress); err != nil {
+		return nil, err
+	}
+
+	if err := s.VerifySecondFactor(user.ID, code); err != nil {
+		remaining := s.challenges.fail(mfaToken)
+		s.recordLoginFailure(user.Username, ipAddress, userAgent)
+
+		userIDStr := fmt.Sprintf("%d", user.ID)
+		s.auditRepo.Create(&database.AuditLog{
+			Action:    "login_failed",
+			UserID:    &userIDStr,
+			Details:   stringPtr(`{"reason": "invalid_second_factor"}`),
+			IPAddress: &ipAddress,
+		})
+
+		if remaining == 0 {
+			return nil, fmt.Errorf("too many invalid codes, please log in again")
+		}
+		return nil, fmt.Errorf("invalid authentication code")
+	}
+
+	s.challenges.remove(mfaToken)
+	return s.startSession(user, ipAddress, userAgent)
+}
+
+// verifyTOTP checks a TOTP code and records its time step so it cannot be used twice
+func (s *Service) verifyTOTP(totp *database.UserTOTP, code string) error {
+	step, ok := ValidateTOTPCode(totp.Secret, code, time.Now())
+	if !ok {
+		return fmt.Errorf("invalid authentication code")
+	}
+
+	fresh, err := s.mfaRepo.UseTOTPStep(totp.UserID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return fmt.Errorf("authentication code has already been used")
+	}
+
+	return nil
+}
+
+// issueRecoveryCodes generates and stores a new set of recovery codes, returning the plaintext
+func (s *Service) issueRecoveryCodes(userID int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		codes[i] = code
+		hashes[i] = hashRecoveryCode(userID, code)
+	}
+
+	if err := s.mfaRepo.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// logMFAChange records a second-factor change through the audit service
+func (s *Service) logMFAChange(action audit.ActionType, userID int64, auditCtx *audit.AuditContext) {
+	user, err := s.userRepo.GetByIDAnyStatus(userID)
+	if err != nil {
+		user = &database.User{ID: userID}
+	}
+	s.logUserChange(action, user, auditCtx, nil, nil)
+}
+
+// generateRecoveryCode returns a code formatted as two groups of five characters
+func generateRecoveryCode() (string, error) {
+	// Bytes at or above the largest multiple of the alphabet size are discarded, so every
+	// character is equally likely
+	limit := 256 - 256%len(recoveryCodeAlphabet)
+
+	var b strings.Builder
+	buf := make([]byte, 16)
+	for n := 0; n < 10; {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, v := range buf {
+			if int(v) >= limit || n == 10 {
+				continue
+			}
+			if n == 5 {
+				b.WriteByte('-')
+			}
+			b.WriteByte(recoveryCodeAlphabet[int(v)%len(recoveryCodeAlphabet)])
+			n++
+		}
+	}
+	return b.String(), nil
+}
+
+// hashRecoveryCode normalises a recovery code and hashes it together with the user ID
+func hashRecoveryCode(userID int64, code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	if len(normalized) == 10 {
+		normalized = normalized[:5] + "-" + normalized[5:]
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", userID, normalized)))
+	return hex.EncodeToString(sum[:])
+}
+
+func newMFAChallenges() *mfaChallenges {
+	return &mfaChallenges{pending: make(map[string]*mfaChallenge)}
+}
+
+// create stores a pending login and returns its token
+func (c *mfaChallenges) create(userID int64) (string, time.Time, error) {
+	token, err := generateSessionID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for id, challenge := range c.pending {
+		if now.After(challenge.expiresAt) {
+			delete(c.pending, id)
+		}
+	}
+
+	expiresAt := now.Add(mfaChallengeLifetime)
+	c.pending[token] = &mfaChallenge{
+		userID:    userID,
+		expiresAt: expiresAt,
+	}
+
+	return token, expiresAt, nil
+}
+
+// get returns a pending login that has not expired
+func (c *mfaChallenges) get(token string) (mfaChallenge, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	challenge, ok := c.pending[token]
+	if !ok || time.Now().After(challenge.expiresAt) {
+		delete(c.pending, token)
+		return mfaChallenge{}, fmt.Errorf("login challenge expired, please log in again")
+	}
+
+	return *challenge, nil
+}
+
+// fail counts a wrong code and returns how many attempts remain
+func (c *mfaChallenges) fail(token string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	challenge, ok := c.pending[token]
+	if !ok {
+		return 0
+	}
+
+	challenge.attempts++
+	remaining := maxMFAChallengeAttempts - challenge.attempts
+	if remaining <= 0 {
+		delete(c.pending, token)
+		return 0
+	}
+	return remaining
+}
+
+func (c *mfaChallenges) remove(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, token)
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTwoStepLogin(t *testing.T) {
+	service := newTestService(t)
+
+	user, err := service.CreateUser("alice", "Secret123!", "", "", RoleOperator, nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	enrollment, err := service.BeginTOTPEnrollment(user.ID)
+	if err != nil {
+		t.Fatalf("BeginTOTPEnrollment failed: %v", err)
+	}
+
+	// Not enabled until confirmed, so login is still single-step
+	resp, err := service.Login("alice", "Secret123!", "192.0.2.1", "test")
+	if err != nil || resp.MFARequired {
+		t.Fatalf("Expected single-step login before confirmation, got %+v, %v", resp, err)
+	}
+
+	code, _ := TOTPCode(enrollment.Secret, time.Now())
+	recoveryCodes, err := service.ConfirmTOTPEnrollment(user.ID, code, nil)
+	if err != nil {
+		t.Fatalf("ConfirmTOTPEnrollment failed: %v", err)
+	}
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("Expected %d recovery codes, got %d", recoveryCodeCount, len(recoveryCodes))
+	}
+
+	resp, err = service.Login("alice", "Secret123!", "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if !resp.MFARequired || resp.SessionID != "" || resp.MFAToken == "" {
+		t.Fatalf("Expected a pending second step, got %+v", resp)
+	}
+
+	// The confirmation code was already used, so replaying it must fail
+	if _, err := service.CompleteMFALogin(resp.MFAToken, code, "192.0.2.1", "test"); err == nil {
+		t.Error("Expected replayed TOTP code to be rejected")
+	}
+
+	failures := 0
+	if err := service.attemptRepo.GetDB().QueryRow(
+		"SELECT COUNT(*) FROM login_attempts WHERE username = 'alice' AND success = 0",
+	).Scan(&failures); err != nil {
+		t.Fatalf("Failed to count login attempts: %v", err)
+	}
+	if failures != 1 {
+		t.Errorf("Expected the failed second factor to be recorded as a login attempt, got %d", failures)
+	}
+
+	loginResp, err := service.CompleteMFALogin(resp.MFAToken, recoveryCodes[0], "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("CompleteMFALogin with recovery code failed: %v", err)
+	}
+	if loginResp.SessionID == "" {
+		t.Error("Expected a session after the second step")
+	}
+
+	// Recovery codes are single-use
+	resp, _ = service.Login("alice", "Secret123!", "192.0.2.1", "test")
+	if _, err := service.CompleteMFALogin(resp.MFAToken, recoveryCodes[0], "192.0.2.1", "test"); err == nil {
+		t.Error("Expected a used recovery code to be rejected")
+	}
+}
+
+func TestMFAChallengeAttemptLimit(t *testing.T) {
+	challenges := newMFAChallenges()
+
+	token, _, err := challenges.create(1)
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	for i := 0; i < maxMFAChallengeAttempts; i++ {
+		challenges.fail(token)
+	}
+
+	if _, err := challenges.get(token); err == nil {
+		t.Error("Expected challenge to be discarded after too many failures")
+	}
+}
+
+func TestGenerateRecoveryCode(t *testing.T) {
+	seen := make(map[rune]int)
+	for i := 0; i < 2000; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			t.Fatalf("generateRecoveryCode failed: %v", err)
+		}
+		if len(code) != 11 || code[5] != '-' {
+			t.Fatalf("Unexpected recovery code format %q", code)
+		}
+		for _, c := range code[:5] + code[6:] {
+			if !strings.ContainsRune(recoveryCodeAlphabet, c) {
+				t.Fatalf("Recovery code %q contains %q", code, c)
+			}
+			seen[c]++
+		}
+	}
+
+	// 20000 characters give each of the 31 an expected count of about 645
+	for _, c := range recoveryCodeAlphabet {
+		if seen[c] < 450 || seen[c] > 850 {
+			t.Errorf("Character %q appeared %d times", c, seen[c])
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package auth
+
+// Role names stored in the users.role column
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleViewer   = "viewer"
+	RoleAuditor  = "auditor"
+)
+
+// Permission represents an API capability granted to a role
+type Permission string
+
+const (
+	PermissionQueueRead   Permission = "queue:read"
+	PermissionQueueMutate Permission = "queue:mutate"
+	PermissionLogRead     Permission = "log:read"
+	PermissionContentRead Permission = "content:read"
+	PermissionAuditRead   Permission = "audit:read"
+	PermissionAdmin       Permission = "admin"
+)
+
+// allPermissions lists every permission, in the order shown to clients
+var allPermissions = []Permission{
+	PermissionQueueRead, PermissionQueueMutate, PermissionLogRead,
+	PermissionContentRead, PermissionAuditRead, PermissionAdmin,
+}
+
+// rolePermissions maps each role to the capabilities it grants
+var rolePermissions = map[string][]Permission{
+	RoleAdmin: {
+		PermissionQueueRead, PermissionQueueMutate, PermissionLogRead,
+		PermissionContentRead, PermissionAuditRead, PermissionAdmin,
+	},
+	RoleOperator: {
+		PermissionQueueRead, PermissionQueueMutate, PermissionLogRead, PermissionContentRead,
+	},
+	RoleViewer: {
+		PermissionQueueRead, PermissionLogRead,
+	},
+	RoleAuditor: {
+		PermissionQueueRead, PermissionLogRead, PermissionAuditRead,
+	},
+}
+
+// Roles returns all known role names
+func Roles() []string {
+	return []string{RoleAdmin, RoleOperator, RoleViewer, RoleAuditor}
+}
+
+// IsValidRole reports whether role is a known role name
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Permissions returns all known permissions. They double as API token scopes.
+func Permissions() []Permission {
+	permissions := make([]Permission, len(allPermissions))
+	copy(permissions, allPermissions)
+	return permissions
+}
+
+// IsValidPermission reports whether permission is a known permission name
+func IsValidPermission(permission Permission) bool {
+	for _, known := range allPermissions {
+		if known == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether role grants the permission
+func HasPermission(role string, permission Permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionsForRole returns the permissions granted to a role
+func PermissionsForRole(role string) []Permission {
+	permissions := make([]Permission, len(rolePermissions[role]))
+	copy(permissions, rolePermissions[role])
+	return permissions
+}
@@ -0,0 +1,42 @@
+package auth
+
+import "testing"
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		role       string
+		permission Permission
+		expected   bool
+	}{
+		{RoleAdmin, PermissionAdmin, true},
+		{RoleAdmin, PermissionQueueMutate, true},
+		{RoleOperator, PermissionQueueMutate, true},
+		{RoleOperator, PermissionAdmin, false},
+		{RoleOperator, PermissionAuditRead, false},
+		{RoleViewer, PermissionQueueRead, true},
+		{RoleViewer, PermissionQueueMutate, false},
+		{RoleViewer, PermissionContentRead, false},
+		{RoleAuditor, PermissionAuditRead, true},
+		{RoleAuditor, PermissionQueueMutate, false},
+		{"user", PermissionQueueRead, false},
+		{"", PermissionQueueRead, false},
+	}
+
+	for _, tt := range tests {
+		if got := HasPermission(tt.role, tt.permission); got != tt.expected {
+			t.Errorf("HasPermission(%q, %q) = %v, want %v", tt.role, tt.permission, got, tt.expected)
+		}
+	}
+}
+
+func TestIsValidRole(t *testing.T) {
+	for _, role := range Roles() {
+		if !IsValidRole(role) {
+			t.Errorf("Expected %q to be a valid role", role)
+		}
+	}
+
+	if IsValidRole("superuser") {
+		t.Error("Expected unknown role to be invalid")
+	}
+}
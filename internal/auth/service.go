@@ -1,11 +1,15 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/andreitelteu/exim-pilot/internal/audit"
 	"github.com/andreitelteu/exim-pilot/internal/database"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -14,24 +18,65 @@ import (
 type Service struct {
 	userRepo    *database.UserRepository
 	sessionRepo *database.SessionRepository
+	tokenRepo   *database.APITokenRepository
+	mfaRepo     *database.MFARepository
+	attemptRepo *database.LoginAttemptRepository
 	auditRepo   *database.AuditLogRepository
+	audit       *audit.Service
+	challenges  *mfaChallenges
+
+	authenticators []Authenticator
+
+	passwordMinLength     int
+	requireStrongPassword bool
+	totpIssuer            string
+
+	// Failed attempts allowed per IP address and per username within loginLockoutTime
+	maxLoginAttempts int
+	loginLockoutTime time.Duration
+	loginGates       *loginGates
 }
 
 // NewService creates a new authentication service
 func NewService(db *database.DB) *Service {
+	userRepo := database.NewUserRepository(db)
 	return &Service{
-		userRepo:    database.NewUserRepository(db),
+		userRepo:    userRepo,
 		sessionRepo: database.NewSessionRepository(db),
+		tokenRepo:   database.NewAPITokenRepository(db),
+		mfaRepo:     database.NewMFARepository(db),
+		attemptRepo: database.NewLoginAttemptRepository(db),
 		auditRepo:   database.NewAuditLogRepository(db),
+		audit:       audit.NewService(database.NewRepository(db)),
+		challenges:  newMFAChallenges(),
+		totpIssuer:  DefaultTOTPIssuer,
+
+		maxLoginAttempts: DefaultMaxLoginAttempts,
+		loginLockoutTime: DefaultLoginLockoutTime,
+		loginGates:       newLoginGates(),
+
+		authenticators: []Authenticator{&LocalAuthenticator{userRepo: userRepo}},
 	}
 }
 
-// Login authenticates a user and creates a session
+// Login authenticates a user and creates a session. The configured authenticators are
+// consulted in order, see SetAuthenticators. When the user has two-factor
+// authentication enabled no session is created yet: the response has MFARequired set and
+// an MFAToken to pass to CompleteMFALogin together with the second factor. While the IP
+// address or username has too many recent failures a *LoginThrottledError is returned,
+// see SetLoginLimits.
 func (s *Service) Login(username, password, ipAddress, userAgent string) (*database.LoginResponse, error) {
-	// Get user by username
-	user, err := s.userRepo.GetByUsername(username)
-	if err != nil {
+	unlock := s.lockLogin(username, ipAddress)
+	defer unlock()
+
+	if err := s.checkLoginThrottle(username, ipAddress); err != nil {
+		return nil, err
+	}
+
+	identity, err := s.authenticate(username, password)
+	if errors.Is(err, ErrUnknownUser) {
 		// Log failed login attempt
+		s.recordLoginFailure(username, ipAddress, userAgent)
 		s.auditRepo.Create(&database.AuditLog{
 			Action:    "login_failed",
 			UserID:    &username, // Use username since we don't have user ID
@@ -41,25 +86,63 @@ func (s *Service) Login(username, password, ipAddress, userAgent string) (*datab
 		return nil, fmt.Errorf("invalid credentials")
 	}
 
-	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+	var user *database.User
+	if err == nil {
+		user, err = s.ResolveIdentity(identity)
+	}
+
+	if err != nil {
 		// Log failed login attempt
-		userIDStr := fmt.Sprintf("%d", user.ID)
+		s.recordLoginFailure(username, ipAddress, userAgent)
+
+		userIDStr := username
+		if identity != nil && identity.User != nil {
+			userIDStr = fmt.Sprintf("%d", identity.User.ID)
+		}
+		reason := "invalid_password"
+		if !errors.Is(err, ErrInvalidCredentials) {
+			reason = "authentication_error"
+			fmt.Printf("Warning: login for %s failed: %v\n", username, err)
+		}
 		s.auditRepo.Create(&database.AuditLog{
 			Action:    "login_failed",
 			UserID:    &userIDStr,
-			Details:   stringPtr(`{"reason": "invalid_password"}`),
+			Details:   stringPtr(fmt.Sprintf(`{"reason": "%s"}`, reason)),
 			IPAddress: &ipAddress,
 		})
 		return nil, fmt.Errorf("invalid credentials")
 	}
 
+	// Ask for the second factor before creating a session
+	if s.IsTOTPEnabled(user.ID) {
+		mfaToken, expiresAt, err := s.challenges.create(user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start two-factor login: %w", err)
+		}
+
+		return &database.LoginResponse{
+			ExpiresAt:   expiresAt,
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		}, nil
+	}
+
+	return s.startSession(user, ipAddress, userAgent)
+}
+
+// startSession creates a session for a fully authenticated user
+func (s *Service) startSession(user *database.User, ipAddress, userAgent string) (*database.LoginResponse, error) {
 	// Create session
 	sessionID, err := generateSessionID()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate session ID: %w", err)
 	}
 
+	csrfToken, err := generateSessionID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CSRF token: %w", err)
+	}
+
 	expiresAt := time.Now().Add(24 * time.Hour) // 24 hour session
 	session := &database.Session{
 		ID:        sessionID,
@@ -67,6 +150,7 @@ func (s *Service) Login(username, password, ipAddress, userAgent string) (*datab
 		ExpiresAt: expiresAt,
 		IPAddress: &ipAddress,
 		UserAgent: &userAgent,
+		CSRFToken: &csrfToken,
 	}
 
 	if err := s.sessionRepo.Create(session); err != nil {
@@ -80,21 +164,36 @@ func (s *Service) Login(username, password, ipAddress, userAgent string) (*datab
 	}
 
 	// Log successful login
+	s.recordLoginAttempt(user.Username, ipAddress, userAgent, true)
 	userIDStr := fmt.Sprintf("%d", user.ID)
 	s.auditRepo.Create(&database.AuditLog{
 		Action:    "login_success",
 		UserID:    &userIDStr,
-		Details:   stringPtr(fmt.Sprintf(`{"session_id": "%s"}`, sessionID)),
+		Details:   stringPtr(fmt.Sprintf(`{"session": "%s"}`, sessionReference(sessionID))),
 		IPAddress: &ipAddress,
 	})
 
 	return &database.LoginResponse{
 		User:      *user,
 		SessionID: sessionID,
+		CSRFToken: csrfToken,
 		ExpiresAt: expiresAt,
 	}, nil
 }
 
+// recordLoginAttempt stores a login attempt in the login_attempts table
+func (s *Service) recordLoginAttempt(username, ipAddress, userAgent string, success bool) {
+	attempt := &database.LoginAttempt{
+		Username:  normalizeLoginUsername(username),
+		IPAddress: ipAddress,
+		Success:   success,
+		UserAgent: &userAgent,
+	}
+	if err := s.attemptRepo.Create(attempt); err != nil {
+		fmt.Printf("Warning: failed to record login attempt for %s: %v\n", username, err)
+	}
+}
+
 // Logout invalidates a session
 func (s *Service) Logout(sessionID, ipAddress string) error {
 	// Get session to get user ID for audit log
@@ -113,7 +212,7 @@ func (s *Service) Logout(sessionID, ipAddress string) error {
 	s.auditRepo.Create(&database.AuditLog{
 		Action:    "logout",
 		UserID:    &userIDStr,
-		Details:   stringPtr(fmt.Sprintf(`{"session_id": "%s"}`, sessionID)),
+		Details:   stringPtr(fmt.Sprintf(`{"session": "%s"}`, sessionReference(sessionID))),
 		IPAddress: &ipAddress,
 	})
 
@@ -137,8 +236,36 @@ func (s *Service) ValidateSession(sessionID string) (*database.User, error) {
 	return user, nil
 }
 
-// CreateUser creates a new user (for initial setup)
-func (s *Service) CreateUser(username, password, email, fullName string) (*database.User, error) {
+// CreateUser creates a new user with the given role. The password must satisfy the
+// password policy. A nil audit context records the change as made by the system.
+func (s *Service) CreateUser(username, password, email, fullName, role string, auditCtx *audit.AuditContext) (*database.User, error) {
+	return s.createUser(username, password, email, fullName, role, auditCtx, true)
+}
+
+// CreateUserWithoutPasswordPolicy creates a user whose password is not checked against
+// the password policy. It is only for the default-user bootstrap and the database reset
+// tool, which take the password from the operator's environment.
+func (s *Service) CreateUserWithoutPasswordPolicy(username, password, email, fullName, role string) (*database.User, error) {
+	return s.createUser(username, password, email, fullName, role, nil, false)
+}
+
+// createUser creates a user, checking the password policy when enforcePolicy is set
+func (s *Service) createUser(username, password, email, fullName, role string, auditCtx *audit.AuditContext, enforcePolicy bool) (*database.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, fmt.Errorf("username cannot be empty")
+	}
+
+	if !IsValidRole(role) {
+		return nil, fmt.Errorf("invalid role: %s", role)
+	}
+
+	if enforcePolicy {
+		if err := s.ValidatePassword(password); err != nil {
+			return nil, err
+		}
+	}
+
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -150,6 +277,7 @@ func (s *Service) CreateUser(username, password, email, fullName string) (*datab
 		PasswordHash: string(hashedPassword),
 		Email:        &email,
 		FullName:     &fullName,
+		Role:         role,
 		IsActive:     true,
 	}
 
@@ -157,17 +285,43 @@ func (s *Service) CreateUser(username, password, email, fullName string) (*datab
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
-	// Log user creation
-	userIDStr := fmt.Sprintf("%d", user.ID)
-	s.auditRepo.Create(&database.AuditLog{
-		Action:  "user_created",
-		UserID:  &userIDStr,
-		Details: stringPtr(fmt.Sprintf(`{"username": "%s", "email": "%s"}`, username, email)),
+	s.logUserChange(audit.ActionUserCreate, user, auditCtx, nil, map[string]interface{}{
+		"username": username,
+		"email":    email,
+		"role":     role,
 	})
 
 	return user, nil
 }
 
+// SetUserRole changes a user's role and records the change in the audit trail
+func (s *Service) SetUserRole(ctx context.Context, userID int64, role string, auditCtx *audit.AuditContext) (*database.User, error) {
+	if !IsValidRole(role) {
+		return nil, fmt.Errorf("invalid role: %s", role)
+	}
+
+	user, err := s.userRepo.GetByIDAnyStatus(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Role == RoleAdmin && role != RoleAdmin && user.IsActive {
+		if err := s.ensureAnotherAdmin(); err != nil {
+			return nil, err
+		}
+	}
+
+	previousRole := user.Role
+	if err := s.userRepo.UpdateRole(userID, role); err != nil {
+		return nil, err
+	}
+	user.Role = role
+
+	s.logUserChange(audit.ActionUserRoleChange, user, auditCtx, previousRole, role)
+
+	return user, nil
+}
+
 // CleanupExpiredSessions removes expired sessions
 func (s *Service) CleanupExpiredSessions() error {
 	deleted, err := s.sessionRepo.DeleteExpired()
@@ -195,3 +349,12 @@ func generateSessionID() (string, error) {
 func stringPtr(s string) *string {
 	return &s
 }
+
+// sessionReference shortens a session ID for the audit log, which auditors can read, so
+// that entries can be correlated without exposing a usable session
+func sessionReference(sessionID string) string {
+	if len(sessionID) > 12 {
+		return sessionID[:12]
+	}
+	return sessionID
+}
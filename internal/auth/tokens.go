@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/audit"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+const (
+	// APITokenPrefix marks exim-pilot API tokens so they are recognisable in scripts and secret scanners
+	APITokenPrefix = "ept_"
+
+	// DefaultAPITokenLifetime is used when a token is created without an explicit expiry
+	DefaultAPITokenLifetime = 90 * 24 * time.Hour
+
+	// MaxAPITokenLifetime caps how far in the future a token may expire
+	MaxAPITokenLifetime = 365 * 24 * time.Hour
+
+	// tokenUsageInterval limits how often last-used tracking writes to the database
+	tokenUsageInterval = time.Minute
+)
+
+// TokenAuditUserID returns the identity recorded in the audit log for actions taken with a token
+func TokenAuditUserID(token *database.APIToken) string {
+	return fmt.Sprintf("token:%d", token.ID)
+}
+
+// TokenHasScope reports whether the token was granted the permission
+func TokenHasScope(token *database.APIToken, permission Permission) bool {
+	for _, scope := range token.Scopes {
+		if Permission(scope) == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIToken issues a new token for the user. The plaintext token is returned once
+// and only its hash is stored. Scopes must be permissions the user's role already grants.
+func (s *Service) CreateAPIToken(userID int64, name string, scopes []string, expiresAt time.Time, auditCtx *audit.AuditContext) (string, *database.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, fmt.Errorf("token name cannot be empty")
+	}
+	if len(name) > 100 {
+		return "", nil, fmt.Errorf("token name cannot exceed 100 characters")
+	}
+
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return "", nil, fmt.Errorf("user not found")
+	}
+
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("at least one scope is required")
+	}
+	seen := make(map[string]bool)
+	var granted []string
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if seen[scope] {
+			continue
+		}
+		seen[scope] = true
+
+		if !IsValidPermission(Permission(scope)) {
+			return "", nil, fmt.Errorf("invalid scope: %s", scope)
+		}
+		if !HasPermission(user.Role, Permission(scope)) {
+			return "", nil, fmt.Errorf("role '%s' cannot grant scope '%s'", user.Role, scope)
+		}
+		granted = append(granted, scope)
+	}
+
+	now := time.Now()
+	if expiresAt.IsZero() {
+		expiresAt = now.Add(DefaultAPITokenLifetime)
+	}
+	if !expiresAt.After(now) {
+		return "", nil, fmt.Errorf("expiry must be in the future")
+	}
+	if expiresAt.After(now.Add(MaxAPITokenLifetime)) {
+		return "", nil, fmt.Errorf("expiry cannot be more than %d days away", int(MaxAPITokenLifetime.Hours()/24))
+	}
+
+	secret, err := generateAPITokenSecret()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate API token: %w", err)
+	}
+
+	token := &database.APIToken{
+		UserID:      user.ID,
+		Name:        name,
+		TokenPrefix: secret[:len(APITokenPrefix)+8],
+		TokenHash:   hashAPIToken(secret),
+		Scopes:      granted,
+		ExpiresAt:   expiresAt.UTC(),
+	}
+
+	if err := s.tokenRepo.Create(token); err != nil {
+		return "", nil, err
+	}
+
+	s.logTokenChange(audit.ActionAPITokenCreate, token, auditCtx)
+
+	return secret, token, nil
+}
+
+// ValidateAPIToken resolves a bearer token to its token record and owning user.
+// Revoked or expired tokens and tokens of disabled users are rejected.
+func (s *Service) ValidateAPIToken(secret, ipAddress string) (*database.User, *database.APIToken, error) {
+	if !strings.HasPrefix(secret, APITokenPrefix) {
+		return nil, nil, fmt.Errorf("invalid API token")
+	}
+
+	token, err := s.tokenRepo.GetByHash(hashAPIToken(secret))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid API token")
+	}
+
+	if token.RevokedAt != nil {
+		return nil, nil, fmt.Errorf("API token has been revoked")
+	}
+
+	now := time.Now()
+	if !token.ExpiresAt.After(now) {
+		return nil, nil, fmt.Errorf("API token has expired")
+	}
+
+	user, err := s.userRepo.GetByID(token.UserID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid API token")
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= tokenUsageInterval ||
+		token.LastUsedIP == nil || *token.LastUsedIP != ipAddress {
+		if err := s.tokenRepo.UpdateLastUsed(token.ID, ipAddress); err != nil {
+			fmt.Printf("Warning: failed to record use of API token %d: %v\n", token.ID, err)
+		} else {
+			token.LastUsedAt = &now
+			token.LastUsedIP = &ipAddress
+		}
+	}
+
+	return user, token, nil
+}
+
+// ListAPITokens lists the tokens of a user, or of all users when userID is zero
+func (s *Service) ListAPITokens(userID int64) ([]database.APIToken, error) {
+	return s.tokenRepo.List(userID)
+}
+
+// GetAPIToken returns a token by ID
+func (s *Service) GetAPIToken(tokenID int64) (*database.APIToken, error) {
+	return s.tokenRepo.GetByID(tokenID)
+}
+
+// RevokeAPIToken revokes a token so that it can no longer authenticate
+func (s *Service) RevokeAPIToken(tokenID int64, auditCtx *audit.AuditContext) error {
+	token, err := s.tokenRepo.GetByID(tokenID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.tokenRepo.Revoke(tokenID); err != nil {
+		return err
+	}
+
+	s.logTokenChange(audit.ActionAPITokenRevoke, token, auditCtx)
+	return nil
+}
+
+// logTokenChange records token creation or revocation through the audit service
+func (s *Service) logTokenChange(action audit.ActionType, token *database.APIToken, auditCtx *audit.AuditContext) {
+	if auditCtx == nil {
+		auditCtx = systemAuditContext
+	}
+
+	details := &audit.AuditDetails{
+		Operation: string(action),
+		Parameters: map[string]interface{}{
+			"token_id":     token.ID,
+			"token_name":   token.Name,
+			"token_prefix": token.TokenPrefix,
+			"owner_id":     token.UserID,
+			"scopes":       token.Scopes,
+			"expires_at":   token.ExpiresAt,
+		},
+		Result: "success",
+	}
+
+	if err := s.audit.LogAction(context.Background(), action, nil, auditCtx, details); err != nil {
+		log.Printf("Failed to audit %s for API token %d: %v", action, token.ID, err)
+	}
+}
+
+// generateAPITokenSecret generates a random token with the exim-pilot prefix
+func generateAPITokenSecret() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return APITokenPrefix + base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// hashAPIToken returns the stored form of a token. Tokens carry 256 bits of entropy,
+// so a fast hash is sufficient and keeps per-request validation cheap.
+func hashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPITokenLifecycle(t *testing.T) {
+	service := newTestService(t)
+
+	user, err := service.CreateUser("ops", "Secret123!", "", "", RoleOperator, nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	if _, _, err := service.CreateAPIToken(user.ID, "backup", []string{string(PermissionAdmin)}, time.Time{}, nil); err == nil {
+		t.Error("Expected operator to be unable to grant the admin scope")
+	}
+
+	secret, token, err := service.CreateAPIToken(user.ID, "backup", []string{string(PermissionQueueRead)}, time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+	if !strings.HasPrefix(secret, APITokenPrefix) || strings.Contains(token.TokenHash, secret) {
+		t.Errorf("Unexpected token secret %q / hash %q", secret, token.TokenHash)
+	}
+
+	gotUser, gotToken, err := service.ValidateAPIToken(secret, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("ValidateAPIToken failed: %v", err)
+	}
+	if gotUser.ID != user.ID || gotToken.ID != token.ID {
+		t.Errorf("Token resolved to user %d token %d", gotUser.ID, gotToken.ID)
+	}
+	if gotToken.LastUsedAt == nil {
+		t.Error("Expected last-used time to be recorded")
+	}
+	if !TokenHasScope(gotToken, PermissionQueueRead) || TokenHasScope(gotToken, PermissionQueueMutate) {
+		t.Errorf("Unexpected scopes %v", gotToken.Scopes)
+	}
+
+	if _, _, err := service.ValidateAPIToken(secret+"x", "192.0.2.1"); err == nil {
+		t.Error("Expected altered token to be rejected")
+	}
+
+	if err := service.RevokeAPIToken(token.ID, nil); err != nil {
+		t.Fatalf("RevokeAPIToken failed: %v", err)
+	}
+	if _, _, err := service.ValidateAPIToken(secret, "192.0.2.1"); err == nil {
+		t.Error("Expected revoked token to be rejected")
+	}
+}
+
+func TestAPITokenExpiry(t *testing.T) {
+	service := newTestService(t)
+
+	user, err := service.CreateUser("ops", "Secret123!", "", "", RoleViewer, nil)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	if _, _, err := service.CreateAPIToken(user.ID, "old", []string{string(PermissionLogRead)}, time.Now().Add(-time.Hour), nil); err == nil {
+		t.Error("Expected expiry in the past to be rejected")
+	}
+
+	secret, _, err := service.CreateAPIToken(user.ID, "short", []string{string(PermissionLogRead)}, time.Now().Add(time.Second), nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if _, _, err := service.ValidateAPIToken(secret, "192.0.2.1"); err == nil {
+		t.Error("Expected expired token to be rejected")
+	}
+}
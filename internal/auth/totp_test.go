@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	// RFC 6238 appendix B SHA-1 vectors, truncated to six digits
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := TOTPCode(secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode failed: %v", err)
+		}
+		if code != tt.expected {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, code, tt.expected)
+		}
+	}
+}
+
+func TestValidateTOTPCodeSkew(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret failed: %v", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	previous, _ := TOTPCode(secret, now.Add(-30*time.Second))
+	if _, ok := ValidateTOTPCode(secret, previous, now); !ok {
+		t.Error("Expected code from the previous step to be accepted")
+	}
+
+	old, _ := TOTPCode(secret, now.Add(-90*time.Second))
+	if _, ok := ValidateTOTPCode(secret, old, now); ok {
+		t.Error("Expected code from three steps ago to be rejected")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("Exim Pilot", "alice", "JBSWY3DPEHPK3PXP")
+
+	for _, part := range []string{"otpauth://totp/Exim%20Pilot:alice?", "secret=JBSWY3DPEHPK3PXP", "issuer=Exim+Pilot", "digits=6", "period=30"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("Expected %q in %s", part, uri)
+		}
+	}
+}
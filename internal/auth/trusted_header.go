@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedHeaderConfig configures authentication by an upstream reverse proxy that passes
+// the authenticated user in request headers
+type TrustedHeaderConfig struct {
+	UserHeader   string
+	GroupsHeader string
+	EmailHeader  string
+	NameHeader   string
+
+	// TrustedProxies lists the IPs or CIDR ranges allowed to set the headers
+	TrustedProxies []string
+
+	GroupRoles  map[string]string
+	DefaultRole string
+}
+
+// TrustedHeaderAuthenticator identifies users from headers set by a trusted proxy.
+// Requests from any other address are never authenticated this way.
+type TrustedHeaderAuthenticator struct {
+	config  TrustedHeaderConfig
+	proxies []*net.IPNet
+}
+
+// NewTrustedHeaderAuthenticator validates the configuration and fills in defaults
+func NewTrustedHeaderAuthenticator(config TrustedHeaderConfig) (*TrustedHeaderAuthenticator, error) {
+	if len(config.TrustedProxies) == 0 {
+		return nil, fmt.Errorf("trusted header authentication requires at least one trusted proxy")
+	}
+	if err := validateGroupRoles(config.GroupRoles, config.DefaultRole); err != nil {
+		return nil, fmt.Errorf("trusted header group mapping: %w", err)
+	}
+
+	if config.UserHeader == "" {
+		config.UserHeader = "X-Remote-User"
+	}
+	if config.GroupsHeader == "" {
+		config.GroupsHeader = "X-Remote-Groups"
+	}
+	if config.EmailHeader == "" {
+		config.EmailHeader = "X-Remote-Email"
+	}
+	if config.NameHeader == "" {
+		config.NameHeader = "X-Remote-Name"
+	}
+
+	proxies, err := ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TrustedHeaderAuthenticator{config: config, proxies: proxies}, nil
+}
+
+// Identify returns the identity asserted by a trusted proxy. It returns nil without an
+// error when the request carries no user header or does not come from a trusted proxy.
+func (a *TrustedHeaderAuthenticator) Identify(remoteAddr string, header http.Header) (*Identity, error) {
+	username := strings.ToLower(strings.TrimSpace(header.Get(a.config.UserHeader)))
+	if username == "" || !a.isTrustedProxy(remoteAddr) {
+		return nil, nil
+	}
+
+	groups := strings.FieldsFunc(header.Get(a.config.GroupsHeader), func(r rune) bool {
+		return r == ',' || r == ';'
+	})
+	for i := range groups {
+		groups[i] = strings.TrimSpace(groups[i])
+	}
+
+	identity := &Identity{
+		Username: username,
+		Email:    strings.TrimSpace(header.Get(a.config.EmailHeader)),
+		FullName: strings.TrimSpace(header.Get(a.config.NameHeader)),
+		Groups:   groups,
+		Role:     roleForGroups(groups, a.config.GroupRoles, a.config.DefaultRole),
+		Source:   SourceTrustedHeader,
+	}
+
+	if identity.Role == "" {
+		return nil, fmt.Errorf("%w: %s is not in any mapped group", ErrNoRole, username)
+	}
+
+	return identity, nil
+}
+
+// isTrustedProxy reports whether the connection comes from a configured proxy
+func (a *TrustedHeaderAuthenticator) isTrustedProxy(remoteAddr string) bool {
+	return ContainsAddress(a.proxies, remoteAddr)
+}
+
+// ParseTrustedProxies parses security.trusted_proxies, a list of IPs and CIDR ranges
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, proxy := range proxies {
+		network, err := parseTrustedProxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ContainsAddress reports whether an address, with or without a port, is in one of the
+// networks
+func ContainsAddress(networks []*net.IPNet, addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(host))
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxy accepts a single IP or a CIDR range
+func parseTrustedProxy(proxy string) (*net.IPNet, error) {
+	proxy = strings.TrimSpace(proxy)
+	if !strings.Contains(proxy, "/") {
+		ip := net.ParseIP(proxy)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+		if ip.To4() != nil {
+			proxy += "/32"
+		} else {
+			proxy += "/128"
+		}
+	}
+
+	_, network, err := net.ParseCIDR(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+	}
+	return network, nil
+}
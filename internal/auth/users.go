@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"unicode"
+
+	"github.com/andreitelteu/exim-pilot/internal/audit"
+	"github.com/andreitelteu/exim-pilot/internal/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// systemAuditContext identifies changes made by the application itself, such as the
+// default-user bootstrap
+var systemAuditContext = &audit.AuditContext{UserID: "system", IPAddress: "local"}
+
+// SetPasswordPolicy sets the password rules enforced when passwords are set through
+// user management. A zero minimum length disables the length check.
+func (s *Service) SetPasswordPolicy(minLength int, requireStrong bool) {
+	s.passwordMinLength = minLength
+	s.requireStrongPassword = requireStrong
+}
+
+// ValidatePassword checks a password against the configured password policy
+func (s *Service) ValidatePassword(password string) error {
+	if password == "" {
+		return fmt.Errorf("password cannot be empty")
+	}
+
+	if s.passwordMinLength > 0 && len(password) < s.passwordMinLength {
+		return fmt.Errorf("password must be at least %d characters", s.passwordMinLength)
+	}
+
+	if s.requireStrongPassword {
+		var upper, lower, digit, other bool
+		for _, c := range password {
+			switch {
+			case unicode.IsUpper(c):
+				upper = true
+			case unicode.IsLower(c):
+				lower = true
+			case unicode.IsDigit(c):
+				digit = true
+			default:
+				other = true
+			}
+		}
+		if !upper || !lower || !(digit || other) {
+			return fmt.Errorf("password must contain upper and lower case letters and a digit or symbol")
+		}
+	}
+
+	return nil
+}
+
+// ListUsers returns all users, optionally including disabled accounts
+func (s *Service) ListUsers(includeInactive bool) ([]database.User, error) {
+	return s.userRepo.List(includeInactive)
+}
+
+// GetUser returns a user by ID whether or not the account is active
+func (s *Service) GetUser(userID int64) (*database.User, error) {
+	return s.userRepo.GetByIDAnyStatus(userID)
+}
+
+// GetUserByUsername returns a user by username whether or not the account is active
+func (s *Service) GetUserByUsername(username string) (*database.User, error) {
+	return s.userRepo.GetByUsernameAnyStatus(username)
+}
+
+// UpdateUserProfile changes a user's email and full name
+func (s *Service) UpdateUserProfile(userID int64, email, fullName *string, auditCtx *audit.AuditContext) (*database.User, error) {
+	user, err := s.userRepo.GetByIDAnyStatus(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := map[string]interface{}{"email": user.Email, "full_name": user.FullName}
+	if email != nil {
+		user.Email = email
+	}
+	if fullName != nil {
+		user.FullName = fullName
+	}
+
+	if err := s.userRepo.UpdateProfile(userID, user.Email, user.FullName); err != nil {
+		return nil, err
+	}
+
+	s.logUserChange(audit.ActionUserUpdate, user, auditCtx, previous, map[string]interface{}{
+		"email":     user.Email,
+		"full_name": user.FullName,
+	})
+
+	return user, nil
+}
+
+// SetUserActive enables or disables a user. Disabling a user also revokes all of
+// their sessions.
+func (s *Service) SetUserActive(userID int64, active bool, auditCtx *audit.AuditContext) (*database.User, error) {
+	user, err := s.userRepo.GetByIDAnyStatus(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !active && user.IsActive && user.Role == RoleAdmin {
+		if err := s.ensureAnotherAdmin(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.userRepo.SetActive(userID, active); err != nil {
+		return nil, err
+	}
+	previous := user.IsActive
+	user.IsActive = active
+
+	action := audit.ActionUserEnable
+	if !active {
+		action = audit.ActionUserDisable
+		if err := s.sessionRepo.DeleteByUserID(userID); err != nil {
+			return user, err
+		}
+	}
+
+	s.logUserChange(action, user, auditCtx, previous, active)
+
+	return user, nil
+}
+
+// ResetPassword sets a new password for a user and revokes their sessions
+func (s *Service) ResetPassword(userID int64, password string, auditCtx *audit.AuditContext) error {
+	if err := s.ValidatePassword(password); err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.GetByIDAnyStatus(userID)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	if err := s.userRepo.UpdatePassword(userID, string(hashedPassword)); err != nil {
+		return err
+	}
+
+	if err := s.sessionRepo.DeleteByUserID(userID); err != nil {
+		return err
+	}
+
+	s.logUserChange(audit.ActionUserPasswordReset, user, auditCtx, nil, nil)
+
+	return nil
+}
+
+// RevokeSessions deletes all sessions of a user, logging them out everywhere
+func (s *Service) RevokeSessions(userID int64, auditCtx *audit.AuditContext) error {
+	user, err := s.userRepo.GetByIDAnyStatus(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.sessionRepo.DeleteByUserID(userID); err != nil {
+		return err
+	}
+
+	s.logUserChange(audit.ActionUserSessionsRevoke, user, auditCtx, nil, nil)
+
+	return nil
+}
+
+// ensureAnotherAdmin refuses changes that would leave no active administrator
+func (s *Service) ensureAnotherAdmin() error {
+	count, err := s.userRepo.CountActiveByRole(RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if count <= 1 {
+		return fmt.Errorf("cannot remove the last active admin")
+	}
+	return nil
+}
+
+// logUserChange records a user management change through the audit service
+func (s *Service) logUserChange(action audit.ActionType, user *database.User, auditCtx *audit.AuditContext, previous, next interface{}) {
+	if auditCtx == nil {
+		auditCtx = systemAuditContext
+	}
+
+	details := &audit.AuditDetails{
+		Operation: string(action),
+		Parameters: map[string]interface{}{
+			"target_user_id":  user.ID,
+			"target_username": user.Username,
+		},
+		Result:        "success",
+		PreviousValue: previous,
+		NewValue:      next,
+	}
+
+	if err := s.audit.LogAction(context.Background(), action, nil, auditCtx, details); err != nil {
+		log.Printf("Failed to audit %s for user %d: %v", action, user.ID, err)
+	}
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+
+	db, err := database.Connect(&database.Config{
+		Path:            filepath.Join(t.TempDir(), "auth.db"),
+		MaxOpenConns:    1,
+		MaxIdleConns:    1,
+		ConnMaxLifetime: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := database.MigrateUp(db); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	return NewService(db)
+}
+
+func TestCreateUserPasswordPolicy(t *testing.T) {
+	service := newTestService(t)
+	service.SetPasswordPolicy(10, true)
+
+	// The policy applies whether or not the change is attributed to a user
+	if _, err := service.CreateUser("weak", "admin123", "", "", RoleViewer, nil); err == nil {
+		t.Error("Expected a weak password to be rejected without an audit context")
+	}
+	if _, err := service.CreateUser("weak", "admin123", "", "", RoleViewer, systemAuditContext); err == nil {
+		t.Error("Expected a weak password to be rejected")
+	}
+	if _, err := service.CreateUser("strong", "Secret123!x", "", "", RoleViewer, nil); err != nil {
+		t.Errorf("Expected a compliant password to be accepted, got %v", err)
+	}
+
+	// Only the bootstrap path skips it
+	user, err := service.CreateUserWithoutPasswordPolicy("admin", "admin123", "admin@localhost", "Administrator", RoleAdmin)
+	if err != nil {
+		t.Fatalf("CreateUserWithoutPasswordPolicy failed: %v", err)
+	}
+	if user.Role != RoleAdmin || !user.IsActive {
+		t.Errorf("Unexpected bootstrap user %+v", user)
+	}
+}
@@ -20,6 +20,8 @@ type Config struct {
 	Retention RetentionConfig `yaml:"retention" json:"retention"`
 	Security  SecurityConfig  `yaml:"security" json:"security"`
 	Auth      AuthConfig      `yaml:"auth" json:"auth"`
+	Alerting  AlertingConfig  `yaml:"alerting" json:"alerting"`
+	Metrics   MetricsConfig   `yaml:"metrics" json:"metrics"`
 }
 
 // ServerConfig holds HTTP server configuration
@@ -34,6 +36,14 @@ type ServerConfig struct {
 	TLSEnabled     bool     `yaml:"tls_enabled" json:"tls_enabled"`
 	TLSCertFile    string   `yaml:"tls_cert_file" json:"tls_cert_file"`
 	TLSKeyFile     string   `yaml:"tls_key_file" json:"tls_key_file"`
+
+	// TLSRedirectPort, when set, serves plain HTTP on this port and redirects it to HTTPS
+	TLSRedirectPort int `yaml:"tls_redirect_port" json:"tls_redirect_port"`
+
+	// TLSClientCAFile holds the CAs that sign client certificates. Clients may then
+	// present a certificate; TLSRequireTokenClientCert makes it mandatory for API tokens.
+	TLSClientCAFile           string `yaml:"tls_client_ca_file" json:"tls_client_ca_file"`
+	TLSRequireTokenClientCert bool   `yaml:"tls_require_token_client_cert" json:"tls_require_token_client_cert"`
 }
 
 // DatabaseConfig holds database configuration
@@ -45,6 +55,7 @@ type DatabaseConfig struct {
 	BackupEnabled   bool   `yaml:"backup_enabled" json:"backup_enabled"`
 	BackupInterval  int    `yaml:"backup_interval" json:"backup_interval"` // hours
 	BackupPath      string `yaml:"backup_path" json:"backup_path"`
+	BackupKeep      int    `yaml:"backup_keep" json:"backup_keep"` // number of backups kept
 }
 
 // EximConfig holds Exim-specific configuration
@@ -55,6 +66,10 @@ type EximConfig struct {
 	ConfigFile     string   `yaml:"config_file" json:"config_file"`
 	QueueRunUser   string   `yaml:"queue_run_user" json:"queue_run_user"`
 	LogRotationDir string   `yaml:"log_rotation_dir" json:"log_rotation_dir"`
+
+	// QueueSnapshotInterval is how often the queue size is recorded for history, in
+	// seconds. Zero disables snapshots.
+	QueueSnapshotInterval int `yaml:"queue_snapshot_interval" json:"queue_snapshot_interval"`
 }
 
 // LoggingConfig holds application logging configuration
@@ -79,13 +94,17 @@ type RetentionConfig struct {
 // SecurityConfig holds security-related configuration
 type SecurityConfig struct {
 	SessionTimeout   int      `yaml:"session_timeout" json:"session_timeout"`       // minutes
-	MaxLoginAttempts int      `yaml:"max_login_attempts" json:"max_login_attempts"` // per IP
+	MaxLoginAttempts int      `yaml:"max_login_attempts" json:"max_login_attempts"` // per IP address and per username
 	LoginLockoutTime int      `yaml:"login_lockout_time" json:"login_lockout_time"` // minutes
 	CSRFProtection   bool     `yaml:"csrf_protection" json:"csrf_protection"`
 	SecureCookies    bool     `yaml:"secure_cookies" json:"secure_cookies"`
+	CookieSameSite   string   `yaml:"cookie_same_site" json:"cookie_same_site"` // strict, lax or none
 	ContentRedaction bool     `yaml:"content_redaction" json:"content_redaction"`
 	AuditAllActions  bool     `yaml:"audit_all_actions" json:"audit_all_actions"`
 	TrustedProxies   []string `yaml:"trusted_proxies" json:"trusted_proxies"`
+
+	// AuditSigningKey signs exported audit log checkpoints. Keep a copy to verify them later.
+	AuditSigningKey string `yaml:"audit_signing_key" json:"-"`
 }
 
 // AuthConfig holds authentication configuration
@@ -95,6 +114,115 @@ type AuthConfig struct {
 	PasswordMinLen  int    `yaml:"password_min_length" json:"password_min_length"`
 	RequireStrongPw bool   `yaml:"require_strong_password" json:"require_strong_password"`
 	SessionSecret   string `yaml:"session_secret" json:"session_secret"`
+
+	// TOTPIssuer names this installation in authenticator apps
+	TOTPIssuer string `yaml:"totp_issuer" json:"totp_issuer"`
+	// RequireTOTPForMutate blocks queue changes by users who have not enrolled TOTP
+	RequireTOTPForMutate bool `yaml:"require_totp_for_mutate" json:"require_totp_for_mutate"`
+
+	// Backends lists the password backends tried in order at login: "local", "ldap"
+	Backends      []string            `yaml:"backends" json:"backends"`
+	LDAP          LDAPConfig          `yaml:"ldap" json:"ldap"`
+	TrustedHeader TrustedHeaderConfig `yaml:"trusted_header" json:"trusted_header"`
+}
+
+// AlertingConfig holds alert rules and the notifiers they report to. Rules can also be
+// managed through the API; the ones defined here are read-only there.
+type AlertingConfig struct {
+	Enabled            bool                  `yaml:"enabled" json:"enabled"`
+	EvaluationInterval int                   `yaml:"evaluation_interval" json:"evaluation_interval"` // seconds
+	Notifiers          []AlertNotifierConfig `yaml:"notifiers" json:"notifiers"`
+	Rules              []AlertRuleConfig     `yaml:"rules" json:"rules"`
+}
+
+// MetricsConfig controls the Prometheus metrics endpoint. It is not behind the session
+// login; scrapers are checked against allowed_networks and the optional bearer token.
+type MetricsConfig struct {
+	Enabled         bool     `yaml:"enabled" json:"enabled"`
+	Path            string   `yaml:"path" json:"path"`
+	Token           string   `yaml:"token" json:"-"`
+	AllowedNetworks []string `yaml:"allowed_networks" json:"allowed_networks"` // IPs or CIDR ranges, empty for any
+	MaxDomains      int      `yaml:"max_domains" json:"max_domains"`           // recipient domains tracked as labels
+}
+
+// AlertNotifierConfig configures one notifier. Type selects which fields apply:
+// email (smtp_host, smtp_port, from, to), webhook (url, headers), slack (url, channel,
+// username) or syslog (network, address, tag).
+type AlertNotifierConfig struct {
+	Name string `yaml:"name" json:"name"`
+	Type string `yaml:"type" json:"type"`
+
+	SMTPHost string   `yaml:"smtp_host" json:"smtp_host,omitempty"`
+	SMTPPort int      `yaml:"smtp_port" json:"smtp_port,omitempty"`
+	From     string   `yaml:"from" json:"from,omitempty"`
+	To       []string `yaml:"to" json:"to,omitempty"`
+
+	// URL and Headers often carry credentials
+	URL      string            `yaml:"url" json:"-"`
+	Headers  map[string]string `yaml:"headers" json:"-"`
+	Channel  string            `yaml:"channel" json:"channel,omitempty"`
+	Username string            `yaml:"username" json:"username,omitempty"`
+
+	Network string `yaml:"network" json:"network,omitempty"`
+	Address string `yaml:"address" json:"address,omitempty"`
+	Tag     string `yaml:"tag" json:"tag,omitempty"`
+}
+
+// AlertRuleConfig defines an alert rule. Metrics are queue_total, queue_deferred,
+// queue_frozen, queue_oldest_age, log_event_rate, bounce_ratio and panic_entries.
+type AlertRuleConfig struct {
+	Name           string   `yaml:"name" json:"name"`
+	Description    string   `yaml:"description" json:"description"`
+	Metric         string   `yaml:"metric" json:"metric"`
+	Event          string   `yaml:"event" json:"event"`   // log event counted by log_event_rate
+	Window         int      `yaml:"window" json:"window"` // seconds
+	Operator       string   `yaml:"operator" json:"operator"`
+	Threshold      float64  `yaml:"threshold" json:"threshold"`
+	For            int      `yaml:"for" json:"for"`                         // seconds
+	Cooldown       int      `yaml:"cooldown" json:"cooldown"`               // seconds
+	RepeatInterval int      `yaml:"repeat_interval" json:"repeat_interval"` // seconds
+	Severity       string   `yaml:"severity" json:"severity"`
+	Notifiers      []string `yaml:"notifiers" json:"notifiers"`
+	SendResolved   *bool    `yaml:"send_resolved" json:"send_resolved"` // default true
+	Enabled        *bool    `yaml:"enabled" json:"enabled"`             // default true
+}
+
+// LDAPConfig holds LDAP authentication settings. Users are found either through
+// UserDNTemplate or by searching UserBaseDN with UserFilter.
+type LDAPConfig struct {
+	URL                string `yaml:"url" json:"url"` // ldap://host:389 or ldaps://host:636
+	StartTLS           bool   `yaml:"start_tls" json:"start_tls"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify"`
+	Timeout            int    `yaml:"timeout" json:"timeout"` // seconds
+
+	BindDN       string `yaml:"bind_dn" json:"bind_dn"`
+	BindPassword string `yaml:"bind_password" json:"-"`
+
+	UserDNTemplate string `yaml:"user_dn_template" json:"user_dn_template"`
+	UserBaseDN     string `yaml:"user_base_dn" json:"user_base_dn"`
+	UserFilter     string `yaml:"user_filter" json:"user_filter"`
+	EmailAttribute string `yaml:"email_attribute" json:"email_attribute"`
+	NameAttribute  string `yaml:"name_attribute" json:"name_attribute"`
+
+	GroupBaseDN       string `yaml:"group_base_dn" json:"group_base_dn"`
+	GroupFilter       string `yaml:"group_filter" json:"group_filter"`
+	GroupAttribute    string `yaml:"group_attribute" json:"group_attribute"`
+	MemberOfAttribute string `yaml:"member_of_attribute" json:"member_of_attribute"`
+
+	GroupRoles  map[string]string `yaml:"group_roles" json:"group_roles"`
+	DefaultRole string            `yaml:"default_role" json:"default_role"`
+}
+
+// TrustedHeaderConfig enables authentication by a reverse proxy listed in
+// security.trusted_proxies
+type TrustedHeaderConfig struct {
+	Enabled      bool              `yaml:"enabled" json:"enabled"`
+	UserHeader   string            `yaml:"user_header" json:"user_header"`
+	GroupsHeader string            `yaml:"groups_header" json:"groups_header"`
+	EmailHeader  string            `yaml:"email_header" json:"email_header"`
+	NameHeader   string            `yaml:"name_header" json:"name_header"`
+	GroupRoles   map[string]string `yaml:"group_roles" json:"group_roles"`
+	DefaultRole  string            `yaml:"default_role" json:"default_role"`
 }
 
 // DefaultConfig returns a configuration with sensible defaults
@@ -118,6 +246,7 @@ func DefaultConfig() *Config {
 			BackupEnabled:   true,
 			BackupInterval:  24, // hours
 			BackupPath:      "/opt/exim-pilot/backups",
+			BackupKeep:      7,
 		},
 		Exim: EximConfig{
 			LogPaths: []string{
@@ -130,6 +259,8 @@ func DefaultConfig() *Config {
 			ConfigFile:     "/etc/exim4/exim4.conf",
 			QueueRunUser:   "Debian-exim",
 			LogRotationDir: "/var/log/exim4",
+
+			QueueSnapshotInterval: 300,
 		},
 		Logging: LoggingConfig{
 			Level:      "info",
@@ -152,6 +283,7 @@ func DefaultConfig() *Config {
 			LoginLockoutTime: 15, // minutes
 			CSRFProtection:   true,
 			SecureCookies:    true,
+			CookieSameSite:   "strict",
 			ContentRedaction: true,
 			AuditAllActions:  true,
 			TrustedProxies:   []string{},
@@ -162,6 +294,33 @@ func DefaultConfig() *Config {
 			PasswordMinLen:  8,
 			RequireStrongPw: true,
 			SessionSecret:   "", // Will be generated if empty
+			TOTPIssuer:      "Exim Pilot",
+			Backends:        []string{"local"},
+			LDAP: LDAPConfig{
+				Timeout:           10,
+				UserFilter:        "(uid=%s)",
+				EmailAttribute:    "mail",
+				NameAttribute:     "cn",
+				GroupFilter:       "(member=%s)",
+				GroupAttribute:    "cn",
+				MemberOfAttribute: "memberOf",
+			},
+			TrustedHeader: TrustedHeaderConfig{
+				UserHeader:   "X-Remote-User",
+				GroupsHeader: "X-Remote-Groups",
+				EmailHeader:  "X-Remote-Email",
+				NameHeader:   "X-Remote-Name",
+			},
+		},
+		Alerting: AlertingConfig{
+			Enabled:            true,
+			EvaluationInterval: 60, // seconds
+		},
+		Metrics: MetricsConfig{
+			Enabled:         true,
+			Path:            "/metrics",
+			AllowedNetworks: []string{"127.0.0.1", "::1"},
+			MaxDomains:      100,
 		},
 	}
 }
@@ -238,6 +397,16 @@ func (c *Config) LoadFromEnv() {
 		c.Server.TLSKeyFile = key
 	}
 
+	if redirectPort := os.Getenv("EXIM_PILOT_TLS_REDIRECT_PORT"); redirectPort != "" {
+		if p, err := strconv.Atoi(redirectPort); err == nil {
+			c.Server.TLSRedirectPort = p
+		}
+	}
+
+	if clientCA := os.Getenv("EXIM_PILOT_TLS_CLIENT_CA"); clientCA != "" {
+		c.Server.TLSClientCAFile = clientCA
+	}
+
 	// Database configuration
 	if dbPath := os.Getenv("EXIM_PILOT_DB_PATH"); dbPath != "" {
 		c.Database.Path = dbPath
@@ -262,6 +431,12 @@ func (c *Config) LoadFromEnv() {
 		c.Exim.BinaryPath = binaryPath
 	}
 
+	if interval := os.Getenv("EXIM_PILOT_QUEUE_SNAPSHOT_INTERVAL"); interval != "" {
+		if i, err := strconv.Atoi(interval); err == nil {
+			c.Exim.QueueSnapshotInterval = i
+		}
+	}
+
 	// Logging configuration
 	if logLevel := os.Getenv("EXIM_PILOT_LOG_LEVEL"); logLevel != "" {
 		c.Logging.Level = logLevel
@@ -284,6 +459,10 @@ func (c *Config) LoadFromEnv() {
 		c.Auth.SessionSecret = sessionSecret
 	}
 
+	if signingKey := os.Getenv("EXIM_PILOT_AUDIT_SIGNING_KEY"); signingKey != "" {
+		c.Security.AuditSigningKey = signingKey
+	}
+
 	// Security configuration
 	if sessionTimeout := os.Getenv("EXIM_PILOT_SESSION_TIMEOUT"); sessionTimeout != "" {
 		if t, err := strconv.Atoi(sessionTimeout); err == nil {
@@ -294,6 +473,15 @@ func (c *Config) LoadFromEnv() {
 	if secureCookies := os.Getenv("EXIM_PILOT_SECURE_COOKIES"); secureCookies != "" {
 		c.Security.SecureCookies = secureCookies == "true"
 	}
+
+	if sameSite := os.Getenv("EXIM_PILOT_COOKIE_SAME_SITE"); sameSite != "" {
+		c.Security.CookieSameSite = sameSite
+	}
+
+	// Metrics configuration
+	if metricsToken := os.Getenv("EXIM_PILOT_METRICS_TOKEN"); metricsToken != "" {
+		c.Metrics.Token = metricsToken
+	}
 }
 
 // Validate validates the configuration
@@ -319,6 +507,25 @@ func (c *Config) Validate() error {
 		if _, err := os.Stat(c.Server.TLSKeyFile); os.IsNotExist(err) {
 			return fmt.Errorf("TLS key file not found: %s", c.Server.TLSKeyFile)
 		}
+
+		if c.Server.TLSRedirectPort != 0 {
+			if c.Server.TLSRedirectPort < 1 || c.Server.TLSRedirectPort > 65535 {
+				return fmt.Errorf("invalid TLS redirect port: %d", c.Server.TLSRedirectPort)
+			}
+			if c.Server.TLSRedirectPort == c.Server.Port {
+				return fmt.Errorf("TLS redirect port must differ from the server port")
+			}
+		}
+
+		if c.Server.TLSClientCAFile != "" {
+			if _, err := os.Stat(c.Server.TLSClientCAFile); os.IsNotExist(err) {
+				return fmt.Errorf("TLS client CA file not found: %s", c.Server.TLSClientCAFile)
+			}
+		}
+	}
+
+	if c.Server.TLSRequireTokenClientCert && (!c.Server.TLSEnabled || c.Server.TLSClientCAFile == "") {
+		return fmt.Errorf("tls_require_token_client_cert needs TLS enabled and tls_client_ca_file set")
 	}
 
 	// Validate database configuration
@@ -336,6 +543,19 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("database max_open_conns must be at least 1")
 	}
 
+	if c.Database.BackupEnabled {
+		if c.Database.BackupInterval < 1 {
+			return fmt.Errorf("database backup_interval must be at least 1 hour")
+		}
+		if c.Database.BackupPath == "" {
+			return fmt.Errorf("database backup_path cannot be empty when backups are enabled")
+		}
+	}
+
+	if c.Database.BackupKeep < 0 {
+		return fmt.Errorf("database backup_keep cannot be negative")
+	}
+
 	if c.Database.MaxIdleConns < 0 {
 		return fmt.Errorf("database max_idle_conns cannot be negative")
 	}
@@ -364,6 +584,19 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("Exim binary not found: %s", c.Exim.BinaryPath)
 	}
 
+	if c.Exim.QueueSnapshotInterval < 0 {
+		return fmt.Errorf("Exim queue_snapshot_interval cannot be negative")
+	}
+
+	// Alert rules and notifiers are checked in detail when alerting starts
+	if c.Alerting.EvaluationInterval < 0 {
+		return fmt.Errorf("alerting evaluation_interval cannot be negative")
+	}
+
+	if c.Metrics.Enabled && (!strings.HasPrefix(c.Metrics.Path, "/") || strings.HasPrefix(c.Metrics.Path, "/api/") || c.Metrics.Path == "/ws") {
+		return fmt.Errorf("metrics path must start with / and not clash with /api/ or /ws: %q", c.Metrics.Path)
+	}
+
 	// Validate logging configuration
 	validLogLevels := map[string]bool{
 		"debug": true, "info": true, "warn": true, "error": true, "fatal": true,
@@ -402,6 +635,25 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("minimum password length must be at least 4")
 	}
 
+	for _, backend := range c.Auth.Backends {
+		switch backend {
+		case "local":
+		case "ldap":
+			if c.Auth.LDAP.URL == "" {
+				return fmt.Errorf("auth backend ldap requires auth.ldap.url")
+			}
+			if c.Auth.LDAP.UserDNTemplate == "" && c.Auth.LDAP.UserBaseDN == "" {
+				return fmt.Errorf("auth backend ldap requires user_dn_template or user_base_dn")
+			}
+		default:
+			return fmt.Errorf("unknown auth backend: %s", backend)
+		}
+	}
+
+	if c.Auth.TrustedHeader.Enabled && len(c.Security.TrustedProxies) == 0 {
+		return fmt.Errorf("trusted header authentication requires security.trusted_proxies")
+	}
+
 	// Generate session secret if not provided
 	if c.Auth.SessionSecret == "" {
 		c.Auth.SessionSecret = generateSessionSecret()
@@ -416,6 +668,16 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("max login attempts must be at least 1")
 	}
 
+	switch c.Security.CookieSameSite {
+	case "", "strict", "lax":
+	case "none":
+		if !c.Security.SecureCookies {
+			return fmt.Errorf("cookie_same_site none requires secure_cookies")
+		}
+	default:
+		return fmt.Errorf("cookie_same_site must be strict, lax or none")
+	}
+
 	return nil
 }
 
@@ -0,0 +1,275 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const alertRuleColumns = `id, name, description, metric, event, window_seconds, operator, threshold, for_seconds,
+	cooldown_seconds, repeat_interval_seconds, severity, notifiers, send_resolved, enabled, created_by, created_at, updated_at`
+
+const alertEventColumns = `id, rule_name, metric, severity, status, value, threshold, operator, message,
+	fired_at, resolved_at, last_notified_at, notifications, last_error`
+
+// AlertRuleRepository handles alert rules created through the API
+type AlertRuleRepository struct {
+	*Repository
+}
+
+// NewAlertRuleRepository creates a new alert rule repository
+func NewAlertRuleRepository(db *DB) *AlertRuleRepository {
+	return &AlertRuleRepository{Repository: NewRepository(db)}
+}
+
+// Create inserts a new alert rule
+func (r *AlertRuleRepository) Create(rule *AlertRule) error {
+	query := `
+		INSERT INTO alert_rules (name, description, metric, event, window_seconds, operator, threshold, for_seconds,
+			cooldown_seconds, repeat_interval_seconds, severity, notifiers, send_resolved, enabled, created_by, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	notifiers, err := json.Marshal(rule.Notifiers)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	rule.CreatedAt = &now
+	rule.UpdatedAt = &now
+	rule.Source = AlertRuleSourceAPI
+
+	result, err := r.db.Exec(query, rule.Name, rule.Description, rule.Metric, rule.Event, rule.Window, rule.Operator,
+		rule.Threshold, rule.For, rule.Cooldown, rule.RepeatInterval, rule.Severity, string(notifiers),
+		rule.SendResolved, rule.Enabled, rule.CreatedBy, now, now)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	rule.ID = id
+	return nil
+}
+
+// Update replaces an alert rule, found by name. Returns sql.ErrNoRows if there is none.
+func (r *AlertRuleRepository) Update(rule *AlertRule) error {
+	query := `
+		UPDATE alert_rules SET description = ?, metric = ?, event = ?, window_seconds = ?, operator = ?, threshold = ?,
+			for_seconds = ?, cooldown_seconds = ?, repeat_interval_seconds = ?, severity = ?, notifiers = ?,
+			send_resolved = ?, enabled = ?, updated_at = ?
+		WHERE name = ?`
+
+	notifiers, err := json.Marshal(rule.Notifiers)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	result, err := r.db.Exec(query, rule.Description, rule.Metric, rule.Event, rule.Window, rule.Operator, rule.Threshold,
+		rule.For, rule.Cooldown, rule.RepeatInterval, rule.Severity, string(notifiers),
+		rule.SendResolved, rule.Enabled, now, rule.Name)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	rule.UpdatedAt = &now
+	rule.Source = AlertRuleSourceAPI
+	return nil
+}
+
+// Delete removes an alert rule by name. Returns sql.ErrNoRows if there is none.
+func (r *AlertRuleRepository) Delete(name string) error {
+	result, err := r.db.Exec("DELETE FROM alert_rules WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetByName retrieves an alert rule by name, or nil if there is none
+func (r *AlertRuleRepository) GetByName(name string) (*AlertRule, error) {
+	rows, err := r.db.Query("SELECT "+alertRuleColumns+" FROM alert_rules WHERE name = ?", name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules, err := scanAlertRules(rows)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+
+	return &rules[0], nil
+}
+
+// List retrieves all alert rules ordered by name
+func (r *AlertRuleRepository) List() ([]AlertRule, error) {
+	rows, err := r.db.Query("SELECT " + alertRuleColumns + " FROM alert_rules ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAlertRules(rows)
+}
+
+func scanAlertRules(rows *sql.Rows) ([]AlertRule, error) {
+	var rules []AlertRule
+	for rows.Next() {
+		var rule AlertRule
+		var description, event, notifiers sql.NullString
+		var createdAt, updatedAt time.Time
+		err := rows.Scan(&rule.ID, &rule.Name, &description, &rule.Metric, &event, &rule.Window, &rule.Operator,
+			&rule.Threshold, &rule.For, &rule.Cooldown, &rule.RepeatInterval, &rule.Severity, &notifiers,
+			&rule.SendResolved, &rule.Enabled, &rule.CreatedBy, &createdAt, &updatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		rule.Description = description.String
+		rule.Event = event.String
+		if notifiers.Valid && notifiers.String != "" {
+			if err := json.Unmarshal([]byte(notifiers.String), &rule.Notifiers); err != nil {
+				return nil, err
+			}
+		}
+		rule.Source = AlertRuleSourceAPI
+		rule.CreatedAt = &createdAt
+		rule.UpdatedAt = &updatedAt
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// AlertHistoryRepository handles the stored history of fired alerts
+type AlertHistoryRepository struct {
+	*Repository
+}
+
+// NewAlertHistoryRepository creates a new alert history repository
+func NewAlertHistoryRepository(db *DB) *AlertHistoryRepository {
+	return &AlertHistoryRepository{Repository: NewRepository(db)}
+}
+
+// Create inserts a new alert
+func (r *AlertHistoryRepository) Create(event *AlertEvent) error {
+	query := `
+		INSERT INTO alert_history (rule_name, metric, severity, status, value, threshold, operator, message,
+			fired_at, resolved_at, last_notified_at, notifications, last_error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := r.db.Exec(query, event.RuleName, event.Metric, event.Severity, event.Status, event.Value,
+		event.Threshold, event.Operator, event.Message, event.FiredAt, event.ResolvedAt, event.LastNotifiedAt,
+		event.Notifications, event.LastError)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	event.ID = id
+	return nil
+}
+
+// Update stores the status, latest value and notification state of an alert
+func (r *AlertHistoryRepository) Update(event *AlertEvent) error {
+	query := `
+		UPDATE alert_history SET status = ?, value = ?, message = ?, resolved_at = ?, last_notified_at = ?,
+			notifications = ?, last_error = ?
+		WHERE id = ?`
+
+	_, err := r.db.Exec(query, event.Status, event.Value, event.Message, event.ResolvedAt, event.LastNotifiedAt,
+		event.Notifications, event.LastError, event.ID)
+	return err
+}
+
+// ListFiring retrieves the alerts that have not resolved, oldest first
+func (r *AlertHistoryRepository) ListFiring() ([]AlertEvent, error) {
+	rows, err := r.db.Query("SELECT "+alertEventColumns+" FROM alert_history WHERE status = ? ORDER BY fired_at", AlertStatusFiring)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAlertEvents(rows)
+}
+
+// List retrieves alerts, newest first, optionally limited to one rule and status
+func (r *AlertHistoryRepository) List(limit, offset int, ruleName, status string, since *time.Time) ([]AlertEvent, error) {
+	query := "SELECT " + alertEventColumns + " FROM alert_history"
+
+	var conditions []string
+	var args []interface{}
+
+	if ruleName != "" {
+		conditions = append(conditions, "rule_name = ?")
+		args = append(args, ruleName)
+	}
+
+	if status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, status)
+	}
+
+	if since != nil {
+		conditions = append(conditions, "fired_at >= ?")
+		args = append(args, *since)
+	}
+
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query += " ORDER BY fired_at DESC, id DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAlertEvents(rows)
+}
+
+func scanAlertEvents(rows *sql.Rows) ([]AlertEvent, error) {
+	var events []AlertEvent
+	for rows.Next() {
+		var event AlertEvent
+		err := rows.Scan(&event.ID, &event.RuleName, &event.Metric, &event.Severity, &event.Status, &event.Value,
+			&event.Threshold, &event.Operator, &event.Message, &event.FiredAt, &event.ResolvedAt,
+			&event.LastNotifiedAt, &event.Notifications, &event.LastError)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
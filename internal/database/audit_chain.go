@@ -0,0 +1,74 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// AuditEntryHash computes the chain hash of an audit log entry. The hash covers the
+// entry ID, its content and the hash of the preceding entry, so editing, deleting or
+// reordering rows breaks the chain. Fields are length-prefixed and NULL is distinguished
+// from an empty string.
+func AuditEntryHash(entry *AuditLog, prevHash string) string {
+	h := sha256.New()
+	write := func(value *string) {
+		if value == nil {
+			h.Write([]byte("-1:"))
+			return
+		}
+		h.Write([]byte(strconv.Itoa(len(*value)) + ":" + *value))
+	}
+	str := func(value string) *string { return &value }
+
+	write(str(strconv.FormatInt(entry.ID, 10)))
+	write(str(prevHash))
+	write(str(entry.Timestamp.UTC().Format(time.RFC3339Nano)))
+	write(str(entry.Action))
+	write(entry.MessageID)
+	write(entry.UserID)
+	write(entry.Details)
+	write(entry.IPAddress)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// insertAuditLog appends an entry to the hash chain. It must run inside a transaction.
+// The row is inserted before the predecessor is read so the write lock is already held
+// and no concurrent writer can append in between.
+func insertAuditLog(tx *sql.Tx, entry *AuditLog) error {
+	result, err := tx.Exec(`
+		INSERT INTO audit_log (timestamp, action, message_id, user_id, details, ip_address, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		entry.Timestamp, entry.Action, entry.MessageID, entry.UserID, entry.Details, entry.IPAddress, entry.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	entry.ID = id
+
+	var prev sql.NullString
+	err = tx.QueryRow("SELECT entry_hash FROM audit_log WHERE id < ? ORDER BY id DESC LIMIT 1", id).Scan(&prev)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read previous audit entry: %w", err)
+	}
+
+	// The first entry, and the first one after unsealed legacy entries, starts the chain
+	prevHash := prev.String
+	entryHash := AuditEntryHash(entry, prevHash)
+
+	if _, err := tx.Exec("UPDATE audit_log SET prev_hash = ?, entry_hash = ? WHERE id = ?", prevHash, entryHash, id); err != nil {
+		return fmt.Errorf("failed to seal audit entry: %w", err)
+	}
+
+	entry.PrevHash = &prevHash
+	entry.EntryHash = &entryHash
+	return nil
+}
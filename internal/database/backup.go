@@ -0,0 +1,329 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backupPrefix     = "exim-pilot-"
+	backupSuffix     = ".db"
+	backupTimeFormat = "20060102T150405Z"
+
+	// DefaultBackupKeep is the number of backups kept when none is configured
+	DefaultBackupKeep = 7
+)
+
+// ErrBackupNotFound is returned when a named backup does not exist in the backup directory
+var ErrBackupNotFound = errors.New("backup not found")
+
+// BackupInfo describes a backup file
+type BackupInfo struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BackupService copies the live database into a backup directory with VACUUM INTO,
+// which takes a consistent snapshot without stopping writers, and keeps the newest
+// copies
+type BackupService struct {
+	db   *DB
+	dir  string
+	keep int
+
+	// Serializes backups so a scheduled and a manual backup do not overlap
+	mu sync.Mutex
+}
+
+// NewBackupService creates a backup service writing to dir and keeping the newest keep
+// backups
+func NewBackupService(db *DB, dir string, keep int) *BackupService {
+	if keep <= 0 {
+		keep = DefaultBackupKeep
+	}
+	return &BackupService{db: db, dir: dir, keep: keep}
+}
+
+// Dir returns the backup directory
+func (s *BackupService) Dir() string {
+	return s.dir
+}
+
+// Backup writes a new backup, verifies it and removes backups beyond the retention
+// count. A backup that fails verification is deleted.
+func (s *BackupService) Backup(ctx context.Context) (*BackupInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	created := time.Now().UTC()
+	name := backupPrefix + created.Format(backupTimeFormat) + backupSuffix
+	path := filepath.Join(s.dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("backup %s already exists", name)
+	}
+
+	// Written under a temporary name so a partial file is never listed as a backup
+	tmpPath := path + ".tmp"
+	os.Remove(tmpPath)
+	if _, err := s.db.ExecContext(ctx, "VACUUM INTO ?", tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	if err := VerifyBackup(ctx, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to set backup permissions: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to store backup: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	if err := s.prune(); err != nil {
+		log.Printf("Failed to remove old backups: %v", err)
+	}
+
+	return &BackupInfo{Name: name, Path: path, Size: info.Size(), CreatedAt: created}, nil
+}
+
+// List returns the backups in the backup directory, newest first
+func (s *BackupService) List() ([]BackupInfo, error) {
+	return ListBackups(s.dir)
+}
+
+// Find returns the backup with the given file name
+func (s *BackupService) Find(name string) (*BackupInfo, error) {
+	backups, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range backups {
+		if backups[i].Name == name {
+			return &backups[i], nil
+		}
+	}
+	return nil, ErrBackupNotFound
+}
+
+// prune removes the oldest backups beyond the retention count. The caller must hold s.mu.
+func (s *BackupService) prune() error {
+	backups, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	for _, backup := range backups[min(len(backups), s.keep):] {
+		if err := os.Remove(backup.Path); err != nil {
+			return err
+		}
+		log.Printf("Removed old backup %s", backup.Name)
+	}
+	return nil
+}
+
+// ScheduleBackups backs up every interval until ctx is done. A backup is taken at once
+// when the newest one is older than the interval, so restarts do not delay backups.
+func (s *BackupService) ScheduleBackups(ctx context.Context, interval time.Duration) {
+	log.Printf("Starting database backup scheduler (interval: %s, keeping %d)", interval, s.keep)
+
+	runBackup := func() {
+		backup, err := s.Backup(ctx)
+		if err != nil {
+			log.Printf("Scheduled database backup failed: %v", err)
+			return
+		}
+		log.Printf("Database backed up to %s (%d bytes)", backup.Path, backup.Size)
+	}
+
+	backups, err := s.List()
+	if err != nil || len(backups) == 0 || time.Since(backups[0].CreatedAt) >= interval {
+		runBackup()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Database backup scheduler stopped")
+			return
+		case <-ticker.C:
+			runBackup()
+		}
+	}
+}
+
+// ListBackups returns the backups in dir, newest first. A missing directory has none.
+func ListBackups(dir string) ([]BackupInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var backups []BackupInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+		created, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupInfo{
+			Name:      name,
+			Path:      filepath.Join(dir, name),
+			Size:      info.Size(),
+			CreatedAt: created,
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+// VerifyBackup opens a database file read-only and runs SQLite's integrity check on it
+func VerifyBackup(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	sqlDB, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer sqlDB.Close()
+
+	rows, err := sqlDB.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("backup integrity check failed: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return fmt.Errorf("backup integrity check failed: %w", err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("backup integrity check failed: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("backup integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// RestoreBackup replaces the database at dbPath with a verified copy of backupPath. Exim
+// Pilot must be stopped first. The current database, if any, is kept as a consistent
+// copy at dbPath + ".pre-restore", which is returned.
+func RestoreBackup(ctx context.Context, backupPath, dbPath string) (string, error) {
+	if err := VerifyBackup(ctx, backupPath); err != nil {
+		return "", err
+	}
+
+	preRestorePath := ""
+	if _, err := os.Stat(dbPath); err == nil {
+		preRestorePath = dbPath + ".pre-restore"
+		if err := snapshotDatabase(ctx, dbPath, preRestorePath); err != nil {
+			return "", fmt.Errorf("failed to keep the current database: %w", err)
+		}
+	}
+
+	tmpPath := dbPath + ".restore"
+	if err := copyFile(backupPath, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to copy backup: %w", err)
+	}
+
+	// The write-ahead log of the old database must not be applied to the restored one
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmpPath)
+			return "", fmt.Errorf("failed to remove %s: %w", dbPath+suffix, err)
+		}
+	}
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to replace database: %w", err)
+	}
+
+	return preRestorePath, nil
+}
+
+// snapshotDatabase writes a consistent copy of the database at path, including changes
+// still in its write-ahead log, to target
+func snapshotDatabase(ctx context.Context, path, target string) error {
+	sqlDB, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	os.Remove(target)
+	_, err = sqlDB.ExecContext(ctx, "VACUUM INTO ?", target)
+	return err
+}
+
+// copyFile copies src to dst and syncs it to disk
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func countRows(t *testing.T, db *DB) int {
+	t.Helper()
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM backup_test").Scan(&count); err != nil {
+		t.Fatalf("Failed to count rows: %v", err)
+	}
+	return count
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "exim-pilot.db")
+	backupDir := filepath.Join(dir, "backups")
+
+	db, err := Connect(&Config{Path: dbPath, MaxOpenConns: 1, MaxIdleConns: 1, ConnMaxLifetime: time.Minute})
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE backup_test (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO backup_test (id) VALUES (1), (2)"); err != nil {
+		t.Fatalf("Failed to insert rows: %v", err)
+	}
+
+	// Older backups beyond the retention count are removed after the next backup
+	if err := os.MkdirAll(backupDir, 0750); err != nil {
+		t.Fatalf("Failed to create backup directory: %v", err)
+	}
+	for _, name := range []string{"exim-pilot-20240101T000000Z.db", "exim-pilot-20240102T000000Z.db", "unrelated.db"} {
+		if err := os.WriteFile(filepath.Join(backupDir, name), nil, 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	service := NewBackupService(db, backupDir, 2)
+	backup, err := service.Backup(ctx)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	backups, err := service.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(backups) != 2 || backups[0].Name != backup.Name || backups[1].Name != "exim-pilot-20240102T000000Z.db" {
+		t.Fatalf("Unexpected backups after pruning: %+v", backups)
+	}
+	if _, err := os.Stat(filepath.Join(backupDir, "unrelated.db")); err != nil {
+		t.Errorf("Expected files that are not backups to be left alone: %v", err)
+	}
+	if found, err := service.Find(backup.Name); err != nil || found.Size != backup.Size {
+		t.Errorf("Find returned %+v, %v", found, err)
+	}
+	if _, err := service.Find("exim-pilot-20240101T000000Z.db"); err != ErrBackupNotFound {
+		t.Errorf("Expected ErrBackupNotFound for a pruned backup, got %v", err)
+	}
+
+	// Changes after the backup are undone by the restore and kept in the pre-restore copy
+	if _, err := db.Exec("INSERT INTO backup_test (id) VALUES (3)"); err != nil {
+		t.Fatalf("Failed to insert row: %v", err)
+	}
+	db.Close()
+
+	preRestore, err := RestoreBackup(ctx, backup.Path, dbPath)
+	if err != nil {
+		t.Fatalf("RestoreBackup failed: %v", err)
+	}
+
+	restored, err := Connect(&Config{Path: dbPath, MaxOpenConns: 1, MaxIdleConns: 1, ConnMaxLifetime: time.Minute})
+	if err != nil {
+		t.Fatalf("Failed to open restored database: %v", err)
+	}
+	defer restored.Close()
+	if count := countRows(t, restored); count != 2 {
+		t.Errorf("Expected 2 rows after restore, got %d", count)
+	}
+
+	previous, err := Connect(&Config{Path: preRestore, MaxOpenConns: 1, MaxIdleConns: 1, ConnMaxLifetime: time.Minute})
+	if err != nil {
+		t.Fatalf("Failed to open pre-restore copy: %v", err)
+	}
+	defer previous.Close()
+	if count := countRows(t, previous); count != 3 {
+		t.Errorf("Expected 3 rows in the pre-restore copy, got %d", count)
+	}
+}
+
+func TestVerifyBackupRejectsCorruptFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exim-pilot-20240101T000000Z.db")
+	if err := os.WriteFile(path, []byte("this is not a database"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if err := VerifyBackup(context.Background(), path); err == nil {
+		t.Error("Expected a corrupt backup to fail verification")
+	}
+	if _, err := RestoreBackup(context.Background(), path, filepath.Join(t.TempDir(), "exim-pilot.db")); err == nil {
+		t.Error("Expected restoring a corrupt backup to fail")
+	}
+}
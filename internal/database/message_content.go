@@ -0,0 +1,451 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// MessageSource provides the raw RFC 822 form of a queued message
+type MessageSource interface {
+	// ReadMessageSource returns up to limit bytes of the message body together with its
+	// headers, the full message size and whether the body was truncated
+	ReadMessageSource(messageID string, limit int64) ([]byte, int64, bool, error)
+}
+
+// ContentPreviewOptions controls how message content previews are built
+type ContentPreviewOptions struct {
+	MaxMessageBytes  int64 // bytes of the message read from the spool
+	MaxPreviewBytes  int   // bytes of decoded text returned per text part
+	MaxMIMEDepth     int   // maximum nesting of multipart bodies
+	ContentRedaction bool  // mask addresses and card numbers, suppress attachment previews
+}
+
+// DefaultContentPreviewOptions returns the default content preview limits
+func DefaultContentPreviewOptions() ContentPreviewOptions {
+	return ContentPreviewOptions{
+		MaxMessageBytes:  10 * 1024 * 1024,
+		MaxPreviewBytes:  64 * 1024,
+		MaxMIMEDepth:     10,
+		ContentRedaction: true,
+	}
+}
+
+// maxAttachmentPreview limits the text preview of a text attachment
+const maxAttachmentPreview = 512
+
+// attachmentSafeTypes lists attachment content types that are never active content
+var attachmentSafeTypes = map[string]bool{
+	"text/plain":      true,
+	"text/csv":        true,
+	"application/pdf": true,
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"message/rfc822":  true,
+}
+
+// attachmentDangerousExtensions lists filename extensions that are flagged as unsafe
+var attachmentDangerousExtensions = []string{
+	".exe", ".scr", ".bat", ".cmd", ".com", ".pif", ".js", ".jse", ".vbs", ".vbe",
+	".wsf", ".hta", ".msi", ".jar", ".ps1", ".lnk", ".iso", ".docm", ".xlsm", ".html", ".htm",
+}
+
+// redactedHeaders lists the address headers masked when content redaction is enabled
+var redactedHeaders = []string{"From", "To", "Cc", "Bcc", "Reply-To", "Sender", "Return-Path", "Delivered-To"}
+
+// ParseMessageContent parses a raw RFC 822 message into a safe content preview.
+// size is the full size of the message and truncated reports whether raw was cut short.
+func ParseMessageContent(messageID string, raw []byte, size int64, truncated bool, opts ContentPreviewOptions) (*MessageContent, error) {
+	msg, err := mail.ReadMessage(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message: %w", err)
+	}
+
+	content := &MessageContent{
+		MessageID:      messageID,
+		Headers:        make(map[string]string),
+		Attachments:    []MessageAttachment{},
+		ContentSafe:    true,
+		SizeBytes:      size,
+		PreviewLimited: truncated,
+	}
+
+	decoder := &mime.WordDecoder{CharsetReader: charsetReader}
+	for name, values := range msg.Header {
+		decoded := make([]string, 0, len(values))
+		for _, value := range values {
+			if text, err := decoder.DecodeHeader(value); err == nil {
+				value = text
+			}
+			decoded = append(decoded, value)
+		}
+		content.Headers[name] = strings.Join(decoded, "\n")
+	}
+
+	parser := &mimeParser{content: content, opts: opts, decoder: decoder}
+	if err := parser.walk(textproto.MIMEHeader(msg.Header), msg.Body, 0); err != nil {
+		// A truncated body routinely ends in the middle of a MIME part
+		if !truncated {
+			return nil, err
+		}
+	}
+
+	if opts.ContentRedaction {
+		for _, name := range redactedHeaders {
+			if value, ok := content.Headers[name]; ok {
+				content.Headers[name] = RedactContent(value)
+			}
+		}
+		if content.TextContent != nil {
+			redacted := RedactContent(*content.TextContent)
+			content.TextContent = &redacted
+		}
+		if content.HTMLContent != nil {
+			redacted := RedactContent(*content.HTMLContent)
+			content.HTMLContent = &redacted
+		}
+	}
+
+	return content, nil
+}
+
+// mimeParser walks the MIME tree of a message, filling in a MessageContent
+type mimeParser struct {
+	content *MessageContent
+	opts    ContentPreviewOptions
+	decoder *mime.WordDecoder
+}
+
+// walk processes a single MIME entity and recurses into multipart bodies
+func (p *mimeParser) walk(header textproto.MIMEHeader, body io.Reader, depth int) error {
+	if p.opts.MaxMIMEDepth > 0 && depth > p.opts.MaxMIMEDepth {
+		p.content.PreviewLimited = true
+		return nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+		params = map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		boundary := params["boundary"]
+		if boundary == "" {
+			return fmt.Errorf("multipart entity without boundary")
+		}
+
+		reader := multipart.NewReader(body, boundary)
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read MIME part: %w", err)
+			}
+
+			err = p.walk(part.Header, part, depth+1)
+			part.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("failed to decode MIME part: %w", err)
+	}
+
+	disposition, dispParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if decoded, err := p.decoder.DecodeHeader(filename); err == nil {
+		filename = decoded
+	}
+
+	isAttachment := disposition == "attachment" || filename != ""
+	if !isAttachment && mediaType == "text/plain" && p.content.TextContent == nil {
+		text := p.limitText(toUTF8(data, params["charset"]))
+		p.content.TextContent = &text
+		return nil
+	}
+	if !isAttachment && mediaType == "text/html" && p.content.HTMLContent == nil {
+		sanitized, changed := SanitizeHTML(toUTF8(data, params["charset"]))
+		if changed {
+			p.content.ContentSafe = false
+		}
+		sanitized = p.limitText(sanitized)
+		p.content.HTMLContent = &sanitized
+		return nil
+	}
+
+	p.addAttachment(filename, mediaType, params["charset"], data)
+	return nil
+}
+
+// addAttachment records metadata for a non-inline MIME part
+func (p *mimeParser) addAttachment(filename, mediaType, charset string, data []byte) {
+	sum := sha256.Sum256(data)
+	attachment := MessageAttachment{
+		Filename:    filename,
+		ContentType: mediaType,
+		Size:        int64(len(data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		IsSafe:      isSafeAttachment(filename, mediaType),
+	}
+
+	if !attachment.IsSafe {
+		p.content.ContentSafe = false
+	}
+
+	if attachment.IsSafe && !p.opts.ContentRedaction && strings.HasPrefix(mediaType, "text/") {
+		preview := toUTF8(data, charset)
+		if len(preview) > maxAttachmentPreview {
+			preview = truncateUTF8(preview, maxAttachmentPreview)
+		}
+		attachment.Preview = &preview
+	}
+
+	p.content.Attachments = append(p.content.Attachments, attachment)
+}
+
+// limitText enforces the preview size limit on decoded text
+func (p *mimeParser) limitText(text string) string {
+	if p.opts.MaxPreviewBytes > 0 && len(text) > p.opts.MaxPreviewBytes {
+		p.content.PreviewLimited = true
+		return truncateUTF8(text, p.opts.MaxPreviewBytes)
+	}
+	return text
+}
+
+// decodeTransferEncoding wraps a reader with the decoder for a Content-Transfer-Encoding
+func decodeTransferEncoding(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &base64Cleaner{r: body})
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// base64Cleaner strips line breaks and whitespace that the base64 decoder rejects
+type base64Cleaner struct {
+	r io.Reader
+}
+
+// Read implements io.Reader
+func (c *base64Cleaner) Read(buf []byte) (int, error) {
+	n, err := c.r.Read(buf)
+	kept := 0
+	for _, b := range buf[:n] {
+		if b == '\r' || b == '\n' || b == ' ' || b == '\t' {
+			continue
+		}
+		buf[kept] = b
+		kept++
+	}
+	return kept, err
+}
+
+// charsetReader converts the charsets Go cannot decode natively for encoded-words
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(toUTF8(data, charset)), nil
+}
+
+// toUTF8 converts decoded part data to UTF-8, handling the common single-byte charsets
+func toUTF8(data []byte, charset string) string {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "iso-8859-1", "latin1", "windows-1252", "cp1252":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	}
+
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	return strings.ToValidUTF8(string(data), "�")
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a multi-byte character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// isSafeAttachment reports whether an attachment is a passive, well-known type
+func isSafeAttachment(filename, mediaType string) bool {
+	lower := strings.ToLower(filename)
+	for _, ext := range attachmentDangerousExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return false
+		}
+	}
+	return attachmentSafeTypes[mediaType]
+}
+
+// redactEmailRegex matches email addresses in message text
+var redactEmailRegex = regexp.MustCompile(`([A-Za-z0-9._%+-])[A-Za-z0-9._%+-]*@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+
+// redactCardRegex matches runs of 13-19 digits that look like payment card numbers
+var redactCardRegex = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+
+// RedactContent masks email addresses and card-like numbers in preview text
+func RedactContent(text string) string {
+	text = redactEmailRegex.ReplaceAllString(text, "$1***@$2")
+	return redactCardRegex.ReplaceAllString(text, "[REDACTED]")
+}
+
+// htmlDropElements lists elements that are removed together with their content
+var htmlDropElements = map[string]bool{
+	"script": true, "style": true, "head": true, "title": true, "iframe": true,
+	"object": true, "embed": true, "applet": true, "frameset": true, "frame": true,
+	"noscript": true, "template": true, "svg": true, "math": true, "form": true,
+}
+
+// htmlAllowedElements lists elements that are kept, mapped to their allowed attributes
+var htmlAllowedElements = map[string]map[string]bool{
+	"a": {"href": true, "title": true}, "abbr": {"title": true}, "b": {}, "blockquote": {},
+	"br": {}, "caption": {}, "center": {}, "code": {}, "dd": {}, "div": {}, "dl": {}, "dt": {},
+	"em": {}, "font": {"color": true, "size": true}, "h1": {}, "h2": {}, "h3": {}, "h4": {},
+	"h5": {}, "h6": {}, "hr": {}, "i": {}, "img": {"alt": true, "title": true, "width": true, "height": true},
+	"li": {}, "ol": {}, "p": {}, "pre": {}, "s": {}, "small": {}, "span": {}, "strong": {},
+	"sub": {}, "sup": {}, "table": {"border": true, "cellpadding": true, "cellspacing": true},
+	"tbody": {}, "td": {"colspan": true, "rowspan": true, "align": true}, "tfoot": {},
+	"th": {"colspan": true, "rowspan": true, "align": true}, "thead": {}, "tr": {}, "u": {}, "ul": {},
+}
+
+// htmlVoidElements lists elements that never have a closing tag
+var htmlVoidElements = map[string]bool{"br": true, "hr": true, "img": true}
+
+// htmlTagRegex matches an HTML tag, comment or declaration
+var htmlTagRegex = regexp.MustCompile(`(?s)<!--.*?-->|<![^>]*>|<\?[^>]*>|</?([A-Za-z][A-Za-z0-9]*)((?:[^>"']|"[^"]*"|'[^']*')*)>`)
+
+// htmlAttrRegex matches a single attribute inside a tag
+var htmlAttrRegex = regexp.MustCompile(`([A-Za-z_:][-A-Za-z0-9_:.]*)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+)))?`)
+
+// SanitizeHTML reduces HTML to an allowlist of passive elements and attributes. Scripts,
+// event handlers, styles, forms and remote images are removed. It reports whether anything
+// had to be removed.
+func SanitizeHTML(input string) (string, bool) {
+	var out strings.Builder
+	changed := false
+	dropping := ""
+	last := 0
+
+	for _, loc := range htmlTagRegex.FindAllStringSubmatchIndex(input, -1) {
+		if dropping == "" {
+			out.WriteString(escapeHTMLText(input[last:loc[0]]))
+		}
+		last = loc[1]
+
+		tag := input[loc[0]:loc[1]]
+		if loc[2] < 0 {
+			// Comments, doctypes and processing instructions are dropped silently
+			continue
+		}
+
+		name := strings.ToLower(input[loc[2]:loc[3]])
+		closing := strings.HasPrefix(tag, "</")
+
+		if dropping != "" {
+			if closing && name == dropping {
+				dropping = ""
+			}
+			continue
+		}
+
+		if htmlDropElements[name] {
+			changed = true
+			if !closing && !strings.HasSuffix(tag, "/>") {
+				dropping = name
+			}
+			continue
+		}
+
+		allowedAttrs, ok := htmlAllowedElements[name]
+		if !ok {
+			// Unknown elements are unwrapped but their text is kept
+			if name != "html" && name != "body" {
+				changed = true
+			}
+			continue
+		}
+
+		if closing {
+			if !htmlVoidElements[name] {
+				out.WriteString("</" + name + ">")
+			}
+			continue
+		}
+
+		out.WriteString("<" + name)
+		for _, attr := range htmlAttrRegex.FindAllStringSubmatch(input[loc[4]:loc[5]], -1) {
+			attrName := strings.ToLower(attr[1])
+			value := html.UnescapeString(attr[2] + attr[3] + attr[4])
+
+			if !allowedAttrs[attrName] {
+				changed = true
+				continue
+			}
+			if attrName == "href" && !isSafeURL(value) {
+				changed = true
+				continue
+			}
+			out.WriteString(" " + attrName + `="` + html.EscapeString(value) + `"`)
+		}
+		if name == "a" {
+			out.WriteString(` rel="noopener noreferrer nofollow" target="_blank"`)
+		}
+		out.WriteString(">")
+	}
+
+	if dropping == "" {
+		out.WriteString(escapeHTMLText(input[last:]))
+	}
+
+	return out.String(), changed
+}
+
+// escapeHTMLText escapes stray markup characters in text between tags
+func escapeHTMLText(text string) string {
+	return strings.NewReplacer("<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// isSafeURL reports whether a link target uses a passive scheme
+func isSafeURL(value string) bool {
+	lower := strings.ToLower(strings.TrimSpace(value))
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") ||
+		strings.HasPrefix(lower, "mailto:") || strings.HasPrefix(lower, "#")
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+const testMultipartMessage = "From: =?UTF-8?B?SsO8cmdlbg==?= <juergen@example.com>\n" +
+	"To: ops@example.org\n" +
+	"Subject: Invoice\n" +
+	"MIME-Version: 1.0\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\n" +
+	"\n" +
+	"--outer\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\n" +
+	"\n" +
+	"--inner\n" +
+	"Content-Type: text/plain; charset=utf-8\n" +
+	"Content-Transfer-Encoding: quoted-printable\n" +
+	"\n" +
+	"Hello, contact billing@example.com or pay with 4111 1111 1111 1111=2E\n" +
+	"--inner\n" +
+	"Content-Type: text/html; charset=utf-8\n" +
+	"\n" +
+	"<html><body><p onclick=\"evil()\">Hi</p><script>alert(1)</script><a href=\"javascript:x\">x</a><img src=\"http://track/p.gif\"></body></html>\n" +
+	"--inner--\n" +
+	"--outer\n" +
+	"Content-Type: application/octet-stream; name=\"run.exe\"\n" +
+	"Content-Disposition: attachment; filename=\"run.exe\"\n" +
+	"Content-Transfer-Encoding: base64\n" +
+	"\n" +
+	"TVqQAAMAAAAEAAAA\n" +
+	"--outer--\n"
+
+func TestParseMessageContent(t *testing.T) {
+	opts := DefaultContentPreviewOptions()
+	opts.ContentRedaction = false
+
+	content, err := ParseMessageContent("1rABCD-123456-78", []byte(testMultipartMessage), int64(len(testMultipartMessage)), false, opts)
+	if err != nil {
+		t.Fatalf("ParseMessageContent failed: %v", err)
+	}
+
+	if content.Headers["From"] != "Jürgen <juergen@example.com>" {
+		t.Errorf("Expected decoded From header, got %q", content.Headers["From"])
+	}
+
+	if content.TextContent == nil || !strings.Contains(*content.TextContent, "billing@example.com") {
+		t.Fatalf("Expected decoded text part, got %v", content.TextContent)
+	}
+
+	if content.HTMLContent == nil {
+		t.Fatal("Expected HTML part")
+	}
+	for _, unsafe := range []string{"script", "onclick", "javascript:", "http://track"} {
+		if strings.Contains(*content.HTMLContent, unsafe) {
+			t.Errorf("Sanitized HTML still contains %q: %s", unsafe, *content.HTMLContent)
+		}
+	}
+
+	if len(content.Attachments) != 1 {
+		t.Fatalf("Expected 1 attachment, got %d", len(content.Attachments))
+	}
+	attachment := content.Attachments[0]
+	if attachment.Filename != "run.exe" || attachment.Size != 12 || attachment.IsSafe {
+		t.Errorf("Unexpected attachment metadata: %+v", attachment)
+	}
+	if len(attachment.SHA256) != 64 {
+		t.Errorf("Expected SHA-256 hex digest, got %q", attachment.SHA256)
+	}
+
+	if content.ContentSafe {
+		t.Error("Expected message with executable attachment to be marked unsafe")
+	}
+}
+
+func TestParseMessageContentRedactionAndLimit(t *testing.T) {
+	opts := DefaultContentPreviewOptions()
+	opts.MaxPreviewBytes = 40
+
+	content, err := ParseMessageContent("1rABCD-123456-78", []byte(testMultipartMessage), int64(len(testMultipartMessage)), false, opts)
+	if err != nil {
+		t.Fatalf("ParseMessageContent failed: %v", err)
+	}
+
+	if !content.PreviewLimited {
+		t.Error("Expected preview to be limited")
+	}
+	if len(*content.TextContent) > 40 {
+		t.Errorf("Expected text preview of at most 40 bytes, got %d", len(*content.TextContent))
+	}
+	if strings.Contains(*content.TextContent, "billing@") {
+		t.Errorf("Expected address to be redacted, got %q", *content.TextContent)
+	}
+	if content.Headers["From"] != "Jürgen <j***@example.com>" || content.Headers["To"] != "o***@example.org" {
+		t.Errorf("Expected address headers to be redacted, got %q and %q", content.Headers["From"], content.Headers["To"])
+	}
+	if content.Headers["Subject"] != "Invoice" {
+		t.Errorf("Expected other headers to be kept, got %q", content.Headers["Subject"])
+	}
+
+	opts.MaxPreviewBytes = 0
+	content, _ = ParseMessageContent("1rABCD-123456-78", []byte(testMultipartMessage), int64(len(testMultipartMessage)), false, opts)
+	if strings.Contains(*content.TextContent, "4111") {
+		t.Errorf("Expected card number to be redacted, got %q", *content.TextContent)
+	}
+}
+
+func TestSanitizeHTML(t *testing.T) {
+	input := `<div style="x"><a href="https://example.com" onmouseover="x()">ok</a><iframe src="x">bad</iframe>&amp;</div>`
+	output, changed := SanitizeHTML(input)
+
+	expected := `<div><a href="https://example.com" rel="noopener noreferrer nofollow" target="_blank">ok</a>&amp;</div>`
+	if output != expected {
+		t.Errorf("Expected %s, got %s", expected, output)
+	}
+	if !changed {
+		t.Error("Expected sanitizer to report removed content")
+	}
+}
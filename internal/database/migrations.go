@@ -362,6 +362,274 @@ UPDATE sessions SET updated_at = created_at WHERE updated_at IS NULL;
 -- Note: SQLite doesn't support DROP COLUMN, so we'd need to recreate tables
 -- For safety, we'll leave the columns in place during rollback
 -- This is a complex rollback that would require table recreation
+`,
+		},
+		{
+			Version:     7,
+			Description: "Assign roles to existing users",
+			Up: `
+-- Users created before role-based access control had full access; keep it that way
+UPDATE users SET role = 'admin' WHERE role IS NULL OR role = '' OR role = 'user';
+
+CREATE INDEX IF NOT EXISTS idx_users_role ON users(role);
+`,
+			Down: `
+DROP INDEX IF EXISTS idx_users_role;
+`,
+		},
+		{
+			Version:     8,
+			Description: "Add API tokens for automation clients",
+			Up: `
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    token_prefix TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    expires_at DATETIME NOT NULL,
+    last_used_at DATETIME,
+    last_used_ip TEXT,
+    revoked_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_expires_at ON api_tokens(expires_at);
+`,
+			Down: `
+DROP INDEX IF EXISTS idx_api_tokens_expires_at;
+DROP INDEX IF EXISTS idx_api_tokens_user_id;
+DROP TABLE IF EXISTS api_tokens;
+`,
+		},
+		{
+			Version:     9,
+			Description: "Add TOTP two-factor authentication",
+			Up: `
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id INTEGER PRIMARY KEY,
+    secret TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT 0,
+    last_used_step INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    enabled_at DATETIME,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_recovery_codes_user_id ON user_recovery_codes(user_id);
+`,
+			Down: `
+DROP INDEX IF EXISTS idx_user_recovery_codes_user_id;
+DROP TABLE IF EXISTS user_recovery_codes;
+DROP TABLE IF EXISTS user_totp;
+`,
+		},
+		{
+			Version:     10,
+			Description: "Hash-chain audit log entries",
+			Up: `
+-- Entries written before this migration stay unsealed and are reported as such
+ALTER TABLE audit_log ADD COLUMN prev_hash TEXT;
+ALTER TABLE audit_log ADD COLUMN entry_hash TEXT;
+
+-- The last entry written before the chain, so later unsealed entries count as tampering
+CREATE TABLE IF NOT EXISTS audit_chain_state (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    last_unsealed_id INTEGER NOT NULL DEFAULT 0
+);
+INSERT OR IGNORE INTO audit_chain_state (id, last_unsealed_id)
+SELECT 1, COALESCE(MAX(id), 0) FROM audit_log;
+`,
+			Down: `
+-- SQLite cannot drop columns without recreating the table, so the hash columns stay
+DROP TABLE IF EXISTS audit_chain_state;
+`,
+		},
+		{
+			Version:     11,
+			Description: "Add structured main log fields to log entries",
+			Up: `
+ALTER TABLE log_entries ADD COLUMN message_id_header TEXT;
+ALTER TABLE log_entries ADD COLUMN protocol TEXT;
+ALTER TABLE log_entries ADD COLUMN tls_cipher TEXT;
+ALTER TABLE log_entries ADD COLUMN tls_verify TEXT;
+ALTER TABLE log_entries ADD COLUMN tls_peer_dn TEXT;
+ALTER TABLE log_entries ADD COLUMN confirmation TEXT;
+ALTER TABLE log_entries ADD COLUMN queue_time REAL;
+ALTER TABLE log_entries ADD COLUMN delivery_time REAL;
+ALTER TABLE log_entries ADD COLUMN chunking BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE log_entries ADD COLUMN prdr BOOLEAN NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_log_entries_message_id_header ON log_entries(message_id_header);
+`,
+			Down: `
+DROP INDEX IF EXISTS idx_log_entries_message_id_header;
+`,
+		},
+		{
+			Version:     12,
+			Description: "Persist log file read offsets",
+			Up: `
+CREATE TABLE IF NOT EXISTS log_file_offsets (
+    path TEXT PRIMARY KEY,
+    fingerprint TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+`,
+			Down: `
+DROP TABLE IF EXISTS log_file_offsets;
+`,
+		},
+		{
+			Version:     13,
+			Description: "Record the source position of log entries",
+			Up: `
+-- Entries stored before this migration have no source and are never treated as duplicates
+ALTER TABLE log_entries ADD COLUMN source_fingerprint TEXT;
+ALTER TABLE log_entries ADD COLUMN source_offset INTEGER;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_log_entries_source ON log_entries(source_fingerprint, source_offset);
+`,
+			Down: `
+DROP INDEX IF EXISTS idx_log_entries_source;
+`,
+		},
+		{
+			Version:     14,
+			Description: "Add hourly and daily queue snapshot rollups",
+			Up: `
+-- Buckets are unix times in UTC. Sums are kept rather than averages so a bucket can be extended one snapshot at a time.
+CREATE TABLE IF NOT EXISTS queue_snapshot_rollups (
+    period TEXT NOT NULL CHECK (period IN ('hour', 'day')),
+    bucket_start INTEGER NOT NULL,
+    samples INTEGER NOT NULL DEFAULT 0,
+    total_sum INTEGER NOT NULL DEFAULT 0,
+    total_max INTEGER NOT NULL DEFAULT 0,
+    deferred_sum INTEGER NOT NULL DEFAULT 0,
+    deferred_max INTEGER NOT NULL DEFAULT 0,
+    frozen_sum INTEGER NOT NULL DEFAULT 0,
+    frozen_max INTEGER NOT NULL DEFAULT 0,
+    oldest_age_max INTEGER,
+    PRIMARY KEY (period, bucket_start)
+);
+INSERT OR IGNORE INTO queue_snapshot_rollups (period, bucket_start, samples, total_sum, total_max, deferred_sum, deferred_max, frozen_sum, frozen_max, oldest_age_max)
+SELECT 'hour', CAST(strftime('%s', timestamp) AS INTEGER) / 3600 * 3600 AS bucket, COUNT(*),
+       SUM(total_messages), MAX(total_messages), SUM(deferred_messages), MAX(deferred_messages),
+       SUM(frozen_messages), MAX(frozen_messages), MAX(oldest_message_age)
+FROM queue_snapshots WHERE timestamp IS NOT NULL GROUP BY bucket;
+INSERT OR IGNORE INTO queue_snapshot_rollups (period, bucket_start, samples, total_sum, total_max, deferred_sum, deferred_max, frozen_sum, frozen_max, oldest_age_max)
+SELECT 'day', CAST(strftime('%s', timestamp) AS INTEGER) / 86400 * 86400 AS bucket, COUNT(*),
+       SUM(total_messages), MAX(total_messages), SUM(deferred_messages), MAX(deferred_messages),
+       SUM(frozen_messages), MAX(frozen_messages), MAX(oldest_message_age)
+FROM queue_snapshots WHERE timestamp IS NOT NULL GROUP BY bucket;
+`,
+			Down: `
+DROP TABLE IF EXISTS queue_snapshot_rollups;
+`,
+		},
+		{
+			Version:     15,
+			Description: "Add alert rules and alert history",
+			Up: `
+-- Rules created through the API. Rules from the configuration file are not stored.
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT,
+    metric TEXT NOT NULL,
+    event TEXT,
+    window_seconds INTEGER NOT NULL DEFAULT 0,
+    operator TEXT NOT NULL,
+    threshold REAL NOT NULL,
+    for_seconds INTEGER NOT NULL DEFAULT 0,
+    cooldown_seconds INTEGER NOT NULL DEFAULT 0,
+    repeat_interval_seconds INTEGER NOT NULL DEFAULT 0,
+    severity TEXT NOT NULL DEFAULT 'warning',
+    notifiers TEXT,
+    send_resolved BOOLEAN NOT NULL DEFAULT 1,
+    enabled BOOLEAN NOT NULL DEFAULT 1,
+    created_by TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS alert_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    rule_name TEXT NOT NULL,
+    metric TEXT NOT NULL,
+    severity TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('firing', 'resolved')),
+    value REAL NOT NULL,
+    threshold REAL NOT NULL,
+    operator TEXT NOT NULL,
+    message TEXT NOT NULL,
+    fired_at DATETIME NOT NULL,
+    resolved_at DATETIME,
+    last_notified_at DATETIME,
+    notifications INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_alert_history_rule_name ON alert_history(rule_name);
+CREATE INDEX IF NOT EXISTS idx_alert_history_status ON alert_history(status);
+CREATE INDEX IF NOT EXISTS idx_alert_history_fired_at ON alert_history(fired_at);
+`,
+			Down: `
+DROP TABLE IF EXISTS alert_history;
+DROP TABLE IF EXISTS alert_rules;
+`,
+		},
+		{
+			Version:     16,
+			Description: "Add router and transport to log entries",
+			Up: `
+ALTER TABLE log_entries ADD COLUMN router TEXT;
+ALTER TABLE log_entries ADD COLUMN transport TEXT;
+`,
+			Down: `
+-- SQLite cannot drop columns without recreating the table, so the columns stay
+`,
+		},
+		{
+			Version:     17,
+			Description: "Add the Exim process ID to log entries",
+			Up: `
+ALTER TABLE log_entries ADD COLUMN pid INTEGER;
+CREATE INDEX IF NOT EXISTS idx_log_entries_pid ON log_entries(pid);
+`,
+			Down: `
+DROP INDEX IF EXISTS idx_log_entries_pid;
+`,
+		},
+		{
+			Version:     18,
+			Description: "Let administrators clear login lockouts",
+			Up: `
+-- Failed attempts cleared by an administrator no longer count towards a lockout
+ALTER TABLE login_attempts ADD COLUMN cleared_at DATETIME;
+`,
+			Down: `
+-- SQLite cannot drop columns without recreating the table, so the column stays
+`,
+		},
+		{
+			Version:     19,
+			Description: "Add CSRF tokens to sessions",
+			Up: `
+-- Sessions created before this migration get a token the next time it is requested
+ALTER TABLE sessions ADD COLUMN csrf_token TEXT;
+`,
+			Down: `
+-- SQLite cannot drop columns without recreating the table, so the column stays
 `,
 		},
 	}
@@ -454,7 +722,7 @@ func MigrateDown(db *DB) error {
 
 	// Execute down migration
 	if targetMigration.Down != "" {
-		statements := strings.Split(targetMigration.Down, ";")
+		statements := splitMigrationStatements(targetMigration.Down)
 		for _, stmt := range statements {
 			stmt = strings.TrimSpace(stmt)
 			if stmt == "" {
@@ -534,7 +802,7 @@ func MigrateToVersion(db *DB, targetVersion int) error {
 
 			// Execute down migration
 			if migration.Down != "" {
-				statements := strings.Split(migration.Down, ";")
+				statements := splitMigrationStatements(migration.Down)
 				for _, stmt := range statements {
 					stmt = strings.TrimSpace(stmt)
 					if stmt == "" {
@@ -624,7 +892,7 @@ func applyMigration(db *DB, migration Migration) error {
 	defer tx.Rollback()
 
 	// Execute migration statements
-	statements := strings.Split(migration.Up, ";")
+	statements := splitMigrationStatements(migration.Up)
 	for _, stmt := range statements {
 		stmt = strings.TrimSpace(stmt)
 		if stmt == "" {
@@ -652,6 +920,21 @@ func applyMigration(db *DB, migration Migration) error {
 	return nil
 }
 
+// splitMigrationStatements splits migration SQL into statements. Comment lines are
+// dropped first so that semicolons in them do not split a statement. The splitter does
+// not parse SQL: a semicolon in a trailing "--" comment, a block comment, a string
+// literal or a trigger body still ends the statement, so migrations must avoid them.
+func splitMigrationStatements(sql string) []string {
+	lines := strings.Split(sql, "\n")
+	code := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			code = append(code, line)
+		}
+	}
+	return strings.Split(strings.Join(code, "\n"), ";")
+}
+
 // ValidateMigrations validates that all migrations are consistent
 func ValidateMigrations() error {
 	migrations := GetMigrations()
@@ -84,6 +84,79 @@ type LogEntry struct {
 	ErrorText    *string   `json:"error_text" db:"error_text"`
 	RawLine      string    `json:"raw_line" db:"raw_line"`
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+
+	// Fields extracted from the tagged values of main log lines
+	MessageIDHeader *string  `json:"message_id_header,omitempty" db:"message_id_header"` // id=
+	Protocol        *string  `json:"protocol,omitempty" db:"protocol"`                   // P=
+	TLSCipher       *string  `json:"tls_cipher,omitempty" db:"tls_cipher"`               // X=
+	TLSVerify       *string  `json:"tls_verify,omitempty" db:"tls_verify"`               // CV=
+	TLSPeerDN       *string  `json:"tls_peer_dn,omitempty" db:"tls_peer_dn"`             // DN=
+	Confirmation    *string  `json:"confirmation,omitempty" db:"confirmation"`           // C=
+	QueueTime       *float64 `json:"queue_time,omitempty" db:"queue_time"`               // QT=, in seconds
+	DeliveryTime    *float64 `json:"delivery_time,omitempty" db:"delivery_time"`         // DT=, in seconds
+	Chunking        bool     `json:"chunking,omitempty" db:"chunking"`                   // K
+	PRDR            bool     `json:"prdr,omitempty" db:"prdr"`                           // PRDR
+	Router          *string  `json:"router,omitempty" db:"router"`                       // R=
+	Transport       *string  `json:"transport,omitempty" db:"transport"`                 // T=
+
+	// PID is the Exim process that wrote the line, when log_selector includes +pid or the
+	// line came through syslog
+	PID *int64 `json:"pid,omitempty" db:"pid"`
+
+	// SourceFingerprint and SourceOffset locate the line in the log file it was read
+	// from. Together they are unique, so the same line is never stored twice.
+	SourceFingerprint *string `json:"-" db:"source_fingerprint"`
+	SourceOffset      *int64  `json:"-" db:"source_offset"`
+}
+
+// LogEntryColumns lists the log_entries columns in the order of LogEntry.ScanFields
+const LogEntryColumns = "id, timestamp, message_id, log_type, event, host, sender, recipients, size, status, error_code, error_text, raw_line, created_at, " +
+	"message_id_header, protocol, tls_cipher, tls_verify, tls_peer_dn, confirmation, queue_time, delivery_time, chunking, prdr, " +
+	"source_fingerprint, source_offset, router, transport, pid"
+
+// LogEntryInsertColumns lists the columns written on insert, in the order of LogEntry.InsertValues
+const LogEntryInsertColumns = "timestamp, message_id, log_type, event, host, sender, recipients, size, status, error_code, error_text, raw_line, created_at, " +
+	"message_id_header, protocol, tls_cipher, tls_verify, tls_peer_dn, confirmation, queue_time, delivery_time, chunking, prdr, " +
+	"source_fingerprint, source_offset, router, transport, pid"
+
+// LogEntryInsertPlaceholders holds one placeholder per LogEntryInsertColumns entry
+const LogEntryInsertPlaceholders = "?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?"
+
+// LogEntryInsertQuery inserts a log entry unless a line from the same file position is
+// already stored
+const LogEntryInsertQuery = "INSERT INTO log_entries (" + LogEntryInsertColumns + ") VALUES (" + LogEntryInsertPlaceholders + ")" +
+	" ON CONFLICT(source_fingerprint, source_offset) DO NOTHING"
+
+// ScanFields returns the scan destinations for a row selected with LogEntryColumns
+func (l *LogEntry) ScanFields() []interface{} {
+	return []interface{}{
+		&l.ID, &l.Timestamp, &l.MessageID, &l.LogType, &l.Event, &l.Host, &l.Sender, &l.RecipientsDB,
+		&l.Size, &l.Status, &l.ErrorCode, &l.ErrorText, &l.RawLine, &l.CreatedAt,
+		&l.MessageIDHeader, &l.Protocol, &l.TLSCipher, &l.TLSVerify, &l.TLSPeerDN, &l.Confirmation,
+		&l.QueueTime, &l.DeliveryTime, &l.Chunking, &l.PRDR, &l.SourceFingerprint, &l.SourceOffset,
+		&l.Router, &l.Transport, &l.PID,
+	}
+}
+
+// InsertValues returns the values for LogEntryInsertColumns. Recipients must already be marshaled.
+func (l *LogEntry) InsertValues() []interface{} {
+	return []interface{}{
+		l.Timestamp, l.MessageID, l.LogType, l.Event, l.Host, l.Sender, l.RecipientsDB,
+		l.Size, l.Status, l.ErrorCode, l.ErrorText, l.RawLine, l.CreatedAt,
+		l.MessageIDHeader, l.Protocol, l.TLSCipher, l.TLSVerify, l.TLSPeerDN, l.Confirmation,
+		l.QueueTime, l.DeliveryTime, l.Chunking, l.PRDR, l.SourceFingerprint, l.SourceOffset,
+		l.Router, l.Transport, l.PID,
+	}
+}
+
+// LogFileOffset records how far a monitored log file has been ingested. The fingerprint
+// identifies the file by its first line, so a rotated or truncated file is not resumed
+// at a stale position.
+type LogFileOffset struct {
+	Path        string    `json:"path" db:"path"`
+	Fingerprint string    `json:"fingerprint" db:"fingerprint"`
+	Position    int64     `json:"position" db:"position"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
 }
 
 // LogType constants
@@ -101,6 +174,16 @@ const (
 	EventBounce   = "bounce"
 	EventReject   = "reject"
 	EventPanic    = "panic"
+
+	EventCompleted       = "completed"
+	EventSuppressed      = "suppressed" // *> delivery suppressed by -N or fakedelivery
+	EventFrozen          = "frozen"
+	EventUnfrozen        = "unfrozen"
+	EventConnection      = "connection"        // SMTP connection from
+	EventQueued          = "queued"            // no immediate delivery
+	EventRetryNotReached = "retry_not_reached" // skipped without a delivery attempt
+	EventRemoved         = "removed"
+	EventCancelled       = "cancelled"
 )
 
 // MarshalRecipients converts the Recipients slice to JSON for database storage
@@ -140,6 +223,11 @@ type AuditLog struct {
 	Details   *string   `json:"details" db:"details"` // JSON string
 	IPAddress *string   `json:"ip_address" db:"ip_address"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
+
+	// PrevHash and EntryHash chain each entry to its predecessor. They are nil for
+	// entries written before the audit log was hash-chained.
+	PrevHash  *string `json:"prev_hash,omitempty" db:"prev_hash"`
+	EntryHash *string `json:"entry_hash,omitempty" db:"entry_hash"`
 }
 
 // QueueSnapshot represents a point-in-time snapshot of the queue
@@ -153,6 +241,81 @@ type QueueSnapshot struct {
 	CreatedAt        time.Time `json:"created_at" db:"created_at"`
 }
 
+// QueueHistory is a downsampled series of queue snapshots between Start and End
+type QueueHistory struct {
+	Start         time.Time           `json:"start"`
+	End           time.Time           `json:"end"`
+	Resolution    string              `json:"resolution"` // raw, hour or day
+	BucketSeconds int64               `json:"bucket_seconds"`
+	Points        []QueueHistoryPoint `json:"points"`
+}
+
+// QueueHistoryPoint summarizes the snapshots taken in one bucket of a QueueHistory
+type QueueHistoryPoint struct {
+	Timestamp        time.Time `json:"timestamp"`
+	Samples          int       `json:"samples"`
+	TotalMessages    float64   `json:"total_messages"`
+	TotalMax         int       `json:"total_max"`
+	DeferredMessages float64   `json:"deferred_messages"`
+	DeferredMax      int       `json:"deferred_max"`
+	FrozenMessages   float64   `json:"frozen_messages"`
+	FrozenMax        int       `json:"frozen_max"`
+	OldestMessageAge *int      `json:"oldest_message_age"` // seconds, largest in the bucket
+}
+
+// Alert rule sources
+const (
+	AlertRuleSourceConfig = "config"
+	AlertRuleSourceAPI    = "api"
+)
+
+// Alert statuses
+const (
+	AlertStatusFiring   = "firing"
+	AlertStatusResolved = "resolved"
+)
+
+// AlertRule fires an alert when a metric crosses a threshold for a sustained time
+type AlertRule struct {
+	ID             int64      `json:"id,omitempty" db:"id"`
+	Name           string     `json:"name" db:"name"`
+	Description    string     `json:"description,omitempty" db:"description"`
+	Metric         string     `json:"metric" db:"metric"`
+	Event          string     `json:"event,omitempty" db:"event"` // log event counted by log_event_rate
+	Window         int        `json:"window" db:"window_seconds"` // seconds looked back by log metrics
+	Operator       string     `json:"operator" db:"operator"`     // >, >=, < or <=
+	Threshold      float64    `json:"threshold" db:"threshold"`
+	For            int        `json:"for" db:"for_seconds"`                         // seconds the condition must hold before firing
+	Cooldown       int        `json:"cooldown" db:"cooldown_seconds"`               // seconds after resolving before the rule can fire again
+	RepeatInterval int        `json:"repeat_interval" db:"repeat_interval_seconds"` // seconds between reminders while firing, 0 for none
+	Severity       string     `json:"severity" db:"severity"`                       // info, warning or critical
+	Notifiers      []string   `json:"notifiers" db:"notifiers"`                     // notifier names, empty for all
+	SendResolved   bool       `json:"send_resolved" db:"send_resolved"`
+	Enabled        bool       `json:"enabled" db:"enabled"`
+	Source         string     `json:"source" db:"-"` // config or api
+	CreatedBy      *string    `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt      *time.Time `json:"created_at,omitempty" db:"created_at"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// AlertEvent is one alert from the time it fired until it resolved
+type AlertEvent struct {
+	ID             int64      `json:"id" db:"id"`
+	RuleName       string     `json:"rule_name" db:"rule_name"`
+	Metric         string     `json:"metric" db:"metric"`
+	Severity       string     `json:"severity" db:"severity"`
+	Status         string     `json:"status" db:"status"`
+	Value          float64    `json:"value" db:"value"` // latest value seen while firing
+	Threshold      float64    `json:"threshold" db:"threshold"`
+	Operator       string     `json:"operator" db:"operator"`
+	Message        string     `json:"message" db:"message"`
+	FiredAt        time.Time  `json:"fired_at" db:"fired_at"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty" db:"resolved_at"`
+	LastNotifiedAt *time.Time `json:"last_notified_at,omitempty" db:"last_notified_at"`
+	Notifications  int        `json:"notifications" db:"notifications"`
+	LastError      *string    `json:"last_error,omitempty" db:"last_error"`
+}
+
 // MessageWithRecipients represents a message with its recipients
 type MessageWithRecipients struct {
 	Message    Message     `json:"message"`
@@ -184,6 +347,8 @@ type RecipientDeliveryStatus struct {
 	DeliveredAt     *time.Time        `json:"delivered_at,omitempty"`
 	LastAttemptAt   *time.Time        `json:"last_attempt_at,omitempty"`
 	NextRetryAt     *time.Time        `json:"next_retry_at,omitempty"`
+	BounceDeadline  *time.Time        `json:"bounce_deadline,omitempty"`
+	RetryRule       *string           `json:"retry_rule,omitempty"`
 	AttemptCount    int               `json:"attempt_count"`
 	LastSMTPCode    *string           `json:"last_smtp_code,omitempty"`
 	LastErrorText   *string           `json:"last_error_text,omitempty"`
@@ -206,11 +371,15 @@ type DeliveryTimelineEvent struct {
 
 // RetryScheduleEntry represents a scheduled retry attempt
 type RetryScheduleEntry struct {
-	Recipient     string    `json:"recipient"`
-	ScheduledAt   time.Time `json:"scheduled_at"`
-	AttemptNumber int       `json:"attempt_number"`
-	Reason        string    `json:"reason"`
-	IsEstimated   bool      `json:"is_estimated"` // true if calculated, false if from queue
+	Recipient     string     `json:"recipient"`
+	ScheduledAt   time.Time  `json:"scheduled_at"`
+	EarliestAt    *time.Time `json:"earliest_at,omitempty"` // window of randomised (H) rules
+	LatestAt      *time.Time `json:"latest_at,omitempty"`
+	AttemptNumber int        `json:"attempt_number"`
+	Reason        string     `json:"reason"`
+	Rule          string     `json:"rule,omitempty"` // Exim retry rule the time was predicted from
+	IsFinal       bool       `json:"is_final"`       // the address bounces at this attempt
+	IsEstimated   bool       `json:"is_estimated"`   // true if calculated, false if from queue
 }
 
 // DeliveryTraceSummary provides summary statistics for the trace
@@ -264,6 +433,7 @@ type MessageAttachment struct {
 	Filename    string  `json:"filename"`
 	ContentType string  `json:"content_type"`
 	Size        int64   `json:"size"`
+	SHA256      string  `json:"sha256"`
 	IsSafe      bool    `json:"is_safe"`
 	Preview     *string `json:"preview,omitempty"` // safe preview text
 }
@@ -309,6 +479,7 @@ type User struct {
 	PasswordHash string     `json:"-" db:"password_hash"` // Never include in JSON
 	Email        *string    `json:"email" db:"email"`
 	FullName     *string    `json:"full_name" db:"full_name"`
+	Role         string     `json:"role" db:"role"`
 	IsActive     bool       `json:"is_active" db:"active"`
 	LastLoginAt  *time.Time `json:"last_login_at" db:"last_login"`
 	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
@@ -324,6 +495,47 @@ type Session struct {
 	UserAgent *string   `json:"user_agent" db:"user_agent"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+
+	// CSRFToken must accompany state-changing requests made with the session cookie.
+	// Nil for sessions created before tokens were introduced.
+	CSRFToken *string `json:"-" db:"csrf_token"`
+}
+
+// APIToken represents a long-lived bearer token used by automation clients.
+// Scopes hold the permission names the token may exercise on behalf of its owner.
+type APIToken struct {
+	ID          int64      `json:"id" db:"id"`
+	UserID      int64      `json:"user_id" db:"user_id"`
+	Name        string     `json:"name" db:"name"`
+	TokenPrefix string     `json:"token_prefix" db:"token_prefix"`
+	TokenHash   string     `json:"-" db:"token_hash"`
+	Scopes      []string   `json:"scopes" db:"scopes"`
+	ExpiresAt   time.Time  `json:"expires_at" db:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at" db:"last_used_at"`
+	LastUsedIP  *string    `json:"last_used_ip" db:"last_used_ip"`
+	RevokedAt   *time.Time `json:"revoked_at" db:"revoked_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
+
+// UserTOTP holds a user's TOTP secret. Enabled is false until the user confirms
+// enrollment with a valid code.
+type UserTOTP struct {
+	UserID       int64      `json:"user_id" db:"user_id"`
+	Secret       string     `json:"-" db:"secret"`
+	Enabled      bool       `json:"enabled" db:"enabled"`
+	LastUsedStep int64      `json:"-" db:"last_used_step"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	EnabledAt    *time.Time `json:"enabled_at" db:"enabled_at"`
+}
+
+// LoginAttempt represents a row in the login_attempts table
+type LoginAttempt struct {
+	ID        int64     `json:"id" db:"id"`
+	Username  string    `json:"username" db:"username"`
+	IPAddress string    `json:"ip_address" db:"ip_address"`
+	Success   bool      `json:"success" db:"success"`
+	UserAgent *string   `json:"user_agent" db:"user_agent"`
+	Timestamp time.Time `json:"timestamp" db:"timestamp"`
 }
 
 // LoginRequest represents a login request
@@ -336,5 +548,17 @@ type LoginRequest struct {
 type LoginResponse struct {
 	User      User      `json:"user"`
 	SessionID string    `json:"session_id"`
+	CSRFToken string    `json:"csrf_token"`
 	ExpiresAt time.Time `json:"expires_at"`
+
+	// MFARequired is set when the password was accepted but a second factor is still
+	// needed. MFAToken identifies the pending login and SessionID is empty.
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+}
+
+// MFALoginRequest completes a login that requires a second factor
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
 }
@@ -0,0 +1,264 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Queue history resolutions. Hourly and daily rollups are updated as each snapshot is
+// stored and are not trimmed by retention, so long ranges stay cheap to query.
+const (
+	QueueHistoryRaw  = "raw"
+	QueueHistoryHour = "hour"
+	QueueHistoryDay  = "day"
+)
+
+// DefaultQueueHistoryPoints is the number of points returned when none is requested
+const DefaultQueueHistoryPoints = 200
+
+var snapshotRollupPeriods = []struct {
+	name    string
+	seconds int64
+}{
+	{QueueHistoryHour, 3600},
+	{QueueHistoryDay, 86400},
+}
+
+// addToSnapshotRollups adds a snapshot to the hourly and daily rollup buckets it falls in
+func addToSnapshotRollups(tx *sql.Tx, snapshot *QueueSnapshot) error {
+	query := `
+		INSERT INTO queue_snapshot_rollups (period, bucket_start, samples, total_sum, total_max, deferred_sum, deferred_max, frozen_sum, frozen_max, oldest_age_max)
+		VALUES (?, ?, 1, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (period, bucket_start) DO UPDATE SET
+			samples = samples + 1,
+			total_sum = total_sum + excluded.total_sum,
+			total_max = MAX(total_max, excluded.total_max),
+			deferred_sum = deferred_sum + excluded.deferred_sum,
+			deferred_max = MAX(deferred_max, excluded.deferred_max),
+			frozen_sum = frozen_sum + excluded.frozen_sum,
+			frozen_max = MAX(frozen_max, excluded.frozen_max),
+			oldest_age_max = NULLIF(MAX(COALESCE(oldest_age_max, -1), COALESCE(excluded.oldest_age_max, -1)), -1)`
+
+	unix := snapshot.Timestamp.Unix()
+	for _, period := range snapshotRollupPeriods {
+		bucket := unix - unix%period.seconds
+		_, err := tx.Exec(query, period.name, bucket,
+			snapshot.TotalMessages, snapshot.TotalMessages,
+			snapshot.DeferredMessages, snapshot.DeferredMessages,
+			snapshot.FrozenMessages, snapshot.FrozenMessages,
+			snapshot.OldestMessageAge)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// History returns the queue size between start and end downsampled to about maxPoints
+// points. Ranges too long for raw snapshots are served from the hourly or daily rollups,
+// as are ranges reaching back before the oldest snapshot retention has kept.
+func (r *QueueSnapshotRepository) History(start, end time.Time, maxPoints int) (*QueueHistory, error) {
+	if !end.After(start) {
+		return nil, fmt.Errorf("history end must be after start")
+	}
+	if maxPoints <= 0 {
+		maxPoints = DefaultQueueHistoryPoints
+	}
+
+	span := end.Unix() - start.Unix()
+	width := (span + int64(maxPoints) - 1) / int64(maxPoints)
+	if width < 1 {
+		width = 1
+	}
+
+	resolution := QueueHistoryRaw
+	switch {
+	case width >= 86400:
+		resolution = QueueHistoryDay
+	case width >= 3600:
+		resolution = QueueHistoryHour
+	default:
+		trimmed, err := r.rawSnapshotsTrimmed(start)
+		if err != nil {
+			return nil, err
+		}
+		if trimmed {
+			resolution = QueueHistoryHour
+		}
+	}
+
+	var buckets []snapshotBucket
+	var err error
+	if resolution == QueueHistoryRaw {
+		buckets, err = r.rawHistory(start, end, width)
+	} else {
+		periodSeconds := int64(3600)
+		if resolution == QueueHistoryDay {
+			periodSeconds = 86400
+		}
+		// Whole rollup buckets only, so none is split across two points
+		width = (width + periodSeconds - 1) / periodSeconds * periodSeconds
+		buckets, err = r.rollupHistory(resolution, periodSeconds, start, end, width)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	history := &QueueHistory{
+		Start:         start,
+		End:           end,
+		Resolution:    resolution,
+		BucketSeconds: width,
+		Points:        make([]QueueHistoryPoint, 0, len(buckets)),
+	}
+	for _, bucket := range buckets {
+		history.Points = append(history.Points, bucket.point())
+	}
+
+	return history, nil
+}
+
+// rawSnapshotsTrimmed reports whether retention has removed raw snapshots from before
+// start that are still covered by the hourly rollups
+func (r *QueueSnapshotRepository) rawSnapshotsTrimmed(start time.Time) (bool, error) {
+	var oldestRaw sql.NullInt64
+	err := r.db.QueryRow("SELECT CAST(strftime('%s', MIN(timestamp)) AS INTEGER) FROM queue_snapshots").Scan(&oldestRaw)
+	if err != nil {
+		return false, err
+	}
+
+	var oldestRollup sql.NullInt64
+	err = r.db.QueryRow("SELECT MIN(bucket_start) FROM queue_snapshot_rollups WHERE period = ?", QueueHistoryHour).Scan(&oldestRollup)
+	if err != nil {
+		return false, err
+	}
+
+	if !oldestRollup.Valid {
+		return false, nil
+	}
+	if !oldestRaw.Valid {
+		return true, nil
+	}
+
+	return start.Unix() < oldestRaw.Int64 && oldestRollup.Int64 < oldestRaw.Int64-oldestRaw.Int64%3600, nil
+}
+
+// rawHistory buckets the stored snapshots between start and end
+func (r *QueueSnapshotRepository) rawHistory(start, end time.Time, width int64) ([]snapshotBucket, error) {
+	// Timestamps are stored with the writer's UTC offset, so they are compared as unix times
+	query := `
+		SELECT CAST(strftime('%s', timestamp) AS INTEGER) AS ts, total_messages, deferred_messages, frozen_messages, oldest_message_age
+		FROM queue_snapshots
+		WHERE ts >= ? AND ts <= ?
+		ORDER BY ts`
+
+	rows, err := r.db.Query(query, start.Unix(), end.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []snapshotBucket
+	for rows.Next() {
+		var unix int64
+		var total, deferred, frozen int
+		var oldest sql.NullInt64
+		if err := rows.Scan(&unix, &total, &deferred, &frozen, &oldest); err != nil {
+			return nil, err
+		}
+
+		buckets = addToBucket(buckets, unix-unix%width, 1,
+			int64(total), total, int64(deferred), deferred, int64(frozen), frozen, oldest)
+	}
+
+	return buckets, rows.Err()
+}
+
+// rollupHistory buckets the rollups of one period between start and end
+func (r *QueueSnapshotRepository) rollupHistory(period string, periodSeconds int64, start, end time.Time, width int64) ([]snapshotBucket, error) {
+	query := `
+		SELECT bucket_start, samples, total_sum, total_max, deferred_sum, deferred_max, frozen_sum, frozen_max, oldest_age_max
+		FROM queue_snapshot_rollups
+		WHERE period = ? AND bucket_start >= ? AND bucket_start <= ?
+		ORDER BY bucket_start`
+
+	first := start.Unix() - start.Unix()%periodSeconds
+	rows, err := r.db.Query(query, period, first, end.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []snapshotBucket
+	for rows.Next() {
+		var unix, totalSum, deferredSum, frozenSum int64
+		var samples, totalMax, deferredMax, frozenMax int
+		var oldest sql.NullInt64
+		if err := rows.Scan(&unix, &samples, &totalSum, &totalMax, &deferredSum, &deferredMax, &frozenSum, &frozenMax, &oldest); err != nil {
+			return nil, err
+		}
+
+		buckets = addToBucket(buckets, unix-unix%width, samples,
+			totalSum, totalMax, deferredSum, deferredMax, frozenSum, frozenMax, oldest)
+	}
+
+	return buckets, rows.Err()
+}
+
+// snapshotBucket accumulates the snapshots of one history point
+type snapshotBucket struct {
+	start                            int64
+	samples                          int
+	totalSum, deferredSum, frozenSum int64
+	totalMax, deferredMax, frozenMax int
+	oldest                           sql.NullInt64
+}
+
+// addToBucket adds values to the last bucket if it starts at start, or to a new one.
+// Rows are read in time order, so a bucket never needs to be revisited.
+func addToBucket(buckets []snapshotBucket, start int64, samples int, totalSum int64, totalMax int, deferredSum int64, deferredMax int, frozenSum int64, frozenMax int, oldest sql.NullInt64) []snapshotBucket {
+	if len(buckets) == 0 || buckets[len(buckets)-1].start != start {
+		buckets = append(buckets, snapshotBucket{start: start})
+	}
+
+	b := &buckets[len(buckets)-1]
+	b.samples += samples
+	b.totalSum += totalSum
+	b.deferredSum += deferredSum
+	b.frozenSum += frozenSum
+	b.totalMax = max(b.totalMax, totalMax)
+	b.deferredMax = max(b.deferredMax, deferredMax)
+	b.frozenMax = max(b.frozenMax, frozenMax)
+	if oldest.Valid && (!b.oldest.Valid || oldest.Int64 > b.oldest.Int64) {
+		b.oldest = oldest
+	}
+
+	return buckets
+}
+
+// point returns the averages and maximums of the bucket
+func (b snapshotBucket) point() QueueHistoryPoint {
+	point := QueueHistoryPoint{
+		Timestamp:   time.Unix(b.start, 0).UTC(),
+		Samples:     b.samples,
+		TotalMax:    b.totalMax,
+		DeferredMax: b.deferredMax,
+		FrozenMax:   b.frozenMax,
+	}
+
+	if b.samples > 0 {
+		n := float64(b.samples)
+		point.TotalMessages = float64(b.totalSum) / n
+		point.DeferredMessages = float64(b.deferredSum) / n
+		point.FrozenMessages = float64(b.frozenSum) / n
+	}
+
+	if b.oldest.Valid {
+		age := int(b.oldest.Int64)
+		point.OldestMessageAge = &age
+	}
+
+	return point
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newQueueHistoryTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	db, err := Connect(&Config{
+		Path:            filepath.Join(t.TempDir(), "history.db"),
+		MaxOpenConns:    1,
+		MaxIdleConns:    1,
+		ConnMaxLifetime: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := MigrateUp(db); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	return db
+}
+
+func TestQueueSnapshotHistory(t *testing.T) {
+	db := newQueueHistoryTestDB(t)
+	repo := NewQueueSnapshotRepository(db)
+
+	// Four days of snapshots every 15 minutes, stored with a non-UTC offset
+	zone := time.FixedZone("EET", 2*3600)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 4*24*4; i++ {
+		age := i * 60
+		snapshot := &QueueSnapshot{
+			Timestamp:        start.Add(time.Duration(i) * 15 * time.Minute).In(zone),
+			TotalMessages:    i % 4,
+			DeferredMessages: 1,
+			OldestMessageAge: &age,
+		}
+		if err := repo.Create(snapshot); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+
+	// Two hours at up to 200 points is served from raw snapshots
+	history, err := repo.History(start, start.Add(2*time.Hour), 200)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if history.Resolution != QueueHistoryRaw || len(history.Points) != 9 {
+		t.Fatalf("Expected 9 raw points, got %d at %s", len(history.Points), history.Resolution)
+	}
+	if p := history.Points[1]; p.TotalMax != 1 || p.Samples != 1 || !p.Timestamp.Equal(start.Add(15*time.Minute)) {
+		t.Errorf("Unexpected raw point %+v", p)
+	}
+
+	// Four days in 24 points uses 4-hour buckets over the hourly rollups
+	history, err = repo.History(start, start.Add(96*time.Hour), 24)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if history.Resolution != QueueHistoryHour || history.BucketSeconds != 4*3600 || len(history.Points) != 24 {
+		t.Fatalf("Expected 24 hourly points of 4h, got %d of %ds at %s", len(history.Points), history.BucketSeconds, history.Resolution)
+	}
+	p := history.Points[1]
+	if p.Samples != 16 || p.TotalMessages != 1.5 || p.TotalMax != 3 || p.DeferredMessages != 1 {
+		t.Errorf("Unexpected hourly point %+v", p)
+	}
+	if p.OldestMessageAge == nil || *p.OldestMessageAge != 31*60 {
+		t.Errorf("Expected oldest age %d, got %v", 31*60, p.OldestMessageAge)
+	}
+
+	// Four days in 4 points uses the daily rollups
+	history, err = repo.History(start, start.Add(96*time.Hour), 4)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if history.Resolution != QueueHistoryDay || len(history.Points) != 4 || history.Points[3].Samples != 96 {
+		t.Fatalf("Expected 4 daily points of 96 samples, got %+v", history)
+	}
+
+	// Once retention has trimmed raw snapshots, short old ranges fall back to the rollups
+	if _, err := repo.DeleteOlderThan(start.Add(48 * time.Hour).In(zone)); err != nil {
+		t.Fatalf("DeleteOlderThan failed: %v", err)
+	}
+	history, err = repo.History(start, start.Add(2*time.Hour), 200)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if history.Resolution != QueueHistoryHour || len(history.Points) != 3 || history.Points[0].Samples != 4 {
+		t.Fatalf("Expected 3 hourly points after trimming, got %+v", history)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"log"
+	"sort"
 	"strings"
 	"time"
 )
@@ -362,17 +364,18 @@ func (r *LogEntryRepository) Create(entry *LogEntry) error {
 		return fmt.Errorf("failed to marshal recipients: %w", err)
 	}
 
-	query := `
-		INSERT INTO log_entries (timestamp, message_id, log_type, event, host, sender, recipients, size, status, error_code, error_text, raw_line, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-
 	entry.CreatedAt = time.Now()
 
-	result, err := r.db.Exec(query, entry.Timestamp, entry.MessageID, entry.LogType, entry.Event, entry.Host, entry.Sender, entry.RecipientsDB, entry.Size, entry.Status, entry.ErrorCode, entry.ErrorText, entry.RawLine, entry.CreatedAt)
+	result, err := r.db.Exec(LogEntryInsertQuery, entry.InsertValues()...)
 	if err != nil {
 		return err
 	}
 
+	// A line already stored from the same source position is skipped
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return nil
+	}
+
 	id, err := result.LastInsertId()
 	if err != nil {
 		return err
@@ -385,7 +388,7 @@ func (r *LogEntryRepository) Create(entry *LogEntry) error {
 // GetByMessageID retrieves all log entries for a message
 func (r *LogEntryRepository) GetByMessageID(messageID string) ([]LogEntry, error) {
 	query := `
-		SELECT id, timestamp, message_id, log_type, event, host, sender, recipients, size, status, error_code, error_text, raw_line, created_at
+		SELECT ` + LogEntryColumns + `
 		FROM log_entries WHERE message_id = ? ORDER BY timestamp`
 
 	rows, err := r.db.Query(query, messageID)
@@ -397,7 +400,7 @@ func (r *LogEntryRepository) GetByMessageID(messageID string) ([]LogEntry, error
 	var entries []LogEntry
 	for rows.Next() {
 		var entry LogEntry
-		err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.MessageID, &entry.LogType, &entry.Event, &entry.Host, &entry.Sender, &entry.RecipientsDB, &entry.Size, &entry.Status, &entry.ErrorCode, &entry.ErrorText, &entry.RawLine, &entry.CreatedAt)
+		err := rows.Scan(entry.ScanFields()...)
 		if err != nil {
 			return nil, err
 		}
@@ -413,10 +416,30 @@ func (r *LogEntryRepository) GetByMessageID(messageID string) ([]LogEntry, error
 	return entries, rows.Err()
 }
 
+// CountSince counts log entries at or after since, optionally of one log type and event
+func (r *LogEntryRepository) CountSince(logType, event string, since time.Time) (int, error) {
+	query := "SELECT COUNT(*) FROM log_entries WHERE timestamp >= ?"
+	args := []interface{}{since}
+
+	if logType != "" {
+		query += " AND log_type = ?"
+		args = append(args, logType)
+	}
+
+	if event != "" {
+		query += " AND event = ?"
+		args = append(args, event)
+	}
+
+	var count int
+	err := r.db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
 // List retrieves log entries with pagination and filtering
 func (r *LogEntryRepository) List(limit, offset int, logType, event string, startTime, endTime *time.Time) ([]LogEntry, error) {
 	query := `
-		SELECT id, timestamp, message_id, log_type, event, host, sender, recipients, size, status, error_code, error_text, raw_line, created_at
+		SELECT ` + LogEntryColumns + `
 		FROM log_entries`
 
 	var conditions []string
@@ -458,7 +481,7 @@ func (r *LogEntryRepository) List(limit, offset int, logType, event string, star
 	var entries []LogEntry
 	for rows.Next() {
 		var entry LogEntry
-		err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.MessageID, &entry.LogType, &entry.Event, &entry.Host, &entry.Sender, &entry.RecipientsDB, &entry.Size, &entry.Status, &entry.ErrorCode, &entry.ErrorText, &entry.RawLine, &entry.CreatedAt)
+		err := rows.Scan(entry.ScanFields()...)
 		if err != nil {
 			return nil, err
 		}
@@ -484,34 +507,194 @@ func NewAuditLogRepository(db *DB) *AuditLogRepository {
 	return &AuditLogRepository{Repository: NewRepository(db)}
 }
 
-// Create inserts a new audit log entry
+// Create appends a new audit log entry to the hash chain
 func (r *AuditLogRepository) Create(entry *AuditLog) error {
-	query := `
-		INSERT INTO audit_log (timestamp, action, message_id, user_id, details, ip_address, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)`
-
 	now := time.Now()
 	entry.Timestamp = now
 	entry.CreatedAt = now
 
-	result, err := r.db.Exec(query, entry.Timestamp, entry.Action, entry.MessageID, entry.UserID, entry.Details, entry.IPAddress, entry.CreatedAt)
+	return NewTxManager(r.db).WithTransaction(func(tx *sql.Tx) error {
+		return insertAuditLog(tx, entry)
+	})
+}
+
+// AuditLogFilter selects audit log entries. Empty fields do not filter.
+type AuditLogFilter struct {
+	UserID    string
+	Action    string
+	MessageID string
+	IPAddress string
+	StartTime *time.Time
+	EndTime   *time.Time
+
+	// Success selects succeeded (true) or failed (false) entries. An entry failed when its
+	// action ends in "_failed" or its details record a "failure" result.
+	Success *bool
+}
+
+// auditFailedCondition is true for failed entries; details written by older code may not
+// be JSON, so they are checked with json_valid first
+const auditFailedCondition = `(action LIKE '%\_failed' ESCAPE '\' OR
+	(json_valid(details) AND json_extract(details, '$.result') = 'failure'))`
+
+// where builds the WHERE clause for the filter
+func (f *AuditLogFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	add := func(column, value string) {
+		if value != "" {
+			conditions = append(conditions, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	add("user_id", f.UserID)
+	add("action", f.Action)
+	add("message_id", f.MessageID)
+	add("ip_address", f.IPAddress)
+
+	// Timestamps are stored as text in local time, so bounds are compared in local time too
+	if f.StartTime != nil {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, f.StartTime.Local())
+	}
+	if f.EndTime != nil {
+		conditions = append(conditions, "timestamp <= ?")
+		args = append(args, f.EndTime.Local())
+	}
+	if f.Success != nil {
+		if *f.Success {
+			conditions = append(conditions, "NOT "+auditFailedCondition)
+		} else {
+			conditions = append(conditions, auditFailedCondition)
+		}
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// Query retrieves filtered audit log entries, newest first
+func (r *AuditLogRepository) Query(filter AuditLogFilter, limit, offset int) ([]AuditLog, error) {
+	where, args := filter.where()
+	query := `
+		SELECT id, timestamp, action, message_id, user_id, details, ip_address, created_at, prev_hash, entry_hash
+		FROM audit_log` + where + " ORDER BY timestamp DESC, id DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	id, err := result.LastInsertId()
+	var entries []AuditLog
+	for rows.Next() {
+		var entry AuditLog
+		err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.Action, &entry.MessageID, &entry.UserID, &entry.Details, &entry.IPAddress, &entry.CreatedAt, &entry.PrevHash, &entry.EntryHash)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// Count returns the number of audit log entries matching the filter
+func (r *AuditLogRepository) Count(filter AuditLogFilter) (int, error) {
+	where, args := filter.where()
+
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM audit_log"+where, args...).Scan(&count)
+	return count, err
+}
+
+// Each calls fn for every matching entry in chain order without loading them all into
+// memory. Iteration stops at the first error from fn or when ctx is cancelled.
+func (r *AuditLogRepository) Each(ctx context.Context, filter AuditLogFilter, fn func(*AuditLog) error) error {
+	where, args := filter.where()
+	query := `
+		SELECT id, timestamp, action, message_id, user_id, details, ip_address, created_at, prev_hash, entry_hash
+		FROM audit_log` + where + " ORDER BY id"
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
 
-	entry.ID = id
-	return nil
+	for rows.Next() {
+		var entry AuditLog
+		err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.Action, &entry.MessageID, &entry.UserID, &entry.Details, &entry.IPAddress, &entry.CreatedAt, &entry.PrevHash, &entry.EntryHash)
+		if err != nil {
+			return err
+		}
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// ListChain returns entries with an ID greater than afterID in chain order
+func (r *AuditLogRepository) ListChain(afterID int64, limit int) ([]AuditLog, error) {
+	query := `
+		SELECT id, timestamp, action, message_id, user_id, details, ip_address, created_at, prev_hash, entry_hash
+		FROM audit_log WHERE id > ? ORDER BY id LIMIT ?`
+
+	rows, err := r.db.Query(query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditLog
+	for rows.Next() {
+		var entry AuditLog
+		err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.Action, &entry.MessageID, &entry.UserID, &entry.Details, &entry.IPAddress, &entry.CreatedAt, &entry.PrevHash, &entry.EntryHash)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// LastUnsealedID returns the ID of the last entry written before the log was
+// hash-chained, or 0 if there was none
+func (r *AuditLogRepository) LastUnsealedID() (int64, error) {
+	var id int64
+	err := r.db.QueryRow("SELECT last_unsealed_id FROM audit_chain_state WHERE id = 1").Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// GetByID retrieves an audit log entry
+func (r *AuditLogRepository) GetByID(id int64) (*AuditLog, error) {
+	query := `
+		SELECT id, timestamp, action, message_id, user_id, details, ip_address, created_at, prev_hash, entry_hash
+		FROM audit_log WHERE id = ?`
+
+	var entry AuditLog
+	err := r.db.QueryRow(query, id).Scan(&entry.ID, &entry.Timestamp, &entry.Action, &entry.MessageID, &entry.UserID, &entry.Details, &entry.IPAddress, &entry.CreatedAt, &entry.PrevHash, &entry.EntryHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
 }
 
 // List retrieves audit log entries with pagination
 func (r *AuditLogRepository) List(limit, offset int, action, userID string) ([]AuditLog, error) {
 	query := `
-		SELECT id, timestamp, action, message_id, user_id, details, ip_address, created_at
+		SELECT id, timestamp, action, message_id, user_id, details, ip_address, created_at, prev_hash, entry_hash
 		FROM audit_log`
 
 	var conditions []string
@@ -543,7 +726,7 @@ func (r *AuditLogRepository) List(limit, offset int, action, userID string) ([]A
 	var entries []AuditLog
 	for rows.Next() {
 		var entry AuditLog
-		err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.Action, &entry.MessageID, &entry.UserID, &entry.Details, &entry.IPAddress, &entry.CreatedAt)
+		err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.Action, &entry.MessageID, &entry.UserID, &entry.Details, &entry.IPAddress, &entry.CreatedAt, &entry.PrevHash, &entry.EntryHash)
 		if err != nil {
 			return nil, err
 		}
@@ -563,28 +746,37 @@ func NewQueueSnapshotRepository(db *DB) *QueueSnapshotRepository {
 	return &QueueSnapshotRepository{Repository: NewRepository(db)}
 }
 
-// Create inserts a new queue snapshot
+// Create inserts a new queue snapshot and adds it to the hourly and daily rollups.
+// The snapshot is timestamped now unless a timestamp is already set.
 func (r *QueueSnapshotRepository) Create(snapshot *QueueSnapshot) error {
 	query := `
 		INSERT INTO queue_snapshots (timestamp, total_messages, deferred_messages, frozen_messages, oldest_message_age, created_at)
 		VALUES (?, ?, ?, ?, ?, ?)`
 
 	now := time.Now()
-	snapshot.Timestamp = now
+	if snapshot.Timestamp.IsZero() {
+		snapshot.Timestamp = now
+	}
 	snapshot.CreatedAt = now
 
-	result, err := r.db.Exec(query, snapshot.Timestamp, snapshot.TotalMessages, snapshot.DeferredMessages, snapshot.FrozenMessages, snapshot.OldestMessageAge, snapshot.CreatedAt)
-	if err != nil {
-		return err
-	}
+	return NewTxManager(r.db).WithTransaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec(query, snapshot.Timestamp, snapshot.TotalMessages, snapshot.DeferredMessages, snapshot.FrozenMessages, snapshot.OldestMessageAge, snapshot.CreatedAt)
+		if err != nil {
+			return err
+		}
 
-	id, err := result.LastInsertId()
-	if err != nil {
-		return err
-	}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
 
-	snapshot.ID = id
-	return nil
+		if err := addToSnapshotRollups(tx, snapshot); err != nil {
+			return fmt.Errorf("failed to update queue snapshot rollups: %w", err)
+		}
+
+		snapshot.ID = id
+		return nil
+	})
 }
 
 // GetLatest retrieves the most recent queue snapshot
@@ -670,6 +862,9 @@ type MessageTraceRepository struct {
 	deliveryAttemptRepo *DeliveryAttemptRepository
 	logEntryRepo        *LogEntryRepository
 	auditLogRepo        *AuditLogRepository
+	messageSource       MessageSource
+	contentOptions      ContentPreviewOptions
+	retryPolicy         RetryPolicy
 }
 
 // NewMessageTraceRepository creates a new message trace repository
@@ -681,9 +876,26 @@ func NewMessageTraceRepository(db *DB) *MessageTraceRepository {
 		deliveryAttemptRepo: NewDeliveryAttemptRepository(db),
 		logEntryRepo:        NewLogEntryRepository(db),
 		auditLogRepo:        NewAuditLogRepository(db),
+		contentOptions:      DefaultContentPreviewOptions(),
 	}
 }
 
+// SetMessageSource sets where raw message content is read from for previews
+func (r *MessageTraceRepository) SetMessageSource(source MessageSource) {
+	r.messageSource = source
+}
+
+// SetRetryPolicy sets the policy used to predict retry schedules. Without one the
+// schedule is a rough estimate.
+func (r *MessageTraceRepository) SetRetryPolicy(policy RetryPolicy) {
+	r.retryPolicy = policy
+}
+
+// SetContentPreviewOptions overrides the content preview limits and redaction
+func (r *MessageTraceRepository) SetContentPreviewOptions(opts ContentPreviewOptions) {
+	r.contentOptions = opts
+}
+
 // GetMessageDeliveryTrace generates a comprehensive delivery trace for a message
 func (r *MessageTraceRepository) GetMessageDeliveryTrace(messageID string) (*MessageDeliveryTrace, error) {
 	// Get message details
@@ -855,12 +1067,25 @@ func (r *MessageTraceRepository) buildDeliveryTimeline(logEntries []LogEntry, at
 	return timeline
 }
 
-// buildRetrySchedule creates estimated retry schedule for deferred messages
+// buildRetrySchedule creates the retry schedule for deferred recipients. With a retry
+// policy the times follow the Exim retry rules, and the recipient statuses are updated
+// with the predicted next attempt and bounce deadline.
 func (r *MessageTraceRepository) buildRetrySchedule(recipientStatuses []RecipientDeliveryStatus, attempts []DeliveryAttempt) []RetryScheduleEntry {
 	var schedule []RetryScheduleEntry
+	now := time.Now()
 
-	for _, status := range recipientStatuses {
-		if status.Status == RecipientStatusDeferred && status.NextRetryAt != nil {
+	for i := range recipientStatuses {
+		status := &recipientStatuses[i]
+		if status.Status != RecipientStatusDeferred {
+			continue
+		}
+
+		if prediction := r.predictRetries(status, now); prediction != nil {
+			schedule = append(schedule, applyRetryPrediction(status, prediction)...)
+			continue
+		}
+
+		if status.NextRetryAt != nil {
 			entry := RetryScheduleEntry{
 				Recipient:     status.Recipient,
 				ScheduledAt:   *status.NextRetryAt,
@@ -880,6 +1105,76 @@ func (r *MessageTraceRepository) buildRetrySchedule(recipientStatuses []Recipien
 	return schedule
 }
 
+// predictRetries asks the retry policy for the schedule of a deferred recipient
+func (r *MessageTraceRepository) predictRetries(status *RecipientDeliveryStatus, now time.Time) *RetryPrediction {
+	if r.retryPolicy == nil {
+		return nil
+	}
+
+	var failures []time.Time
+	for _, attempt := range status.DeliveryHistory {
+		if attempt.Status == AttemptStatusDefer {
+			failures = append(failures, attempt.Timestamp)
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Before(failures[j]) })
+
+	var errorText string
+	if status.LastSMTPCode != nil {
+		errorText = *status.LastSMTPCode + " "
+	}
+	if status.LastErrorText != nil {
+		errorText += *status.LastErrorText
+	}
+
+	prediction, err := r.retryPolicy.PredictRetries(status.Recipient, errorText, failures, now)
+	if err != nil {
+		log.Printf("Failed to predict retries for %s: %v", status.Recipient, err)
+		return nil
+	}
+	return prediction
+}
+
+// applyRetryPrediction records a prediction on the recipient status and returns its
+// schedule entries
+func applyRetryPrediction(status *RecipientDeliveryStatus, prediction *RetryPrediction) []RetryScheduleEntry {
+	status.NextRetryAt = nil
+	status.BounceDeadline = prediction.BounceAt
+	if prediction.Rule != "" {
+		rule := prediction.Rule
+		status.RetryRule = &rule
+	}
+
+	var entries []RetryScheduleEntry
+	for n, attempt := range prediction.Attempts {
+		entry := RetryScheduleEntry{
+			Recipient:     status.Recipient,
+			ScheduledAt:   attempt.At,
+			AttemptNumber: status.AttemptCount + n + 1,
+			Reason:        attempt.Reason,
+			Rule:          prediction.Rule,
+			IsFinal:       prediction.BounceAt != nil && n == len(prediction.Attempts)-1,
+			IsEstimated:   true,
+		}
+		if !attempt.Earliest.Equal(attempt.Latest) {
+			earliest, latest := attempt.Earliest, attempt.Latest
+			entry.EarliestAt = &earliest
+			entry.LatestAt = &latest
+		}
+		if n == 0 {
+			next := attempt.At
+			status.NextRetryAt = &next
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
 // calculateDeliveryTraceSummary calculates summary statistics for the trace
 func (r *MessageTraceRepository) calculateDeliveryTraceSummary(recipientStatuses []RecipientDeliveryStatus, attempts []DeliveryAttempt, message *Message) DeliveryTraceSummary {
 	summary := DeliveryTraceSummary{
@@ -1385,26 +1680,18 @@ func (r *MessageTraceRepository) getCorrelatedIncidents(messageID string) []Corr
 	return []CorrelatedIncident{}
 }
 
-// GetMessageContent retrieves safe message content preview
+// GetMessageContent retrieves a safe MIME-decoded preview of a queued message
 func (r *MessageTraceRepository) GetMessageContent(messageID string) (*MessageContent, error) {
-	// This is a placeholder implementation
-	// In a real system, this would safely parse and preview message content
-	// from the Exim spool files
-
-	content := &MessageContent{
-		MessageID:      messageID,
-		Headers:        make(map[string]string),
-		Attachments:    []MessageAttachment{},
-		ContentSafe:    true,
-		SizeBytes:      0,
-		PreviewLimited: false,
+	if r.messageSource == nil {
+		return nil, fmt.Errorf("message content source not configured")
 	}
 
-	// Add warning that this is not implemented
-	textContent := "Message content preview is not yet implemented. This would require parsing Exim spool files safely."
-	content.TextContent = &textContent
+	raw, size, truncated, err := r.messageSource.ReadMessageSource(messageID, r.contentOptions.MaxMessageBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message %s: %w", messageID, err)
+	}
 
-	return content, nil
+	return ParseMessageContent(messageID, raw, size, truncated, r.contentOptions)
 }
 
 // UserRepository handles user-related database operations
@@ -1420,11 +1707,11 @@ func NewUserRepository(db *DB) *UserRepository {
 // Create inserts a new user
 func (r *UserRepository) Create(user *User) error {
 	query := `
-		INSERT INTO users (username, password_hash, email, full_name, is_active, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		INSERT INTO users (username, password_hash, email, full_name, role, is_active, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 	`
 
-	result, err := r.db.Exec(query, user.Username, user.PasswordHash, user.Email, user.FullName, user.IsActive)
+	result, err := r.db.Exec(query, user.Username, user.PasswordHash, user.Email, user.FullName, user.Role, user.IsActive)
 	if err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
 	}
@@ -1441,7 +1728,7 @@ func (r *UserRepository) Create(user *User) error {
 // GetByUsername retrieves a user by username
 func (r *UserRepository) GetByUsername(username string) (*User, error) {
 	query := `
-		SELECT id, username, password_hash, email, full_name, is_active, last_login_at, created_at, updated_at
+		SELECT id, username, password_hash, email, full_name, COALESCE(role, ''), is_active, last_login_at, created_at, updated_at
 		FROM users
 		WHERE username = ? AND is_active = 1
 	`
@@ -1449,7 +1736,7 @@ func (r *UserRepository) GetByUsername(username string) (*User, error) {
 	var user User
 	err := r.db.QueryRow(query, username).Scan(
 		&user.ID, &user.Username, &user.PasswordHash, &user.Email, &user.FullName,
-		&user.IsActive, &user.LastLoginAt, &user.CreatedAt, &user.UpdatedAt,
+		&user.Role, &user.IsActive, &user.LastLoginAt, &user.CreatedAt, &user.UpdatedAt,
 	)
 
 	if err != nil {
@@ -1465,7 +1752,7 @@ func (r *UserRepository) GetByUsername(username string) (*User, error) {
 // GetByID retrieves a user by ID
 func (r *UserRepository) GetByID(id int64) (*User, error) {
 	query := `
-		SELECT id, username, password_hash, email, full_name, is_active, last_login_at, created_at, updated_at
+		SELECT id, username, password_hash, email, full_name, COALESCE(role, ''), is_active, last_login_at, created_at, updated_at
 		FROM users
 		WHERE id = ? AND is_active = 1
 	`
@@ -1473,7 +1760,7 @@ func (r *UserRepository) GetByID(id int64) (*User, error) {
 	var user User
 	err := r.db.QueryRow(query, id).Scan(
 		&user.ID, &user.Username, &user.PasswordHash, &user.Email, &user.FullName,
-		&user.IsActive, &user.LastLoginAt, &user.CreatedAt, &user.UpdatedAt,
+		&user.Role, &user.IsActive, &user.LastLoginAt, &user.CreatedAt, &user.UpdatedAt,
 	)
 
 	if err != nil {
@@ -1502,6 +1789,163 @@ func (r *UserRepository) UpdateLastLogin(userID int64) error {
 	return nil
 }
 
+// List retrieves all users ordered by username, optionally including disabled accounts
+func (r *UserRepository) List(includeInactive bool) ([]User, error) {
+	query := `
+		SELECT id, username, password_hash, email, full_name, COALESCE(role, ''), is_active, last_login_at, created_at, updated_at
+		FROM users
+	`
+	if !includeInactive {
+		query += " WHERE is_active = 1"
+	}
+	query += " ORDER BY username"
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(
+			&user.ID, &user.Username, &user.PasswordHash, &user.Email, &user.FullName,
+			&user.Role, &user.IsActive, &user.LastLoginAt, &user.CreatedAt, &user.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// GetByIDAnyStatus retrieves a user by ID whether or not the account is active
+func (r *UserRepository) GetByIDAnyStatus(id int64) (*User, error) {
+	query := `
+		SELECT id, username, password_hash, email, full_name, COALESCE(role, ''), is_active, last_login_at, created_at, updated_at
+		FROM users
+		WHERE id = ?
+	`
+
+	var user User
+	err := r.db.QueryRow(query, id).Scan(
+		&user.ID, &user.Username, &user.PasswordHash, &user.Email, &user.FullName,
+		&user.Role, &user.IsActive, &user.LastLoginAt, &user.CreatedAt, &user.UpdatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return &user, nil
+}
+
+// GetByUsernameAnyStatus retrieves a user by username whether or not the account is active
+func (r *UserRepository) GetByUsernameAnyStatus(username string) (*User, error) {
+	var id int64
+	err := r.db.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return r.GetByIDAnyStatus(id)
+}
+
+// UpdateProfile updates a user's email and full name
+func (r *UserRepository) UpdateProfile(userID int64, email, fullName *string) error {
+	query := `
+		UPDATE users
+		SET email = ?, full_name = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	return r.execUserUpdate(query, email, fullName, userID)
+}
+
+// UpdatePassword replaces a user's password hash
+func (r *UserRepository) UpdatePassword(userID int64, passwordHash string) error {
+	query := `
+		UPDATE users
+		SET password_hash = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	return r.execUserUpdate(query, passwordHash, userID)
+}
+
+// SetActive enables or disables a user account
+func (r *UserRepository) SetActive(userID int64, active bool) error {
+	query := `
+		UPDATE users
+		SET is_active = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	return r.execUserUpdate(query, active, userID)
+}
+
+// CountActiveByRole counts active users holding a role
+func (r *UserRepository) CountActiveByRole(role string) (int, error) {
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ? AND is_active = 1", role).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return count, nil
+}
+
+// execUserUpdate runs an UPDATE against a single user and reports a missing user
+func (r *UserRepository) execUserUpdate(query string, args ...interface{}) error {
+	result, err := r.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
+// UpdateRole changes the role of a user
+func (r *UserRepository) UpdateRole(userID int64, role string) error {
+	query := `
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	result, err := r.db.Exec(query, role, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update user role: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
 // SessionRepository handles session-related database operations
 type SessionRepository struct {
 	*Repository
@@ -1515,11 +1959,11 @@ func NewSessionRepository(db *DB) *SessionRepository {
 // Create inserts a new session
 func (r *SessionRepository) Create(session *Session) error {
 	query := `
-		INSERT INTO sessions (id, user_id, expires_at, ip_address, user_agent, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		INSERT INTO sessions (id, user_id, expires_at, ip_address, user_agent, csrf_token, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 	`
 
-	_, err := r.db.Exec(query, session.ID, session.UserID, session.ExpiresAt, session.IPAddress, session.UserAgent)
+	_, err := r.db.Exec(query, session.ID, session.UserID, session.ExpiresAt, session.IPAddress, session.UserAgent, session.CSRFToken)
 	if err != nil {
 		return fmt.Errorf("failed to create session: %w", err)
 	}
@@ -1530,7 +1974,7 @@ func (r *SessionRepository) Create(session *Session) error {
 // GetByID retrieves a session by ID
 func (r *SessionRepository) GetByID(id string) (*Session, error) {
 	query := `
-		SELECT id, user_id, expires_at, ip_address, user_agent, created_at, updated_at
+		SELECT id, user_id, expires_at, ip_address, user_agent, created_at, updated_at, csrf_token
 		FROM sessions
 		WHERE id = ? AND expires_at > CURRENT_TIMESTAMP
 	`
@@ -1538,7 +1982,7 @@ func (r *SessionRepository) GetByID(id string) (*Session, error) {
 	var session Session
 	err := r.db.QueryRow(query, id).Scan(
 		&session.ID, &session.UserID, &session.ExpiresAt, &session.IPAddress,
-		&session.UserAgent, &session.CreatedAt, &session.UpdatedAt,
+		&session.UserAgent, &session.CreatedAt, &session.UpdatedAt, &session.CSRFToken,
 	)
 
 	if err != nil {
@@ -1551,6 +1995,15 @@ func (r *SessionRepository) GetByID(id string) (*Session, error) {
 	return &session, nil
 }
 
+// SetCSRFToken stores the CSRF token of a session
+func (r *SessionRepository) SetCSRFToken(id, token string) error {
+	_, err := r.db.Exec("UPDATE sessions SET csrf_token = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", token, id)
+	if err != nil {
+		return fmt.Errorf("failed to set CSRF token: %w", err)
+	}
+	return nil
+}
+
 // Delete removes a session by ID
 func (r *SessionRepository) Delete(id string) error {
 	query := "DELETE FROM sessions WHERE id = ?"
@@ -1600,3 +2053,509 @@ func (r *SessionRepository) DeleteByUserID(userID int64) error {
 
 	return nil
 }
+
+// APITokenRepository handles API token database operations
+type APITokenRepository struct {
+	*Repository
+}
+
+// NewAPITokenRepository creates a new API token repository
+func NewAPITokenRepository(db *DB) *APITokenRepository {
+	return &APITokenRepository{Repository: NewRepository(db)}
+}
+
+const apiTokenColumns = `id, user_id, name, token_prefix, token_hash, scopes, expires_at,
+		last_used_at, last_used_ip, revoked_at, created_at`
+
+// Create inserts a new API token
+func (r *APITokenRepository) Create(token *APIToken) error {
+	query := `
+		INSERT INTO api_tokens (user_id, name, token_prefix, token_hash, scopes, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+
+	result, err := r.db.Exec(query, token.UserID, token.Name, token.TokenPrefix, token.TokenHash,
+		strings.Join(token.Scopes, ","), token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create API token: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get API token ID: %w", err)
+	}
+
+	token.ID = id
+	token.CreatedAt = time.Now()
+	return nil
+}
+
+// GetByHash retrieves a token by the hash of its secret, including revoked and expired tokens
+func (r *APITokenRepository) GetByHash(tokenHash string) (*APIToken, error) {
+	query := "SELECT " + apiTokenColumns + " FROM api_tokens WHERE token_hash = ?"
+
+	token, err := r.scanToken(r.db.QueryRow(query, tokenHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("API token not found")
+		}
+		return nil, fmt.Errorf("failed to get API token: %w", err)
+	}
+
+	return token, nil
+}
+
+// GetByID retrieves a token by ID
+func (r *APITokenRepository) GetByID(id int64) (*APIToken, error) {
+	query := "SELECT " + apiTokenColumns + " FROM api_tokens WHERE id = ?"
+
+	token, err := r.scanToken(r.db.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("API token not found")
+		}
+		return nil, fmt.Errorf("failed to get API token: %w", err)
+	}
+
+	return token, nil
+}
+
+// List returns tokens ordered by creation time. A zero userID lists tokens of all users.
+func (r *APITokenRepository) List(userID int64) ([]APIToken, error) {
+	query := "SELECT " + apiTokenColumns + " FROM api_tokens"
+	var args []interface{}
+	if userID > 0 {
+		query += " WHERE user_id = ?"
+		args = append(args, userID)
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		token, err := r.scanToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API token: %w", err)
+		}
+		tokens = append(tokens, *token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// UpdateLastUsed records when and from where a token was last used
+func (r *APITokenRepository) UpdateLastUsed(id int64, ipAddress string) error {
+	query := "UPDATE api_tokens SET last_used_at = ?, last_used_ip = ? WHERE id = ?"
+
+	if _, err := r.db.Exec(query, time.Now(), ipAddress, id); err != nil {
+		return fmt.Errorf("failed to update API token usage: %w", err)
+	}
+
+	return nil
+}
+
+// Revoke marks a token as revoked
+func (r *APITokenRepository) Revoke(id int64) error {
+	query := "UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL"
+
+	result, err := r.db.Exec(query, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API token: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("API token not found or already revoked")
+	}
+
+	return nil
+}
+
+// RevokeByUserID revokes all active tokens belonging to a user
+func (r *APITokenRepository) RevokeByUserID(userID int64) (int64, error) {
+	query := "UPDATE api_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL"
+
+	result, err := r.db.Exec(query, time.Now(), userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke user API tokens: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// scanToken scans a single api_tokens row
+func (r *APITokenRepository) scanToken(row interface{ Scan(...interface{}) error }) (*APIToken, error) {
+	var token APIToken
+	var scopes string
+	err := row.Scan(
+		&token.ID, &token.UserID, &token.Name, &token.TokenPrefix, &token.TokenHash, &scopes,
+		&token.ExpiresAt, &token.LastUsedAt, &token.LastUsedIP, &token.RevokedAt, &token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Scopes = []string{}
+	for _, scope := range strings.Split(scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			token.Scopes = append(token.Scopes, scope)
+		}
+	}
+
+	return &token, nil
+}
+
+// MFARepository handles TOTP secrets and recovery codes
+type MFARepository struct {
+	*Repository
+}
+
+// NewMFARepository creates a new MFA repository
+func NewMFARepository(db *DB) *MFARepository {
+	return &MFARepository{Repository: NewRepository(db)}
+}
+
+// GetTOTP retrieves the TOTP record of a user
+func (r *MFARepository) GetTOTP(userID int64) (*UserTOTP, error) {
+	query := `
+		SELECT user_id, secret, enabled, last_used_step, created_at, enabled_at
+		FROM user_totp
+		WHERE user_id = ?
+	`
+
+	var totp UserTOTP
+	err := r.db.QueryRow(query, userID).Scan(
+		&totp.UserID, &totp.Secret, &totp.Enabled, &totp.LastUsedStep, &totp.CreatedAt, &totp.EnabledAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("TOTP not configured")
+		}
+		return nil, fmt.Errorf("failed to get TOTP: %w", err)
+	}
+
+	return &totp, nil
+}
+
+// SavePendingTOTP stores a new, not yet enabled secret, replacing any earlier pending enrollment
+func (r *MFARepository) SavePendingTOTP(userID int64, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret, enabled, last_used_step, created_at)
+		VALUES (?, ?, 0, 0, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id) DO UPDATE SET
+			secret = excluded.secret, last_used_step = 0, created_at = CURRENT_TIMESTAMP
+		WHERE user_totp.enabled = 0
+	`
+
+	result, err := r.db.Exec(query, userID, secret)
+	if err != nil {
+		return fmt.Errorf("failed to save TOTP secret: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("TOTP is already enabled")
+	}
+
+	return nil
+}
+
+// EnableTOTP marks a pending enrollment as enabled
+func (r *MFARepository) EnableTOTP(userID int64) error {
+	query := "UPDATE user_totp SET enabled = 1, enabled_at = ? WHERE user_id = ?"
+
+	if _, err := r.db.Exec(query, time.Now(), userID); err != nil {
+		return fmt.Errorf("failed to enable TOTP: %w", err)
+	}
+
+	return nil
+}
+
+// UseTOTPStep records an accepted time step. It returns false when the step is not newer
+// than the last accepted one, which means the code is being replayed.
+func (r *MFARepository) UseTOTPStep(userID int64, step int64) (bool, error) {
+	query := "UPDATE user_totp SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?"
+
+	result, err := r.db.Exec(query, step, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to record TOTP use: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// DeleteTOTP removes a user's TOTP secret and recovery codes
+func (r *MFARepository) DeleteTOTP(userID int64) error {
+	return NewTxManager(r.db).WithTransaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+		if _, err := tx.Exec("DELETE FROM user_totp WHERE user_id = ?", userID); err != nil {
+			return fmt.Errorf("failed to delete TOTP: %w", err)
+		}
+		return nil
+	})
+}
+
+// ReplaceRecoveryCodes discards a user's recovery codes and stores the given hashes
+func (r *MFARepository) ReplaceRecoveryCodes(userID int64, codeHashes []string) error {
+	return NewTxManager(r.db).WithTransaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+
+		for _, hash := range codeHashes {
+			if _, err := tx.Exec(
+				"INSERT INTO user_recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)",
+				userID, hash,
+			); err != nil {
+				return fmt.Errorf("failed to store recovery code: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It returns false when no unused
+// code with the hash exists.
+func (r *MFARepository) UseRecoveryCode(userID int64, codeHash string) (bool, error) {
+	query := `
+		UPDATE user_recovery_codes SET used_at = ?
+		WHERE id = (
+			SELECT id FROM user_recovery_codes
+			WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+			LIMIT 1
+		)
+	`
+
+	result, err := r.db.Exec(query, time.Now(), userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// CountUnusedRecoveryCodes returns how many recovery codes a user has left
+func (r *MFARepository) CountUnusedRecoveryCodes(userID int64) (int, error) {
+	var count int
+	err := r.db.QueryRow(
+		"SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = ? AND used_at IS NULL", userID,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+
+	return count, nil
+}
+
+// LoginAttemptRepository handles login attempt records
+type LoginAttemptRepository struct {
+	*Repository
+}
+
+// NewLoginAttemptRepository creates a new login attempt repository
+func NewLoginAttemptRepository(db *DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{Repository: NewRepository(db)}
+}
+
+// Create records a login attempt
+func (r *LoginAttemptRepository) Create(attempt *LoginAttempt) error {
+	query := `
+		INSERT INTO login_attempts (username, ip_address, success, user_agent, timestamp)
+		VALUES (?, ?, ?, ?, ?)
+	`
+
+	if attempt.Timestamp.IsZero() {
+		attempt.Timestamp = time.Now()
+	}
+
+	result, err := r.db.Exec(query, attempt.Username, attempt.IPAddress, attempt.Success, attempt.UserAgent, attempt.Timestamp)
+	if err != nil {
+		return fmt.Errorf("failed to record login attempt: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get login attempt ID: %w", err)
+	}
+
+	attempt.ID = id
+	return nil
+}
+
+// Columns login attempts are grouped by when counting failures
+const (
+	LoginAttemptsByIP       = "ip_address"
+	LoginAttemptsByUsername = "username"
+)
+
+// LoginFailureCount is the number of recent failed login attempts of one IP address or
+// username
+type LoginFailureCount struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+}
+
+// FailureCounts counts the failed attempts since the given time that were not cleared,
+// grouped by column, which is LoginAttemptsByIP or LoginAttemptsByUsername. A non-empty
+// key counts only that IP address or username. With sinceSuccess, failures before the
+// last successful login of the same key are not counted.
+func (r *LoginAttemptRepository) FailureCounts(column, key string, since time.Time, sinceSuccess bool) ([]LoginFailureCount, error) {
+	if column != LoginAttemptsByIP && column != LoginAttemptsByUsername {
+		return nil, fmt.Errorf("invalid login attempt column: %s", column)
+	}
+
+	// Timestamps are stored as text in local time, so the bound is compared in local time too
+	conditions := []string{"f.success = 0", "f.cleared_at IS NULL", "f.timestamp > ?"}
+	args := []interface{}{since.Local()}
+	if key != "" {
+		conditions = append(conditions, "f."+column+" = ?")
+		args = append(args, key)
+	}
+	if sinceSuccess {
+		conditions = append(conditions, fmt.Sprintf(
+			"f.id > COALESCE((SELECT MAX(s.id) FROM login_attempts s WHERE s.%[1]s = f.%[1]s AND s.success = 1), 0)", column))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT g.login_key, g.failures, a.timestamp
+		FROM (
+			SELECT f.%s AS login_key, COUNT(*) AS failures, MAX(f.id) AS last_id
+			FROM login_attempts f
+			WHERE %s
+			GROUP BY f.%[1]s
+		) g
+		JOIN login_attempts a ON a.id = g.last_id
+		ORDER BY a.id DESC
+	`, column, strings.Join(conditions, " AND "))
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count failed login attempts: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []LoginFailureCount
+	for rows.Next() {
+		var count LoginFailureCount
+		if err := rows.Scan(&count.Key, &count.Failures, &count.LastFailure); err != nil {
+			return nil, fmt.Errorf("failed to scan failed login attempts: %w", err)
+		}
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
+
+// CountFailuresSince returns the number of failed login attempts since the given time
+func (r *LoginAttemptRepository) CountFailuresSince(since time.Time) (int, error) {
+	var count int
+	err := r.db.QueryRow(
+		"SELECT COUNT(*) FROM login_attempts WHERE success = 0 AND timestamp > ?", since.Local(),
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count failed login attempts: %w", err)
+	}
+
+	return count, nil
+}
+
+// ClearFailures marks the failed attempts of an IP address or username as cleared so
+// they no longer count towards a lockout. It returns the number of attempts cleared.
+func (r *LoginAttemptRepository) ClearFailures(column, key string) (int64, error) {
+	if column != LoginAttemptsByIP && column != LoginAttemptsByUsername {
+		return 0, fmt.Errorf("invalid login attempt column: %s", column)
+	}
+
+	result, err := r.db.Exec(
+		"UPDATE login_attempts SET cleared_at = ? WHERE "+column+" = ? AND success = 0 AND cleared_at IS NULL",
+		time.Now(), key,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear failed login attempts: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// LogFileOffsetRepository handles log file read offsets
+type LogFileOffsetRepository struct {
+	*Repository
+}
+
+// NewLogFileOffsetRepository creates a new log file offset repository
+func NewLogFileOffsetRepository(db *DB) *LogFileOffsetRepository {
+	return &LogFileOffsetRepository{Repository: NewRepository(db)}
+}
+
+// logFileOffsetUpsert inserts or replaces the offset of a log file
+const logFileOffsetUpsert = `
+	INSERT INTO log_file_offsets (path, fingerprint, position, updated_at)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(path) DO UPDATE SET fingerprint = excluded.fingerprint, position = excluded.position, updated_at = excluded.updated_at`
+
+// Get retrieves the offset of a log file, or nil if it was never read
+func (r *LogFileOffsetRepository) Get(path string) (*LogFileOffset, error) {
+	var offset LogFileOffset
+	err := r.db.QueryRow("SELECT path, fingerprint, position, updated_at FROM log_file_offsets WHERE path = ?", path).
+		Scan(&offset.Path, &offset.Fingerprint, &offset.Position, &offset.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &offset, nil
+}
+
+// Save inserts or updates the offset of a log file
+func (r *LogFileOffsetRepository) Save(offset *LogFileOffset) error {
+	offset.UpdatedAt = time.Now()
+	_, err := r.db.Exec(logFileOffsetUpsert, offset.Path, offset.Fingerprint, offset.Position, offset.UpdatedAt)
+	return err
+}
+
+// List retrieves the offsets of all log files
+func (r *LogFileOffsetRepository) List() ([]LogFileOffset, error) {
+	rows, err := r.db.Query("SELECT path, fingerprint, position, updated_at FROM log_file_offsets ORDER BY path")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var offsets []LogFileOffset
+	for rows.Next() {
+		var offset LogFileOffset
+		if err := rows.Scan(&offset.Path, &offset.Fingerprint, &offset.Position, &offset.UpdatedAt); err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, offset)
+	}
+
+	return offsets, rows.Err()
+}
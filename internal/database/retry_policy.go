@@ -0,0 +1,35 @@
+package database
+
+import "time"
+
+// RetryPolicy predicts when Exim will next try a deferred address, normally from the
+// retry rules of the Exim configuration
+type RetryPolicy interface {
+	// PredictRetries returns the attempts Exim will make for an address after a temporary
+	// error, given the times of its deferrals so far, oldest first. It returns nil when
+	// no prediction can be made.
+	PredictRetries(address, errorText string, failures []time.Time, now time.Time) (*RetryPrediction, error)
+}
+
+// RetryPrediction is the predicted retry schedule of one address
+type RetryPrediction struct {
+	// Rule is the matching retry rule as written in the configuration. It is empty when
+	// no rule matches, in which case Exim treats the temporary error as permanent.
+	Rule string
+
+	// Attempts are the predicted attempts in order. If BounceAt is set the last one is
+	// the attempt at which the address bounces.
+	Attempts []PredictedAttempt
+
+	// BounceAt is when the address times out and is bounced
+	BounceAt *time.Time
+}
+
+// PredictedAttempt is one predicted delivery attempt. Randomised (H) rules give a
+// window rather than a time, in which case At is its middle.
+type PredictedAttempt struct {
+	At       time.Time
+	Earliest time.Time
+	Latest   time.Time
+	Reason   string
+}
@@ -55,7 +55,30 @@ CREATE TABLE IF NOT EXISTS log_entries (
     error_code TEXT,
     error_text TEXT,
     raw_line TEXT NOT NULL,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    message_id_header TEXT, -- id=
+    protocol TEXT, -- P=
+    tls_cipher TEXT, -- X=
+    tls_verify TEXT, -- CV=
+    tls_peer_dn TEXT, -- DN=
+    confirmation TEXT, -- C=
+    queue_time REAL, -- QT= in seconds
+    delivery_time REAL, -- DT= in seconds
+    chunking BOOLEAN NOT NULL DEFAULT 0,
+    prdr BOOLEAN NOT NULL DEFAULT 0,
+    source_fingerprint TEXT, -- first line hash of the file the line was read from
+    source_offset INTEGER, -- byte offset of the line in that file
+    router TEXT, -- R=
+    transport TEXT, -- T=
+    pid INTEGER -- [pid] prefix written with log_selector +pid
+);
+
+-- Read offsets of monitored log files
+CREATE TABLE IF NOT EXISTS log_file_offsets (
+    path TEXT PRIMARY KEY,
+    fingerprint TEXT NOT NULL, -- hash of the first line
+    position INTEGER NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 );
 
 -- Audit log for administrative actions
@@ -67,9 +90,18 @@ CREATE TABLE IF NOT EXISTS audit_log (
     user_id TEXT,
     details TEXT, -- JSON
     ip_address TEXT,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    prev_hash TEXT, -- entry_hash of the preceding entry
+    entry_hash TEXT -- SHA-256 over this entry and prev_hash
 );
 
+-- ID of the last entry written before the audit log was hash-chained
+CREATE TABLE IF NOT EXISTS audit_chain_state (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    last_unsealed_id INTEGER NOT NULL DEFAULT 0
+);
+INSERT OR IGNORE INTO audit_chain_state (id, last_unsealed_id) VALUES (1, 0);
+
 -- Queue snapshots for historical tracking
 CREATE TABLE IF NOT EXISTS queue_snapshots (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -109,6 +141,9 @@ CREATE INDEX IF NOT EXISTS idx_log_entries_message_id ON log_entries(message_id)
 CREATE INDEX IF NOT EXISTS idx_log_entries_event ON log_entries(event);
 CREATE INDEX IF NOT EXISTS idx_log_entries_log_type ON log_entries(log_type);
 CREATE INDEX IF NOT EXISTS idx_log_entries_sender ON log_entries(sender);
+CREATE INDEX IF NOT EXISTS idx_log_entries_message_id_header ON log_entries(message_id_header);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_log_entries_source ON log_entries(source_fingerprint, source_offset);
+CREATE INDEX IF NOT EXISTS idx_log_entries_pid ON log_entries(pid);
 -- Composite indexes for log search optimization
 CREATE INDEX IF NOT EXISTS idx_log_entries_timestamp_type ON log_entries(timestamp, log_type);
 CREATE INDEX IF NOT EXISTS idx_log_entries_timestamp_event ON log_entries(timestamp, event);
@@ -126,6 +161,22 @@ CREATE INDEX IF NOT EXISTS idx_audit_log_timestamp_action ON audit_log(timestamp
 
 CREATE INDEX IF NOT EXISTS idx_queue_snapshots_timestamp ON queue_snapshots(timestamp);
 
+-- Hourly and daily queue snapshot rollups, kept after retention trims queue_snapshots.
+-- Buckets are unix times in UTC.
+CREATE TABLE IF NOT EXISTS queue_snapshot_rollups (
+    period TEXT NOT NULL CHECK (period IN ('hour', 'day')),
+    bucket_start INTEGER NOT NULL,
+    samples INTEGER NOT NULL DEFAULT 0,
+    total_sum INTEGER NOT NULL DEFAULT 0,
+    total_max INTEGER NOT NULL DEFAULT 0,
+    deferred_sum INTEGER NOT NULL DEFAULT 0,
+    deferred_max INTEGER NOT NULL DEFAULT 0,
+    frozen_sum INTEGER NOT NULL DEFAULT 0,
+    frozen_max INTEGER NOT NULL DEFAULT 0,
+    oldest_age_max INTEGER,
+    PRIMARY KEY (period, bucket_start)
+);
+
 -- Message notes table for operator notes
 CREATE TABLE IF NOT EXISTS message_notes (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -166,6 +217,7 @@ CREATE TABLE IF NOT EXISTS users (
     password_hash TEXT NOT NULL,
     email TEXT,
     full_name TEXT,
+    role TEXT NOT NULL DEFAULT 'viewer',
     is_active BOOLEAN NOT NULL DEFAULT 1,
     last_login_at DATETIME,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
@@ -181,6 +233,7 @@ CREATE TABLE IF NOT EXISTS sessions (
     user_agent TEXT,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    csrf_token TEXT, -- sent back in X-CSRF-Token by the browser on state-changing requests
     FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 );
 
@@ -192,4 +245,90 @@ CREATE INDEX IF NOT EXISTS idx_users_created_at ON users(created_at);
 CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
 CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
 CREATE INDEX IF NOT EXISTS idx_sessions_created_at ON sessions(created_at);
+
+-- API tokens for automation clients; only a SHA-256 hash of each token is stored
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    token_prefix TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    expires_at DATETIME NOT NULL,
+    last_used_at DATETIME,
+    last_used_ip TEXT,
+    revoked_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_expires_at ON api_tokens(expires_at);
+
+-- TOTP second factor; a row with enabled = 0 is an enrollment awaiting confirmation
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id INTEGER PRIMARY KEY,
+    secret TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT 0,
+    last_used_step INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    enabled_at DATETIME,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Single-use recovery codes, stored hashed
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_recovery_codes_user_id ON user_recovery_codes(user_id);
+
+-- Alert rules created through the API; rules from the configuration file are not stored
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT,
+    metric TEXT NOT NULL,
+    event TEXT,
+    window_seconds INTEGER NOT NULL DEFAULT 0,
+    operator TEXT NOT NULL,
+    threshold REAL NOT NULL,
+    for_seconds INTEGER NOT NULL DEFAULT 0,
+    cooldown_seconds INTEGER NOT NULL DEFAULT 0,
+    repeat_interval_seconds INTEGER NOT NULL DEFAULT 0,
+    severity TEXT NOT NULL DEFAULT 'warning',
+    notifiers TEXT,
+    send_resolved BOOLEAN NOT NULL DEFAULT 1,
+    enabled BOOLEAN NOT NULL DEFAULT 1,
+    created_by TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- One row per alert, from firing until resolved
+CREATE TABLE IF NOT EXISTS alert_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    rule_name TEXT NOT NULL,
+    metric TEXT NOT NULL,
+    severity TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('firing', 'resolved')),
+    value REAL NOT NULL,
+    threshold REAL NOT NULL,
+    operator TEXT NOT NULL,
+    message TEXT NOT NULL,
+    fired_at DATETIME NOT NULL,
+    resolved_at DATETIME,
+    last_notified_at DATETIME,
+    notifications INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_history_rule_name ON alert_history(rule_name);
+CREATE INDEX IF NOT EXISTS idx_alert_history_status ON alert_history(status);
+CREATE INDEX IF NOT EXISTS idx_alert_history_fired_at ON alert_history(fired_at);
 `
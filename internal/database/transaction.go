@@ -3,6 +3,7 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"time"
 )
 
 // TxManager provides transaction management utilities
@@ -107,45 +108,48 @@ func (r *TxRepository) CreateDeliveryAttempt(attempt *DeliveryAttempt) error {
 
 // CreateLogEntry inserts a log entry within a transaction
 func (r *TxRepository) CreateLogEntry(entry *LogEntry) error {
+	_, err := r.CreateLogEntryIfNew(entry)
+	return err
+}
+
+// CreateLogEntryIfNew inserts a log entry within a transaction and reports whether it was
+// stored. An entry whose source position is already stored is skipped.
+func (r *TxRepository) CreateLogEntryIfNew(entry *LogEntry) (bool, error) {
 	// Marshal recipients to JSON
 	if err := entry.MarshalRecipients(); err != nil {
-		return fmt.Errorf("failed to marshal recipients: %w", err)
+		return false, fmt.Errorf("failed to marshal recipients: %w", err)
 	}
 
-	query := `
-		INSERT INTO log_entries (timestamp, message_id, log_type, event, host, sender, recipients, size, status, error_code, error_text, raw_line, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-
-	result, err := r.tx.Exec(query, entry.Timestamp, entry.MessageID, entry.LogType, entry.Event, entry.Host, entry.Sender, entry.RecipientsDB, entry.Size, entry.Status, entry.ErrorCode, entry.ErrorText, entry.RawLine, entry.CreatedAt)
-	if err != nil {
-		return err
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
 	}
 
-	id, err := result.LastInsertId()
+	result, err := r.tx.Exec(LogEntryInsertQuery, entry.InsertValues()...)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	entry.ID = id
-	return nil
-}
-
-// CreateAuditLog inserts an audit log entry within a transaction
-func (r *TxRepository) CreateAuditLog(entry *AuditLog) error {
-	query := `
-		INSERT INTO audit_log (timestamp, action, message_id, user_id, details, ip_address, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)`
-
-	result, err := r.tx.Exec(query, entry.Timestamp, entry.Action, entry.MessageID, entry.UserID, entry.Details, entry.IPAddress, entry.CreatedAt)
-	if err != nil {
-		return err
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
 	}
 
 	id, err := result.LastInsertId()
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	entry.ID = id
-	return nil
+	return true, nil
+}
+
+// SaveLogFileOffset records a log file read offset within a transaction
+func (r *TxRepository) SaveLogFileOffset(offset *LogFileOffset) error {
+	offset.UpdatedAt = time.Now()
+	_, err := r.tx.Exec(logFileOffsetUpsert, offset.Path, offset.Fingerprint, offset.Position, offset.UpdatedAt)
+	return err
+}
+
+// CreateAuditLog appends an audit log entry to the hash chain within a transaction
+func (r *TxRepository) CreateAuditLog(entry *AuditLog) error {
+	return insertAuditLog(r.tx, entry)
 }
@@ -2,7 +2,9 @@ package logmonitor
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"database/sql"
 	"fmt"
 	"io"
 	"log"
@@ -18,15 +20,25 @@ import (
 	"github.com/fsnotify/fsnotify"
 )
 
+const (
+	// ingestBatchSize is the number of lines stored per transaction while following a file
+	ingestBatchSize = 500
+
+	// pollInterval is how often files are checked in case a watcher event was missed
+	pollInterval = 5 * time.Second
+)
+
 // LogMonitor monitors Exim log files for changes and processes new entries
 type LogMonitor struct {
 	watcher         *fsnotify.Watcher
 	parser          *parser.EximParser
 	repository      *database.Repository
+	offsets         *database.LogFileOffsetRepository
 	logProcessor    LogProcessor
 	logPaths        []string
 	fileStates      map[string]*FileState
 	securityService *security.Service
+	ingestStats     map[string]*ingestStats
 	mu              sync.RWMutex
 	ctx             context.Context
 	cancel          context.CancelFunc
@@ -35,11 +47,37 @@ type LogMonitor struct {
 
 // FileState tracks the state of a monitored log file
 type FileState struct {
-	Path     string
-	Size     int64
-	ModTime  time.Time
-	Position int64
-	File     *os.File
+	Path        string
+	Size        int64
+	ModTime     time.Time
+	Position    int64
+	Fingerprint string
+	File        *os.File
+
+	// info identifies the open file so a rename of the path can be detected
+	info os.FileInfo
+}
+
+// ingestStats counts what has been ingested from one log path since the monitor started
+type ingestStats struct {
+	position    int64 // copy of FileState.Position that is safe to read from other goroutines
+	lines       int64
+	parseErrors int64
+	lastEntry   time.Time // log timestamp of the newest stored line, as stored
+}
+
+// FileStatus reports how far a monitored log file has been ingested
+type FileStatus struct {
+	Path        string
+	LogType     string
+	Size        int64 // current size of the file at Path
+	Position    int64 // offset up to which lines are stored
+	Lines       int64 // lines read since the monitor started
+	ParseErrors int64
+
+	// LastEntry is the local time logged on the newest stored line, zero if none
+	// has been stored since the monitor started
+	LastEntry time.Time
 }
 
 // Config holds configuration for the log monitor
@@ -57,13 +95,23 @@ func NewLogMonitor(config Config) (*LogMonitor, error) {
 
 	ctx, cancel := context.WithCancel(context.Background())
 
+	// The configured log directories are trusted even outside the Debian layout
+	securityService := security.NewService()
+	logPaths := make([]string, len(config.LogPaths))
+	for i, logPath := range config.LogPaths {
+		logPaths[i] = filepath.Clean(logPath)
+		securityService.AllowReadOnlyPath(filepath.Dir(logPaths[i]))
+	}
+
 	monitor := &LogMonitor{
 		watcher:         watcher,
 		parser:          parser.NewEximParser(),
 		repository:      config.Repository,
-		logPaths:        config.LogPaths,
+		offsets:         database.NewLogFileOffsetRepository(config.Repository.GetDB()),
+		logPaths:        logPaths,
 		fileStates:      make(map[string]*FileState),
-		securityService: security.NewService(),
+		securityService: securityService,
+		ingestStats:     make(map[string]*ingestStats),
 		ctx:             ctx,
 		cancel:          cancel,
 		done:            make(chan struct{}),
@@ -72,31 +120,46 @@ func NewLogMonitor(config Config) (*LogMonitor, error) {
 	return monitor, nil
 }
 
-// Start begins monitoring log files
+// Start begins monitoring log files. Files read before resume from their stored offset;
+// files seen for the first time are followed from their current end.
 func (m *LogMonitor) Start() error {
 	// Validate log paths
 	if err := m.validateLogPaths(); err != nil {
 		return fmt.Errorf("log path validation failed: %w", err)
 	}
 
-	// Initialize file states and add watchers
-	successCount := 0
+	// Watch the directories rather than the files so renames and re-creation are seen
+	watchedDirs := make(map[string]bool)
 	for _, logPath := range m.logPaths {
-		if err := m.addLogFile(logPath); err != nil {
-			log.Printf("Warning: failed to add log file %s: %v", logPath, err)
+		dir := filepath.Dir(logPath)
+		if watchedDirs[dir] {
 			continue
 		}
-		successCount++
+		if err := m.securityService.ValidateFileAccess(dir, security.AccessRead); err != nil {
+			log.Printf("SECURITY: Directory access denied for %s: %v", dir, err)
+			continue
+		}
+		if err := m.watcher.Add(dir); err != nil {
+			log.Printf("Warning: failed to watch log directory %s: %v", dir, err)
+			continue
+		}
+		watchedDirs[dir] = true
 	}
 
-	if successCount == 0 {
+	if len(watchedDirs) == 0 {
 		return fmt.Errorf("no log files could be monitored")
 	}
 
+	for _, logPath := range m.logPaths {
+		if err := m.addLogFile(logPath); err != nil {
+			log.Printf("Warning: failed to add log file %s: %v", logPath, err)
+		}
+	}
+
 	// Start the monitoring goroutine
 	go m.monitorLoop()
 
-	log.Printf("Log monitor started, watching %d files", len(m.fileStates))
+	log.Printf("Log monitor started, watching %d files", len(m.logPaths))
 	return nil
 }
 
@@ -128,10 +191,19 @@ func (m *LogMonitor) validateLogPaths() error {
 	return nil
 }
 
-// Stop stops the log monitor
+// Stop stops the log monitor. A batch being stored is finished first, so the stored
+// offsets always match the stored entries.
 func (m *LogMonitor) Stop() error {
 	m.cancel()
 
+	// Close the watcher
+	if err := m.watcher.Close(); err != nil {
+		return fmt.Errorf("failed to close watcher: %w", err)
+	}
+
+	// Wait for monitoring loop to finish
+	<-m.done
+
 	// Close all open files
 	m.mu.Lock()
 	for _, state := range m.fileStates {
@@ -141,73 +213,87 @@ func (m *LogMonitor) Stop() error {
 	}
 	m.mu.Unlock()
 
-	// Close the watcher
-	if err := m.watcher.Close(); err != nil {
-		return fmt.Errorf("failed to close watcher: %w", err)
-	}
-
-	// Wait for monitoring loop to finish
-	<-m.done
-
 	log.Println("Log monitor stopped")
 	return nil
 }
 
-// addLogFile adds a log file to the monitor
+// addLogFile opens a log file and positions it from its stored offset
 func (m *LogMonitor) addLogFile(logPath string) error {
+	if !fileExists(logPath) {
+		log.Printf("Log file %s does not exist, will monitor for creation", logPath)
+		return nil
+	}
+
 	// Validate file access with security service
 	if err := m.securityService.ValidateFileAccess(logPath, security.AccessRead); err != nil {
 		log.Printf("SECURITY: File access denied for %s: %v", logPath, err)
 		return fmt.Errorf("security validation failed for log file %s: %w", logPath, err)
 	}
 
-	// Check if file exists
-	info, err := os.Stat(logPath)
+	// Open file for reading
+	file, err := os.Open(logPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			log.Printf("Log file %s does not exist, will monitor for creation", logPath)
-			// Watch the directory for file creation (with security validation)
-			dir := filepath.Dir(logPath)
-			if err := m.securityService.ValidateFileAccess(dir, security.AccessRead); err != nil {
-				log.Printf("SECURITY: Directory access denied for %s: %v", dir, err)
-				return fmt.Errorf("security validation failed for log directory %s: %w", dir, err)
-			}
-			return m.watcher.Add(dir)
+			return nil
 		}
-		return fmt.Errorf("failed to stat log file %s: %w", logPath, err)
+		return fmt.Errorf("failed to open log file %s: %w", logPath, err)
 	}
 
-	// Open file for reading
-	file, err := os.Open(logPath)
+	info, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to open log file %s: %w", logPath, err)
+		file.Close()
+		return fmt.Errorf("failed to stat log file %s: %w", logPath, err)
+	}
+
+	state := &FileState{
+		Path:        logPath,
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		Fingerprint: fileFingerprint(file),
+		File:        file,
+		info:        info,
 	}
 
-	// Seek to end of file to only process new entries
-	position, err := file.Seek(0, io.SeekEnd)
+	stored, err := m.offsets.Get(logPath)
 	if err != nil {
 		file.Close()
-		return fmt.Errorf("failed to seek to end of file %s: %w", logPath, err)
+		return fmt.Errorf("failed to read stored offset for %s: %w", logPath, err)
 	}
 
-	// Create file state
-	m.mu.Lock()
-	m.fileStates[logPath] = &FileState{
-		Path:     logPath,
-		Size:     info.Size(),
-		ModTime:  info.ModTime(),
-		Position: position,
-		File:     file,
+	switch {
+	case stored == nil:
+		// Never read before: older content is left to historical imports
+		state.Position = info.Size() - partialLineLength(file, info.Size())
+	case stored.Fingerprint == "":
+		// The file had no complete line when the offset was stored
+		state.Position = 0
+	case stored.Fingerprint == state.Fingerprint && stored.Position <= info.Size():
+		state.Position = stored.Position
+	default:
+		// The file was rotated while the monitor was not running. Finish the old
+		// file first, then read the new one from the start.
+		if err := m.finishRotatedFile(logPath, stored); err != nil {
+			log.Printf("Warning: entries between offset %d of the previous %s and its rotation may be missing: %v", stored.Position, logPath, err)
+		}
+		state.Position = 0
 	}
-	m.mu.Unlock()
 
-	// Add to watcher
-	if err := m.watcher.Add(logPath); err != nil {
+	if err := m.saveOffset(state, state.Position); err != nil {
 		file.Close()
-		return fmt.Errorf("failed to add file to watcher %s: %w", logPath, err)
+		return fmt.Errorf("failed to store offset for %s: %w", logPath, err)
 	}
 
-	log.Printf("Added log file to monitor: %s (size: %d, position: %d)", logPath, info.Size(), position)
+	m.mu.Lock()
+	m.fileStates[logPath] = state
+	m.mu.Unlock()
+
+	log.Printf("Added log file to monitor: %s (size: %d, position: %d)", logPath, info.Size(), state.Position)
+
+	// Catch up on anything written while the monitor was not running
+	if err := m.processNewContent(state); err != nil {
+		log.Printf("Failed to process content of %s: %v", logPath, err)
+	}
 	return nil
 }
 
@@ -215,6 +301,9 @@ func (m *LogMonitor) addLogFile(logPath string) error {
 func (m *LogMonitor) monitorLoop() {
 	defer close(m.done)
 
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-m.ctx.Done():
@@ -231,196 +320,267 @@ func (m *LogMonitor) monitorLoop() {
 				return
 			}
 			log.Printf("File watcher error: %v", err)
+
+		case <-ticker.C:
+			for _, logPath := range m.logPaths {
+				m.syncFile(logPath)
+			}
 		}
 	}
 }
 
 // handleFileEvent processes file system events
 func (m *LogMonitor) handleFileEvent(event fsnotify.Event) {
-	// Check if this is a log file we're monitoring
-	isMonitoredFile := false
+	eventPath := filepath.Clean(event.Name)
 	for _, logPath := range m.logPaths {
-		if event.Name == logPath || strings.HasSuffix(event.Name, filepath.Base(logPath)) {
-			isMonitoredFile = true
-			break
+		if eventPath == logPath {
+			m.syncFile(logPath)
+			return
 		}
 	}
-
-	if !isMonitoredFile {
-		return
-	}
-
-	switch {
-	case event.Has(fsnotify.Write):
-		m.handleFileWrite(event.Name)
-	case event.Has(fsnotify.Create):
-		m.handleFileCreate(event.Name)
-	case event.Has(fsnotify.Remove):
-		m.handleFileRemove(event.Name)
-	case event.Has(fsnotify.Rename):
-		m.handleFileRename(event.Name)
-	}
 }
 
-// handleFileWrite processes file write events
-func (m *LogMonitor) handleFileWrite(filePath string) {
+// syncFile reads new content from a monitored file and follows it through rotation.
+// The open handle is always drained before switching, so lines written to the old
+// file just before it was renamed are not lost.
+func (m *LogMonitor) syncFile(logPath string) {
 	m.mu.RLock()
-	state, exists := m.fileStates[filePath]
+	state, exists := m.fileStates[logPath]
 	m.mu.RUnlock()
 
-	if !exists {
-		// File not being monitored, try to add it
-		if err := m.addLogFile(filePath); err != nil {
-			log.Printf("Failed to add new log file %s: %v", filePath, err)
+	if !exists || state.File == nil {
+		if !fileExists(logPath) {
+			return
+		}
+		if err := m.addLogFile(logPath); err != nil {
+			log.Printf("Failed to add log file %s: %v", logPath, err)
 		}
 		return
 	}
 
-	// Check if file was truncated (log rotation)
-	info, err := os.Stat(filePath)
-	if err != nil {
-		log.Printf("Failed to stat file %s: %v", filePath, err)
+	if err := m.processNewContent(state); err != nil {
+		log.Printf("Failed to process new content in %s: %v", logPath, err)
 		return
 	}
 
-	if info.Size() < state.Size {
-		log.Printf("Log rotation detected for %s, reopening file", filePath)
-		m.handleLogRotation(filePath)
+	info, err := os.Stat(logPath)
+	if err != nil {
+		// Renamed or removed, keep the old handle until a new file appears
 		return
 	}
 
-	// Process new content
-	if err := m.processNewContent(state); err != nil {
-		log.Printf("Failed to process new content in %s: %v", filePath, err)
-	}
-}
-
-// handleFileCreate processes file creation events
-func (m *LogMonitor) handleFileCreate(filePath string) {
-	// Check if this is a log file we should monitor
-	for _, logPath := range m.logPaths {
-		if filePath == logPath {
-			log.Printf("Log file created: %s", filePath)
-			if err := m.addLogFile(filePath); err != nil {
-				log.Printf("Failed to add created log file %s: %v", filePath, err)
-			}
-			break
+	switch {
+	case !os.SameFile(info, state.info):
+		log.Printf("Log rotation detected for %s, reopening file", logPath)
+		m.handleLogRotation(state)
+	case info.Size() < state.Position:
+		// Truncated in place (copytruncate): lines written since the last read are lost
+		log.Printf("Log file %s was truncated, reading from the start", logPath)
+		state.Position = 0
+		m.mu.Lock()
+		m.statsFor(logPath).position = 0
+		m.mu.Unlock()
+		state.Fingerprint = fileFingerprint(state.File)
+		state.info = info
+		if err := m.processNewContent(state); err != nil {
+			log.Printf("Failed to process new content in %s: %v", logPath, err)
 		}
 	}
 }
 
-// handleFileRemove processes file removal events
-func (m *LogMonitor) handleFileRemove(filePath string) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	if state, exists := m.fileStates[filePath]; exists {
-		log.Printf("Log file removed: %s", filePath)
-		if state.File != nil {
-			state.File.Close()
-		}
-		delete(m.fileStates, filePath)
+// handleLogRotation switches a drained state to the file now at its path
+func (m *LogMonitor) handleLogRotation(state *FileState) {
+	file, err := os.Open(state.Path)
+	if err != nil {
+		log.Printf("Failed to reopen rotated log file %s: %v", state.Path, err)
+		return
 	}
-}
 
-// handleFileRename processes file rename events (log rotation)
-func (m *LogMonitor) handleFileRename(filePath string) {
-	log.Printf("Log file renamed/rotated: %s", filePath)
-	m.handleLogRotation(filePath)
-}
+	info, err := file.Stat()
+	if err != nil {
+		log.Printf("Failed to stat reopened log file %s: %v", state.Path, err)
+		file.Close()
+		return
+	}
 
-// handleLogRotation handles log file rotation
-func (m *LogMonitor) handleLogRotation(filePath string) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	state.File.Close()
+	state.File = file
+	state.info = info
+	state.Size = info.Size()
+	state.ModTime = info.ModTime()
+	state.Position = 0
+	state.Fingerprint = fileFingerprint(file)
+	m.mu.Unlock()
 
-	state, exists := m.fileStates[filePath]
-	if !exists {
-		return
+	if err := m.saveOffset(state, 0); err != nil {
+		log.Printf("Failed to store offset for %s: %v", state.Path, err)
 	}
 
-	// Close the old file
-	if state.File != nil {
-		state.File.Close()
+	log.Printf("Reopened rotated log file: %s (new size: %d)", state.Path, info.Size())
+
+	if err := m.processNewContent(state); err != nil {
+		log.Printf("Failed to process new content in %s: %v", state.Path, err)
 	}
+}
 
-	// Check for rotated files (e.g., mainlog.1, mainlog.2.gz)
-	m.processRotatedFiles(filePath)
+// finishRotatedFile reads the remainder of a file that was rotated away from logPath
+// while the monitor was stopped. The rotated copy is found next to it by fingerprint.
+func (m *LogMonitor) finishRotatedFile(logPath string, stored *database.LogFileOffset) error {
+	rotatedPath, err := findRotatedFile(logPath, stored.Fingerprint)
+	if err != nil {
+		return err
+	}
 
-	// Try to open the new file
-	file, err := os.Open(filePath)
+	file, err := os.Open(rotatedPath)
 	if err != nil {
-		log.Printf("Failed to reopen rotated log file %s: %v", filePath, err)
-		// Don't delete the state, the file might be recreated
-		state.File = nil
-		return
+		return err
 	}
+	defer file.Close()
 
-	// Update state
-	info, err := os.Stat(filePath)
+	info, err := file.Stat()
 	if err != nil {
-		log.Printf("Failed to stat reopened log file %s: %v", filePath, err)
-		file.Close()
-		state.File = nil
-		return
+		return err
+	}
+	if stored.Position > info.Size() {
+		return fmt.Errorf("rotated file %s is shorter than the stored offset", rotatedPath)
 	}
 
-	state.File = file
-	state.Size = info.Size()
-	state.ModTime = info.ModTime()
-	state.Position = 0
+	log.Printf("Finishing rotated log file %s from offset %d", rotatedPath, stored.Position)
 
-	log.Printf("Reopened rotated log file: %s (new size: %d)", filePath, info.Size())
+	// The rotated file is complete, so a final line without a newline is read as well
+	state := &FileState{
+		Path:        logPath,
+		Position:    stored.Position,
+		Fingerprint: stored.Fingerprint,
+		File:        file,
+	}
+	return m.readLines(state, true)
 }
 
-// processRotatedFiles checks for and processes rotated log files
-func (m *LogMonitor) processRotatedFiles(originalPath string) {
-	dir := filepath.Dir(originalPath)
-	baseName := filepath.Base(originalPath)
+// findRotatedFile looks for an uncompressed rotated copy of logPath, such as mainlog.1,
+// mainlog.01 or mainlog-20240115, whose fingerprint matches
+func findRotatedFile(logPath, fingerprint string) (string, error) {
+	dir := filepath.Dir(logPath)
+	base := filepath.Base(logPath)
 
-	// Look for rotated files like mainlog.1, mainlog.2, etc.
-	for i := 1; i <= 5; i++ { // Check up to 5 rotated files
-		rotatedPath := filepath.Join(dir, fmt.Sprintf("%s.%d", baseName, i))
-		if _, err := os.Stat(rotatedPath); err == nil {
-			log.Printf("Found rotated log file: %s", rotatedPath)
-			// Process the rotated file if we haven't seen it before
-			// This is a simplified approach - in production you might want to track processed files
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == base {
+			continue
+		}
+		if !strings.HasPrefix(name, base+".") && !strings.HasPrefix(name, base+"-") {
+			continue
+		}
+		switch filepath.Ext(name) {
+		case ".gz", ".bz2", ".xz", ".zst":
+			continue
+		}
+
+		candidate := filepath.Join(dir, name)
+		file, err := os.Open(candidate)
+		if err != nil {
+			continue
+		}
+		matches := fileFingerprint(file) == fingerprint
+		file.Close()
+		if matches {
+			return candidate, nil
 		}
 	}
+
+	return "", fmt.Errorf("no uncompressed rotated copy of %s found", logPath)
 }
 
-// processNewContent reads and processes new content from a log file
+// processNewContent reads and processes the complete lines added to a log file
 func (m *LogMonitor) processNewContent(state *FileState) error {
 	if state.File == nil {
 		return fmt.Errorf("file handle is nil for %s", state.Path)
 	}
 
-	// Seek to last known position
+	if err := m.readLines(state, false); err != nil {
+		return err
+	}
+
+	// Update file size and modification time
+	if info, err := state.File.Stat(); err == nil {
+		state.Size = info.Size()
+		state.ModTime = info.ModTime()
+	}
+
+	return nil
+}
+
+// readLines parses the lines after state.Position and stores them in batches. Each
+// batch is committed together with the offset just past its last line, so after a
+// crash or restart reading resumes exactly where the stored entries end. A trailing
+// line without a newline is still being written and is left for the next read unless
+// final is set.
+func (m *LogMonitor) readLines(state *FileState, final bool) error {
 	if _, err := state.File.Seek(state.Position, io.SeekStart); err != nil {
-		// If seek fails, try to reopen the file
-		log.Printf("Seek failed for %s, attempting to reopen: %v", state.Path, err)
-		return m.reopenFile(state)
+		return fmt.Errorf("failed to seek %s: %w", state.Path, err)
 	}
 
-	scanner := bufio.NewScanner(state.File)
-	// Increase buffer size for large log lines
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
+	if state.Fingerprint == "" {
+		state.Fingerprint = fileFingerprint(state.File)
+	}
+	fingerprint := state.Fingerprint
 
+	reader := bufio.NewReaderSize(state.File, 64*1024)
+	logType := m.getLogType(state.Path)
+	position := state.Position
+
+	var batch []*database.LogEntry
 	lineCount := 0
 	errorCount := 0
-	logType := m.getLogType(state.Path)
+	batchLines := 0
+	batchErrors := 0
 
-	for scanner.Scan() {
-		line := scanner.Text()
+	flush := func() error {
+		if position == state.Position {
+			return nil
+		}
+		if err := m.storeBatch(batch, state, position); err != nil {
+			return err
+		}
+		m.recordIngest(state.Path, position, batch, batchLines, batchErrors)
+		state.Position = position
+		batch = batch[:0]
+		batchLines, batchErrors = 0, 0
+		return nil
+	}
+
+	for {
+		if err := m.ctx.Err(); err != nil {
+			return flush()
+		}
+
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && (line == "" || !final) {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error reading file: %w", err)
+		}
+		lineOffset := position
+		position += int64(len(line))
+
+		line = strings.TrimRight(line, "\r\n")
 		if strings.TrimSpace(line) == "" {
 			continue
 		}
 
 		// Parse the log line
+		batchLines++
 		logEntry, err := m.parser.ParseLogLine(line, logType)
 		if err != nil {
 			errorCount++
+			batchErrors++
 			if errorCount <= 5 { // Only log first few errors to avoid spam
 				log.Printf("Failed to parse log line from %s: %v", state.Path, err)
 			}
@@ -428,40 +588,23 @@ func (m *LogMonitor) processNewContent(state *FileState) error {
 		}
 
 		if logEntry != nil {
-			// Use log processor if available, otherwise store directly
-			if m.logProcessor != nil {
-				if err := m.logProcessor.ProcessLogEntry(m.ctx, logEntry); err != nil {
-					log.Printf("Failed to process log entry: %v", err)
-					continue
-				}
-			} else {
-				// Store in database with retry logic
-				if err := m.storeLogEntryWithRetry(logEntry, 3); err != nil {
-					log.Printf("Failed to store log entry after retries: %v", err)
-					continue
-				}
+			if fingerprint != "" {
+				logEntry.SourceFingerprint = &fingerprint
+				logEntry.SourceOffset = &lineOffset
 			}
+			batch = append(batch, logEntry)
 		}
-
 		lineCount++
-	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading file: %w", err)
+		if len(batch) >= ingestBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
 	}
 
-	// Update position
-	newPosition, err := state.File.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return fmt.Errorf("failed to get current position: %w", err)
-	}
-
-	state.Position = newPosition
-
-	// Update file size and modification time
-	if info, err := os.Stat(state.Path); err == nil {
-		state.Size = info.Size()
-		state.ModTime = info.ModTime()
+	if err := flush(); err != nil {
+		return err
 	}
 
 	if lineCount > 0 {
@@ -471,41 +614,139 @@ func (m *LogMonitor) processNewContent(state *FileState) error {
 	return nil
 }
 
-// reopenFile attempts to reopen a file after an error
-func (m *LogMonitor) reopenFile(state *FileState) error {
-	if state.File != nil {
-		state.File.Close()
+// storeBatch stores parsed entries and the new read offset in one transaction
+func (m *LogMonitor) storeBatch(entries []*database.LogEntry, state *FileState, position int64) error {
+	offset := &database.LogFileOffset{
+		Path:        state.Path,
+		Fingerprint: state.Fingerprint,
+		Position:    position,
+	}
+	saveOffset := func(tx *database.TxRepository) error {
+		return tx.SaveLogFileOffset(offset)
 	}
 
-	file, err := os.Open(state.Path)
-	if err != nil {
-		return fmt.Errorf("failed to reopen file %s: %w", state.Path, err)
+	m.mu.RLock()
+	processor := m.logProcessor
+	m.mu.RUnlock()
+
+	if processor != nil {
+		return processor.ProcessLogEntriesWith(m.ctx, entries, saveOffset)
 	}
 
-	state.File = file
-	state.Position = 0 // Start from beginning after reopen
+	return database.NewTxManager(m.repository.GetDB()).WithTransaction(func(tx *sql.Tx) error {
+		txRepo := database.NewTxRepository(tx)
+		for _, entry := range entries {
+			if err := txRepo.CreateLogEntry(entry); err != nil {
+				return fmt.Errorf("failed to store log entry: %w", err)
+			}
+		}
+		return saveOffset(txRepo)
+	})
+}
 
-	log.Printf("Successfully reopened file: %s", state.Path)
-	return nil
+// recordIngest adds a stored batch to the ingestion statistics of a path
+func (m *LogMonitor) recordIngest(path string, position int64, entries []*database.LogEntry, lines, parseErrors int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.statsFor(path)
+	stats.position = position
+	stats.lines += int64(lines)
+	stats.parseErrors += int64(parseErrors)
+	if len(entries) > 0 {
+		stats.lastEntry = entries[len(entries)-1].Timestamp
+	}
 }
 
-// storeLogEntryWithRetry attempts to store a log entry with retry logic
-func (m *LogMonitor) storeLogEntryWithRetry(entry *database.LogEntry, maxRetries int) error {
-	var lastErr error
+// statsFor returns the ingestion statistics of a path. The caller must hold m.mu.
+func (m *LogMonitor) statsFor(path string) *ingestStats {
+	stats, ok := m.ingestStats[path]
+	if !ok {
+		stats = &ingestStats{}
+		m.ingestStats[path] = stats
+	}
+	return stats
+}
 
-	for i := 0; i < maxRetries; i++ {
-		if err := m.repository.CreateLogEntry(m.ctx, entry); err != nil {
-			lastErr = err
-			if i < maxRetries-1 {
-				// Wait a bit before retrying
-				time.Sleep(time.Millisecond * 100 * time.Duration(i+1))
+// Status reports the ingestion progress of every configured log path
+func (m *LogMonitor) Status() []FileStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	statuses := make([]FileStatus, 0, len(m.logPaths))
+	for _, logPath := range m.logPaths {
+		status := FileStatus{Path: logPath, LogType: m.getLogType(logPath)}
+		if info, err := os.Stat(logPath); err == nil {
+			status.Size = info.Size()
+		}
+		if stats, ok := m.ingestStats[logPath]; ok {
+			status.Position = stats.position
+			status.Lines = stats.lines
+			status.ParseErrors = stats.parseErrors
+			if !stats.lastEntry.IsZero() {
+				// Log timestamps are local wall-clock times stored as UTC
+				t := stats.lastEntry
+				status.LastEntry = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
 			}
-			continue
 		}
-		return nil
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// saveOffset stores the read offset of a file outside of a batch
+func (m *LogMonitor) saveOffset(state *FileState, position int64) error {
+	m.mu.Lock()
+	m.statsFor(state.Path).position = position
+	m.mu.Unlock()
+
+	return m.offsets.Save(&database.LogFileOffset{
+		Path:        state.Path,
+		Fingerprint: state.Fingerprint,
+		Position:    position,
+	})
+}
+
+// fileFingerprint identifies an open file by its first line
+func fileFingerprint(file *os.File) string {
+	buf := make([]byte, parser.FingerprintSize)
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return ""
+	}
+	return parser.Fingerprint(buf[:n])
+}
+
+// partialLineLength returns the length of an unterminated last line, which is still
+// being written and must be read once it is complete
+func partialLineLength(file *os.File, size int64) int64 {
+	const chunk = 4096
+	var length int64
+	buf := make([]byte, chunk)
+
+	for end := size; end > 0; {
+		start := end - chunk
+		if start < 0 {
+			start = 0
+		}
+		n, err := file.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return length + int64(n-i-1)
+		}
+		length += int64(n)
+		end = start
 	}
 
-	return fmt.Errorf("failed after %d retries: %w", maxRetries, lastErr)
+	return length
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
 
 // SetLogProcessor sets a log processor for enhanced processing
@@ -519,6 +760,9 @@ func (m *LogMonitor) SetLogProcessor(processor LogProcessor) {
 type LogProcessor interface {
 	ProcessLogEntry(ctx context.Context, entry *database.LogEntry) error
 	ProcessLogEntries(ctx context.Context, entries []*database.LogEntry) error
+
+	// ProcessLogEntriesWith stores the entries and runs commit in the same transaction
+	ProcessLogEntriesWith(ctx context.Context, entries []*database.LogEntry, commit func(tx *database.TxRepository) error) error
 }
 
 // getLogType determines the log type based on file path
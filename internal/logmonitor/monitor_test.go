@@ -0,0 +1,160 @@
+package logmonitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+func newTestRepository(t *testing.T) *database.Repository {
+	t.Helper()
+
+	db, err := database.Connect(&database.Config{
+		Path:            filepath.Join(t.TempDir(), "monitor.db"),
+		MaxOpenConns:    1,
+		MaxIdleConns:    1,
+		ConnMaxLifetime: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := database.MigrateUp(db); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	return database.NewRepository(db)
+}
+
+func startTestMonitor(t *testing.T, repository *database.Repository, logPath string) *LogMonitor {
+	t.Helper()
+
+	monitor, err := NewLogMonitor(Config{LogPaths: []string{logPath}, Repository: repository})
+	if err != nil {
+		t.Fatalf("NewLogMonitor failed: %v", err)
+	}
+	if err := monitor.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	return monitor
+}
+
+func arrivalLine(n int) string {
+	return fmt.Sprintf("2024-01-15 10:%02d:00 1rABCD-123456-%02d <= sender@example.com H=mail.example.com [192.0.2.1] P=esmtp S=100\n", n, n)
+}
+
+func appendToFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+// waitForEntries waits until exactly the given message IDs are stored, each once
+func waitForEntries(t *testing.T, repository *database.Repository, ids ...int) {
+	t.Helper()
+
+	var got []string
+	deadline := time.Now().Add(3 * pollInterval)
+	for time.Now().Before(deadline) {
+		rows, err := repository.GetDB().Query("SELECT message_id FROM log_entries ORDER BY id")
+		if err != nil {
+			t.Fatalf("Failed to query log entries: %v", err)
+		}
+		got = got[:0]
+		for rows.Next() {
+			var id string
+			rows.Scan(&id)
+			got = append(got, id)
+		}
+		rows.Close()
+
+		if len(got) == len(ids) {
+			match := true
+			for i, n := range ids {
+				if got[i] != fmt.Sprintf("1rABCD-123456-%02d", n) {
+					match = false
+				}
+			}
+			if match {
+				return
+			}
+		}
+		if len(got) > len(ids) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Fatalf("Expected entries %v, got %v", ids, got)
+}
+
+func TestLogMonitorResumesWithoutDuplicatesOrGaps(t *testing.T) {
+	repository := newTestRepository(t)
+	logPath := filepath.Join(t.TempDir(), "mainlog")
+
+	// Content present on the very first start is left to historical imports
+	appendToFile(t, logPath, arrivalLine(1))
+	monitor := startTestMonitor(t, repository, logPath)
+
+	// A line still being written is not read until it is complete
+	appendToFile(t, logPath, arrivalLine(2))
+	appendToFile(t, logPath, arrivalLine(3)[:20])
+	waitForEntries(t, repository, 2)
+	appendToFile(t, logPath, arrivalLine(3)[20:])
+	waitForEntries(t, repository, 2, 3)
+
+	// Lines written while stopped are picked up on restart, and nothing is read twice
+	monitor.Stop()
+	appendToFile(t, logPath, arrivalLine(4))
+	monitor = startTestMonitor(t, repository, logPath)
+	waitForEntries(t, repository, 2, 3, 4)
+
+	// Rotation while stopped: the rest of the old file is read before the new one
+	monitor.Stop()
+	appendToFile(t, logPath, arrivalLine(5))
+	if err := os.Rename(logPath, logPath+".1"); err != nil {
+		t.Fatalf("Failed to rotate log: %v", err)
+	}
+	appendToFile(t, logPath, arrivalLine(6))
+	monitor = startTestMonitor(t, repository, logPath)
+	waitForEntries(t, repository, 2, 3, 4, 5, 6)
+
+	// Rotation while running
+	appendToFile(t, logPath, arrivalLine(7))
+	if err := os.Rename(logPath, logPath+".2"); err != nil {
+		t.Fatalf("Failed to rotate log: %v", err)
+	}
+	appendToFile(t, logPath, arrivalLine(8))
+	waitForEntries(t, repository, 2, 3, 4, 5, 6, 7, 8)
+
+	// Lines 5 to 8 were read since the last start and the monitor has caught up
+	status := monitor.Status()
+	if len(status) != 1 || status[0].Lines != 4 || status[0].Position != status[0].Size {
+		t.Errorf("Unexpected status %+v", status)
+	}
+	if want := time.Date(2024, 1, 15, 10, 8, 0, 0, time.Local); !status[0].LastEntry.Equal(want) {
+		t.Errorf("LastEntry = %v, want %v", status[0].LastEntry, want)
+	}
+	monitor.Stop()
+
+	offset, err := database.NewLogFileOffsetRepository(repository.GetDB()).Get(logPath)
+	if err != nil || offset == nil {
+		t.Fatalf("Expected a stored offset, got %v (%v)", offset, err)
+	}
+	if offset.Position != int64(len(arrivalLine(8))) {
+		t.Errorf("Stored position = %d, want %d", offset.Position, len(arrivalLine(8)))
+	}
+}
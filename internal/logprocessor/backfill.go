@@ -0,0 +1,552 @@
+package logprocessor
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+	"github.com/andreitelteu/exim-pilot/internal/parser"
+)
+
+// backfillBatchSize is the number of entries stored per transaction during a backfill
+const backfillBatchSize = 1000
+
+// Backfill file statuses
+const (
+	BackfillPending   = "pending"
+	BackfillRunning   = "running"
+	BackfillCompleted = "completed"
+	BackfillSkipped   = "skipped"
+	BackfillFailed    = "failed"
+	BackfillCancelled = "cancelled"
+)
+
+// ErrBackfillRunning is returned when a backfill is started while another one runs
+var ErrBackfillRunning = errors.New("a log backfill is already running")
+
+// BackfillFile is one log file of a backfill and its progress
+type BackfillFile struct {
+	Path        string     `json:"path"`
+	LogType     string     `json:"log_type"`
+	Compression string     `json:"compression,omitempty"`
+	Status      string     `json:"status"`
+	BytesRead   int64      `json:"bytes_read"` // uncompressed
+	LinesRead   int64      `json:"lines_read"`
+	Inserted    int64      `json:"inserted"`
+	Duplicates  int64      `json:"duplicates"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+
+	// active marks the file Exim is still writing, whose last line may be incomplete
+	active bool
+
+	// firstLine orders the series oldest to newest
+	firstLine time.Time
+}
+
+// BackfillJob is a run of the backfill importer over a rotated log series
+type BackfillJob struct {
+	ID          string          `json:"id"`
+	Status      string          `json:"status"`
+	Directory   string          `json:"directory"`
+	Files       []*BackfillFile `json:"files"`
+	Error       string          `json:"error,omitempty"`
+	StartedAt   time.Time       `json:"started_at"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+}
+
+// Backfiller imports rotated, optionally compressed, Exim logs. Lines are keyed by the
+// fingerprint of their file and their offset in it, the same key the live monitor
+// uses, so re-running a backfill or overlapping with live ingestion stores nothing twice.
+type Backfiller struct {
+	repository *database.Repository
+	parser     *parser.EximParser
+
+	// onFile is called after each file finishes, e.g. to correlate the new entries
+	onFile func(ctx context.Context, file *BackfillFile, first, last time.Time)
+
+	mu     sync.RWMutex
+	job    *BackfillJob
+	cancel context.CancelFunc
+}
+
+// NewBackfiller creates a backfill importer
+func NewBackfiller(repository *database.Repository) *Backfiller {
+	return &Backfiller{
+		repository: repository,
+		parser:     parser.NewEximParser(),
+	}
+}
+
+// DiscoverRotatedLogs finds the rotated series of each log in dir, such as mainlog,
+// mainlog.1, mainlog.2.gz or mainlog-20240115.xz, ordered oldest to newest
+func DiscoverRotatedLogs(dir string, logPaths []string) ([]*BackfillFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log directory %s: %w", dir, err)
+	}
+
+	active := make(map[string]bool)
+	for _, logPath := range logPaths {
+		active[filepath.Clean(logPath)] = true
+	}
+
+	var files []*BackfillFile
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+
+		for _, logPath := range logPaths {
+			base := filepath.Base(logPath)
+			if name != base && !strings.HasPrefix(name, base+".") && !strings.HasPrefix(name, base+"-") {
+				continue
+			}
+
+			compression := compressionOf(name)
+			if compression == "unsupported" {
+				break
+			}
+
+			path := filepath.Join(dir, name)
+			file := &BackfillFile{
+				Path:        path,
+				LogType:     logTypeOf(base),
+				Compression: compression,
+				Status:      BackfillPending,
+				active:      active[path],
+			}
+			file.firstLine = firstLineTime(path, compression)
+			files = append(files, file)
+			break
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if !files[i].firstLine.Equal(files[j].firstLine) {
+			return files[i].firstLine.Before(files[j].firstLine)
+		}
+		return files[i].Path < files[j].Path
+	})
+
+	return files, nil
+}
+
+// compressionOf returns the compression of a log file from its name
+func compressionOf(name string) string {
+	switch filepath.Ext(name) {
+	case ".gz":
+		return "gzip"
+	case ".bz2":
+		return "bzip2"
+	case ".xz":
+		return "xz"
+	case ".zst", ".lz4", ".Z":
+		return "unsupported"
+	default:
+		return ""
+	}
+}
+
+// logTypeOf determines the log type from a log file name
+func logTypeOf(name string) string {
+	switch {
+	case strings.Contains(name, "reject"):
+		return database.LogTypeReject
+	case strings.Contains(name, "panic"):
+		return database.LogTypePanic
+	default:
+		return database.LogTypeMain
+	}
+}
+
+// firstLineTime returns the timestamp of the first line of a log file, falling back to
+// its modification time
+func firstLineTime(path, compression string) time.Time {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+
+	reader, err := OpenLogFile(context.Background(), path, compression)
+	if err != nil {
+		return modTime
+	}
+	defer reader.Close()
+
+	line, _ := bufio.NewReader(reader).ReadString('\n')
+	if t, ok := parser.LineTimestamp(line); ok {
+		return t
+	}
+	return modTime
+}
+
+// OpenLogFile opens a log file for reading, decompressing gzip and bzip2 in process
+// and xz through the system xz command
+func OpenLogFile(ctx context.Context, path, compression string) (io.ReadCloser, error) {
+	if compression == "xz" {
+		return openXZ(ctx, path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch compression {
+	case "gzip":
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read gzip file %s: %w", path, err)
+		}
+		return &decompressedFile{Reader: gz, closers: []io.Closer{gz, file}}, nil
+	case "bzip2":
+		return &decompressedFile{Reader: bzip2.NewReader(file), closers: []io.Closer{file}}, nil
+	default:
+		return file, nil
+	}
+}
+
+// decompressedFile closes a decompressor together with its underlying file
+type decompressedFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (f *decompressedFile) Close() error {
+	var firstErr error
+	for _, closer := range f.closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// xzReader reads the output of xz -dc and reports its exit status on Close
+type xzReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func openXZ(ctx context.Context, path string) (io.ReadCloser, error) {
+	if _, err := exec.LookPath("xz"); err != nil {
+		return nil, fmt.Errorf("cannot read %s: the xz command is not installed", path)
+	}
+
+	cmd := exec.CommandContext(ctx, "xz", "-dc", "--", path)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start xz for %s: %w", path, err)
+	}
+
+	return &xzReader{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+func (r *xzReader) Close() error {
+	r.ReadCloser.Close()
+	if err := r.cmd.Wait(); err != nil {
+		return fmt.Errorf("xz failed: %w", err)
+	}
+	return nil
+}
+
+// Start runs a backfill of dir in the background. Only one backfill runs at a time.
+func (b *Backfiller) Start(dir string, logPaths []string) (*BackfillJob, error) {
+	b.mu.Lock()
+	if b.job != nil && b.job.Status == BackfillRunning {
+		b.mu.Unlock()
+		return nil, ErrBackfillRunning
+	}
+
+	files, err := DiscoverRotatedLogs(dir, logPaths)
+	if err != nil {
+		b.mu.Unlock()
+		return nil, err
+	}
+
+	job := &BackfillJob{
+		ID:        fmt.Sprintf("backfill-%d", time.Now().UnixNano()),
+		Status:    BackfillRunning,
+		Directory: dir,
+		Files:     files,
+		StartedAt: time.Now(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b.job = job
+	b.cancel = cancel
+	b.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		b.run(ctx, job, nil)
+	}()
+
+	return b.Status(), nil
+}
+
+// Run performs a backfill of dir and returns when it is done. progress, if set, is
+// called as each file starts and finishes.
+func (b *Backfiller) Run(ctx context.Context, dir string, logPaths []string, progress func(*BackfillFile)) (*BackfillJob, error) {
+	files, err := DiscoverRotatedLogs(dir, logPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &BackfillJob{
+		ID:        fmt.Sprintf("backfill-%d", time.Now().UnixNano()),
+		Status:    BackfillRunning,
+		Directory: dir,
+		Files:     files,
+		StartedAt: time.Now(),
+	}
+
+	b.mu.Lock()
+	if b.job != nil && b.job.Status == BackfillRunning {
+		b.mu.Unlock()
+		return nil, ErrBackfillRunning
+	}
+	b.job = job
+	b.cancel = nil
+	b.mu.Unlock()
+
+	b.run(ctx, job, progress)
+	return b.Status(), nil
+}
+
+// Cancel stops a background backfill after its current batch. It reports whether one
+// was running.
+func (b *Backfiller) Cancel() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.job == nil || b.job.Status != BackfillRunning || b.cancel == nil {
+		return false
+	}
+	b.cancel()
+	return true
+}
+
+// Status returns a copy of the current or most recent backfill job, or nil
+func (b *Backfiller) Status() *BackfillJob {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.job == nil {
+		return nil
+	}
+
+	job := *b.job
+	job.Files = make([]*BackfillFile, len(b.job.Files))
+	for i, file := range b.job.Files {
+		copied := *file
+		job.Files[i] = &copied
+	}
+	return &job
+}
+
+// run imports the files of a job in order, recording progress under the lock
+func (b *Backfiller) run(ctx context.Context, job *BackfillJob, progress func(*BackfillFile)) {
+	report := func(file *BackfillFile) {
+		if progress != nil {
+			b.mu.RLock()
+			copied := *file
+			b.mu.RUnlock()
+			progress(&copied)
+		}
+	}
+
+	failed := 0
+	for _, file := range job.Files {
+		if ctx.Err() != nil {
+			b.update(func() { file.Status = BackfillCancelled })
+			continue
+		}
+
+		now := time.Now()
+		b.update(func() {
+			file.Status = BackfillRunning
+			file.StartedAt = &now
+		})
+		report(file)
+
+		first, last, err := b.importFile(ctx, file)
+
+		done := time.Now()
+		b.update(func() {
+			file.CompletedAt = &done
+			switch {
+			case err == nil:
+				file.Status = BackfillCompleted
+			case ctx.Err() != nil:
+				file.Status = BackfillCancelled
+			default:
+				file.Status = BackfillFailed
+				file.Error = err.Error()
+				failed++
+			}
+		})
+		if err != nil {
+			log.Printf("Backfill of %s failed: %v", file.Path, err)
+		}
+		report(file)
+
+		if file.Inserted > 0 && b.onFile != nil {
+			b.onFile(ctx, file, first, last)
+		}
+	}
+
+	completed := time.Now()
+	b.update(func() {
+		job.CompletedAt = &completed
+		switch {
+		case ctx.Err() != nil:
+			job.Status = BackfillCancelled
+		case failed > 0:
+			job.Status = BackfillFailed
+			job.Error = fmt.Sprintf("%d of %d files failed", failed, len(job.Files))
+		default:
+			job.Status = BackfillCompleted
+		}
+	})
+}
+
+// update applies a change to job state under the lock
+func (b *Backfiller) update(fn func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fn()
+}
+
+// importFile reads one file and stores its lines in batches. It returns the time range
+// of the entries it inserted.
+func (b *Backfiller) importFile(ctx context.Context, file *BackfillFile) (time.Time, time.Time, error) {
+	var first, last time.Time
+
+	reader, err := OpenLogFile(ctx, file.Path, file.Compression)
+	if err != nil {
+		return first, last, err
+	}
+	defer reader.Close()
+
+	buffered := bufio.NewReaderSize(reader, 64*1024)
+	head, _ := buffered.Peek(parser.FingerprintSize)
+	fingerprint := parser.Fingerprint(head)
+	if fingerprint == "" {
+		b.update(func() { file.Status = BackfillSkipped })
+		return first, last, nil
+	}
+
+	var position int64
+	var batch []*database.LogEntry
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		var inserted, duplicates int64
+		err := database.NewTxManager(b.repository.GetDB()).WithTransaction(func(tx *sql.Tx) error {
+			txRepo := database.NewTxRepository(tx)
+			for _, entry := range batch {
+				stored, err := txRepo.CreateLogEntryIfNew(entry)
+				if err != nil {
+					return fmt.Errorf("failed to store log entry: %w", err)
+				}
+				if !stored {
+					duplicates++
+					continue
+				}
+				inserted++
+				if first.IsZero() || entry.Timestamp.Before(first) {
+					first = entry.Timestamp
+				}
+				if entry.Timestamp.After(last) {
+					last = entry.Timestamp
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		b.update(func() {
+			file.Inserted += inserted
+			file.Duplicates += duplicates
+			file.BytesRead = position
+		})
+		batch = batch[:0]
+		return nil
+	}
+
+	var lines int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return first, last, err
+		}
+
+		line, err := buffered.ReadString('\n')
+		if err == io.EOF && (line == "" || file.active) {
+			// The last line of the live file may still be being written
+			break
+		}
+		if err != nil && err != io.EOF {
+			return first, last, fmt.Errorf("error reading %s: %w", file.Path, err)
+		}
+
+		lineOffset := position
+		position += int64(len(line))
+		lines++
+
+		line = strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		entry, parseErr := b.parser.ParseLogLine(line, file.LogType)
+		if parseErr != nil || entry == nil {
+			continue
+		}
+		entry.SourceFingerprint = &fingerprint
+		entry.SourceOffset = &lineOffset
+		batch = append(batch, entry)
+
+		if len(batch) >= backfillBatchSize {
+			if err := flush(); err != nil {
+				return first, last, err
+			}
+			b.update(func() { file.LinesRead = lines })
+		}
+	}
+
+	if err := flush(); err != nil {
+		return first, last, err
+	}
+	b.update(func() {
+		file.LinesRead = lines
+		file.BytesRead = position
+	})
+
+	return first, last, nil
+}
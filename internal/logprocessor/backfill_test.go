@@ -0,0 +1,140 @@
+package logprocessor
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+func backfillLine(n int) string {
+	return fmt.Sprintf("2024-01-%02d 10:00:00 1rABCD-123456-%02d <= sender@example.com H=mail.example.com [192.0.2.1] P=esmtp S=100\n", n, n)
+}
+
+func writeBackfillFile(t *testing.T, path string, gz bool, lines ...int) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var content string
+	for _, n := range lines {
+		content += backfillLine(n)
+	}
+
+	if gz {
+		writer := gzip.NewWriter(file)
+		writer.Write([]byte(content))
+		writer.Close()
+		return
+	}
+	file.WriteString(content)
+}
+
+func TestBackfillOrdersRotatedSeriesAndSkipsDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	db, err := database.Connect(&database.Config{
+		Path:            filepath.Join(dir, "backfill.db"),
+		MaxOpenConns:    1,
+		MaxIdleConns:    1,
+		ConnMaxLifetime: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := database.MigrateUp(db); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	logDir := filepath.Join(dir, "exim4")
+	os.Mkdir(logDir, 0755)
+	logPath := filepath.Join(logDir, "mainlog")
+
+	want := []int{1, 2, 3, 4, 5, 6, 7}
+	wantOrder := []string{"mainlog.3.gz", "mainlog.2.gz", "mainlog.1", "mainlog.0.xz", "mainlog"}
+	writeBackfillFile(t, logPath+".3.gz", true, 1, 2)
+	writeBackfillFile(t, logPath+".2.gz", true, 3)
+	writeBackfillFile(t, logPath+".1", false, 4, 5)
+	writeBackfillFile(t, logPath, false, 7)
+	if _, err := exec.LookPath("xz"); err == nil {
+		writeBackfillFile(t, logPath+".0", false, 6)
+		if out, err := exec.Command("xz", logPath+".0").CombinedOutput(); err != nil {
+			t.Fatalf("xz failed: %v: %s", err, out)
+		}
+	} else {
+		want = []int{1, 2, 3, 4, 5, 7}
+		wantOrder = []string{"mainlog.3.gz", "mainlog.2.gz", "mainlog.1", "mainlog"}
+	}
+	// Unrelated and unsupported files are ignored
+	writeBackfillFile(t, filepath.Join(logDir, "paniclog-old.zst"), false, 9)
+	writeBackfillFile(t, filepath.Join(logDir, "othermainlog"), false, 9)
+
+	config := DefaultServiceConfig()
+	config.EnableCorrelation = false
+	config.LogPaths = []string{logPath}
+	config.LogRotationDir = logDir
+	service := NewService(database.NewRepository(db), config)
+
+	var order []string
+	job, err := service.RunBackfill(context.Background(), "", func(file *BackfillFile) {
+		if file.Status == BackfillRunning {
+			order = append(order, filepath.Base(file.Path))
+		}
+	})
+	if err != nil {
+		t.Fatalf("RunBackfill failed: %v", err)
+	}
+	if job.Status != BackfillCompleted {
+		t.Fatalf("Job status = %s, files %+v", job.Status, job.Files)
+	}
+	if fmt.Sprint(order) != fmt.Sprint(wantOrder) {
+		t.Errorf("Files processed in order %v, want %v", order, wantOrder)
+	}
+
+	assertMessages := func() {
+		t.Helper()
+		rows, err := db.Query("SELECT message_id FROM log_entries ORDER BY timestamp")
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		defer rows.Close()
+
+		var got []string
+		for rows.Next() {
+			var id string
+			rows.Scan(&id)
+			got = append(got, id)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("Stored %v, want messages %v", got, want)
+		}
+		for i, n := range want {
+			if got[i] != fmt.Sprintf("1rABCD-123456-%02d", n) {
+				t.Errorf("Entry %d = %s, want message %d", i, got[i], n)
+			}
+		}
+	}
+	assertMessages()
+
+	// A second run stores nothing new
+	job, err = service.RunBackfill(context.Background(), "", nil)
+	if err != nil {
+		t.Fatalf("Second RunBackfill failed: %v", err)
+	}
+	for _, file := range job.Files {
+		if file.Inserted != 0 || file.Duplicates == 0 {
+			t.Errorf("Re-run of %s inserted %d, skipped %d", file.Path, file.Inserted, file.Duplicates)
+		}
+	}
+	assertMessages()
+}
@@ -37,6 +37,9 @@ type SearchCriteria struct {
 	Events   []string `json:"events,omitempty"`
 	Status   string   `json:"status,omitempty"`
 
+	// PID limits entries to one Exim process, for logs written with +pid or via syslog
+	PID *int64 `json:"pid,omitempty"`
+
 	// Content filtering
 	Keywords  []string `json:"keywords,omitempty"`
 	ErrorCode string   `json:"error_code,omitempty"`
@@ -109,11 +112,7 @@ func (s *SearchService) Search(ctx context.Context, criteria SearchCriteria) (*S
 	var entries []database.LogEntry
 	for rows.Next() {
 		var entry database.LogEntry
-		err := rows.Scan(
-			&entry.ID, &entry.Timestamp, &entry.MessageID, &entry.LogType, &entry.Event,
-			&entry.Host, &entry.Sender, &entry.RecipientsDB, &entry.Size, &entry.Status,
-			&entry.ErrorCode, &entry.ErrorText, &entry.RawLine, &entry.CreatedAt,
-		)
+		err := rows.Scan(entry.ScanFields()...)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan log entry: %w", err)
 		}
@@ -150,8 +149,7 @@ func (s *SearchService) Search(ctx context.Context, criteria SearchCriteria) (*S
 // buildSearchQuery constructs the SQL query based on search criteria
 func (s *SearchService) buildSearchQuery(criteria SearchCriteria) (string, string, []interface{}) {
 	baseQuery := `
-		SELECT id, timestamp, message_id, log_type, event, host, sender, recipients, 
-		       size, status, error_code, error_text, raw_line, created_at
+		SELECT ` + database.LogEntryColumns + `
 		FROM log_entries`
 
 	countQuery := "SELECT COUNT(*) FROM log_entries"
@@ -218,6 +216,12 @@ func (s *SearchService) buildSearchQuery(criteria SearchCriteria) (string, strin
 		args = append(args, criteria.Status)
 	}
 
+	// Process filtering
+	if criteria.PID != nil {
+		conditions = append(conditions, "pid = ?")
+		args = append(args, *criteria.PID)
+	}
+
 	// Keyword filtering
 	if len(criteria.Keywords) > 0 {
 		keywordConditions := make([]string, len(criteria.Keywords))
@@ -2,6 +2,7 @@ package logprocessor
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
 	"sync"
@@ -19,6 +20,7 @@ type Service struct {
 	aggregator        *LogAggregator
 	backgroundService *BackgroundService
 	searchService     *SearchService
+	backfiller        *Backfiller
 	config            ServiceConfig
 	logEntryCallback  LogEntryCallback
 	mu                sync.RWMutex
@@ -41,6 +43,10 @@ type ServiceConfig struct {
 	EnableCorrelation bool `json:"enable_correlation"`
 	EnableCleanup     bool `json:"enable_cleanup"`
 	EnableMetrics     bool `json:"enable_metrics"`
+
+	// Log files and the directory holding their rotated copies, used by backfills
+	LogPaths       []string `json:"log_paths"`
+	LogRotationDir string   `json:"log_rotation_dir"`
 }
 
 // DefaultServiceConfig returns default service configuration
@@ -64,8 +70,10 @@ func NewService(repository *database.Repository, config ServiceConfig) *Service
 		aggregator:        NewLogAggregator(repository),
 		backgroundService: NewBackgroundService(repository, config.BackgroundConfig),
 		searchService:     NewSearchService(repository),
+		backfiller:        NewBackfiller(repository),
 		config:            config,
 	}
+	service.backfiller.onFile = service.correlateBackfilledFile
 
 	return service
 }
@@ -137,42 +145,59 @@ func (s *Service) ProcessLogEntry(ctx context.Context, entry *database.LogEntry)
 
 // ProcessLogEntries processes multiple log entries in batch
 func (s *Service) ProcessLogEntries(ctx context.Context, entries []*database.LogEntry) error {
-	if len(entries) == 0 {
+	return s.ProcessLogEntriesWith(ctx, entries, nil)
+}
+
+// ProcessLogEntriesWith stores the entries and runs commit in a single transaction, so
+// a caller can record how far it has read atomically with the entries themselves.
+// Nothing is stored if commit fails.
+func (s *Service) ProcessLogEntriesWith(ctx context.Context, entries []*database.LogEntry, commit func(tx *database.TxRepository) error) error {
+	if len(entries) == 0 && commit == nil {
 		return nil
 	}
 
-	// Process in batches
-	batchSize := s.config.BatchSize
-	messageIDs := make(map[string]bool)
-
-	for i := 0; i < len(entries); i += batchSize {
-		end := i + batchSize
-		if end > len(entries) {
-			end = len(entries)
+	err := database.NewTxManager(s.repository.GetDB()).WithTransaction(func(tx *sql.Tx) error {
+		txRepo := database.NewTxRepository(tx)
+		for _, entry := range entries {
+			if err := txRepo.CreateLogEntry(entry); err != nil {
+				return fmt.Errorf("failed to store log entry: %w", err)
+			}
 		}
+		if commit != nil {
+			return commit(txRepo)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		batch := entries[i:end]
-
-		// Store batch
-		for _, entry := range batch {
-			if err := s.repository.CreateLogEntry(ctx, entry); err != nil {
-				log.Printf("Failed to store log entry: %v", err)
-				continue
-			}
+	// Broadcast in order, from a single goroutine
+	s.mu.RLock()
+	callback := s.logEntryCallback
+	s.mu.RUnlock()
 
-			// Collect message IDs for correlation
-			if s.config.EnableCorrelation && entry.MessageID != nil && *entry.MessageID != "" {
-				messageIDs[*entry.MessageID] = true
+	if callback != nil && len(entries) > 0 {
+		go func() {
+			for _, entry := range entries {
+				callback(entry)
 			}
-		}
+		}()
 	}
 
 	// Trigger correlation for unique message IDs
 	if s.config.EnableCorrelation {
-		go s.correlateMessagesAsync(messageIDs)
+		messageIDs := make(map[string]bool)
+		for _, entry := range entries {
+			if entry.MessageID != nil && *entry.MessageID != "" {
+				messageIDs[*entry.MessageID] = true
+			}
+		}
+		if len(messageIDs) > 0 {
+			go s.correlateMessagesAsync(messageIDs)
+		}
 	}
 
-	log.Printf("Processed %d log entries in %d batches", len(entries), (len(entries)+batchSize-1)/batchSize)
 	return nil
 }
 
@@ -299,6 +324,52 @@ func (s *Service) ProcessHistoricalLogs(ctx context.Context, logPaths []string)
 	return s.backgroundService.ProcessHistoricalLogs(logPaths)
 }
 
+// StartBackfill imports the rotated log series in the background. dir defaults to the
+// configured rotation directory.
+func (s *Service) StartBackfill(dir string) (*BackfillJob, error) {
+	s.mu.RLock()
+	logPaths := s.config.LogPaths
+	if dir == "" {
+		dir = s.config.LogRotationDir
+	}
+	s.mu.RUnlock()
+
+	return s.backfiller.Start(dir, logPaths)
+}
+
+// RunBackfill imports the rotated log series and returns when done, reporting progress
+// per file. dir defaults to the configured rotation directory.
+func (s *Service) RunBackfill(ctx context.Context, dir string, progress func(*BackfillFile)) (*BackfillJob, error) {
+	s.mu.RLock()
+	logPaths := s.config.LogPaths
+	if dir == "" {
+		dir = s.config.LogRotationDir
+	}
+	s.mu.RUnlock()
+
+	return s.backfiller.Run(ctx, dir, logPaths, progress)
+}
+
+// BackfillStatus returns the current or most recent backfill job, or nil if none ran
+func (s *Service) BackfillStatus() *BackfillJob {
+	return s.backfiller.Status()
+}
+
+// CancelBackfill stops a running background backfill
+func (s *Service) CancelBackfill() bool {
+	return s.backfiller.Cancel()
+}
+
+// correlateBackfilledFile correlates the entries a backfill inserted from one file
+func (s *Service) correlateBackfilledFile(ctx context.Context, file *BackfillFile, first, last time.Time) {
+	if !s.config.EnableCorrelation {
+		return
+	}
+	if err := s.aggregator.CorrelateLogEntries(ctx, first, last); err != nil {
+		log.Printf("Failed to correlate entries backfilled from %s: %v", file.Path, err)
+	}
+}
+
 // GetRetentionInfo returns information about data retention
 func (s *Service) GetRetentionInfo(ctx context.Context) (*RetentionInfo, error) {
 	info := &RetentionInfo{
@@ -250,12 +250,7 @@ func (sp *StreamingProcessor) processBatch(ctx context.Context, entries []*datab
 	defer tx.Rollback()
 
 	// Prepare statement for efficient batch insert
-	stmt, err := tx.PrepareContext(ctx, `
-		INSERT INTO log_entries (
-			timestamp, message_id, log_type, event, host, sender, 
-			recipients, size, status, error_code, error_text, raw_line
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`)
+	stmt, err := tx.PrepareContext(ctx, database.LogEntryInsertQuery)
 	if err != nil {
 		return fmt.Errorf("failed to prepare statement: %w", err)
 	}
@@ -263,34 +258,15 @@ func (sp *StreamingProcessor) processBatch(ctx context.Context, entries []*datab
 
 	// Insert entries in batch
 	for _, entry := range entries {
-		var recipients *string
-		if len(entry.Recipients) > 0 {
-			recipientsJSON := fmt.Sprintf(`["%s"]`, entry.Recipients[0])
-			if len(entry.Recipients) > 1 {
-				// Simple JSON array construction for multiple recipients
-				recipientsJSON = `["` + entry.Recipients[0]
-				for _, r := range entry.Recipients[1:] {
-					recipientsJSON += `","` + r
-				}
-				recipientsJSON += `"]`
-			}
-			recipients = &recipientsJSON
+		if err := entry.MarshalRecipients(); err != nil {
+			log.Printf("Failed to marshal recipients: %v", err)
+			continue
+		}
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = time.Now()
 		}
 
-		_, err := stmt.ExecContext(ctx,
-			entry.Timestamp,
-			entry.MessageID,
-			entry.LogType,
-			entry.Event,
-			entry.Host,
-			entry.Sender,
-			recipients,
-			entry.Size,
-			entry.Status,
-			entry.ErrorCode,
-			entry.ErrorText,
-			entry.RawLine,
-		)
+		_, err := stmt.ExecContext(ctx, entry.InsertValues()...)
 		if err != nil {
 			log.Printf("Failed to insert log entry: %v", err)
 			continue
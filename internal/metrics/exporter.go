@@ -0,0 +1,361 @@
+package metrics
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+	"github.com/andreitelteu/exim-pilot/internal/logmonitor"
+	"github.com/andreitelteu/exim-pilot/internal/parser"
+	"github.com/andreitelteu/exim-pilot/internal/queue"
+)
+
+const (
+	// DefaultMaxDomains limits the recipient domains tracked as label values. Mail to
+	// further domains is counted under the domain "other".
+	DefaultMaxDomains = 100
+
+	// queueCacheTTL is how long a queue listing is reused between scrapes, since
+	// listing a large queue is expensive
+	queueCacheTTL = 15 * time.Second
+)
+
+// QueueSource provides the queue size. queue.Service implements it.
+type QueueSource interface {
+	GetQueueHealth() (*queue.QueueHealth, error)
+}
+
+// LogSource reports log ingestion progress. logmonitor.LogMonitor implements it.
+type LogSource interface {
+	Status() []logmonitor.FileStatus
+}
+
+// ClientCounter reports connected WebSocket clients. websocket.Hub implements it.
+// Server-Sent Events streams are not WebSocket clients and are not counted.
+type ClientCounter interface {
+	GetClientCount() int
+}
+
+// Config configures the exporter. Sources left nil are not exported.
+type Config struct {
+	Queue        QueueSource
+	Logs         LogSource
+	DatabasePath string
+	MaxDomains   int
+
+	// Token, when set, must be presented as "Authorization: Bearer <token>"
+	Token string
+	// AllowedNetworks lists the IP addresses and CIDR ranges that may scrape. Empty
+	// allows any client. The connecting address is used, not forwarded headers.
+	AllowedNetworks []string
+}
+
+// Exporter collects Exim and Exim Pilot metrics and serves them to Prometheus
+type Exporter struct {
+	registry *Registry
+	config   Config
+	networks []*net.IPNet
+
+	queueMessages   *GaugeVec
+	queueOldestAge  *GaugeVec
+	queueReadUp     *GaugeVec
+	logLines        *CounterVec
+	logParseErrors  *CounterVec
+	logLagBytes     *GaugeVec
+	logLagSeconds   *GaugeVec
+	logLastEntry    *GaugeVec
+	deliveries      *CounterVec
+	deferrals       *CounterVec
+	bounces         *CounterVec
+	rejects         *CounterVec
+	httpDuration    *HistogramVec
+	websocketClient *GaugeVec
+	sqliteSize      *GaugeVec
+
+	mu          sync.Mutex
+	domains     map[string]bool
+	clients     ClientCounter
+	queueHealth *queue.QueueHealth
+	queueErr    error
+	queueRead   time.Time
+
+	now func() time.Time
+}
+
+// NewExporter creates an exporter and registers its metrics
+func NewExporter(config Config) (*Exporter, error) {
+	if config.MaxDomains <= 0 {
+		config.MaxDomains = DefaultMaxDomains
+	}
+
+	var networks []*net.IPNet
+	for _, entry := range config.AllowedNetworks {
+		network, err := parseNetwork(entry)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	r := NewRegistry()
+	e := &Exporter{
+		registry: r,
+		config:   config,
+		networks: networks,
+		domains:  make(map[string]bool),
+		now:      time.Now,
+
+		queueMessages:  r.NewGaugeVec("exim_pilot_queue_messages", "Messages in the Exim queue by status.", "status"),
+		queueOldestAge: r.NewGaugeVec("exim_pilot_queue_oldest_message_age_seconds", "Age of the oldest message in the Exim queue."),
+		queueReadUp:    r.NewGaugeVec("exim_pilot_queue_read_success", "Whether the last queue listing succeeded."),
+
+		logLines:       r.NewCounterVec("exim_pilot_log_lines_total", "Log lines ingested since Exim Pilot started.", "file"),
+		logParseErrors: r.NewCounterVec("exim_pilot_log_parse_errors_total", "Log lines that could not be parsed since Exim Pilot started.", "file"),
+		logLagBytes:    r.NewGaugeVec("exim_pilot_log_ingestion_lag_bytes", "Bytes written to a log file that are not ingested yet.", "file"),
+		logLagSeconds:  r.NewGaugeVec("exim_pilot_log_ingestion_lag_seconds", "Time since the newest ingested line was logged, or 0 when ingestion has caught up.", "file"),
+		logLastEntry:   r.NewGaugeVec("exim_pilot_log_last_entry_timestamp_seconds", "Time logged on the newest ingested line, as a Unix timestamp.", "file"),
+
+		deliveries: r.NewCounterVec("exim_pilot_deliveries_total", "Deliveries by transport and recipient domain.", "transport", "domain"),
+		deferrals:  r.NewCounterVec("exim_pilot_deferrals_total", "Deferred delivery attempts by transport and recipient domain.", "transport", "domain"),
+		bounces:    r.NewCounterVec("exim_pilot_bounces_total", "Bounced recipients by transport and recipient domain.", "transport", "domain"),
+		rejects:    r.NewCounterVec("exim_pilot_rejects_total", "SMTP rejects by reason.", "reason"),
+
+		httpDuration:    r.NewHistogramVec("exim_pilot_http_request_duration_seconds", "Latency of API requests.", DefaultBuckets, "method", "route", "code"),
+		websocketClient: r.NewGaugeVec("exim_pilot_websocket_clients", "Connected WebSocket clients."),
+		sqliteSize:      r.NewGaugeVec("exim_pilot_sqlite_size_bytes", "Size of the SQLite database files.", "file"),
+	}
+
+	r.OnCollect(e.collectQueue)
+	r.OnCollect(e.collectLogs)
+	r.OnCollect(e.collectWebSocket)
+	r.OnCollect(e.collectDatabase)
+
+	return e, nil
+}
+
+// SetClientCounter sets the source of the WebSocket client count
+func (e *Exporter) SetClientCounter(clients ClientCounter) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.clients = clients
+}
+
+// ObserveLogEntry counts a newly ingested log entry
+func (e *Exporter) ObserveLogEntry(entry *database.LogEntry) {
+	switch entry.Event {
+	case database.EventDelivery:
+		e.deliveries.Inc(e.transportDomain(entry)...)
+	case database.EventDefer:
+		e.deferrals.Inc(e.transportDomain(entry)...)
+	case database.EventBounce:
+		e.bounces.Inc(e.transportDomain(entry)...)
+	case database.EventReject:
+		reason := ""
+		if entry.ErrorText != nil {
+			reason = *entry.ErrorText
+		}
+		e.rejects.Inc(parser.RejectReason(reason))
+	}
+}
+
+// ObserveHTTPRequest records the latency of an API request. Route is the path template,
+// such as /api/v1/queue/{id}, so message IDs do not become label values.
+func (e *Exporter) ObserveHTTPRequest(method, route string, code int, duration time.Duration) {
+	e.httpDuration.Observe(duration.Seconds(), method, route, strconv.Itoa(code))
+}
+
+// transportDomain returns the transport and domain label values of a delivery outcome
+func (e *Exporter) transportDomain(entry *database.LogEntry) []string {
+	transport := "none"
+	if entry.Transport != nil && *entry.Transport != "" {
+		transport = *entry.Transport
+	}
+
+	domain := "none"
+	if len(entry.Recipients) > 0 {
+		if at := strings.LastIndexByte(entry.Recipients[0], '@'); at >= 0 {
+			domain = strings.ToLower(strings.TrimRight(entry.Recipients[0][at+1:], ">"))
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.domains[domain] {
+		if len(e.domains) >= e.config.MaxDomains {
+			domain = "other"
+		} else {
+			e.domains[domain] = true
+		}
+	}
+
+	return []string{transport, domain}
+}
+
+func (e *Exporter) collectQueue() {
+	if e.config.Queue == nil {
+		return
+	}
+
+	e.mu.Lock()
+	if e.queueRead.IsZero() || e.now().Sub(e.queueRead) >= queueCacheTTL {
+		e.queueHealth, e.queueErr = e.config.Queue.GetQueueHealth()
+		e.queueRead = e.now()
+		if e.queueErr != nil {
+			log.Printf("Metrics: failed to read the queue: %v", e.queueErr)
+		}
+	}
+	health, err := e.queueHealth, e.queueErr
+	e.mu.Unlock()
+
+	if err != nil {
+		e.queueReadUp.Set(0)
+		return
+	}
+	e.queueReadUp.Set(1)
+
+	active := health.TotalMessages - health.DeferredMessages - health.FrozenMessages
+	if active < 0 {
+		active = 0
+	}
+	e.queueMessages.Set(float64(active), "active")
+	e.queueMessages.Set(float64(health.DeferredMessages), "deferred")
+	e.queueMessages.Set(float64(health.FrozenMessages), "frozen")
+	e.queueOldestAge.Set(health.OldestMessageAge.Seconds())
+}
+
+func (e *Exporter) collectLogs() {
+	if e.config.Logs == nil {
+		return
+	}
+
+	e.logLagSeconds.Reset()
+	e.logLastEntry.Reset()
+
+	now := e.now()
+	for _, status := range e.config.Logs.Status() {
+		e.logLines.Set(float64(status.Lines), status.Path)
+		e.logParseErrors.Set(float64(status.ParseErrors), status.Path)
+
+		behind := status.Size - status.Position
+		if behind < 0 {
+			// Truncated or rotated since the last read
+			behind = 0
+		}
+		e.logLagBytes.Set(float64(behind), status.Path)
+
+		if status.LastEntry.IsZero() {
+			continue
+		}
+		e.logLastEntry.Set(float64(status.LastEntry.Unix()), status.Path)
+		lag := 0.0
+		if behind > 0 {
+			lag = now.Sub(status.LastEntry).Seconds()
+		}
+		e.logLagSeconds.Set(lag, status.Path)
+	}
+}
+
+func (e *Exporter) collectWebSocket() {
+	e.mu.Lock()
+	clients := e.clients
+	e.mu.Unlock()
+
+	if clients != nil {
+		e.websocketClient.Set(float64(clients.GetClientCount()))
+	}
+}
+
+func (e *Exporter) collectDatabase() {
+	if e.config.DatabasePath == "" {
+		return
+	}
+
+	for file, path := range map[string]string{
+		"database": e.config.DatabasePath,
+		"wal":      e.config.DatabasePath + "-wal",
+	} {
+		if info, err := os.Stat(path); err == nil {
+			e.sqliteSize.Set(float64(info.Size()), file)
+		}
+	}
+}
+
+// ServeHTTP serves the metrics to clients that pass the access checks
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !e.allowed(r.RemoteAddr) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if e.config.Token != "" {
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(e.config.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := e.registry.Expose(&buf); err != nil {
+		http.Error(w, "Failed to collect metrics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Write(buf.Bytes())
+}
+
+// allowed reports whether the connecting address may scrape
+func (e *Exporter) allowed(remoteAddr string) bool {
+	if len(e.networks) == 0 {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range e.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNetwork accepts a single IP or a CIDR range
+func parseNetwork(entry string) (*net.IPNet, error) {
+	entry = strings.TrimSpace(entry)
+	if !strings.Contains(entry, "/") {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid metrics allowed network %q", entry)
+		}
+		if ip.To4() != nil {
+			entry += "/32"
+		} else {
+			entry += "/128"
+		}
+	}
+
+	_, network, err := net.ParseCIDR(entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics allowed network %q: %w", entry, err)
+	}
+	return network, nil
+}
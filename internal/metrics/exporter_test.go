@@ -0,0 +1,178 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+	"github.com/andreitelteu/exim-pilot/internal/logmonitor"
+	"github.com/andreitelteu/exim-pilot/internal/queue"
+)
+
+type fakeQueue struct {
+	reads int
+}
+
+func (q *fakeQueue) GetQueueHealth() (*queue.QueueHealth, error) {
+	q.reads++
+	return &queue.QueueHealth{TotalMessages: 10, DeferredMessages: 3, FrozenMessages: 2, OldestMessageAge: 90 * time.Minute}, nil
+}
+
+type fakeLogs struct {
+	status []logmonitor.FileStatus
+}
+
+func (l *fakeLogs) Status() []logmonitor.FileStatus { return l.status }
+
+type fakeClients int
+
+func (c fakeClients) GetClientCount() int { return int(c) }
+
+func stringPtr(s string) *string { return &s }
+
+func scrape(t *testing.T, handler http.Handler, remoteAddr, authorization string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = remoteAddr
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func expectLines(t *testing.T, body string, want ...string) {
+	t.Helper()
+
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(body, "\n") {
+		lines[line] = true
+	}
+	for _, line := range want {
+		if !lines[line] {
+			t.Errorf("Expected line %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestExporterMetrics(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "exim-pilot.db")
+	if err := os.WriteFile(dbPath, make([]byte, 4096), 0600); err != nil {
+		t.Fatalf("Failed to write database file: %v", err)
+	}
+
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	source := &fakeQueue{}
+	logs := &fakeLogs{status: []logmonitor.FileStatus{
+		{Path: "/var/log/exim4/mainlog", Size: 1500, Position: 1000, Lines: 42, ParseErrors: 1, LastEntry: now.Add(-30 * time.Second)},
+		{Path: "/var/log/exim4/rejectlog", Size: 200, Position: 200, Lines: 3},
+	}}
+
+	exporter, err := NewExporter(Config{Queue: source, Logs: logs, DatabasePath: dbPath, MaxDomains: 2})
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	exporter.now = func() time.Time { return now }
+	exporter.SetClientCounter(fakeClients(4))
+
+	for _, entry := range []*database.LogEntry{
+		{Event: database.EventDelivery, Transport: stringPtr("remote_smtp"), Recipients: []string{"a@Example.com"}},
+		{Event: database.EventDelivery, Transport: stringPtr("remote_smtp"), Recipients: []string{"b@example.com"}},
+		{Event: database.EventDefer, Transport: stringPtr("remote_smtp"), Recipients: []string{"c@example.net"}},
+		{Event: database.EventBounce, Recipients: []string{"d@example.org"}},
+		{Event: database.EventReject, ErrorText: stringPtr("relay not permitted")},
+		{Event: database.EventArrival, Recipients: []string{"e@example.com"}},
+	} {
+		exporter.ObserveLogEntry(entry)
+	}
+	exporter.ObserveHTTPRequest("GET", "/api/v1/queue/{id}", 200, 30*time.Millisecond)
+
+	rec := scrape(t, exporter, "127.0.0.1:5000", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != ContentType {
+		t.Fatalf("Unexpected response %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	expectLines(t, rec.Body.String(),
+		"# TYPE exim_pilot_queue_messages gauge",
+		`exim_pilot_queue_messages{status="active"} 5`,
+		`exim_pilot_queue_messages{status="deferred"} 3`,
+		`exim_pilot_queue_messages{status="frozen"} 2`,
+		"exim_pilot_queue_oldest_message_age_seconds 5400",
+		"exim_pilot_queue_read_success 1",
+		"# TYPE exim_pilot_log_lines_total counter",
+		`exim_pilot_log_lines_total{file="/var/log/exim4/mainlog"} 42`,
+		`exim_pilot_log_parse_errors_total{file="/var/log/exim4/mainlog"} 1`,
+		`exim_pilot_log_ingestion_lag_bytes{file="/var/log/exim4/mainlog"} 500`,
+		`exim_pilot_log_ingestion_lag_bytes{file="/var/log/exim4/rejectlog"} 0`,
+		`exim_pilot_log_ingestion_lag_seconds{file="/var/log/exim4/mainlog"} 30`,
+		`exim_pilot_deliveries_total{transport="remote_smtp",domain="example.com"} 2`,
+		`exim_pilot_deferrals_total{transport="remote_smtp",domain="example.net"} 1`,
+		// Only two domains are tracked, so the third is counted as other
+		`exim_pilot_bounces_total{transport="none",domain="other"} 1`,
+		`exim_pilot_rejects_total{reason="relay_denied"} 1`,
+		"# TYPE exim_pilot_http_request_duration_seconds histogram",
+		`exim_pilot_http_request_duration_seconds_bucket{method="GET",route="/api/v1/queue/{id}",code="200",le="0.025"} 0`,
+		`exim_pilot_http_request_duration_seconds_bucket{method="GET",route="/api/v1/queue/{id}",code="200",le="0.05"} 1`,
+		`exim_pilot_http_request_duration_seconds_bucket{method="GET",route="/api/v1/queue/{id}",code="200",le="+Inf"} 1`,
+		`exim_pilot_http_request_duration_seconds_count{method="GET",route="/api/v1/queue/{id}",code="200"} 1`,
+		"exim_pilot_websocket_clients 4",
+		`exim_pilot_sqlite_size_bytes{file="database"} 4096`,
+	)
+
+	// The queue listing is reused between scrapes that follow each other closely
+	scrape(t, exporter, "127.0.0.1:5000", "")
+	if source.reads != 1 {
+		t.Errorf("Expected the queue to be read once, got %d", source.reads)
+	}
+}
+
+func TestExporterAccess(t *testing.T) {
+	exporter, err := NewExporter(Config{Token: "s3cret", AllowedNetworks: []string{"127.0.0.1", "10.0.0.0/8"}})
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+
+	tests := []struct {
+		remoteAddr    string
+		authorization string
+		want          int
+	}{
+		{"127.0.0.1:5000", "Bearer s3cret", http.StatusOK},
+		{"10.1.2.3:5000", "bearer s3cret", http.StatusOK},
+		{"192.0.2.1:5000", "Bearer s3cret", http.StatusForbidden},
+		{"127.0.0.1:5000", "", http.StatusUnauthorized},
+		{"127.0.0.1:5000", "Bearer wrong", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if rec := scrape(t, exporter, tt.remoteAddr, tt.authorization); rec.Code != tt.want {
+			t.Errorf("Scrape from %s with %q: got %d, want %d", tt.remoteAddr, tt.authorization, rec.Code, tt.want)
+		}
+	}
+
+	if _, err := NewExporter(Config{AllowedNetworks: []string{"localhost"}}); err == nil {
+		t.Error("Expected an invalid network to be rejected")
+	}
+}
+
+func TestRegistryEscapesLabelValues(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("test_total", "Help with a \\ and\na newline.", "value")
+	counter.Inc(`quote " backslash \ newline` + "\n")
+
+	var b strings.Builder
+	if err := registry.Expose(&b); err != nil {
+		t.Fatalf("Expose failed: %v", err)
+	}
+
+	expectLines(t, b.String(),
+		`# HELP test_total Help with a \\ and\na newline.`,
+		`test_total{value="quote \" backslash \\ newline\n"} 1`,
+	)
+}
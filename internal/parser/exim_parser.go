@@ -3,13 +3,18 @@ package parser
 import (
 	"fmt"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/andreitelteu/exim-pilot/internal/database"
 )
 
+const (
+	// messageIDPattern matches both the classic 1rABCD-123456-78 message IDs and the
+	// longer 1rABCD-1234567890A-1234 form used since Exim 4.97
+	messageIDPattern = `([A-Za-z0-9]{6}-[A-Za-z0-9]{6,11}-[A-Za-z0-9]{2,4})`
+)
+
 // EximParser parses Exim log entries into structured data
 type EximParser struct {
 	// Compiled regular expressions for different log patterns
@@ -33,45 +38,71 @@ func NewEximParser() *EximParser {
 
 // initializePatterns compiles all the regex patterns for different log types
 func (p *EximParser) initializePatterns() {
-	// Main log patterns
+	// Main log patterns. Lines about a message start with its ID and a marker; the
+	// tagged fields that follow are parsed by applyLogFields.
 	p.mainLogPatterns = []*LogPattern{
 		// Message arrival
 		{
-			Regex:   regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) ([A-Za-z0-9-]+) <= ([^\s]+) H=([^\s]+) \[([^\]]+)\].*?S=(\d+)`),
+			Regex:   regexp.MustCompile(`^` + messageIDPattern + ` <= (\S+)(?: (.*))?$`),
 			Handler: p.handleMessageArrival,
 		},
-		// Message delivery
+		// Delivery, additional delivery to the same host, and suppressed delivery
 		{
-			Regex:   regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) ([A-Za-z0-9-]+) => ([^\s]+) R=([^\s]+) T=([^\s]+) H=([^\s]+) \[([^\]]+)\]`),
+			Regex:   regexp.MustCompile(`^` + messageIDPattern + ` (=>|->|\*>) (\S+)(?: (.*))?$`),
 			Handler: p.handleMessageDelivery,
 		},
 		// Message deferral
 		{
-			Regex:   regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) ([A-Za-z0-9-]+) == ([^\s]+) R=([^\s]+) T=([^\s]+) defer \(([^)]+)\): (.+)`),
+			Regex:   regexp.MustCompile(`^` + messageIDPattern + ` == (\S+)(?: (.*))?$`),
 			Handler: p.handleMessageDefer,
 		},
 		// Message bounce
 		{
-			Regex:   regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) ([A-Za-z0-9-]+) \*\* ([^\s]+) R=([^\s]+) T=([^\s]+): (.+)`),
+			Regex:   regexp.MustCompile(`^` + messageIDPattern + ` \*\* (\S+)(?: (.*))?$`),
 			Handler: p.handleMessageBounce,
 		},
 		// Message completion
 		{
-			Regex:   regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) ([A-Za-z0-9-]+) Completed`),
+			Regex:   regexp.MustCompile(`^` + messageIDPattern + ` Completed(?: (.*))?$`),
 			Handler: p.handleMessageCompleted,
 		},
+		// Frozen and unfrozen, automatically or by an administrator
+		{
+			Regex:   regexp.MustCompile(`^` + messageIDPattern + ` ((?i:frozen|unfrozen))(?: (.*))?$`),
+			Handler: p.handleMessageFrozen,
+		},
+		// Message removed or cancelled by an administrator
+		{
+			Regex:   regexp.MustCompile(`^` + messageIDPattern + ` (removed|cancelled) by (.+)$`),
+			Handler: p.handleMessageRemoved,
+		},
+		// Message queued without an immediate delivery attempt
+		{
+			Regex:   regexp.MustCompile(`^` + messageIDPattern + ` no immediate delivery:? ?(.*)$`),
+			Handler: p.handleNoImmediateDelivery,
+		},
+		// Queue runner skipping a message or host whose retry time has not come
+		{
+			Regex:   regexp.MustCompile(`^(?:` + messageIDPattern + ` )?(.*\bretry time not reached\b.*)$`),
+			Handler: p.handleRetryNotReached,
+		},
+		// Incoming SMTP connection opened, lost or closed
+		{
+			Regex:   regexp.MustCompile(`^SMTP connection from (\S.*)$`),
+			Handler: p.handleSMTPConnection,
+		},
 	}
 
 	// Reject log patterns
 	p.rejectLogPatterns = []*LogPattern{
 		// Connection rejected
 		{
-			Regex:   regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) rejected connection from \[([^\]]+)\]: (.+)`),
+			Regex:   regexp.MustCompile(`^rejected connection from \[([^\]]+)\]: (.+)`),
 			Handler: p.handleConnectionRejected,
 		},
 		// SMTP rejection
 		{
-			Regex:   regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) H=([^\s]+) \[([^\]]+)\] rejected ([A-Z]+) <([^>]+)>: (.+)`),
+			Regex:   regexp.MustCompile(`^H=([^\s]+) \[([^\]]+)\] rejected ([A-Z]+) <([^>]+)>: (.+)`),
 			Handler: p.handleSMTPRejected,
 		},
 	}
@@ -80,7 +111,7 @@ func (p *EximParser) initializePatterns() {
 	p.panicLogPatterns = []*LogPattern{
 		// General panic/error
 		{
-			Regex:   regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) exim: (panic|error): (.+)`),
+			Regex:   regexp.MustCompile(`^exim: (panic|error): (.+)`),
 			Handler: p.handlePanicError,
 		},
 	}
@@ -104,20 +135,21 @@ func (p *EximParser) ParseLogLine(line, logType string) (*database.LogEntry, err
 		return nil, fmt.Errorf("unknown log type: %s", logType)
 	}
 
+	// The timestamp, pid and syslog framing are split off, so the patterns only see the
+	// text Exim logged
+	prefix, text, ok := parsePrefix(line)
+	if !ok {
+		return p.createGenericLogEntry(line, logType, time.Now(), nil)
+	}
+
 	// Try each pattern until one matches
 	for _, pattern := range patterns {
-		if matches := pattern.Regex.FindStringSubmatch(line); matches != nil {
-			// Parse timestamp from the first capture group
-			timestamp, err := p.parseTimestamp(matches[1])
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse timestamp: %w", err)
-			}
-
-			// Call the pattern's handler
-			entry := pattern.Handler(matches, timestamp, line)
+		if matches := pattern.Regex.FindStringSubmatch(text); matches != nil {
+			entry := pattern.Handler(matches, prefix.Timestamp, line)
 			if entry != nil {
 				entry.LogType = logType
 				entry.RawLine = line
+				entry.PID = prefix.PID
 				entry.CreatedAt = time.Now()
 			}
 			return entry, nil
@@ -125,36 +157,17 @@ func (p *EximParser) ParseLogLine(line, logType string) (*database.LogEntry, err
 	}
 
 	// If no pattern matched, create a generic log entry
-	return p.createGenericLogEntry(line, logType)
-}
-
-// parseTimestamp parses Exim timestamp format
-func (p *EximParser) parseTimestamp(timestampStr string) (time.Time, error) {
-	return time.Parse("2006-01-02 15:04:05", timestampStr)
+	return p.createGenericLogEntry(line, logType, prefix.Timestamp, prefix.PID)
 }
 
 // createGenericLogEntry creates a generic log entry for unparsed lines
-func (p *EximParser) createGenericLogEntry(line, logType string) (*database.LogEntry, error) {
-	timestampRegex := regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})`)
-	matches := timestampRegex.FindStringSubmatch(line)
-
-	var timestamp time.Time
-	var err error
-
-	if len(matches) > 1 {
-		timestamp, err = p.parseTimestamp(matches[1])
-		if err != nil {
-			timestamp = time.Now()
-		}
-	} else {
-		timestamp = time.Now()
-	}
-
+func (p *EximParser) createGenericLogEntry(line, logType string, timestamp time.Time, pid *int64) (*database.LogEntry, error) {
 	return &database.LogEntry{
 		Timestamp: timestamp,
 		LogType:   logType,
 		Event:     "unknown",
 		RawLine:   line,
+		PID:       pid,
 		CreatedAt: time.Now(),
 	}, nil
 }
@@ -162,85 +175,224 @@ func (p *EximParser) createGenericLogEntry(line, logType string) (*database.LogE
 // Handler functions for different log patterns
 
 func (p *EximParser) handleMessageArrival(matches []string, timestamp time.Time, rawLine string) *database.LogEntry {
-	messageID := matches[2]
-	sender := matches[3]
-	host := matches[4]
-	sizeStr := matches[6]
-
-	size, _ := strconv.ParseInt(sizeStr, 10, 64)
+	messageID := matches[1]
+	sender := matches[2]
 
-	return &database.LogEntry{
+	entry := &database.LogEntry{
 		Timestamp: timestamp,
 		MessageID: &messageID,
 		Event:     database.EventArrival,
-		Host:      &host,
 		Sender:    &sender,
-		Size:      &size,
 		Status:    stringPtr("received"),
 	}
+
+	fields := splitLogFields(matches[3])
+	applyLogFields(entry, fields)
+
+	// With +received_recipients the line ends with "for" and the envelope recipients
+	for i, field := range fields {
+		if field.Key == "" && field.Value == "for" {
+			for _, recipient := range fields[i+1:] {
+				entry.Recipients = append(entry.Recipients, recipient.Value)
+			}
+			break
+		}
+	}
+
+	return entry
 }
 
 func (p *EximParser) handleMessageDelivery(matches []string, timestamp time.Time, rawLine string) *database.LogEntry {
-	messageID := matches[2]
+	messageID := matches[1]
+	marker := matches[2]
 	recipient := matches[3]
-	host := matches[6]
 
-	return &database.LogEntry{
+	entry := &database.LogEntry{
 		Timestamp:  timestamp,
 		MessageID:  &messageID,
 		Event:      database.EventDelivery,
-		Host:       &host,
 		Recipients: []string{recipient},
 		Status:     stringPtr("delivered"),
 	}
+	if marker == "*>" {
+		entry.Event = database.EventSuppressed
+		entry.Status = stringPtr("suppressed")
+	}
+
+	applyLogFields(entry, splitLogFields(matches[4]))
+	return entry
 }
 
+// deferCodePattern matches the errno printed in deferral lines, e.g. "defer (-44)"
+var deferCodePattern = regexp.MustCompile(`(?:^|\s)(?:routing )?defer \((-?\d+)\)`)
+
 func (p *EximParser) handleMessageDefer(matches []string, timestamp time.Time, rawLine string) *database.LogEntry {
-	messageID := matches[2]
-	recipient := matches[3]
-	errorCode := matches[6]
-	errorText := matches[7]
+	messageID := matches[1]
+	recipient := matches[2]
+	rest := matches[3]
 
-	return &database.LogEntry{
+	entry := &database.LogEntry{
 		Timestamp:  timestamp,
 		MessageID:  &messageID,
 		Event:      database.EventDefer,
 		Recipients: []string{recipient},
 		Status:     stringPtr("deferred"),
-		ErrorCode:  &errorCode,
-		ErrorText:  &errorText,
 	}
+
+	// "R=... T=... defer (-44) H=host [ip]: text": the code sits between the routing
+	// fields and the host fields, and the error text follows the first colon
+	if loc := deferCodePattern.FindStringSubmatchIndex(rest); loc != nil {
+		entry.ErrorCode = stringPtr(rest[loc[2]:loc[3]])
+		rest = rest[:loc[0]] + rest[loc[1]:]
+	}
+	fields, errorText := splitErrorText(rest)
+	applyLogFields(entry, splitLogFields(fields))
+	entry.ErrorText = stringPtr(errorText)
+
+	// No delivery was attempted, so these are not counted as deferrals
+	if strings.Contains(errorText, "retry time not reached") {
+		entry.Event = database.EventRetryNotReached
+	}
+
+	return entry
 }
 
 func (p *EximParser) handleMessageBounce(matches []string, timestamp time.Time, rawLine string) *database.LogEntry {
-	messageID := matches[2]
-	recipient := matches[3]
-	errorText := matches[6]
+	messageID := matches[1]
+	recipient := matches[2]
+	rest := matches[3]
+
+	// "** user@example.com: Unrouteable address" has no fields before the text
+	if strings.HasSuffix(recipient, ":") {
+		recipient = strings.TrimSuffix(recipient, ":")
+		rest = ": " + rest
+	}
 
-	return &database.LogEntry{
+	entry := &database.LogEntry{
 		Timestamp:  timestamp,
 		MessageID:  &messageID,
 		Event:      database.EventBounce,
 		Recipients: []string{recipient},
 		Status:     stringPtr("bounced"),
-		ErrorText:  &errorText,
 	}
+
+	fields, errorText := splitErrorText(rest)
+	applyLogFields(entry, splitLogFields(fields))
+	entry.ErrorText = stringPtr(errorText)
+
+	return entry
 }
 
 func (p *EximParser) handleMessageCompleted(matches []string, timestamp time.Time, rawLine string) *database.LogEntry {
-	messageID := matches[2]
+	messageID := matches[1]
 
-	return &database.LogEntry{
+	entry := &database.LogEntry{
 		Timestamp: timestamp,
 		MessageID: &messageID,
-		Event:     "completed",
+		Event:     database.EventCompleted,
 		Status:    stringPtr("completed"),
 	}
+
+	// With +queue_time_overall the line carries the total time on queue
+	applyLogFields(entry, splitLogFields(matches[2]))
+	return entry
+}
+
+func (p *EximParser) handleMessageFrozen(matches []string, timestamp time.Time, rawLine string) *database.LogEntry {
+	messageID := matches[1]
+	event := database.EventFrozen
+	if strings.EqualFold(matches[2], "unfrozen") {
+		event = database.EventUnfrozen
+	}
+
+	// The reason, e.g. "(delivery error message)" or "by root", goes in the error text
+	return &database.LogEntry{
+		Timestamp: timestamp,
+		MessageID: &messageID,
+		Event:     event,
+		Status:    stringPtr(event),
+		ErrorText: stringPtr(matches[3]),
+	}
+}
+
+func (p *EximParser) handleMessageRemoved(matches []string, timestamp time.Time, rawLine string) *database.LogEntry {
+	messageID := matches[1]
+	event := database.EventRemoved
+	if matches[2] == "cancelled" {
+		event = database.EventCancelled
+	}
+
+	return &database.LogEntry{
+		Timestamp: timestamp,
+		MessageID: &messageID,
+		Event:     event,
+		Status:    stringPtr(event),
+		ErrorText: stringPtr(matches[2] + " by " + matches[3]),
+	}
+}
+
+func (p *EximParser) handleNoImmediateDelivery(matches []string, timestamp time.Time, rawLine string) *database.LogEntry {
+	messageID := matches[1]
+
+	return &database.LogEntry{
+		Timestamp: timestamp,
+		MessageID: &messageID,
+		Event:     database.EventQueued,
+		Status:    stringPtr("queued"),
+		ErrorText: stringPtr(matches[2]),
+	}
+}
+
+func (p *EximParser) handleRetryNotReached(matches []string, timestamp time.Time, rawLine string) *database.LogEntry {
+	return &database.LogEntry{
+		Timestamp: timestamp,
+		MessageID: stringPtr(matches[1]),
+		Event:     database.EventRetryNotReached,
+		Status:    stringPtr("deferred"),
+		ErrorText: stringPtr(matches[2]),
+	}
+}
+
+func (p *EximParser) handleSMTPConnection(matches []string, timestamp time.Time, rawLine string) *database.LogEntry {
+	// "host.example.com (helo) [192.0.2.1]:25 I=[192.0.2.2]:25 lost D=2s" or
+	// "[192.0.2.1]:25 (TCP/IP connection count = 3)"
+	fields := splitLogFields(matches[1])
+	hostFields := append([]logField{{Key: "H", Value: fields[0].Value}}, fields[1:]...)
+
+	// The description is the bare text after the remote address
+	var description []string
+	for i, field := range fields {
+		if strings.HasPrefix(field.Value, "[") && field.Key == "" {
+			for _, rest := range fields[i+1:] {
+				if rest.Key == "" {
+					description = append(description, rest.Value)
+				}
+			}
+			break
+		}
+	}
+	text := strings.Join(description, " ")
+
+	status := "connected"
+	switch {
+	case strings.HasPrefix(text, "lost"):
+		status = "lost"
+	case strings.HasPrefix(text, "closed"):
+		status = "closed"
+	}
+
+	return &database.LogEntry{
+		Timestamp: timestamp,
+		Event:     database.EventConnection,
+		Host:      hostFromFields(hostFields),
+		Status:    &status,
+		ErrorText: stringPtr(text),
+	}
 }
 
 func (p *EximParser) handleConnectionRejected(matches []string, timestamp time.Time, rawLine string) *database.LogEntry {
-	ipAddress := matches[2]
-	reason := matches[3]
+	ipAddress := matches[1]
+	reason := matches[2]
 
 	return &database.LogEntry{
 		Timestamp: timestamp,
@@ -252,9 +404,9 @@ func (p *EximParser) handleConnectionRejected(matches []string, timestamp time.T
 }
 
 func (p *EximParser) handleSMTPRejected(matches []string, timestamp time.Time, rawLine string) *database.LogEntry {
-	host := matches[2]
-	recipient := matches[5]
-	reason := matches[6]
+	host := matches[1]
+	recipient := matches[4]
+	reason := matches[5]
 
 	return &database.LogEntry{
 		Timestamp:  timestamp,
@@ -267,8 +419,8 @@ func (p *EximParser) handleSMTPRejected(matches []string, timestamp time.Time, r
 }
 
 func (p *EximParser) handlePanicError(matches []string, timestamp time.Time, rawLine string) *database.LogEntry {
-	level := matches[2]
-	message := matches[3]
+	level := matches[1]
+	message := matches[2]
 
 	return &database.LogEntry{
 		Timestamp: timestamp,
@@ -289,8 +441,9 @@ func stringPtr(s string) *string {
 
 // ExtractMessageID extracts message ID from a log line if present
 func (p *EximParser) ExtractMessageID(line string) string {
-	// Exim message IDs have format: 1rABC-123456-78 (6 chars, dash, 6 chars, dash, 2 chars)
-	messageIDRegex := regexp.MustCompile(`\b([A-Za-z0-9]{6}-[A-Za-z0-9]{6}-[A-Za-z0-9]{2})\b`)
+	// Exim message IDs have format: 1rABC-123456-78 (6 chars, dash, 6 chars, dash, 2 chars),
+	// or 1rABCD-1234567890A-1234 since Exim 4.97
+	messageIDRegex := regexp.MustCompile(`\b` + messageIDPattern + `\b`)
 	matches := messageIDRegex.FindStringSubmatch(line)
 	if len(matches) > 1 {
 		return matches[1]
@@ -1,6 +1,9 @@
 package parser
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/andreitelteu/exim-pilot/internal/database"
@@ -144,6 +147,299 @@ func TestEximParser_ParseLogLine(t *testing.T) {
 	}
 }
 
+func TestEximParser_MainLogVocabulary(t *testing.T) {
+	parser := NewEximParser()
+
+	tests := []struct {
+		name     string
+		line     string
+		expected database.LogEntry
+	}{
+		{
+			name: "TLS arrival with message ID header and flags",
+			line: `2024-01-15 10:30:45 1rABCD-123456-78 <= sender@example.com H=mail.example.com (helo.example.com) [192.168.1.1]:41234 I=[10.0.0.1]:25 P=esmtps X=TLS1.3:TLS_AES_256_GCM_SHA384:256 CV=no DN="CN=mail.example.com,O=Example \"Mail\"" K S=1234 PRDR id=abc.123@example.com T="Hello: world" for a@example.net b@example.net`,
+			expected: database.LogEntry{
+				MessageID:       testStringPtr("1rABCD-123456-78"),
+				Event:           database.EventArrival,
+				Host:            testStringPtr("mail.example.com"),
+				Sender:          testStringPtr("sender@example.com"),
+				Recipients:      []string{"a@example.net", "b@example.net"},
+				Size:            testInt64Ptr(1234),
+				Status:          testStringPtr("received"),
+				MessageIDHeader: testStringPtr("abc.123@example.com"),
+				Protocol:        testStringPtr("esmtps"),
+				TLSCipher:       testStringPtr("TLS1.3:TLS_AES_256_GCM_SHA384:256"),
+				TLSVerify:       testStringPtr("no"),
+				TLSPeerDN:       testStringPtr(`CN=mail.example.com,O=Example "Mail"`),
+				Chunking:        true,
+				PRDR:            true,
+			},
+		},
+		{
+			name: "Local submission without a host and with a long message ID",
+			line: "2024-01-15 10:30:45 1rABCD-0123456789A-1234 <= <> U=root P=local S=512",
+			expected: database.LogEntry{
+				MessageID: testStringPtr("1rABCD-0123456789A-1234"),
+				Event:     database.EventArrival,
+				Sender:    testStringPtr("<>"),
+				Size:      testInt64Ptr(512),
+				Status:    testStringPtr("received"),
+				Protocol:  testStringPtr("local"),
+			},
+		},
+		{
+			name: "Arrival from a host without reverse DNS",
+			line: "2024-01-15 10:30:45 1rABCD-123456-78 <= sender@example.com H=(helo.example.com) [192.168.1.1]:41234 P=esmtp S=10",
+			expected: database.LogEntry{
+				MessageID: testStringPtr("1rABCD-123456-78"),
+				Event:     database.EventArrival,
+				Host:      testStringPtr("192.168.1.1"),
+				Sender:    testStringPtr("sender@example.com"),
+				Size:      testInt64Ptr(10),
+				Status:    testStringPtr("received"),
+				Protocol:  testStringPtr("esmtp"),
+			},
+		},
+		{
+			name: "Delivery with confirmation and timings",
+			line: `2024-01-15 10:31:00 1rABCD-123456-78 => rcpt@example.net <alias@example.org> R=dnslookup T=remote_smtp H=mx.example.net [192.168.1.2] X=TLS1.2:ECDHE-RSA-AES256-GCM-SHA384:256 CV=yes DN="CN=mx.example.net" K C="250 2.0.0 OK: queued as 4A1B2C" QT=1m2.5s DT=0.25s`,
+			expected: database.LogEntry{
+				MessageID:    testStringPtr("1rABCD-123456-78"),
+				Event:        database.EventDelivery,
+				Host:         testStringPtr("mx.example.net"),
+				Recipients:   []string{"rcpt@example.net"},
+				Status:       testStringPtr("delivered"),
+				TLSCipher:    testStringPtr("TLS1.2:ECDHE-RSA-AES256-GCM-SHA384:256"),
+				TLSVerify:    testStringPtr("yes"),
+				TLSPeerDN:    testStringPtr("CN=mx.example.net"),
+				Confirmation: testStringPtr("250 2.0.0 OK: queued as 4A1B2C"),
+				QueueTime:    testFloat64Ptr(62.5),
+				DeliveryTime: testFloat64Ptr(0.25),
+				Chunking:     true,
+				Router:       testStringPtr("dnslookup"),
+				Transport:    testStringPtr("remote_smtp"),
+			},
+		},
+		{
+			name: "Additional delivery",
+			line: `2024-01-15 10:31:00 1rABCD-123456-78 -> other@example.net R=dnslookup T=remote_smtp H=mx.example.net [192.168.1.2] C="250 OK" QT=2s DT=1s`,
+			expected: database.LogEntry{
+				MessageID:    testStringPtr("1rABCD-123456-78"),
+				Event:        database.EventDelivery,
+				Host:         testStringPtr("mx.example.net"),
+				Recipients:   []string{"other@example.net"},
+				Status:       testStringPtr("delivered"),
+				Confirmation: testStringPtr("250 OK"),
+				QueueTime:    testFloat64Ptr(2),
+				DeliveryTime: testFloat64Ptr(1),
+				Router:       testStringPtr("dnslookup"),
+				Transport:    testStringPtr("remote_smtp"),
+			},
+		},
+		{
+			name: "Suppressed delivery",
+			line: "2024-01-15 10:31:00 1rABCD-123456-78 *> rcpt@example.net R=dnslookup T=remote_smtp H=mx.example.net [192.168.1.2]",
+			expected: database.LogEntry{
+				MessageID:  testStringPtr("1rABCD-123456-78"),
+				Event:      database.EventSuppressed,
+				Host:       testStringPtr("mx.example.net"),
+				Recipients: []string{"rcpt@example.net"},
+				Status:     testStringPtr("suppressed"),
+				Router:     testStringPtr("dnslookup"),
+				Transport:  testStringPtr("remote_smtp"),
+			},
+		},
+		{
+			name: "Deferral after contacting a host",
+			line: "2024-01-15 10:31:00 1rABCD-123456-78 == rcpt@example.net R=dnslookup T=remote_smtp defer (-44) H=mx.example.net [192.168.1.2]: SMTP error from remote mail server after RCPT TO:<rcpt@example.net>: 451 Try later",
+			expected: database.LogEntry{
+				MessageID:  testStringPtr("1rABCD-123456-78"),
+				Event:      database.EventDefer,
+				Host:       testStringPtr("mx.example.net"),
+				Recipients: []string{"rcpt@example.net"},
+				Status:     testStringPtr("deferred"),
+				ErrorCode:  testStringPtr("-44"),
+				ErrorText:  testStringPtr("SMTP error from remote mail server after RCPT TO:<rcpt@example.net>: 451 Try later"),
+				Router:     testStringPtr("dnslookup"),
+				Transport:  testStringPtr("remote_smtp"),
+			},
+		},
+		{
+			name: "Routing deferral with retry time not reached",
+			line: "2024-01-15 10:31:00 1rABCD-123456-78 == rcpt@example.net routing defer (-51): retry time not reached",
+			expected: database.LogEntry{
+				MessageID:  testStringPtr("1rABCD-123456-78"),
+				Event:      database.EventRetryNotReached,
+				Recipients: []string{"rcpt@example.net"},
+				Status:     testStringPtr("deferred"),
+				ErrorCode:  testStringPtr("-51"),
+				ErrorText:  testStringPtr("retry time not reached"),
+			},
+		},
+		{
+			name: "Host retry time not reached",
+			line: "2024-01-15 10:31:00 1rABCD-123456-78 mx.example.net [192.168.1.2]: retry time not reached",
+			expected: database.LogEntry{
+				MessageID: testStringPtr("1rABCD-123456-78"),
+				Event:     database.EventRetryNotReached,
+				Status:    testStringPtr("deferred"),
+				ErrorText: testStringPtr("mx.example.net [192.168.1.2]: retry time not reached"),
+			},
+		},
+		{
+			name: "Bounce without routing fields",
+			line: "2024-01-15 10:31:00 1rABCD-123456-78 ** nobody@example.net: Unrouteable address",
+			expected: database.LogEntry{
+				MessageID:  testStringPtr("1rABCD-123456-78"),
+				Event:      database.EventBounce,
+				Recipients: []string{"nobody@example.net"},
+				Status:     testStringPtr("bounced"),
+				ErrorText:  testStringPtr("Unrouteable address"),
+			},
+		},
+		{
+			name: "Bounce from a remote host",
+			line: "2024-01-15 10:31:00 1rABCD-123456-78 ** rcpt@example.net R=dnslookup T=remote_smtp H=mx.example.net [192.168.1.2]: SMTP error from remote mail server after RCPT TO:<rcpt@example.net>: 550 No such user",
+			expected: database.LogEntry{
+				MessageID:  testStringPtr("1rABCD-123456-78"),
+				Event:      database.EventBounce,
+				Host:       testStringPtr("mx.example.net"),
+				Recipients: []string{"rcpt@example.net"},
+				Status:     testStringPtr("bounced"),
+				ErrorText:  testStringPtr("SMTP error from remote mail server after RCPT TO:<rcpt@example.net>: 550 No such user"),
+				Router:     testStringPtr("dnslookup"),
+				Transport:  testStringPtr("remote_smtp"),
+			},
+		},
+		{
+			name: "Completed with overall queue time",
+			line: "2024-01-15 10:31:00 1rABCD-123456-78 Completed QT=1h2m3s",
+			expected: database.LogEntry{
+				MessageID: testStringPtr("1rABCD-123456-78"),
+				Event:     database.EventCompleted,
+				Status:    testStringPtr("completed"),
+				QueueTime: testFloat64Ptr(3723),
+			},
+		},
+		{
+			name: "Frozen",
+			line: "2024-01-15 10:31:00 1rABCD-123456-78 Frozen (delivery error message)",
+			expected: database.LogEntry{
+				MessageID: testStringPtr("1rABCD-123456-78"),
+				Event:     database.EventFrozen,
+				Status:    testStringPtr(database.EventFrozen),
+				ErrorText: testStringPtr("(delivery error message)"),
+			},
+		},
+		{
+			name: "Unfrozen by administrator",
+			line: "2024-01-15 10:31:00 1rABCD-123456-78 unfrozen by root",
+			expected: database.LogEntry{
+				MessageID: testStringPtr("1rABCD-123456-78"),
+				Event:     database.EventUnfrozen,
+				Status:    testStringPtr(database.EventUnfrozen),
+				ErrorText: testStringPtr("by root"),
+			},
+		},
+		{
+			name: "Removed",
+			line: "2024-01-15 10:31:00 1rABCD-123456-78 removed by root",
+			expected: database.LogEntry{
+				MessageID: testStringPtr("1rABCD-123456-78"),
+				Event:     database.EventRemoved,
+				Status:    testStringPtr(database.EventRemoved),
+				ErrorText: testStringPtr("removed by root"),
+			},
+		},
+		{
+			name: "Cancelled",
+			line: "2024-01-15 10:31:00 1rABCD-123456-78 cancelled by root",
+			expected: database.LogEntry{
+				MessageID: testStringPtr("1rABCD-123456-78"),
+				Event:     database.EventCancelled,
+				Status:    testStringPtr(database.EventCancelled),
+				ErrorText: testStringPtr("cancelled by root"),
+			},
+		},
+		{
+			name: "No immediate delivery",
+			line: "2024-01-15 10:31:00 1rABCD-123456-78 no immediate delivery: load average 8.50",
+			expected: database.LogEntry{
+				MessageID: testStringPtr("1rABCD-123456-78"),
+				Event:     database.EventQueued,
+				Status:    testStringPtr("queued"),
+				ErrorText: testStringPtr("load average 8.50"),
+			},
+		},
+		{
+			name: "SMTP connection opened",
+			line: "2024-01-15 10:30:44 SMTP connection from [192.168.1.1]:41234 (TCP/IP connection count = 3)",
+			expected: database.LogEntry{
+				Event:     database.EventConnection,
+				Host:      testStringPtr("192.168.1.1"),
+				Status:    testStringPtr("connected"),
+				ErrorText: testStringPtr("(TCP/IP connection count = 3)"),
+			},
+		},
+		{
+			name: "SMTP connection lost",
+			line: "2024-01-15 10:30:46 SMTP connection from mail.example.com (helo.example.com) [192.168.1.1]:41234 I=[10.0.0.1]:25 lost D=2s",
+			expected: database.LogEntry{
+				Event:     database.EventConnection,
+				Host:      testStringPtr("mail.example.com"),
+				Status:    testStringPtr("lost"),
+				ErrorText: testStringPtr("lost"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parser.ParseLogLine(tt.line, database.LogTypeMain)
+			if err != nil {
+				t.Fatalf("ParseLogLine() unexpected error: %v", err)
+			}
+
+			expected := tt.expected
+			expected.Timestamp = result.Timestamp
+			expected.LogType = database.LogTypeMain
+			expected.RawLine = tt.line
+			expected.CreatedAt = result.CreatedAt
+
+			if !reflect.DeepEqual(*result, expected) {
+				t.Errorf("ParseLogLine() =\n%s\nwant\n%s", describeEntry(result), describeEntry(&expected))
+			}
+		})
+	}
+}
+
+func TestParseEximDuration(t *testing.T) {
+	tests := map[string]float64{
+		"0s":       0,
+		"2s":       2,
+		"0.123s":   0.123,
+		"1m30s":    90,
+		"1h2m3s":   3723,
+		"1d2h":     93600,
+		"1w":       604800,
+		"45":       45,
+		"1m2.500s": 62.5,
+	}
+
+	for value, want := range tests {
+		got := parseEximDuration(value)
+		if got == nil || *got != want {
+			t.Errorf("parseEximDuration(%q) = %v, want %v", value, got, want)
+		}
+	}
+
+	for _, value := range []string{"", "2x", "s"} {
+		if got := parseEximDuration(value); got != nil {
+			t.Errorf("parseEximDuration(%q) = %v, want nil", value, *got)
+		}
+	}
+}
+
 func TestEximParser_ExtractMessageID(t *testing.T) {
 	parser := NewEximParser()
 
@@ -162,6 +458,11 @@ func TestEximParser_ExtractMessageID(t *testing.T) {
 			line:     "2024-01-15 10:30:45 rejected connection from [192.168.1.100]",
 			expected: "",
 		},
+		{
+			name:     "Long message ID",
+			line:     "2024-01-15 10:30:45 1rABCD-0123456789A-1234 Completed",
+			expected: "1rABCD-0123456789A-1234",
+		},
 		{
 			name:     "Multiple message IDs",
 			line:     "2024-01-15 10:30:45 1rABCD-123456-78 related to 1rDEFG-789012-34",
@@ -188,3 +489,21 @@ func testStringPtr(s string) *string {
 func testInt64Ptr(i int64) *int64 {
 	return &i
 }
+
+func testFloat64Ptr(f float64) *float64 {
+	return &f
+}
+
+// describeEntry formats a log entry with its pointer fields dereferenced
+func describeEntry(entry *database.LogEntry) string {
+	var b strings.Builder
+	value := reflect.ValueOf(entry).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if field.Kind() == reflect.Ptr && !field.IsNil() {
+			field = field.Elem()
+		}
+		fmt.Fprintf(&b, "  %s: %v\n", value.Type().Field(i).Name, field.Interface())
+	}
+	return b.String()
+}
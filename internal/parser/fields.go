@@ -0,0 +1,264 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// logField is one space-separated element of a log line, either a tagged KEY=value
+// pair or a bare word such as an address, a flag or a bracketed IP address
+type logField struct {
+	Key   string
+	Value string
+}
+
+// splitLogFields splits the tail of an Exim log line into fields. Quoted values may
+// contain spaces and backslash escapes, as written by Exim for C=, DN= and T=.
+func splitLogFields(s string) []logField {
+	var fields []logField
+
+	i := 0
+	for i < len(s) {
+		if s[i] == ' ' {
+			i++
+			continue
+		}
+
+		key := ""
+		if k := tagLength(s[i:]); k > 0 {
+			key = s[i : i+k]
+			i += k + 1
+		}
+
+		var value string
+		switch {
+		case key != "" && i < len(s) && s[i] == '"':
+			value, i = readQuoted(s, i)
+		case key == "" && (s[i] == '(' || s[i] == '['):
+			// HELO names and IP addresses are bracketed and may be followed by :port
+			closing := byte(')')
+			if s[i] == '[' {
+				closing = ']'
+			}
+			end := strings.IndexByte(s[i:], closing)
+			if end < 0 {
+				end = len(s) - i - 1
+			}
+			j := i + end + 1
+			for j < len(s) && s[j] != ' ' {
+				j++
+			}
+			value, i = s[i:j], j
+		default:
+			j := i
+			for j < len(s) && s[j] != ' ' {
+				j++
+			}
+			value, i = s[i:j], j
+		}
+
+		fields = append(fields, logField{Key: key, Value: value})
+	}
+
+	return fields
+}
+
+// tagLength returns the length of a KEY= tag at the start of s, excluding the '='
+func tagLength(s string) int {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '=' && i > 0:
+			return i
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return 0
+		}
+	}
+	return 0
+}
+
+// readQuoted reads a double-quoted value starting at s[start] and returns it unescaped
+// along with the index just past the closing quote
+func readQuoted(s string, start int) (string, int) {
+	var b strings.Builder
+	i := start + 1
+	for i < len(s) {
+		c := s[i]
+		if c == '\\' && i+1 < len(s) {
+			b.WriteByte(s[i+1])
+			i += 2
+			continue
+		}
+		if c == '"' {
+			return b.String(), i + 1
+		}
+		b.WriteByte(c)
+		i++
+	}
+	return b.String(), i
+}
+
+// splitErrorText splits "fields: text" at the first colon outside quotes, as used by
+// deferral and bounce lines
+func splitErrorText(s string) (string, string) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ':':
+			if !quoted && (i+1 == len(s) || s[i+1] == ' ') {
+				return s[:i], strings.TrimSpace(s[i+1:])
+			}
+		}
+	}
+	return s, ""
+}
+
+// applyLogFields copies the tagged values shared by arrival and delivery lines into
+// the entry. Fields after a bare "for" are the recipient list and are left alone.
+func applyLogFields(entry *database.LogEntry, fields []logField) {
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+
+		switch field.Key {
+		case "":
+			switch field.Value {
+			case "for":
+				return
+			case "K":
+				entry.Chunking = true
+			case "PRDR":
+				entry.PRDR = true
+			}
+		case "H":
+			entry.Host = hostFromFields(fields[i:])
+		case "S":
+			if size, err := strconv.ParseInt(field.Value, 10, 64); err == nil {
+				entry.Size = &size
+			}
+		case "P":
+			entry.Protocol = stringPtr(field.Value)
+		case "X":
+			entry.TLSCipher = stringPtr(field.Value)
+		case "CV":
+			entry.TLSVerify = stringPtr(field.Value)
+		case "DN":
+			entry.TLSPeerDN = stringPtr(field.Value)
+		case "C":
+			entry.Confirmation = stringPtr(field.Value)
+		case "id":
+			entry.MessageIDHeader = stringPtr(field.Value)
+		case "QT":
+			entry.QueueTime = parseEximDuration(field.Value)
+		case "DT":
+			entry.DeliveryTime = parseEximDuration(field.Value)
+		case "R":
+			entry.Router = stringPtr(field.Value)
+		case "T":
+			// On arrival lines T= is the subject, logged with +subject
+			if entry.Event != database.EventArrival {
+				entry.Transport = stringPtr(field.Value)
+			}
+		}
+	}
+}
+
+// hostFromFields returns the host named by an H= field and the bare fields that follow
+// it. The DNS name is preferred; hosts without one are identified by IP address.
+func hostFromFields(fields []logField) *string {
+	name := fields[0].Value
+	ip := ""
+
+	// H=(helo) [ip] and H=[ip] identify hosts without a DNS name
+	if strings.HasPrefix(name, "[") {
+		ip, name = name, ""
+	} else if strings.HasPrefix(name, "(") {
+		name = ""
+	}
+
+	for _, field := range fields[1:] {
+		if ip != "" || field.Key != "" {
+			break
+		}
+		if strings.HasPrefix(field.Value, "[") {
+			ip = field.Value
+			break
+		}
+		if !strings.HasPrefix(field.Value, "(") {
+			break
+		}
+	}
+
+	if name != "" {
+		return &name
+	}
+	return stringPtr(bracketedIP(ip))
+}
+
+// bracketedIP returns the address from "[ip]" or "[ip]:port"
+func bracketedIP(value string) string {
+	value = strings.TrimPrefix(value, "[")
+	if end := strings.IndexByte(value, ']'); end >= 0 {
+		value = value[:end]
+	}
+	return value
+}
+
+// parseEximDuration parses an Exim time interval such as "2s", "1m30s", "1d2h" or "0.123s"
+// into seconds
+func parseEximDuration(value string) *float64 {
+	if value == "" {
+		return nil
+	}
+
+	var total float64
+	number := ""
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if (c >= '0' && c <= '9') || c == '.' {
+			number += string(c)
+			continue
+		}
+
+		var unit float64
+		switch c {
+		case 'w':
+			unit = 7 * 24 * 3600
+		case 'd':
+			unit = 24 * 3600
+		case 'h':
+			unit = 3600
+		case 'm':
+			unit = 60
+		case 's':
+			unit = 1
+		default:
+			return nil
+		}
+
+		n, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			return nil
+		}
+		total += n * unit
+		number = ""
+	}
+
+	// A bare number is a count of seconds
+	if number != "" {
+		n, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			return nil
+		}
+		total += n
+	}
+
+	return &total
+}
@@ -0,0 +1,29 @@
+package parser
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// FingerprintSize is the number of leading bytes of a log file examined by Fingerprint
+const FingerprintSize = 1024
+
+// Fingerprint identifies a log file by a hash of its first line, given the first
+// FingerprintSize bytes of the file, or all of it if shorter. Exim lines start with a
+// timestamp, so the first line differs between rotations and is the same for a rotated
+// file and its compressed copy. An empty fingerprint means there is no complete line yet.
+func Fingerprint(head []byte) string {
+	if len(head) > FingerprintSize {
+		head = head[:FingerprintSize]
+	}
+
+	if end := bytes.IndexByte(head, '\n'); end >= 0 {
+		head = head[:end+1]
+	} else if len(head) < FingerprintSize {
+		return ""
+	}
+
+	sum := sha256.Sum256(head)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	// eximPrefixPattern matches the timestamp Exim writes at the start of each line, with
+	// the fraction added by log_selector +millisec, the offset added by log_timezone and
+	// the [pid] added by +pid
+	eximPrefixPattern = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})(\.\d{1,9})?(?: ([+-]\d{4}))? (?:\[(\d+)\] )?`)
+
+	// syslogPrefixPattern matches the framing of lines Exim sent to syslog, as written by
+	// syslogd ("Jan 15 10:00:00") or rsyslog with high-precision timestamps
+	// ("2024-01-15T10:00:00.123456+02:00"), followed by the host and the exim[pid] tag
+	syslogPrefixPattern = regexp.MustCompile(`^(?:<\d+>)?([A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}|\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})) \S+ [\w./-]+(?:\[(\d+)\])?: `)
+)
+
+// linePrefix is what precedes the text of a log line
+type linePrefix struct {
+	Timestamp time.Time
+	PID       *int64
+}
+
+// parsePrefix splits a line into its prefix and the text after it. Every log_selector
+// variant of the prefix is accepted, so the parser does not need to be told which
+// options Exim runs with. Lines without a timestamp return false.
+func parsePrefix(line string) (linePrefix, string, bool) {
+	var prefix linePrefix
+	haveTimestamp := false
+
+	if m := syslogPrefixPattern.FindStringSubmatch(line); m != nil {
+		if t, err := parseSyslogTimestamp(m[1], localWallClock(time.Now())); err == nil {
+			prefix.Timestamp = t
+			haveTimestamp = true
+		}
+		prefix.PID = parsePID(m[2])
+		line = line[len(m[0]):]
+	}
+
+	// Exim's own timestamp is more precise than the syslog one when both are present
+	if m := eximPrefixPattern.FindStringSubmatch(line); m != nil {
+		if t, err := parseEximTimestamp(m[1], m[2], m[3]); err == nil {
+			prefix.Timestamp = t
+			haveTimestamp = true
+		}
+		if pid := parsePID(m[4]); pid != nil {
+			prefix.PID = pid
+		}
+		line = line[len(m[0]):]
+	}
+
+	return prefix, line, haveTimestamp
+}
+
+// LineTimestamp returns the time a log line was written, for callers that only need
+// the timestamp
+func LineTimestamp(line string) (time.Time, bool) {
+	prefix, _, ok := parsePrefix(line)
+	return prefix.Timestamp, ok
+}
+
+// parseEximTimestamp parses an Exim timestamp with an optional fraction and offset.
+// Times are stored as the wall clock of the mail server, so an offset is applied by
+// converting to local time.
+func parseEximTimestamp(datetime, fraction, offset string) (time.Time, error) {
+	if offset == "" {
+		return time.Parse("2006-01-02 15:04:05", datetime+fraction)
+	}
+
+	t, err := time.Parse("2006-01-02 15:04:05 -0700", datetime+fraction+" "+offset)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return localWallClock(t), nil
+}
+
+// parseSyslogTimestamp parses the timestamp of a syslog line. Traditional syslog
+// timestamps have no year, so the year is the one that puts the time closest before
+// now, which is given as a wall clock like the result.
+func parseSyslogTimestamp(s string, now time.Time) (time.Time, error) {
+	if len(s) > 4 && s[4] == '-' {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			// rsyslog may omit the colon in the offset
+			t, err = time.Parse("2006-01-02T15:04:05.999999999-0700", s)
+			if err != nil {
+				return time.Time{}, err
+			}
+		}
+		return localWallClock(t), nil
+	}
+
+	t, err := time.Parse("Jan _2 15:04:05", s)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	withYear := func(year int) time.Time {
+		return time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	}
+	if result := withYear(now.Year()); !result.After(now.Add(24 * time.Hour)) {
+		return result, nil
+	}
+	return withYear(now.Year() - 1), nil
+}
+
+// localWallClock returns the local time of t with the UTC location, the form in which
+// log timestamps without an offset are stored
+func localWallClock(t time.Time) time.Time {
+	l := t.In(time.Local)
+	return time.Date(l.Year(), l.Month(), l.Day(), l.Hour(), l.Minute(), l.Second(), l.Nanosecond(), time.UTC)
+}
+
+func parsePID(s string) *int64 {
+	if s == "" {
+		return nil
+	}
+	pid, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &pid
+}
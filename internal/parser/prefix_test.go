@@ -0,0 +1,133 @@
+package parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+func TestEximParser_LogSelectorPrefixes(t *testing.T) {
+	parser := NewEximParser()
+
+	tests := []struct {
+		name      string
+		line      string
+		logType   string
+		event     string
+		timestamp time.Time
+		pid       int64 // 0 for none
+	}{
+		{
+			name:      "pid",
+			line:      "2024-01-15 10:00:00 [12345] 1rABCD-123456-78 <= sender@example.com H=mail.example.com [192.0.2.1] P=esmtp S=1234",
+			logType:   database.LogTypeMain,
+			event:     database.EventArrival,
+			timestamp: time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+			pid:       12345,
+		},
+		{
+			name:      "millisec",
+			line:      "2024-01-15 10:00:00.123 1rABCD-123456-78 Completed",
+			logType:   database.LogTypeMain,
+			event:     database.EventCompleted,
+			timestamp: time.Date(2024, 1, 15, 10, 0, 0, 123e6, time.UTC),
+		},
+		{
+			name:      "log_timezone",
+			line:      "2024-01-15 10:00:00 +0200 1rABCD-123456-78 Completed",
+			logType:   database.LogTypeMain,
+			event:     database.EventCompleted,
+			timestamp: localWallClock(time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC)),
+		},
+		{
+			name:      "millisec, log_timezone and pid",
+			line:      "2024-01-15 10:00:00.250 -0500 [42] 1rABCD-123456-78 == user@example.com R=dnslookup T=remote_smtp defer (-44): SMTP error",
+			logType:   database.LogTypeMain,
+			event:     database.EventDefer,
+			timestamp: localWallClock(time.Date(2024, 1, 15, 15, 0, 0, 250e6, time.UTC)),
+			pid:       42,
+		},
+		{
+			name:      "syslog framing around the Exim timestamp",
+			line:      "Jan 15 10:00:01 mail exim[777]: 2024-01-15 10:00:00 1rABCD-123456-78 Completed",
+			logType:   database.LogTypeMain,
+			event:     database.EventCompleted,
+			timestamp: time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+			pid:       777,
+		},
+		{
+			name:      "rsyslog high-precision timestamp",
+			line:      "2024-01-15T10:00:01.5+00:00 mail exim4[9]: 1rABCD-123456-78 Completed",
+			logType:   database.LogTypeMain,
+			event:     database.EventCompleted,
+			timestamp: localWallClock(time.Date(2024, 1, 15, 10, 0, 1, 5e8, time.UTC)),
+			pid:       9,
+		},
+		{
+			name:      "reject log with pid",
+			line:      "2024-01-15 10:00:00 [55] H=mail.example.com [192.0.2.1] rejected RCPT <user@example.com>: relay not permitted",
+			logType:   database.LogTypeReject,
+			event:     database.EventReject,
+			timestamp: time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+			pid:       55,
+		},
+		{
+			name:      "unrecognized text keeps the prefix",
+			line:      "2024-01-15 10:00:00 [99] Start queue run: pid=99",
+			logType:   database.LogTypeMain,
+			event:     "unknown",
+			timestamp: time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+			pid:       99,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := parser.ParseLogLine(tt.line, tt.logType)
+			if err != nil {
+				t.Fatalf("ParseLogLine failed: %v", err)
+			}
+			if entry.Event != tt.event {
+				t.Errorf("Expected event %s, got %s", tt.event, entry.Event)
+			}
+			if !entry.Timestamp.Equal(tt.timestamp) {
+				t.Errorf("Expected timestamp %v, got %v", tt.timestamp, entry.Timestamp)
+			}
+			switch {
+			case tt.pid == 0 && entry.PID != nil:
+				t.Errorf("Expected no pid, got %d", *entry.PID)
+			case tt.pid != 0 && (entry.PID == nil || *entry.PID != tt.pid):
+				t.Errorf("Expected pid %d, got %v", tt.pid, entry.PID)
+			}
+			if entry.RawLine != tt.line {
+				t.Errorf("Expected the raw line to be kept, got %q", entry.RawLine)
+			}
+		})
+	}
+}
+
+func TestParseSyslogTimestampYear(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		stamp string
+		want  time.Time
+	}{
+		// Logged just before the new year
+		{"Dec 31 23:59:00", time.Date(2024, 12, 31, 23, 59, 0, 0, time.UTC)},
+		{"Jan  1 00:29:00", time.Date(2025, 1, 1, 0, 29, 0, 0, time.UTC)},
+		// A clock slightly ahead of ours is still this year
+		{"Jan  1 03:00:00", time.Date(2025, 1, 1, 3, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSyslogTimestamp(tt.stamp, now)
+		if err != nil {
+			t.Fatalf("parseSyslogTimestamp(%q) failed: %v", tt.stamp, err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseSyslogTimestamp(%q) = %v, want %v", tt.stamp, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package parser
+
+import "strings"
+
+// Reject reason categories returned by RejectReason
+const (
+	RejectRelayDenied  = "relay_denied"
+	RejectUnknownUser  = "unknown_recipient"
+	RejectSenderVerify = "sender_verify"
+	RejectDNSBL        = "dnsbl"
+	RejectSPF          = "spf"
+	RejectDMARC        = "dmarc"
+	RejectSpam         = "spam"
+	RejectMalware      = "malware"
+	RejectRateLimit    = "rate_limit"
+	RejectGreylist     = "greylist"
+	RejectHELO         = "helo"
+	RejectSyncError    = "sync_error"
+	RejectTLSRequired  = "tls_required"
+	RejectAuthFailed   = "auth_failed"
+	RejectMessageSize  = "message_size"
+	RejectOther        = "other"
+)
+
+// rejectReasonPatterns are checked in order against the lower-cased reason text. The
+// texts are those of the Debian default configuration and common ACL recipes; local
+// wording that matches nothing is counted as other.
+var rejectReasonPatterns = []struct {
+	category string
+	contains []string
+}{
+	{RejectRelayDenied, []string{"relay not permitted", "relaying denied", "relay access denied"}},
+	{RejectSenderVerify, []string{"sender verify failed", "sender verification failed"}},
+	{RejectUnknownUser, []string{"unrouteable address", "unknown user", "no such user", "recipient verify failed", "user unknown", "mailbox unavailable"}},
+	{RejectSyncError, []string{"synchronization error"}},
+	{RejectMalware, []string{"virus", "malware"}},
+	{RejectDMARC, []string{"dmarc", "dkim"}},
+	{RejectSPF, []string{"spf"}},
+	{RejectDNSBL, []string{"rbl", "dnsbl", "black list", "blacklist", "blocklist", "blocked using", "listed at", "listed in"}},
+	{RejectSpam, []string{"spam", "scored", "junk"}},
+	{RejectRateLimit, []string{"rate limit", "ratelimit", "too many", "sending rate"}},
+	{RejectGreylist, []string{"greylist", "graylist"}},
+	{RejectHELO, []string{"helo", "ehlo"}},
+	{RejectTLSRequired, []string{"starttls", "encryption required", "tls required"}},
+	{RejectAuthFailed, []string{"authentication failed", "authentication required", "auth failed"}},
+	{RejectMessageSize, []string{"message too big", "too large", "message size"}},
+}
+
+// RejectReason reduces the free-text reason of a reject log line to a small set of
+// categories, so rejects can be counted by cause
+func RejectReason(text string) string {
+	text = strings.ToLower(text)
+	for _, pattern := range rejectReasonPatterns {
+		for _, s := range pattern.contains {
+			if strings.Contains(text, s) {
+				return pattern.category
+			}
+		}
+	}
+	return RejectOther
+}
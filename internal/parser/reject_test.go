@@ -0,0 +1,31 @@
+package parser
+
+import "testing"
+
+func TestRejectReason(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"relay not permitted", RejectRelayDenied},
+		{"Sender verify failed", RejectSenderVerify},
+		{"Unrouteable address", RejectUnknownUser},
+		{"JunkMail rejected - [192.0.2.1] is in an RBL: see https://www.spamhaus.org/", RejectDNSBL},
+		{"Rejected because 192.0.2.1 is in a black list at zen.spamhaus.org", RejectDNSBL},
+		{"SPF check failed: example.com does not designate 192.0.2.1 as permitted sender", RejectSPF},
+		{"This message scored 12.5 spam points.", RejectSpam},
+		{"This message contains a virus (Eicar-Test-Signature).", RejectMalware},
+		{"SMTP synchronization error", RejectSyncError},
+		{"Greylisted, please try again in 5 minutes", RejectGreylist},
+		{"Sending rate 120.0/1h exceeds limit", RejectRateLimit},
+		{"HELO/EHLO required by SMTP RFC", RejectHELO},
+		{"Message too big", RejectMessageSize},
+		{"Administrative prohibition", RejectOther},
+	}
+
+	for _, tt := range tests {
+		if got := RejectReason(tt.text); got != tt.want {
+			t.Errorf("RejectReason(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,544 @@
+package parser
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+const (
+	// DefaultRetryDataExpire is Exim's default retry_data_expire
+	DefaultRetryDataExpire = 7 * 24 * time.Hour
+
+	// maxPredictedRetries limits the predicted attempts per address. Attempts past the
+	// limit are left out, but the attempt at which the address bounces is always kept.
+	maxPredictedRetries = 20
+
+	// maxSimulatedRetries bounds the simulation of a retry schedule
+	maxSimulatedRetries = 10000
+)
+
+// RetryStep is one F, G or H parameter set of a retry rule
+type RetryStep struct {
+	Kind byte // 'F' fixed, 'G' geometric, 'H' randomised geometric
+
+	// Cutoff is the time since the first failure up to which the step applies
+	Cutoff     time.Duration
+	Interval   time.Duration
+	Multiplier float64 // G and H only
+	Text       string
+}
+
+// RetryRule is one line of the retry section of the Exim configuration
+type RetryRule struct {
+	Pattern string
+	Error   string
+	Senders string
+	Steps   []RetryStep
+	Text    string
+
+	regex *regexp.Regexp
+}
+
+// RetryConfig holds the retry rules of an Exim configuration
+type RetryConfig struct {
+	Rules []RetryRule
+
+	// DataExpire is retry_data_expire: retry data older than this is discarded
+	DataExpire time.Duration
+
+	// HasRetrySection reports whether the configuration has a "begin retry" section
+	HasRetrySection bool
+}
+
+// ParseRetryConfig reads the retry rules and retry_data_expire from an Exim
+// configuration. Macros, conditionals and included files are not expanded.
+func ParseRetryConfig(r io.Reader) (*RetryConfig, error) {
+	config := &RetryConfig{DataExpire: DefaultRetryDataExpire}
+
+	section := ""
+	lineNumber := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for {
+		line, n, ok := nextConfigLine(scanner)
+		if !ok {
+			break
+		}
+		lineNumber += n
+
+		if strings.HasPrefix(line, "begin ") {
+			section = strings.TrimSpace(strings.TrimPrefix(line, "begin "))
+			if section == "retry" {
+				config.HasRetrySection = true
+			}
+			continue
+		}
+
+		switch section {
+		case "":
+			name, value, found := strings.Cut(line, "=")
+			if found && strings.TrimSpace(name) == "retry_data_expire" {
+				seconds := parseEximDuration(strings.TrimSpace(value))
+				if seconds == nil {
+					return nil, fmt.Errorf("line %d: invalid retry_data_expire %q", lineNumber, strings.TrimSpace(value))
+				}
+				config.DataExpire = time.Duration(*seconds * float64(time.Second))
+			}
+		case "retry":
+			rule, err := parseRetryRule(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			config.Rules = append(config.Rules, *rule)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// nextConfigLine returns the next logical line of an Exim configuration, with comments,
+// blank lines, directives and macro definitions skipped and continuation lines joined.
+// It also returns the number of physical lines consumed.
+func nextConfigLine(scanner *bufio.Scanner) (string, int, bool) {
+	consumed := 0
+	var logical strings.Builder
+
+	for scanner.Scan() {
+		consumed++
+		line := strings.TrimSpace(scanner.Text())
+
+		if logical.Len() == 0 {
+			if line == "" || line[0] == '#' || line[0] == '.' {
+				continue
+			}
+			// Macro definitions start with an upper-case letter
+			if line[0] >= 'A' && line[0] <= 'Z' && strings.Contains(line, "=") {
+				continue
+			}
+		} else if line != "" && line[0] == '#' {
+			continue
+		}
+
+		if strings.HasSuffix(line, "\\") {
+			logical.WriteString(strings.TrimSuffix(line, "\\"))
+			continue
+		}
+
+		logical.WriteString(line)
+		return logical.String(), consumed, true
+	}
+
+	if logical.Len() > 0 {
+		return logical.String(), consumed, true
+	}
+	return "", consumed, false
+}
+
+// parseRetryRule parses a rule such as "*  *  F,2h,15m; G,16h,1h,1.5; F,4d,6h"
+func parseRetryRule(line string) (*RetryRule, error) {
+	rule := &RetryRule{Text: line}
+
+	rest := line
+	rule.Pattern, rest = nextRetryField(rest)
+	rule.Error, rest = nextRetryField(rest)
+	if rule.Pattern == "" || rule.Error == "" {
+		return nil, fmt.Errorf("retry rule needs an address pattern and an error: %q", line)
+	}
+
+	if strings.HasPrefix(rest, "senders") {
+		if _, value, found := strings.Cut(rest, "="); found {
+			rule.Senders, rest = nextRetryField(strings.TrimSpace(value))
+		}
+	}
+
+	if strings.HasPrefix(rule.Pattern, "^") {
+		regex, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry pattern %q: %w", rule.Pattern, err)
+		}
+		rule.regex = regex
+	}
+
+	for _, text := range strings.Split(rest, ";") {
+		text = strings.Join(strings.Fields(text), "")
+		if text == "" {
+			continue
+		}
+
+		step, err := parseRetryStep(text)
+		if err != nil {
+			return nil, err
+		}
+		rule.Steps = append(rule.Steps, *step)
+	}
+
+	return rule, nil
+}
+
+// nextRetryField returns the next whitespace-separated, possibly quoted, field
+func nextRetryField(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", ""
+	}
+	if s[0] == '"' {
+		value, end := readQuoted(s, 0)
+		return value, strings.TrimSpace(s[end:])
+	}
+
+	end := strings.IndexAny(s, " \t")
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], strings.TrimSpace(s[end:])
+}
+
+// parseRetryStep parses "F,2h,15m", "G,16h,1h,1.5" or "H,16h,1h,1.5"
+func parseRetryStep(text string) (*RetryStep, error) {
+	parts := strings.Split(text, ",")
+	if len(parts) < 3 || len(parts[0]) != 1 {
+		return nil, fmt.Errorf("invalid retry parameters %q", text)
+	}
+
+	step := &RetryStep{Kind: parts[0][0], Text: text}
+	switch step.Kind {
+	case 'F':
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("F retry parameters take a time and an interval: %q", text)
+		}
+	case 'G', 'H':
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("%c retry parameters take a time, an interval and a multiplier: %q", step.Kind, text)
+		}
+		var multiplier float64
+		if _, err := fmt.Sscanf(parts[3], "%g", &multiplier); err != nil || multiplier < 1 {
+			return nil, fmt.Errorf("invalid retry multiplier in %q", text)
+		}
+		step.Multiplier = multiplier
+	default:
+		return nil, fmt.Errorf("unknown retry rule type %q", parts[0])
+	}
+
+	cutoff := parseEximDuration(parts[1])
+	interval := parseEximDuration(parts[2])
+	if cutoff == nil || interval == nil || *interval <= 0 {
+		return nil, fmt.Errorf("invalid retry times in %q", text)
+	}
+	step.Cutoff = time.Duration(*cutoff * float64(time.Second))
+	step.Interval = time.Duration(*interval * float64(time.Second))
+
+	return step, nil
+}
+
+// Match returns the first rule that applies to the address and error, or nil. Rules
+// restricted by sender, and lookup patterns, cannot be evaluated and never match.
+func (c *RetryConfig) Match(address, errorText string) *RetryRule {
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if rule.Senders == "" && rule.matchesAddress(address) && matchesRetryError(rule.Error, errorText) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// matchesAddress matches the rule pattern against the whole address if it contains an
+// @, and against the domain otherwise
+func (r *RetryRule) matchesAddress(address string) bool {
+	address = strings.ToLower(address)
+	if r.regex != nil {
+		return r.regex.MatchString(address)
+	}
+
+	pattern := strings.ToLower(r.Pattern)
+	if strings.Contains(pattern, ";") {
+		return false
+	}
+
+	local, domain := "", address
+	if at := strings.LastIndexByte(address, '@'); at >= 0 {
+		local, domain = address[:at], address[at+1:]
+	}
+
+	if patternLocal, patternDomain, found := strings.Cut(pattern, "@"); found {
+		return matchesWildcard(patternLocal, local) && matchesWildcard(patternDomain, domain)
+	}
+	return matchesWildcard(pattern, domain)
+}
+
+// matchesWildcard matches a value against an Exim pattern that may start with *
+func matchesWildcard(pattern, value string) bool {
+	if strings.HasPrefix(pattern, "*") {
+		return strings.HasSuffix(value, pattern[1:])
+	}
+	return pattern == value
+}
+
+var (
+	retryStagePattern = regexp.MustCompile(`(?i)after (MAIL FROM|RCPT TO|DATA|end of data|pipelined (?:MAIL FROM|RCPT TO|DATA))`)
+	retryCodePattern  = regexp.MustCompile(`\b(4\d\d)\b`)
+)
+
+// matchesRetryError matches the error field of a retry rule against deferral text.
+// Variants that depend on whether the host came from an MX or an A record match both.
+func matchesRetryError(name, errorText string) bool {
+	text := strings.ToLower(errorText)
+
+	switch {
+	case name == "*":
+		return true
+	case name == "auth_failed":
+		return strings.Contains(text, "authentication failed") || strings.Contains(text, "authenticator")
+	case name == "tls_required":
+		return strings.Contains(text, "tls session required") || strings.Contains(text, "tls required")
+	case name == "lost_connection":
+		return strings.Contains(text, "lost connection") || strings.Contains(text, "connection closed") ||
+			strings.Contains(text, "unexpected disconnection")
+	case name == "quota" || strings.HasPrefix(name, "quota_"):
+		return strings.Contains(text, "quota") || strings.Contains(text, "mailbox is full") ||
+			strings.Contains(text, "mailbox full")
+	case strings.HasPrefix(name, "refused"):
+		return strings.Contains(text, "connection refused")
+	case name == "timeout_DNS":
+		return strings.Contains(text, "host lookup did not complete") ||
+			(strings.Contains(text, "dns") && strings.Contains(text, "timed out"))
+	case strings.HasPrefix(name, "timeout_connect"):
+		return strings.Contains(text, "connection timed out") || strings.Contains(text, "connect timed out")
+	case strings.HasPrefix(name, "timeout"):
+		return strings.Contains(text, "timed out") || strings.Contains(text, "timeout")
+	case strings.HasPrefix(name, "mail_4"), strings.HasPrefix(name, "rcpt_4"), strings.HasPrefix(name, "data_4"):
+		return matchesRetrySMTPError(name, errorText)
+	default:
+		return false
+	}
+}
+
+// matchesRetrySMTPError matches mail_4xx, rcpt_45x, data_421 style errors: a 4xx
+// response at the given SMTP stage, with x matching any digit
+func matchesRetrySMTPError(name, errorText string) bool {
+	stage := retryStagePattern.FindStringSubmatch(errorText)
+	if stage == nil {
+		return false
+	}
+
+	verb := strings.ToLower(stage[1])
+	verb = strings.TrimPrefix(verb, "pipelined ")
+	switch {
+	case strings.HasPrefix(name, "mail_") && verb != "mail from":
+		return false
+	case strings.HasPrefix(name, "rcpt_") && verb != "rcpt to":
+		return false
+	case strings.HasPrefix(name, "data_") && verb != "data" && verb != "end of data":
+		return false
+	}
+
+	code := retryCodePattern.FindString(errorText[strings.Index(errorText, stage[0]):])
+	want := name[5:]
+	if code == "" || len(want) != 3 {
+		return false
+	}
+	for i := 0; i < 3; i++ {
+		if want[i] != 'x' && want[i] != code[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// retryState mirrors the retry record Exim keeps for an address
+type retryState struct {
+	firstFailed time.Time
+	lastTry     time.Time
+	nextTry     time.Time
+	started     bool
+}
+
+// stepAt returns the step that applies at the given time since the first failure
+func (r *RetryRule) stepAt(elapsed time.Duration) *RetryStep {
+	for i := range r.Steps {
+		if elapsed <= r.Steps[i].Cutoff {
+			return &r.Steps[i]
+		}
+	}
+	return nil
+}
+
+// fail records a failed attempt at t, as Exim updates its retry record. It returns the
+// window of the next attempt and the step used, or a nil step once the address has
+// failed for longer than the rule allows and times out.
+func (r *RetryRule) fail(state *retryState, t time.Time) (time.Time, time.Time, *RetryStep) {
+	if !state.started {
+		*state = retryState{firstFailed: t, lastTry: t, nextTry: t, started: true}
+	}
+
+	step := r.stepAt(t.Sub(state.firstFailed))
+	if step == nil {
+		return t, t, nil
+	}
+
+	low, high := step.Interval, step.Interval
+	if step.Kind != 'F' {
+		// The previous interval is the shorter of the planned and the actual gap
+		lastGap := state.nextTry.Sub(state.lastTry)
+		if actual := t.Sub(state.lastTry); actual < lastGap {
+			lastGap = actual
+		}
+		if lastGap >= step.Interval {
+			high = time.Duration(float64(lastGap) * step.Multiplier)
+			low = high
+			if step.Kind == 'H' {
+				low = lastGap
+			}
+		}
+	}
+
+	state.lastTry = t
+	state.nextTry = t.Add((low + high) / 2)
+	return t.Add(low), t.Add(high), step
+}
+
+// PredictRetries implements database.RetryPolicy
+func (c *RetryConfig) PredictRetries(address, errorText string, failures []time.Time, now time.Time) (*database.RetryPrediction, error) {
+	if !c.HasRetrySection {
+		return nil, nil
+	}
+
+	prediction := &database.RetryPrediction{}
+	bounce := func(at time.Time, reason string) *database.RetryPrediction {
+		prediction.Attempts = append(prediction.Attempts, database.PredictedAttempt{At: at, Earliest: at, Latest: at, Reason: reason})
+		prediction.BounceAt = &at
+		return prediction
+	}
+
+	rule := c.Match(address, errorText)
+	if rule == nil {
+		return bounce(now, "No retry rule matches, so the temporary error is treated as permanent"), nil
+	}
+	prediction.Rule = rule.Text
+	if len(rule.Steps) == 0 {
+		return bounce(now, "The retry rule has no retry times, so the address is not retried"), nil
+	}
+
+	var state retryState
+	var low, high time.Time
+	var step *RetryStep
+
+	// Retry data older than retry_data_expire is discarded and the clock starts again
+	if len(failures) > 0 && now.Sub(failures[len(failures)-1]) > c.DataExpire {
+		prediction.Attempts = append(prediction.Attempts, database.PredictedAttempt{
+			At: now, Earliest: now, Latest: now,
+			Reason: fmt.Sprintf("Retry data is older than retry_data_expire (%s) and is discarded", c.DataExpire),
+		})
+		failures = []time.Time{now}
+	}
+
+	for _, failure := range failures {
+		if low, high, step = rule.fail(&state, failure); step == nil {
+			return bounce(now, fmt.Sprintf("Retry time of %s exceeded", rule.Steps[len(rule.Steps)-1].Cutoff)), nil
+		}
+	}
+
+	for n := 0; n < maxSimulatedRetries; n++ {
+		at := state.nextTry
+		attempt := database.PredictedAttempt{
+			At: at, Earliest: low, Latest: high,
+			Reason: retryStepReason(step),
+		}
+		if at.Before(now) {
+			// Overdue attempts happen at the next queue run
+			at = now
+			attempt = database.PredictedAttempt{At: at, Earliest: at, Latest: at, Reason: "Retry time reached, due at the next queue run"}
+		}
+
+		if low, high, step = rule.fail(&state, at); step == nil {
+			attempt.Reason = fmt.Sprintf("Retry time of %s exceeded, the address bounces", rule.Steps[len(rule.Steps)-1].Cutoff)
+			prediction.Attempts = append(prediction.Attempts, attempt)
+			prediction.BounceAt = &attempt.At
+			return prediction, nil
+		}
+
+		if len(prediction.Attempts) < maxPredictedRetries-1 {
+			prediction.Attempts = append(prediction.Attempts, attempt)
+		}
+	}
+
+	return prediction, nil
+}
+
+// retryStepReason describes the step an attempt was scheduled by
+func retryStepReason(step *RetryStep) string {
+	switch step.Kind {
+	case 'F':
+		return fmt.Sprintf("%s: every %s until %s after the first failure", step.Text, step.Interval, step.Cutoff)
+	case 'G':
+		return fmt.Sprintf("%s: interval from %s growing by %g until %s after the first failure", step.Text, step.Interval, step.Multiplier, step.Cutoff)
+	default:
+		return fmt.Sprintf("%s: random interval from %s growing by up to %g until %s after the first failure", step.Text, step.Interval, step.Multiplier, step.Cutoff)
+	}
+}
+
+// RetryConfigFile is a database.RetryPolicy backed by an Exim configuration file. The
+// file is parsed again whenever it changes, as Exim itself rereads it.
+type RetryConfigFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	config  *RetryConfig
+}
+
+// NewRetryConfigFile creates a retry policy for the Exim configuration at path
+func NewRetryConfigFile(path string) *RetryConfigFile {
+	return &RetryConfigFile{path: path}
+}
+
+// Config returns the parsed retry configuration
+func (f *RetryConfigFile) Config() (*RetryConfig, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Exim configuration: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.config != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.config, nil
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Exim configuration: %w", err)
+	}
+	defer file.Close()
+
+	config, err := ParseRetryConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", f.path, err)
+	}
+
+	f.config, f.modTime, f.size = config, info.ModTime(), info.Size()
+	return config, nil
+}
+
+// PredictRetries implements database.RetryPolicy
+func (f *RetryConfigFile) PredictRetries(address, errorText string, failures []time.Time, now time.Time) (*database.RetryPrediction, error) {
+	config, err := f.Config()
+	if err != nil {
+		return nil, err
+	}
+	return config.PredictRetries(address, errorText, failures, now)
+}
@@ -0,0 +1,194 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testEximConfig = `
+# Main configuration
+MAIN_TLS = yes
+retry_data_expire = 2d
+
+begin routers
+
+dnslookup:
+  driver = dnslookup
+
+begin retry
+
+# Address or domain    Error       Retries
+example.org            quota       F,1h,10m
+*.example.net          rcpt_45x    G,8h,30m,2
+^.*@slow\.example$     *           H,1d,1h,2
+vip@example.com        *           \
+                       F,1h,5m
+nobounce.example       *
+*                      *           F,2h,15m; G,16h,1h,1.5; F,4d,6h
+
+begin rewrite
+`
+
+func parseTestRetryConfig(t *testing.T) *RetryConfig {
+	t.Helper()
+
+	config, err := ParseRetryConfig(strings.NewReader(testEximConfig))
+	if err != nil {
+		t.Fatalf("ParseRetryConfig failed: %v", err)
+	}
+	return config
+}
+
+func TestParseRetryConfig(t *testing.T) {
+	config := parseTestRetryConfig(t)
+
+	if !config.HasRetrySection {
+		t.Error("Expected a retry section")
+	}
+	if config.DataExpire != 48*time.Hour {
+		t.Errorf("DataExpire = %s, want 48h", config.DataExpire)
+	}
+	if len(config.Rules) != 6 {
+		t.Fatalf("Parsed %d rules, want 6", len(config.Rules))
+	}
+
+	last := config.Rules[5]
+	if last.Pattern != "*" || last.Error != "*" || len(last.Steps) != 3 {
+		t.Fatalf("Unexpected default rule %+v", last)
+	}
+	g := last.Steps[1]
+	if g.Kind != 'G' || g.Cutoff != 16*time.Hour || g.Interval != time.Hour || g.Multiplier != 1.5 {
+		t.Errorf("Unexpected G step %+v", g)
+	}
+	if steps := config.Rules[3].Steps; len(steps) != 1 || steps[0].Interval != 5*time.Minute {
+		t.Errorf("Continuation line not joined: %+v", config.Rules[3])
+	}
+
+	for _, bad := range []string{"begin retry\n* * X,1h,5m\n", "begin retry\n* * G,1h,5m\n", "begin retry\n*\n"} {
+		if _, err := ParseRetryConfig(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestRetryConfigMatch(t *testing.T) {
+	config := parseTestRetryConfig(t)
+
+	tests := []struct {
+		address   string
+		errorText string
+		want      string
+	}{
+		{"user@example.org", "Mailbox is full / over quota", "example.org"},
+		{"user@example.org", "Connection refused", "*"},
+		{"user@mx.example.net", "SMTP error from remote mail server after RCPT TO:<user@mx.example.net>: 452 4.2.2 Over limit", "*.example.net"},
+		{"user@mx.example.net", "SMTP error from remote mail server after MAIL FROM:<a@b>: 452 4.3.1 Try later", "*"},
+		{"user@mx.example.net", "SMTP error from remote mail server after RCPT TO:<x>: 421 Busy", "*"},
+		{"Someone@Slow.Example", "Connection timed out", `^.*@slow\.example$`},
+		{"vip@example.com", "anything", "vip@example.com"},
+		{"other@example.com", "anything", "*"},
+	}
+
+	for _, tt := range tests {
+		rule := config.Match(tt.address, tt.errorText)
+		if rule == nil || rule.Pattern != tt.want {
+			t.Errorf("Match(%q, %q) = %+v, want pattern %q", tt.address, tt.errorText, rule, tt.want)
+		}
+	}
+}
+
+func TestRetryConfigPredictRetries(t *testing.T) {
+	config := parseTestRetryConfig(t)
+	firstFailure := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return firstFailure.Add(d) }
+
+	t.Run("F, G and F steps until the bounce", func(t *testing.T) {
+		prediction, err := config.PredictRetries("user@example.com", "Connection refused", []time.Time{firstFailure}, at(time.Minute))
+		if err != nil || prediction == nil {
+			t.Fatalf("PredictRetries = %v, %v", prediction, err)
+		}
+
+		// Every 15m for 2h, then 1h growing by 1.5
+		want := []time.Duration{
+			15 * time.Minute, 30 * time.Minute, 45 * time.Minute, time.Hour,
+			75 * time.Minute, 90 * time.Minute, 105 * time.Minute, 2 * time.Hour,
+			135 * time.Minute, 195 * time.Minute, 285 * time.Minute, 420 * time.Minute,
+		}
+		for i, d := range want {
+			if !prediction.Attempts[i].At.Equal(at(d)) {
+				t.Errorf("Attempt %d at %s, want %s", i+1, prediction.Attempts[i].At, at(d))
+			}
+		}
+
+		// The G step ends at 23h01m52.5s, after which attempts are 6h apart until 4d passes
+		bounce := at(101*time.Hour + time.Minute + 52500*time.Millisecond)
+		if prediction.BounceAt == nil || !prediction.BounceAt.Equal(bounce) {
+			t.Errorf("BounceAt = %v, want %s", prediction.BounceAt, bounce)
+		}
+		if len(prediction.Attempts) != maxPredictedRetries {
+			t.Errorf("Got %d attempts, want %d", len(prediction.Attempts), maxPredictedRetries)
+		}
+		if final := prediction.Attempts[len(prediction.Attempts)-1]; !final.At.Equal(bounce) {
+			t.Errorf("Final attempt at %s, want the bounce at %s", final.At, bounce)
+		}
+	})
+
+	t.Run("replays actual attempts", func(t *testing.T) {
+		// Attempts that happened later than planned shorten the geometric interval
+		failures := []time.Time{firstFailure, at(30 * time.Minute), at(90 * time.Minute)}
+		prediction, _ := config.PredictRetries("user@mx.example.net", "after RCPT TO:<x>: 451 Greylisted", failures, at(100*time.Minute))
+
+		if prediction.Rule != "*.example.net          rcpt_45x    G,8h,30m,2" {
+			t.Errorf("Rule = %q", prediction.Rule)
+		}
+		if next := prediction.Attempts[0].At; !next.Equal(at(210 * time.Minute)) {
+			t.Errorf("Next attempt at %s, want %s", next, at(210*time.Minute))
+		}
+	})
+
+	t.Run("randomised intervals give a window", func(t *testing.T) {
+		failures := []time.Time{firstFailure, at(time.Hour)}
+		prediction, _ := config.PredictRetries("x@slow.example", "timeout", failures, at(time.Hour))
+
+		next := prediction.Attempts[0]
+		if !next.Earliest.Equal(at(2*time.Hour)) || !next.Latest.Equal(at(3*time.Hour)) || !next.At.Equal(at(150*time.Minute)) {
+			t.Errorf("Unexpected window %+v", next)
+		}
+	})
+
+	t.Run("overdue attempts are due now", func(t *testing.T) {
+		now := at(3 * time.Hour)
+		prediction, _ := config.PredictRetries("vip@example.com", "timeout", []time.Time{firstFailure, at(50 * time.Minute)}, now)
+
+		if len(prediction.Attempts) != 1 || !prediction.Attempts[0].At.Equal(now) || prediction.BounceAt == nil {
+			t.Errorf("Expected a bounce at the next queue run, got %+v", prediction)
+		}
+	})
+
+	t.Run("expired retry data restarts the clock", func(t *testing.T) {
+		now := at(72 * time.Hour)
+		prediction, _ := config.PredictRetries("user@example.org", "quota exceeded", []time.Time{firstFailure}, now)
+
+		if !prediction.Attempts[0].At.Equal(now) || !prediction.Attempts[1].At.Equal(now.Add(10*time.Minute)) {
+			t.Errorf("Unexpected attempts %+v", prediction.Attempts[:2])
+		}
+		if prediction.BounceAt == nil || !prediction.BounceAt.Equal(now.Add(70*time.Minute)) {
+			t.Errorf("BounceAt = %v, want %s", prediction.BounceAt, now.Add(70*time.Minute))
+		}
+	})
+
+	t.Run("rules without retry times bounce", func(t *testing.T) {
+		prediction, _ := config.PredictRetries("a@nobounce.example", "timeout", []time.Time{firstFailure}, at(time.Minute))
+		if prediction.BounceAt == nil || !prediction.BounceAt.Equal(at(time.Minute)) {
+			t.Errorf("Expected an immediate bounce, got %+v", prediction)
+		}
+	})
+
+	t.Run("no retry section", func(t *testing.T) {
+		empty, _ := ParseRetryConfig(strings.NewReader("retry_data_expire = 1d\n"))
+		if prediction, err := empty.PredictRetries("a@b", "x", []time.Time{firstFailure}, firstFailure); prediction != nil || err != nil {
+			t.Errorf("Expected no prediction, got %+v, %v", prediction, err)
+		}
+	})
+}
@@ -0,0 +1,651 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// Hints database sources
+const (
+	HintsSourceSQLite   = "sqlite"
+	HintsSourceDumpDB   = "exim_dumpdb"
+	hintsSQLiteHeader   = "SQLite format 3\x00"
+	hintsDumpTimeFormat = "02-Jan-2006 15:04:05"
+)
+
+// ErrRetryRecordNotFound is returned when clearing a retry record that does not exist
+var ErrRetryRecordNotFound = errors.New("retry record not found")
+
+// RetryRecord is an entry of the Exim retry hints database. Keys starting with T: are
+// host retry records written by transports, R: keys are domain or address records
+// written by routers and local deliveries.
+type RetryRecord struct {
+	Key         string    `json:"key"`
+	Type        string    `json:"type"` // host, domain or address
+	Host        string    `json:"host,omitempty"`
+	IPAddress   string    `json:"ip_address,omitempty"`
+	Domain      string    `json:"domain,omitempty"`
+	Address     string    `json:"address,omitempty"`
+	MessageID   string    `json:"message_id,omitempty"` // set for message-specific host records
+	Errno       int       `json:"errno"`
+	MoreErrno   int       `json:"more_errno"`
+	Text        string    `json:"text,omitempty"`
+	FirstFailed time.Time `json:"first_failed"`
+	LastTry     time.Time `json:"last_try"`
+	NextTry     time.Time `json:"next_try"`
+	Expired     bool      `json:"expired"` // the retry time limit has passed
+	InBackoff   bool      `json:"in_backoff"`
+}
+
+// WaitRecord lists the messages waiting for a host in a wait-<transport> database, which
+// Exim uses to send several messages over one connection
+type WaitRecord struct {
+	Transport  string   `json:"transport"`
+	Host       string   `json:"host"`
+	MessageIDs []string `json:"message_ids"`
+}
+
+// CalloutRecord is a cached callout verification result
+type CalloutRecord struct {
+	Key              string    `json:"key"`
+	Result           string    `json:"result"`
+	PostmasterResult string    `json:"postmaster_result,omitempty"`
+	RandomResult     string    `json:"random_result,omitempty"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// HintsState is the content of the Exim hints databases
+type HintsState struct {
+	Source   string          `json:"source,omitempty"` // sqlite or exim_dumpdb, empty if no database exists
+	Retry    []RetryRecord   `json:"retry"`
+	Wait     []WaitRecord    `json:"wait"`
+	Callouts []CalloutRecord `json:"callouts"`
+	ReadAt   time.Time       `json:"read_at"`
+}
+
+// HintsReader reads the Exim hints databases in the db directory of the spool. Hints
+// databases stored in SQLite are read directly. Berkeley DB, GDBM and TDB files, whose
+// format depends on how Exim was built, are read through exim_dumpdb.
+type HintsReader struct {
+	spoolDir   string
+	dumpdbPath string
+	fixdbPath  string
+}
+
+// NewHintsReader creates a hints database reader. The exim_dumpdb and exim_fixdb
+// utilities are looked for next to the Exim binary.
+func NewHintsReader(spoolDir, eximPath string) *HintsReader {
+	dir := filepath.Dir(eximPath)
+	return &HintsReader{
+		spoolDir:   spoolDir,
+		dumpdbPath: hintsUtility(dir, "exim_dumpdb"),
+		fixdbPath:  hintsUtility(dir, "exim_fixdb"),
+	}
+}
+
+// hintsUtility returns the path of an Exim utility, preferring the Exim binary's directory
+func hintsUtility(dir, name string) string {
+	if path := filepath.Join(dir, name); fileIsExecutable(path) {
+		return path
+	}
+	if path, err := exec.LookPath(name); err == nil {
+		return path
+	}
+	return name
+}
+
+func fileIsExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Mode()&0111 != 0
+}
+
+// dbPath returns the path of a hints database
+func (h *HintsReader) dbPath(name string) string {
+	return filepath.Join(h.spoolDir, "db", name)
+}
+
+// ReadState reads the retry, wait and callout databases. Missing databases are empty.
+func (h *HintsReader) ReadState(now time.Time) (*HintsState, error) {
+	state := &HintsState{ReadAt: now}
+
+	retry, source, err := h.readRetry(now)
+	if err != nil {
+		return nil, err
+	}
+	state.Retry = retry
+	if source != "" {
+		state.Source = source
+	}
+
+	waitFiles, _ := filepath.Glob(h.dbPath("wait-*"))
+	for _, path := range waitFiles {
+		name := filepath.Base(path)
+		if strings.HasSuffix(name, ".lockfile") {
+			continue
+		}
+
+		records, source, err := h.readWait(name)
+		if err != nil {
+			return nil, err
+		}
+		state.Wait = append(state.Wait, records...)
+		if source != "" {
+			state.Source = source
+		}
+	}
+
+	callouts, source, err := h.readCallouts()
+	if err != nil {
+		return nil, err
+	}
+	state.Callouts = callouts
+	if source != "" {
+		state.Source = source
+	}
+
+	return state, nil
+}
+
+// readRetry reads the retry database
+func (h *HintsReader) readRetry(now time.Time) ([]RetryRecord, string, error) {
+	var records []RetryRecord
+
+	source, err := h.read("retry", func(key string, data []byte) {
+		if record, ok := decodeRetryRecord(key, data); ok {
+			records = append(records, record)
+		}
+	}, func(output io.Reader) error {
+		parsed, err := parseRetryDump(output)
+		records = parsed
+		return err
+	})
+	if err != nil {
+		return nil, source, err
+	}
+
+	for i := range records {
+		records[i].InBackoff = records[i].NextTry.After(now)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].NextTry.Before(records[j].NextTry) })
+
+	return records, source, nil
+}
+
+// readWait reads a wait-<transport> database
+func (h *HintsReader) readWait(name string) ([]WaitRecord, string, error) {
+	transport := strings.TrimPrefix(name, "wait-")
+	var records []WaitRecord
+
+	source, err := h.read(name, func(key string, data []byte) {
+		if record, ok := decodeWaitRecord(key, data); ok {
+			record.Transport = transport
+			records = append(records, record)
+		}
+	}, func(output io.Reader) error {
+		parsed, err := parseWaitDump(output)
+		for i := range parsed {
+			parsed[i].Transport = transport
+		}
+		records = parsed
+		return err
+	})
+
+	return records, source, err
+}
+
+// readCallouts reads the callout cache database
+func (h *HintsReader) readCallouts() ([]CalloutRecord, string, error) {
+	var records []CalloutRecord
+
+	source, err := h.read("callout", func(key string, data []byte) {
+		if record, ok := decodeCalloutRecord(key, data); ok {
+			records = append(records, record)
+		}
+	}, func(output io.Reader) error {
+		parsed, err := parseCalloutDump(output)
+		records = parsed
+		return err
+	})
+
+	return records, source, err
+}
+
+// read calls record for each entry of a SQLite hints database, or dump with the output
+// of exim_dumpdb for other formats. It returns the source used, or "" if the database
+// does not exist.
+func (h *HintsReader) read(name string, record func(key string, data []byte), dump func(io.Reader) error) (string, error) {
+	path := h.dbPath(name)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+
+	if isSQLiteFile(path) {
+		return HintsSourceSQLite, readSQLiteHints(path, record)
+	}
+
+	cmd := exec.Command(h.dumpdbPath, h.spoolDir, name)
+	output, err := cmd.Output()
+	if err != nil {
+		return HintsSourceDumpDB, fmt.Errorf("failed to run exim_dumpdb for %s: %w", name, err)
+	}
+	if err := dump(bytes.NewReader(output)); err != nil {
+		return HintsSourceDumpDB, fmt.Errorf("failed to parse exim_dumpdb output for %s: %w", name, err)
+	}
+	return HintsSourceDumpDB, nil
+}
+
+func isSQLiteFile(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	header := make([]byte, len(hintsSQLiteHeader))
+	if _, err := io.ReadFull(file, header); err != nil {
+		return false
+	}
+	return string(header) == hintsSQLiteHeader
+}
+
+// readSQLiteHints reads a hints database written by Exim built with USE_SQLITE
+func readSQLiteHints(path string, record func(key string, data []byte)) error {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro&_busy_timeout=5000")
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT ky, dat FROM tbl")
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, data []byte
+		if err := rows.Scan(&key, &data); err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		record(string(bytes.TrimRight(key, "\x00")), data)
+	}
+	return rows.Err()
+}
+
+// Exim stores hints records as C structures. These offsets are for 64-bit builds, where
+// time_t is 8 bytes and int and BOOL are 4.
+const (
+	hintsRetryErrno       = 8
+	hintsRetryMoreErrno   = 12
+	hintsRetryExpired     = 16
+	hintsRetryFirstFailed = 24
+	hintsRetryLastTry     = 32
+	hintsRetryNextTry     = 40
+	hintsRetryText        = 48
+
+	hintsWaitCount = 8
+	hintsWaitText  = 16
+
+	hintsCalloutResult           = 8
+	hintsCalloutPostmasterResult = 12
+	hintsCalloutRandomResult     = 16
+	hintsCalloutDomainSize       = 40
+)
+
+// decodeRetryRecord decodes a dbdata_retry structure
+func decodeRetryRecord(key string, data []byte) (RetryRecord, bool) {
+	if len(data) < hintsRetryText {
+		return RetryRecord{}, false
+	}
+
+	record := newRetryRecord(key)
+	record.Errno = int(int32(binary.LittleEndian.Uint32(data[hintsRetryErrno:])))
+	record.MoreErrno = int(int32(binary.LittleEndian.Uint32(data[hintsRetryMoreErrno:])))
+	record.Expired = binary.LittleEndian.Uint32(data[hintsRetryExpired:]) != 0
+	record.FirstFailed = hintsTime(data[hintsRetryFirstFailed:])
+	record.LastTry = hintsTime(data[hintsRetryLastTry:])
+	record.NextTry = hintsTime(data[hintsRetryNextTry:])
+	record.Text = string(bytes.TrimRight(cString(data[hintsRetryText:]), " "))
+
+	return record, true
+}
+
+// decodeWaitRecord decodes a dbdata_wait structure: a count followed by fixed-length
+// message IDs
+func decodeWaitRecord(key string, data []byte) (WaitRecord, bool) {
+	if len(data) < hintsWaitText {
+		return WaitRecord{}, false
+	}
+
+	count := int(binary.LittleEndian.Uint32(data[hintsWaitCount:]))
+	text := cString(data[hintsWaitText:])
+	record := WaitRecord{Host: key}
+	if count <= 0 || len(text)%count != 0 {
+		return record, count == 0
+	}
+
+	idLength := len(text) / count
+	for i := 0; i < count; i++ {
+		record.MessageIDs = append(record.MessageIDs, string(text[i*idLength:(i+1)*idLength]))
+	}
+	return record, true
+}
+
+// decodeCalloutRecord decodes a dbdata_callout_cache structure. Address records hold a
+// result only, domain records also hold the postmaster and random address results.
+func decodeCalloutRecord(key string, data []byte) (CalloutRecord, bool) {
+	if len(data) < hintsCalloutPostmasterResult {
+		return CalloutRecord{}, false
+	}
+
+	record := CalloutRecord{
+		Key:       key,
+		Result:    calloutResult(int(binary.LittleEndian.Uint32(data[hintsCalloutResult:]))),
+		UpdatedAt: hintsTime(data),
+	}
+	if len(data) >= hintsCalloutDomainSize {
+		record.PostmasterResult = calloutResult(int(binary.LittleEndian.Uint32(data[hintsCalloutPostmasterResult:])))
+		record.RandomResult = calloutResult(int(binary.LittleEndian.Uint32(data[hintsCalloutRandomResult:])))
+	}
+	return record, true
+}
+
+// calloutResult names a ccache_* result code
+func calloutResult(code int) string {
+	switch code {
+	case 1:
+		return "accept"
+	case 2:
+		return "reject"
+	case 3:
+		return "reject_mfnull"
+	default:
+		return "unknown"
+	}
+}
+
+func hintsTime(data []byte) time.Time {
+	return time.Unix(int64(binary.LittleEndian.Uint64(data)), 0)
+}
+
+func cString(data []byte) []byte {
+	if end := bytes.IndexByte(data, 0); end >= 0 {
+		return data[:end]
+	}
+	return data
+}
+
+// newRetryRecord creates a retry record with the fields implied by its key: T:host:ip,
+// T:host:ip:port or T:host:ip+messageid for hosts, R:domain or R:address for routing
+func newRetryRecord(key string) RetryRecord {
+	record := RetryRecord{Key: key}
+
+	kind, rest, _ := strings.Cut(key, ":")
+	switch kind {
+	case "T":
+		record.Type = "host"
+		if base, messageID, found := strings.Cut(rest, "+"); found {
+			rest, record.MessageID = base, messageID
+		}
+		record.Host = rest
+		if colon := strings.Index(rest, ":"); colon >= 0 {
+			record.Host, record.IPAddress = rest[:colon], rest[colon+1:]
+			// IPv6 addresses contain colons, so only a trailing all-digit part is a port
+			if last := strings.LastIndex(record.IPAddress, ":"); last >= 0 && strings.Count(record.IPAddress, ":") == 1 {
+				if _, err := strconv.Atoi(record.IPAddress[last+1:]); err == nil {
+					record.IPAddress = record.IPAddress[:last]
+				}
+			}
+		}
+	default:
+		// The sender is appended to address records when retries are per sender
+		address, _, _ := strings.Cut(rest, ":<")
+		if at := strings.LastIndex(address, "@"); at >= 0 {
+			record.Type = "address"
+			record.Address = address
+			record.Domain = address[at+1:]
+		} else {
+			record.Type = "domain"
+			record.Domain = address
+		}
+	}
+
+	return record
+}
+
+var (
+	retryDumpTimesRegex  = regexp.MustCompile(`^(\d{2}-[A-Za-z]{3}-\d{4} \d{2}:\d{2}:\d{2})\s+(\d{2}-[A-Za-z]{3}-\d{4} \d{2}:\d{2}:\d{2})\s+(\d{2}-[A-Za-z]{3}-\d{4} \d{2}:\d{2}:\d{2})\s*(\*)?\s*$`)
+	retryDumpKeyRegex    = regexp.MustCompile(`^\s*(\S+) (-?\d+) (-?\d+) ?(.*)$`)
+	calloutDumpRegex     = regexp.MustCompile(`^(\d{2}-[A-Za-z]{3}-\d{4} \d{2}:\d{2}:\d{2}) (\S+) callout=(\S+)(.*)$`)
+	calloutDumpPartRegex = regexp.MustCompile(`(postmaster|random)=(\S+)`)
+	hintsDumpDateRegex   = regexp.MustCompile(`^\d{2}-[A-Za-z]{3}-\d{4}$`)
+)
+
+// parseRetryDump parses "exim_dumpdb <spool> retry" output, two lines per record:
+//
+//	  T:mx.example.com:192.0.2.1 111 0 Connection refused
+//	15-Jan-2024 10:00:00  15-Jan-2024 12:00:00  15-Jan-2024 12:15:00 *
+func parseRetryDump(output io.Reader) ([]RetryRecord, error) {
+	var records []RetryRecord
+	var pending *RetryRecord
+
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if times := retryDumpTimesRegex.FindStringSubmatch(line); times != nil {
+			if pending == nil {
+				continue
+			}
+			var err error
+			if pending.FirstFailed, err = time.ParseInLocation(hintsDumpTimeFormat, times[1], time.Local); err != nil {
+				return nil, err
+			}
+			if pending.LastTry, err = time.ParseInLocation(hintsDumpTimeFormat, times[2], time.Local); err != nil {
+				return nil, err
+			}
+			if pending.NextTry, err = time.ParseInLocation(hintsDumpTimeFormat, times[3], time.Local); err != nil {
+				return nil, err
+			}
+			pending.Expired = times[4] == "*"
+			records = append(records, *pending)
+			pending = nil
+			continue
+		}
+
+		if match := retryDumpKeyRegex.FindStringSubmatch(line); match != nil {
+			record := newRetryRecord(match[1])
+			record.Errno, _ = strconv.Atoi(match[2])
+			record.MoreErrno, _ = strconv.Atoi(match[3])
+			record.Text = strings.TrimSpace(match[4])
+			pending = &record
+		}
+	}
+
+	return records, scanner.Err()
+}
+
+// parseWaitDump parses "exim_dumpdb <spool> wait-<transport>" output, one host and its
+// waiting message IDs per line
+func parseWaitDump(output io.Reader) ([]WaitRecord, error) {
+	var records []WaitRecord
+
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "*") {
+			continue
+		}
+
+		// Lines may start with the record timestamp
+		if len(fields) > 2 && hintsDumpDateRegex.MatchString(fields[0]) {
+			fields = fields[2:]
+		}
+
+		record := WaitRecord{Host: fields[0]}
+		for _, id := range fields[1:] {
+			if spoolMessageIDRegex.MatchString(id) {
+				record.MessageIDs = append(record.MessageIDs, id)
+			}
+		}
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+// parseCalloutDump parses "exim_dumpdb <spool> callout" output:
+//
+//	15-Jan-2024 10:00:00 example.com callout=accept postmaster=unknown random=reject
+func parseCalloutDump(output io.Reader) ([]CalloutRecord, error) {
+	var records []CalloutRecord
+
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		match := calloutDumpRegex.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+
+		updatedAt, err := time.ParseInLocation(hintsDumpTimeFormat, match[1], time.Local)
+		if err != nil {
+			return nil, err
+		}
+
+		record := CalloutRecord{Key: match[2], Result: match[3], UpdatedAt: updatedAt}
+		for _, part := range calloutDumpPartRegex.FindAllStringSubmatch(match[4], -1) {
+			if part[1] == "postmaster" {
+				record.PostmasterResult = part[2]
+			} else {
+				record.RandomResult = part[2]
+			}
+		}
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+// ClearRetryRecord deletes a record from the retry database with exim_fixdb, which
+// takes Exim's lock on the database
+func (h *HintsReader) ClearRetryRecord(key string) (string, error) {
+	if key == "" || len(key) > 1024 || strings.ContainsAny(key, "\r\n\x00") {
+		return "", fmt.Errorf("invalid retry record key")
+	}
+
+	// exim_fixdb reads commands from stdin: a key selects a record and d deletes it
+	cmd := exec.Command(h.fixdbPath, h.spoolDir, "retry")
+	cmd.Stdin = strings.NewReader(key + "\nd\nq\n")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("exim_fixdb failed: %w", err)
+	}
+
+	if strings.Contains(string(output), "no record found") || !strings.Contains(string(output), "deleted") {
+		return string(output), ErrRetryRecordNotFound
+	}
+	return string(output), nil
+}
+
+// GetRetryState reads the Exim hints databases. With activeOnly, only retry records
+// whose next try is still in the future are returned.
+func (m *Manager) GetRetryState(activeOnly bool) (*HintsState, error) {
+	if m.hints == nil {
+		return nil, fmt.Errorf("spool directory is not configured")
+	}
+
+	state, err := m.hints.ReadState(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if activeOnly {
+		active := state.Retry[:0]
+		for _, record := range state.Retry {
+			if record.InBackoff {
+				active = append(active, record)
+			}
+		}
+		state.Retry = active
+	}
+	return state, nil
+}
+
+// ClearRetryRecord deletes a host, domain or address retry record so that Exim tries it
+// at the next queue run, and records the operation in the audit trail
+func (m *Manager) ClearRetryRecord(key string, userID string, ipAddress string) (*OperationResult, error) {
+	result := &OperationResult{Operation: "retry_clear"}
+	if m.hints == nil {
+		return nil, fmt.Errorf("spool directory is not configured")
+	}
+
+	if err := m.securityService.ValidateSystemCommand(m.hints.fixdbPath, []string{m.hints.spoolDir, "retry"}); err != nil {
+		log.Printf("SECURITY: Command validation failed for retry_clear: %v", err)
+		return nil, err
+	}
+
+	m.securityService.LogSecurityEvent("QUEUE_OPERATION",
+		fmt.Sprintf("retry_clear key=%s userID=%s ip=%s", key, userID, ipAddress))
+
+	output, err := m.hints.ClearRetryRecord(key)
+	result.Message = strings.TrimSpace(output)
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Success = true
+		result.Message = "Retry record cleared"
+	}
+
+	if auditErr := m.logRetryAuditAction(key, userID, ipAddress, result); auditErr != nil {
+		log.Printf("Failed to log audit action: %v", auditErr)
+	}
+
+	return result, err
+}
+
+// logRetryAuditAction logs the clearing of a retry record to the audit trail
+func (m *Manager) logRetryAuditAction(key, userID, ipAddress string, result *OperationResult) error {
+	if m.db == nil {
+		return fmt.Errorf("database connection not available")
+	}
+
+	details := map[string]interface{}{
+		"operation": "retry_clear",
+		"key":       key,
+		"success":   result.Success,
+		"message":   result.Message,
+	}
+	if result.Error != "" {
+		details["error"] = result.Error
+	}
+
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit details: %w", err)
+	}
+	detailsStr := string(detailsJSON)
+
+	return database.NewAuditLogRepository(m.db).Create(&database.AuditLog{
+		Action:    "queue_retry_clear",
+		UserID:    &userID,
+		IPAddress: &ipAddress,
+		Details:   &detailsStr,
+	})
+}
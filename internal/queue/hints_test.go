@@ -0,0 +1,155 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRetryDump(t *testing.T) {
+	output := `  T:mx.example.com:192.0.2.1 111 0 Connection refused
+15-Jan-2024 10:00:00  15-Jan-2024 12:00:00  15-Jan-2024 12:15:00
+  R:example.org -44 0 host lookup did not complete
+14-Jan-2024 08:00:00  15-Jan-2024 11:00:00  15-Jan-2024 17:00:00 *
+  T:mx2.example.net:2001:db8::1+1rABCD-123456-78 -53 4 retry time not reached
+15-Jan-2024 09:00:00  15-Jan-2024 09:30:00  15-Jan-2024 10:30:00
+`
+	records, err := parseRetryDump(strings.NewReader(output))
+	if err != nil {
+		t.Fatalf("parseRetryDump failed: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Parsed %d records, want 3", len(records))
+	}
+
+	host := records[0]
+	if host.Type != "host" || host.Host != "mx.example.com" || host.IPAddress != "192.0.2.1" || host.Errno != 111 || host.Text != "Connection refused" {
+		t.Errorf("Unexpected host record %+v", host)
+	}
+	if want := time.Date(2024, 1, 15, 12, 15, 0, 0, time.Local); !host.NextTry.Equal(want) || host.Expired {
+		t.Errorf("NextTry = %s, expired %v", host.NextTry, host.Expired)
+	}
+
+	domain := records[1]
+	if domain.Type != "domain" || domain.Domain != "example.org" || domain.Errno != -44 || !domain.Expired {
+		t.Errorf("Unexpected domain record %+v", domain)
+	}
+
+	messageHost := records[2]
+	if messageHost.Host != "mx2.example.net" || messageHost.IPAddress != "2001:db8::1" || messageHost.MessageID != "1rABCD-123456-78" {
+		t.Errorf("Unexpected message-specific record %+v", messageHost)
+	}
+}
+
+func TestNewRetryRecordKeys(t *testing.T) {
+	tests := []struct {
+		key  string
+		want RetryRecord
+	}{
+		{"T:mx.example.com:192.0.2.1:2525", RetryRecord{Type: "host", Host: "mx.example.com", IPAddress: "192.0.2.1"}},
+		{"R:user@example.com", RetryRecord{Type: "address", Address: "user@example.com", Domain: "example.com"}},
+		{"R:user@example.com:<sender@example.org>", RetryRecord{Type: "address", Address: "user@example.com", Domain: "example.com"}},
+	}
+
+	for _, tt := range tests {
+		got := newRetryRecord(tt.key)
+		tt.want.Key = tt.key
+		if got != tt.want {
+			t.Errorf("newRetryRecord(%q) = %+v, want %+v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestParseWaitAndCalloutDumps(t *testing.T) {
+	waits, err := parseWaitDump(strings.NewReader("mx.example.com 1rABCD-123456-78 1rABCE-123456-79 \n"))
+	if err != nil || len(waits) != 1 || waits[0].Host != "mx.example.com" || len(waits[0].MessageIDs) != 2 {
+		t.Errorf("Unexpected wait records %+v (%v)", waits, err)
+	}
+
+	callouts, err := parseCalloutDump(strings.NewReader(
+		"15-Jan-2024 10:00:00 example.com callout=accept postmaster=reject (15-Jan-2024 10:00:00) random=unknown\n" +
+			"15-Jan-2024 10:01:00 user@example.com callout=reject\n"))
+	if err != nil || len(callouts) != 2 {
+		t.Fatalf("Unexpected callout records %+v (%v)", callouts, err)
+	}
+	if callouts[0].Result != "accept" || callouts[0].PostmasterResult != "reject" || callouts[0].RandomResult != "unknown" {
+		t.Errorf("Unexpected domain callout %+v", callouts[0])
+	}
+	if callouts[1].Key != "user@example.com" || callouts[1].Result != "reject" {
+		t.Errorf("Unexpected address callout %+v", callouts[1])
+	}
+}
+
+// createHintsDB writes a hints database in the format of Exim built with USE_SQLITE
+func createHintsDB(t *testing.T, spoolDir, name string, records map[string][]byte) {
+	t.Helper()
+
+	os.MkdirAll(filepath.Join(spoolDir, "db"), 0755)
+	db, err := sql.Open("sqlite3", filepath.Join(spoolDir, "db", name))
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", name, err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE tbl (ky TEXT PRIMARY KEY, dat BLOB)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	for key, data := range records {
+		if _, err := db.Exec("INSERT INTO tbl (ky, dat) VALUES (?, ?)", key, data); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+}
+
+// encodeRetryRecord builds a retry record as Exim stores it
+func encodeRetryRecord(now, nextTry time.Time, errno int, text string) []byte {
+	retry := make([]byte, hintsRetryText)
+	binary.LittleEndian.PutUint64(retry, uint64(now.Unix()))
+	binary.LittleEndian.PutUint32(retry[hintsRetryErrno:], uint32(errno))
+	binary.LittleEndian.PutUint64(retry[hintsRetryFirstFailed:], uint64(now.Add(-2*time.Hour).Unix()))
+	binary.LittleEndian.PutUint64(retry[hintsRetryLastTry:], uint64(now.Add(-time.Minute).Unix()))
+	binary.LittleEndian.PutUint64(retry[hintsRetryNextTry:], uint64(nextTry.Unix()))
+	return append(retry, text+"\x00"...)
+}
+
+func TestHintsReaderSQLite(t *testing.T) {
+	spoolDir := t.TempDir()
+
+	now := time.Now().Truncate(time.Second)
+	retry := encodeRetryRecord(now, now.Add(time.Hour), 111, "Connection refused")
+
+	wait := make([]byte, hintsWaitText)
+	binary.LittleEndian.PutUint32(wait[hintsWaitCount:], 2)
+	wait = append(wait, "1rABCD-123456-781rABCE-123456-79\x00"...)
+
+	createHintsDB(t, spoolDir, "retry", map[string][]byte{"T:mx.example.com:192.0.2.1": retry})
+	createHintsDB(t, spoolDir, "wait-remote_smtp", map[string][]byte{"mx.example.com": wait})
+
+	state, err := NewHintsReader(spoolDir, "/usr/sbin/exim4").ReadState(now)
+	if err != nil {
+		t.Fatalf("ReadState failed: %v", err)
+	}
+	if state.Source != HintsSourceSQLite {
+		t.Errorf("Source = %q", state.Source)
+	}
+
+	if len(state.Retry) != 1 {
+		t.Fatalf("Read %d retry records, want 1", len(state.Retry))
+	}
+	record := state.Retry[0]
+	if record.Host != "mx.example.com" || record.Errno != 111 || record.Text != "Connection refused" || !record.InBackoff {
+		t.Errorf("Unexpected retry record %+v", record)
+	}
+	if !record.FirstFailed.Equal(now.Add(-2*time.Hour)) || !record.NextTry.Equal(now.Add(time.Hour)) {
+		t.Errorf("Unexpected retry times %+v", record)
+	}
+
+	if len(state.Wait) != 1 || state.Wait[0].Transport != "remote_smtp" ||
+		strings.Join(state.Wait[0].MessageIDs, " ") != "1rABCD-123456-78 1rABCE-123456-79" {
+		t.Errorf("Unexpected wait records %+v", state.Wait)
+	}
+}
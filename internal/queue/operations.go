@@ -421,6 +421,7 @@ func isQueueOperation(action string) bool {
 		"queue_bulk_freeze",
 		"queue_bulk_thaw",
 		"queue_bulk_delete",
+		"queue_retry_clear",
 	}
 
 	for _, queueAction := range queueActions {
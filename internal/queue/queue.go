@@ -2,7 +2,10 @@ package queue
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"log"
+	"os"
 	"os/exec"
 	"regexp"
 	"strconv"
@@ -24,6 +27,12 @@ type QueueMessage struct {
 	RetryCount  int       `json:"retry_count"`
 	LastAttempt time.Time `json:"last_attempt"`
 	NextRetry   time.Time `json:"next_retry"`
+
+	// Envelope data only available when reading the spool directly
+	ReceivedAt       time.Time         `json:"received_at,omitempty"`
+	ReceivedProtocol string            `json:"received_protocol,omitempty"`
+	RecipientStatus  []SpoolRecipient  `json:"recipient_status,omitempty"`
+	ACLVariables     map[string]string `json:"acl_variables,omitempty"`
 }
 
 // QueueStatus represents the overall queue status
@@ -47,14 +56,32 @@ type Manager struct {
 	eximPath        string
 	db              *database.DB
 	securityService *security.Service
+	spool           *SpoolReader
+	hints           *HintsReader
 }
 
 // MessageEnvelope represents envelope information for a message
 type MessageEnvelope struct {
-	Sender      string    `json:"sender"`
-	Recipients  []string  `json:"recipients"`
-	ReceivedAt  time.Time `json:"received_at"`
-	Size        int64     `json:"size"`
+	Sender     string    `json:"sender"`
+	Recipients []string  `json:"recipients"`
+	ReceivedAt time.Time `json:"received_at"`
+	Size       int64     `json:"size"`
+
+	// Fields below are only populated when the spool is read directly
+	ReceivedProtocol    string            `json:"received_protocol,omitempty"`
+	HostName            string            `json:"host_name,omitempty"`
+	HostAddress         string            `json:"host_address,omitempty"`
+	InterfaceAddress    string            `json:"interface_address,omitempty"`
+	Ident               string            `json:"ident,omitempty"`
+	AuthenticatedID     string            `json:"authenticated_id,omitempty"`
+	AuthenticatedSender string            `json:"authenticated_sender,omitempty"`
+	TLSCipher           string            `json:"tls_cipher,omitempty"`
+	BodyLineCount       int               `json:"body_line_count,omitempty"`
+	WarningCount        int               `json:"warning_count,omitempty"`
+	Frozen              bool              `json:"frozen"`
+	FrozenAt            *time.Time        `json:"frozen_at,omitempty"`
+	ACLVariables        map[string]string `json:"acl_variables,omitempty"`
+	RecipientStatus     []SpoolRecipient  `json:"recipient_status,omitempty"`
 }
 
 // MessageDetails represents detailed information about a message
@@ -104,6 +131,18 @@ func NewManager(eximPath string, db *database.DB) *Manager {
 	}
 }
 
+// SetSpoolDir enables reading queue state directly from the Exim spool directory.
+// The exim binary is still used as a fallback when the spool cannot be read.
+func (m *Manager) SetSpoolDir(spoolDir string) {
+	if spoolDir == "" {
+		m.spool = nil
+		m.hints = nil
+		return
+	}
+	m.spool = NewSpoolReader(spoolDir)
+	m.hints = NewHintsReader(spoolDir, m.eximPath)
+}
+
 // createCommand creates an exec.Cmd for the Exim binary, handling Windows batch files
 func (m *Manager) createCommand(args ...string) *exec.Cmd {
 	// Handle Windows batch files
@@ -114,8 +153,16 @@ func (m *Manager) createCommand(args ...string) *exec.Cmd {
 	return exec.Command(m.eximPath, args...)
 }
 
-// ListQueue retrieves the current queue status using exim -bp
+// ListQueue retrieves the current queue status from the spool, falling back to exim -bp
 func (m *Manager) ListQueue() (*QueueStatus, error) {
+	if m.spool.Available() {
+		status, err := m.listQueueFromSpool()
+		if err == nil {
+			return status, nil
+		}
+		log.Printf("Failed to read queue from spool, falling back to exim -bp: %v", err)
+	}
+
 	cmd := m.createCommand("-bp")
 	output, err := cmd.Output()
 	if err != nil {
@@ -301,6 +348,14 @@ func (m *Manager) parseAge(ageStr string) time.Duration {
 
 // InspectMessage retrieves detailed information about a specific message
 func (m *Manager) InspectMessage(messageID string) (*MessageDetails, error) {
+	if m.spool.Available() {
+		details, err := m.inspectMessageFromSpool(messageID)
+		if err == nil {
+			return details, nil
+		}
+		log.Printf("Failed to read message %s from spool, falling back to exim: %v", messageID, err)
+	}
+
 	// Get message headers using exim -Mvh
 	headersCmd := m.createCommand("-Mvh", messageID)
 	headersOutput, err := headersCmd.Output()
@@ -396,6 +451,99 @@ func (m *Manager) InspectMessage(messageID string) (*MessageDetails, error) {
 	return details, nil
 }
 
+// ReadMessageSource returns the raw RFC 822 message, reading the spool directly and
+// falling back to exim -Mvh/-Mvb. The body is cut after limit bytes when limit > 0.
+func (m *Manager) ReadMessageSource(messageID string, limit int64) ([]byte, int64, bool, error) {
+	if m.spool.Available() {
+		raw, size, truncated, err := m.spool.ReadRawMessage(messageID, limit)
+		if err == nil {
+			return raw, size, truncated, nil
+		}
+		log.Printf("Failed to read message %s from spool, falling back to exim: %v", messageID, err)
+	}
+
+	if err := m.ValidateMessageID(messageID); err != nil {
+		return nil, 0, false, err
+	}
+
+	headersOutput, err := m.createCommand("-Mvh", messageID).Output()
+	if err != nil {
+		if eximMessageMissing(headersOutput, err) {
+			return nil, 0, false, fmt.Errorf("message %s is not in the queue: %w", messageID, os.ErrNotExist)
+		}
+		return nil, 0, false, fmt.Errorf("failed to get message headers: %w", err)
+	}
+
+	bodyOutput, err := m.createCommand("-Mvb", messageID).Output()
+	if err != nil {
+		if eximMessageMissing(bodyOutput, err) {
+			return nil, 0, false, fmt.Errorf("message %s is not in the queue: %w", messageID, os.ErrNotExist)
+		}
+		return nil, 0, false, fmt.Errorf("failed to get message body: %w", err)
+	}
+
+	// -Mvb prints the -D file as stored, including its identification line
+	bodyOutput = []byte(strings.TrimPrefix(string(bodyOutput), messageID+"-D\n"))
+
+	headerBlock := m.rawHeadersFromOutput(string(headersOutput))
+	size := int64(len(headerBlock) + len(bodyOutput))
+
+	truncated := false
+	if limit > 0 && int64(len(bodyOutput)) > limit {
+		bodyOutput = bodyOutput[:limit]
+		truncated = true
+	}
+
+	raw := make([]byte, 0, len(headerBlock)+1+len(bodyOutput))
+	raw = append(raw, headerBlock...)
+	raw = append(raw, '\n')
+	raw = append(raw, bodyOutput...)
+
+	return raw, size, truncated, nil
+}
+
+// eximMessageMissing reports whether a failed exim -Mvh/-Mvb run failed because the
+// message has no spool file, which exim reports with the open error on either stream
+func eximMessageMissing(output []byte, err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	return strings.Contains(string(output), "No such file or directory") ||
+		strings.Contains(string(exitErr.Stderr), "No such file or directory")
+}
+
+// spoolHeaderPrefixRegex matches the "NNNx " length and type prefix that exim -Mvh prints
+var spoolHeaderPrefixRegex = regexp.MustCompile(`^(\d{3,})([ A-Za-z*]) `)
+
+// rawHeadersFromOutput converts exim -Mvh output into a plain header block,
+// dropping headers that are marked as deleted. Everything before the first "NNNx "
+// header record is envelope, whose values (IPv6 host addresses, ACL variables) may
+// contain colons and look like headers.
+func (m *Manager) rawHeadersFromOutput(output string) string {
+	var raw strings.Builder
+	inHeaders := false
+	skipping := false
+
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		if matches := spoolHeaderPrefixRegex.FindStringSubmatch(line); matches != nil {
+			inHeaders = true
+			skipping = matches[2] == "*"
+			line = line[len(matches[0]):]
+		} else if !inHeaders || line == "" {
+			continue
+		}
+
+		if skipping {
+			continue
+		}
+		raw.WriteString(line)
+		raw.WriteString("\n")
+	}
+
+	return raw.String()
+}
+
 // parseHeaders parses message headers from exim -Mvh output
 func (m *Manager) parseHeaders(headersOutput string) map[string]string {
 	headers := make(map[string]string)
@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestReadMessageSourceMissingMessage(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a shell script in place of the exim binary")
+	}
+
+	// Exim reports a message without spool files on stderr and exits non-zero
+	eximPath := filepath.Join(t.TempDir(), "exim")
+	script := "#!/bin/sh\necho \"Failed to open input file for $2-H: No such file or directory\" >&2\nexit 1\n"
+	if err := os.WriteFile(eximPath, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake exim: %v", err)
+	}
+
+	manager := NewManager(eximPath, nil)
+	manager.SetSpoolDir(t.TempDir())
+	if _, _, _, err := manager.ReadMessageSource("1rABCD-0001ab-CD", 0); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got %v", err)
+	}
+
+	// Other failures are not reported as a missing message
+	script = "#!/bin/sh\necho \"permission denied\" >&2\nexit 1\n"
+	if err := os.WriteFile(eximPath, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake exim: %v", err)
+	}
+	if _, _, _, err := manager.ReadMessageSource("1rABCD-0001ab-CD", 0); err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected an exim failure, got %v", err)
+	}
+}
+
+func TestReadMessageSourceFromExim(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a shell script in place of the exim binary")
+	}
+
+	// The envelope printed before the headers has colons in its values
+	eximPath := filepath.Join(t.TempDir(), "exim")
+	script := "#!/bin/sh\ncase \"$1\" in\n-Mvh) cat <<'EOF'\n" +
+		"1rABCD-0001ab-CD-H\n" +
+		"exim 93 93\n" +
+		"<sender@example.com>\n" +
+		"1705316400 0\n" +
+		"-host_address [2001:db8::25]:52100\n" +
+		"-aclm 0 12\n" +
+		"verdict: ham\n" +
+		"-body_linecount 1\n" +
+		"XX\n" +
+		"1\n" +
+		"rcpt@example.com\n" +
+		"\n" +
+		"073P Received: from [2001:db8::25] (port=52100)\n" +
+		"\tby mx.example.com with esmtp\n" +
+		"025F From: sender@example.com\n" +
+		"021* To: rcpt@example.com\n" +
+		"014  Subject: Test\n" +
+		"EOF\n;;\n-Mvb) printf '1rABCD-0001ab-CD-D\\nHello\\n' ;;\nesac\n"
+	if err := os.WriteFile(eximPath, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake exim: %v", err)
+	}
+
+	manager := NewManager(eximPath, nil)
+	manager.SetSpoolDir(t.TempDir())
+	raw, size, truncated, err := manager.ReadMessageSource("1rABCD-0001ab-CD", 0)
+	if err != nil {
+		t.Fatalf("ReadMessageSource failed: %v", err)
+	}
+
+	want := "Received: from [2001:db8::25] (port=52100)\n" +
+		"\tby mx.example.com with esmtp\n" +
+		"From: sender@example.com\n" +
+		"Subject: Test\n" +
+		"\n" +
+		"Hello\n"
+	if string(raw) != want {
+		t.Errorf("Message read as %q, want %q", raw, want)
+	}
+	if size != int64(len(want)-1) || truncated {
+		t.Errorf("Got size %d and truncated %v", size, truncated)
+	}
+}
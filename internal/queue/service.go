@@ -25,6 +25,11 @@ func NewService(eximPath string, db *database.DB) *Service {
 	}
 }
 
+// SetSpoolDir enables direct spool reading for queue listing and inspection
+func (s *Service) SetSpoolDir(spoolDir string) {
+	s.manager.SetSpoolDir(spoolDir)
+}
+
 // GetQueueStatus retrieves current queue status
 func (s *Service) GetQueueStatus() (*QueueStatus, error) {
 	return s.manager.ListQueue()
@@ -35,6 +40,21 @@ func (s *Service) GetMessageDetails(messageID string) (*MessageDetails, error) {
 	return s.manager.InspectMessage(messageID)
 }
 
+// ReadMessageSource returns the raw RFC 822 message for content previews
+func (s *Service) ReadMessageSource(messageID string, limit int64) ([]byte, int64, bool, error) {
+	return s.manager.ReadMessageSource(messageID, limit)
+}
+
+// GetRetryState returns the retry, wait and callout state from the Exim hints databases
+func (s *Service) GetRetryState(activeOnly bool) (*HintsState, error) {
+	return s.manager.GetRetryState(activeOnly)
+}
+
+// ClearRetryRecord deletes a retry record from the Exim hints database
+func (s *Service) ClearRetryRecord(key string, userID string, ipAddress string) (*OperationResult, error) {
+	return s.manager.ClearRetryRecord(key, userID, ipAddress)
+}
+
 // CreateQueueSnapshot creates and stores a queue snapshot
 func (s *Service) CreateQueueSnapshot() (*database.QueueSnapshot, error) {
 	return s.manager.CreateSnapshot()
@@ -94,6 +114,12 @@ func (s *Service) GetQueueHealth() (*QueueHealth, error) {
 	return health, nil
 }
 
+// GetQueueHistory returns the recorded queue size between start and end, downsampled
+// to about maxPoints points
+func (s *Service) GetQueueHistory(start, end time.Time, maxPoints int) (*database.QueueHistory, error) {
+	return database.NewQueueSnapshotRepository(s.db).History(start, end, maxPoints)
+}
+
 // QueueHealth represents queue health metrics
 type QueueHealth struct {
 	TotalMessages    int           `json:"total_messages"`
@@ -0,0 +1,1107 @@
+package queue
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSpoolBodyPreview limits how much of a -D file is loaded for previews
+const maxSpoolBodyPreview = 1024 * 1024
+
+// spoolMessageIDRegex matches both the classic (6-6-2) and the extended (6-11-4) Exim message IDs
+var spoolMessageIDRegex = regexp.MustCompile(`^[0-9A-Za-z]{6}-[0-9A-Za-z]{6,11}-[0-9A-Za-z]{2,4}$`)
+
+// msglogLineRegex matches a timestamped line in a per-message log
+var msglogLineRegex = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})(?:\.\d+)?(?: [+-]\d{4})? (.*)$`)
+
+// SpoolReader reads queue state directly from the Exim spool directory
+type SpoolReader struct {
+	spoolDir string
+}
+
+// SpoolRecipient represents a recipient listed in a -H spool file
+type SpoolRecipient struct {
+	Address   string `json:"address"`
+	ErrorsTo  string `json:"errors_to,omitempty"`
+	Delivered bool   `json:"delivered"`
+}
+
+// SpoolHeaderLine represents a single RFC 822 header stored in a -H spool file
+type SpoolHeaderLine struct {
+	Type    byte   `json:"type"`
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	Deleted bool   `json:"deleted"`
+}
+
+// SpoolHeader represents the parsed contents of a -H spool file
+type SpoolHeader struct {
+	MessageID           string
+	OriginatorLogin     string
+	OriginatorUID       int
+	OriginatorGID       int
+	Sender              string
+	ReceivedAt          time.Time
+	WarningCount        int
+	Options             map[string]string
+	ACLVariables        map[string]string
+	ReceivedProtocol    string
+	HostName            string
+	HostAddress         string
+	InterfaceAddress    string
+	Ident               string
+	AuthenticatedID     string
+	AuthenticatedSender string
+	TLSCipher           string
+	TLSPeerDN           string
+	BodyLineCount       int
+	Frozen              bool
+	FrozenAt            time.Time
+	ManualThaw          bool
+	DeliverFirstTime    bool
+	NonRecipients       []string
+	Recipients          []SpoolRecipient
+	Headers             []SpoolHeaderLine
+	HeaderSize          int64
+}
+
+// SpoolLogLine represents a single line of a per-message log (msglog)
+type SpoolLogLine struct {
+	Timestamp time.Time
+	Text      string
+}
+
+// NewSpoolReader creates a new spool reader for the given spool directory
+func NewSpoolReader(spoolDir string) *SpoolReader {
+	return &SpoolReader{
+		spoolDir: filepath.Clean(spoolDir),
+	}
+}
+
+// Available reports whether the spool input directory exists and is readable
+func (r *SpoolReader) Available() bool {
+	if r == nil || r.spoolDir == "" {
+		return false
+	}
+
+	info, err := os.Stat(filepath.Join(r.spoolDir, "input"))
+	if err != nil || !info.IsDir() {
+		return false
+	}
+
+	f, err := os.Open(filepath.Join(r.spoolDir, "input"))
+	if err != nil {
+		return false
+	}
+	f.Close()
+
+	return true
+}
+
+// ListMessageIDs returns the IDs of all messages that have a -H file in the spool,
+// handling both flat and split_spool_directory layouts
+func (r *SpoolReader) ListMessageIDs() ([]string, error) {
+	inputDir := filepath.Join(r.spoolDir, "input")
+
+	entries, err := os.ReadDir(inputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool input directory: %w", err)
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			// split_spool_directory uses single-character subdirectories
+			if len(entry.Name()) != 1 {
+				continue
+			}
+			subEntries, err := os.ReadDir(filepath.Join(inputDir, entry.Name()))
+			if err != nil {
+				continue
+			}
+			for _, subEntry := range subEntries {
+				if id := headerFileMessageID(subEntry.Name()); id != "" {
+					ids = append(ids, id)
+				}
+			}
+			continue
+		}
+
+		if id := headerFileMessageID(entry.Name()); id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// ReadHeader reads and parses the -H file for a message
+func (r *SpoolReader) ReadHeader(messageID string) (*SpoolHeader, error) {
+	path, err := r.findSpoolFile("input", messageID+"-H")
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open header file: %w", err)
+	}
+	defer f.Close()
+
+	header, err := ParseSpoolHeader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse header file for %s: %w", messageID, err)
+	}
+
+	if header.MessageID != messageID {
+		return nil, fmt.Errorf("header file for %s names message %s", messageID, header.MessageID)
+	}
+
+	return header, nil
+}
+
+// ReadBody reads up to limit bytes of the message body from the -D file.
+// It returns the body, the full body size and whether the body was truncated.
+func (r *SpoolReader) ReadBody(messageID string, limit int64) ([]byte, int64, bool, error) {
+	path, err := r.findSpoolFile("input", messageID+"-D")
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("failed to open data file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("failed to stat data file: %w", err)
+	}
+
+	reader := bufio.NewReader(f)
+
+	// The first line of a -D file is its own name
+	firstLine, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, 0, false, fmt.Errorf("failed to read data file: %w", err)
+	}
+	if strings.TrimSpace(firstLine) != messageID+"-D" {
+		return nil, 0, false, fmt.Errorf("data file for %s has unexpected first line", messageID)
+	}
+
+	bodySize := info.Size() - int64(len(firstLine))
+	if limit <= 0 || limit > bodySize {
+		limit = bodySize
+	}
+
+	body := make([]byte, limit)
+	n, err := io.ReadFull(reader, body)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, 0, false, fmt.Errorf("failed to read message body: %w", err)
+	}
+
+	return body[:n], bodySize, int64(n) < bodySize, nil
+}
+
+// ReadRawMessage rebuilds an RFC 822 message from the non-deleted headers in the -H file
+// followed by up to limit bytes of the -D body. It returns the message, the full message
+// size and whether the body was truncated.
+func (r *SpoolReader) ReadRawMessage(messageID string, limit int64) ([]byte, int64, bool, error) {
+	header, err := r.ReadHeader(messageID)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	body, bodySize, truncated, err := r.ReadBody(messageID, limit)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	var raw strings.Builder
+	for _, line := range header.Headers {
+		if line.Deleted || line.Name == "" {
+			continue
+		}
+		raw.WriteString(line.Name)
+		raw.WriteString(": ")
+		raw.WriteString(line.Value)
+		raw.WriteString("\n")
+	}
+	raw.WriteString("\n")
+	raw.Write(body)
+
+	return []byte(raw.String()), header.HeaderSize + bodySize, truncated, nil
+}
+
+// BodySize returns the size of the message body stored in the -D file
+func (r *SpoolReader) BodySize(messageID string) (int64, error) {
+	path, err := r.findSpoolFile("input", messageID+"-D")
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat data file: %w", err)
+	}
+
+	// Subtract the "<id>-D\n" identification line
+	size := info.Size() - int64(len(messageID)+3)
+	if size < 0 {
+		size = 0
+	}
+	return size, nil
+}
+
+// ReadMessageLog reads the per-message log for a message. A missing msglog is not an error.
+func (r *SpoolReader) ReadMessageLog(messageID string) ([]SpoolLogLine, error) {
+	path, err := r.findSpoolFile("msglog", messageID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open message log: %w", err)
+	}
+	defer f.Close()
+
+	return parseSpoolMessageLog(f)
+}
+
+// findSpoolFile locates a file in a spool subdirectory, trying the flat layout first
+// and then the split_spool_directory layout
+func (r *SpoolReader) findSpoolFile(subdir, name string) (string, error) {
+	messageID := strings.TrimSuffix(strings.TrimSuffix(name, "-H"), "-D")
+	if !spoolMessageIDRegex.MatchString(messageID) {
+		return "", fmt.Errorf("invalid message ID: %s", messageID)
+	}
+
+	candidates := []string{
+		filepath.Join(r.spoolDir, subdir, name),
+		filepath.Join(r.spoolDir, subdir, messageID[5:6], name),
+	}
+
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+
+	return "", &os.PathError{Op: "open", Path: candidates[0], Err: os.ErrNotExist}
+}
+
+// headerFileMessageID returns the message ID for a -H file name, or "" if the name is not a -H file
+func headerFileMessageID(name string) string {
+	if !strings.HasSuffix(name, "-H") {
+		return ""
+	}
+	id := strings.TrimSuffix(name, "-H")
+	if !spoolMessageIDRegex.MatchString(id) {
+		return ""
+	}
+	return id
+}
+
+// ParseSpoolHeader parses the contents of an Exim -H spool file
+func ParseSpoolHeader(input io.Reader) (*SpoolHeader, error) {
+	reader := bufio.NewReader(input)
+	header := &SpoolHeader{
+		Options:      make(map[string]string),
+		ACLVariables: make(map[string]string),
+	}
+
+	// Line 1: "<message id>-H"
+	line, err := readSpoolLine(reader)
+	if err != nil {
+		return nil, fmt.Errorf("missing identification line: %w", err)
+	}
+	if !strings.HasSuffix(line, "-H") {
+		return nil, fmt.Errorf("invalid identification line: %q", line)
+	}
+	header.MessageID = strings.TrimSuffix(line, "-H")
+
+	// Line 2: "<login> <uid> <gid>"
+	line, err = readSpoolLine(reader)
+	if err != nil {
+		return nil, fmt.Errorf("missing originator line: %w", err)
+	}
+	fields := strings.Fields(line)
+	if len(fields) >= 3 {
+		header.OriginatorLogin = fields[0]
+		header.OriginatorUID, _ = strconv.Atoi(fields[1])
+		header.OriginatorGID, _ = strconv.Atoi(fields[2])
+	}
+
+	// Line 3: "<sender>"
+	line, err = readSpoolLine(reader)
+	if err != nil {
+		return nil, fmt.Errorf("missing sender line: %w", err)
+	}
+	header.Sender = strings.TrimSuffix(strings.TrimPrefix(line, "<"), ">")
+
+	// Line 4: "<received time> <warning count>"
+	line, err = readSpoolLine(reader)
+	if err != nil {
+		return nil, fmt.Errorf("missing time line: %w", err)
+	}
+	fields = strings.Fields(line)
+	if len(fields) < 1 {
+		return nil, fmt.Errorf("invalid time line: %q", line)
+	}
+	receivedAt, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid received time: %q", fields[0])
+	}
+	header.ReceivedAt = time.Unix(receivedAt, 0)
+	if len(fields) > 1 {
+		header.WarningCount, _ = strconv.Atoi(fields[1])
+	}
+
+	// Option lines, each starting with '-'
+	for {
+		line, err = readSpoolLine(reader)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected end of options: %w", err)
+		}
+		if !strings.HasPrefix(line, "-") {
+			break
+		}
+		if err := header.parseOption(line, reader); err != nil {
+			return nil, err
+		}
+	}
+
+	// Non-recipients tree: addresses that have already been delivered
+	if line != "XX" {
+		if err := readNonRecipientTree(line, reader, &header.NonRecipients); err != nil {
+			return nil, err
+		}
+	}
+
+	delivered := make(map[string]bool, len(header.NonRecipients))
+	for _, address := range header.NonRecipients {
+		delivered[strings.ToLower(address)] = true
+	}
+
+	// Recipient count followed by one recipient per line
+	line, err = readSpoolLine(reader)
+	if err != nil {
+		return nil, fmt.Errorf("missing recipient count: %w", err)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient count: %q", line)
+	}
+
+	for i := 0; i < count; i++ {
+		line, err = readSpoolLine(reader)
+		if err != nil {
+			return nil, fmt.Errorf("missing recipient %d of %d: %w", i+1, count, err)
+		}
+		recipient := parseSpoolRecipient(line)
+		recipient.Delivered = delivered[strings.ToLower(recipient.Address)]
+		header.Recipients = append(header.Recipients, recipient)
+	}
+
+	// A blank line separates the envelope from the headers
+	if _, err := readSpoolLine(reader); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read header separator: %w", err)
+	}
+
+	if err := header.parseHeaderLines(reader); err != nil {
+		return nil, err
+	}
+
+	return header, nil
+}
+
+// parseOption handles a single "-name [value]" option line
+func (h *SpoolHeader) parseOption(line string, reader *bufio.Reader) error {
+	name, value, _ := strings.Cut(line[1:], " ")
+
+	switch name {
+	case "acl", "aclc", "aclm":
+		// ACL variables are followed by a value of an exact byte length
+		fields := strings.Fields(value)
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid ACL variable line: %q", line)
+		}
+		length, err := strconv.Atoi(fields[1])
+		if err != nil || length < 0 {
+			return fmt.Errorf("invalid ACL variable length: %q", line)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return fmt.Errorf("truncated ACL variable %s: %w", fields[0], err)
+		}
+		// Consume the newline that terminates the value
+		if _, err := reader.ReadString('\n'); err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read ACL variable terminator: %w", err)
+		}
+
+		varName := fields[0]
+		switch name {
+		case "aclc":
+			varName = "acl_c" + varName
+		case "aclm":
+			varName = "acl_m" + varName
+		default:
+			// Legacy numbered format: 0-9 are connection, 10-19 are message variables
+			if n, err := strconv.Atoi(varName); err == nil && n >= 10 {
+				varName = fmt.Sprintf("acl_m%d", n-10)
+			} else {
+				varName = "acl_c" + varName
+			}
+		}
+		h.ACLVariables[varName] = string(data)
+		return nil
+	}
+
+	h.Options[name] = value
+
+	switch name {
+	case "received_protocol":
+		h.ReceivedProtocol = value
+	case "host_name":
+		h.HostName = value
+	case "host_address":
+		h.HostAddress = stripSpoolPort(value)
+	case "interface_address":
+		h.InterfaceAddress = stripSpoolPort(value)
+	case "ident":
+		h.Ident = value
+	case "auth_id":
+		h.AuthenticatedID = value
+	case "auth_sender":
+		h.AuthenticatedSender = value
+	case "tls_cipher":
+		h.TLSCipher = value
+	case "tls_peerdn":
+		h.TLSPeerDN = value
+	case "body_linecount":
+		h.BodyLineCount, _ = strconv.Atoi(value)
+	case "frozen":
+		h.Frozen = true
+		if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+			h.FrozenAt = time.Unix(ts, 0)
+		}
+	case "manual_thaw":
+		h.ManualThaw = true
+	case "deliver_firsttime":
+		h.DeliverFirstTime = true
+	}
+
+	return nil
+}
+
+// parseHeaderLines reads the "NNNc text" header records that follow the envelope
+func (h *SpoolHeader) parseHeaderLines(reader *bufio.Reader) error {
+	for {
+		// Skip any blank lines before the next record
+		c, err := reader.ReadByte()
+		for err == nil && (c == '\n' || c == '\r') {
+			c, err = reader.ReadByte()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read header record: %w", err)
+		}
+
+		// The length digits are followed by a one-character type and a space.
+		// The type itself is a space for ordinary headers.
+		var digits []byte
+		for err == nil && c >= '0' && c <= '9' {
+			digits = append(digits, c)
+			c, err = reader.ReadByte()
+		}
+		if err != nil || len(digits) == 0 {
+			return fmt.Errorf("invalid header record prefix")
+		}
+		headerType := c
+		if sep, err := reader.ReadByte(); err != nil || sep != ' ' {
+			return fmt.Errorf("invalid header record prefix: %s%c", digits, headerType)
+		}
+
+		length, err := strconv.Atoi(string(digits))
+		if err != nil {
+			return fmt.Errorf("invalid header record length: %s", digits)
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return fmt.Errorf("truncated header record: %w", err)
+		}
+
+		text := strings.TrimRight(string(data), "\r\n")
+		name, value, _ := strings.Cut(text, ":")
+
+		h.Headers = append(h.Headers, SpoolHeaderLine{
+			Type:    headerType,
+			Name:    strings.TrimSpace(name),
+			Value:   unfoldHeaderValue(value),
+			Deleted: headerType == '*',
+		})
+
+		if headerType != '*' {
+			h.HeaderSize += int64(length)
+		}
+	}
+}
+
+// HeaderMap returns the non-deleted headers as a map, joining repeated headers with newlines
+func (h *SpoolHeader) HeaderMap() map[string]string {
+	headers := make(map[string]string)
+	for _, line := range h.Headers {
+		if line.Deleted || line.Name == "" {
+			continue
+		}
+		if existing, ok := headers[line.Name]; ok {
+			headers[line.Name] = existing + "\n" + line.Value
+		} else {
+			headers[line.Name] = line.Value
+		}
+	}
+	return headers
+}
+
+// PendingRecipients returns the recipients that have not yet been delivered
+func (h *SpoolHeader) PendingRecipients() []string {
+	var pending []string
+	for _, recipient := range h.Recipients {
+		if !recipient.Delivered {
+			pending = append(pending, recipient.Address)
+		}
+	}
+	return pending
+}
+
+// readNonRecipientTree reads a balanced tree of delivered addresses in pre-order.
+// Each node line starts with two Y/N flags for its left and right subtrees.
+func readNonRecipientTree(line string, reader *bufio.Reader, addresses *[]string) error {
+	if len(line) < 3 || line[2] != ' ' {
+		return fmt.Errorf("invalid non-recipient tree node: %q", line)
+	}
+
+	*addresses = append(*addresses, line[3:])
+
+	for _, flag := range []byte{line[0], line[1]} {
+		if flag != 'Y' {
+			continue
+		}
+		next, err := readSpoolLine(reader)
+		if err != nil {
+			return fmt.Errorf("truncated non-recipient tree: %w", err)
+		}
+		if err := readNonRecipientTree(next, reader, addresses); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseSpoolRecipient parses a recipient line. Recipients with extra data end in
+// "#<flags>": flag 0x01 adds "<errors_to> <len>,<pno>" and flag 0x02 adds
+// "<orcpt> <len>,<dsn_flags>" before it, so current Exim versions write
+// "<address> <orcpt> <len>,<dsn_flags> <errors_to> <len>,<pno>#3". Either value may be
+// empty, so the fields are taken from the end by their lengths.
+func parseSpoolRecipient(line string) SpoolRecipient {
+	line = strings.TrimRight(line, " ")
+
+	hashIndex := strings.LastIndex(line, "#")
+	if hashIndex == -1 || !isDigits(line[hashIndex+1:]) {
+		return SpoolRecipient{Address: line}
+	}
+
+	var recipient SpoolRecipient
+	rest := line[:hashIndex]
+	flags, _ := strconv.Atoi(line[hashIndex+1:])
+	if flags&0x01 != 0 {
+		if errorsTo, remaining, ok := cutSpoolRecipientField(rest); ok {
+			recipient.ErrorsTo = errorsTo
+			rest = remaining
+		}
+	}
+	if flags&0x02 != 0 {
+		if _, remaining, ok := cutSpoolRecipientField(rest); ok {
+			rest = remaining
+		}
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return SpoolRecipient{Address: line}
+	}
+	recipient.Address = fields[0]
+
+	return recipient
+}
+
+// cutSpoolRecipientField removes a trailing "<value> <len>,<n>" field from a recipient
+// line, where value is exactly len bytes long, and returns the value and what precedes it
+func cutSpoolRecipientField(rest string) (value, remaining string, ok bool) {
+	space := strings.LastIndex(rest, " ")
+	if space == -1 {
+		return "", rest, false
+	}
+	lengthText, n, found := strings.Cut(rest[space+1:], ",")
+	length, err := strconv.Atoi(lengthText)
+	if !found || err != nil || length < 0 || length > space {
+		return "", rest, false
+	}
+	if _, err := strconv.Atoi(n); err != nil {
+		return "", rest, false
+	}
+
+	start := space - length
+	if length > 0 && (start == 0 || rest[start-1] != ' ') {
+		return "", rest, false
+	}
+	return rest[start:space], strings.TrimSuffix(rest[:start], " "), true
+}
+
+// parseSpoolMessageLog parses msglog content into timestamped lines
+func parseSpoolMessageLog(input io.Reader) ([]SpoolLogLine, error) {
+	var lines []SpoolLogLine
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		matches := msglogLineRegex.FindStringSubmatch(text)
+		if matches == nil {
+			// Continuation of the previous line
+			if len(lines) > 0 {
+				lines[len(lines)-1].Text += " " + strings.TrimSpace(text)
+			}
+			continue
+		}
+
+		timestamp, err := time.ParseInLocation("2006-01-02 15:04:05", matches[1], time.Local)
+		if err != nil {
+			continue
+		}
+
+		lines = append(lines, SpoolLogLine{Timestamp: timestamp, Text: matches[2]})
+	}
+
+	return lines, scanner.Err()
+}
+
+// readSpoolLine reads a single newline-terminated line without the terminator
+func readSpoolLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// stripSpoolPort removes the ".port" suffix Exim appends to stored IP addresses
+func stripSpoolPort(address string) string {
+	if i := strings.LastIndex(address, "."); i != -1 && isDigits(address[i+1:]) {
+		candidate := address[:i]
+		// IPv4 addresses keep three dots after the port is removed; IPv6 addresses contain colons
+		if strings.Count(candidate, ".") == 3 || strings.Contains(candidate, ":") {
+			return candidate
+		}
+	}
+	return address
+}
+
+// unfoldHeaderValue collapses folded header continuation lines
+func unfoldHeaderValue(value string) string {
+	lines := strings.Split(value, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return strings.TrimSpace(strings.Join(lines, " "))
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// formatQueueAge formats a message age the same way exim -bp does
+func formatQueueAge(age time.Duration) string {
+	minutes := int(age / time.Minute)
+	if minutes > 90 {
+		hours := (minutes + 30) / 60
+		if hours > 72 {
+			return fmt.Sprintf("%dd", (hours+12)/24)
+		}
+		return fmt.Sprintf("%dh", hours)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+// listQueueFromSpool builds the queue status by reading the spool directory directly
+func (m *Manager) listQueueFromSpool() (*QueueStatus, error) {
+	ids, err := m.spool.ListMessageIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &QueueStatus{
+		Messages: make([]QueueMessage, 0, len(ids)),
+	}
+
+	schedule := m.retrySchedule()
+
+	now := time.Now()
+	for _, id := range ids {
+		msg, err := m.queueMessageFromSpool(id, schedule)
+		if err != nil {
+			// Messages can be removed by a delivery process between listing and reading
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		status.Messages = append(status.Messages, *msg)
+
+		switch msg.Status {
+		case "frozen":
+			status.FrozenMessages++
+		case "deferred":
+			status.DeferredMessages++
+		}
+
+		if age := now.Sub(msg.ReceivedAt); age > status.OldestMessageAge {
+			status.OldestMessageAge = age
+		}
+	}
+
+	status.TotalMessages = len(status.Messages)
+	return status, nil
+}
+
+// queueMessageFromSpool builds a queue listing entry for a single spooled message
+func (m *Manager) queueMessageFromSpool(messageID string, schedule retrySchedule) (*QueueMessage, error) {
+	header, err := m.spool.ReadHeader(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	bodySize, err := m.spool.BodySize(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	msgLog, err := m.spool.ReadMessageLog(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &QueueMessage{
+		ID:               messageID,
+		Size:             header.HeaderSize + bodySize,
+		Age:              formatQueueAge(time.Since(header.ReceivedAt)),
+		Sender:           header.Sender,
+		Recipients:       make([]string, 0, len(header.Recipients)),
+		Status:           "queued",
+		ReceivedAt:       header.ReceivedAt,
+		ReceivedProtocol: header.ReceivedProtocol,
+		RecipientStatus:  header.Recipients,
+		ACLVariables:     header.ACLVariables,
+	}
+
+	for _, recipient := range header.Recipients {
+		msg.Recipients = append(msg.Recipients, recipient.Address)
+	}
+
+	for _, line := range msgLog {
+		if isDeferLogLine(line.Text) {
+			msg.RetryCount++
+			msg.LastAttempt = line.Timestamp
+		} else if isDeliveryLogLine(line.Text) {
+			msg.LastAttempt = line.Timestamp
+		}
+	}
+
+	if header.Frozen {
+		msg.Status = "frozen"
+	} else if msg.RetryCount > 0 {
+		msg.Status = "deferred"
+	}
+
+	msg.NextRetry = schedule.nextRetry(messageID, header, msgLog)
+
+	return msg, nil
+}
+
+// inspectMessageFromSpool builds detailed message information from the spool files
+func (m *Manager) inspectMessageFromSpool(messageID string) (*MessageDetails, error) {
+	header, err := m.spool.ReadHeader(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	body, bodySize, _, err := m.spool.ReadBody(messageID, maxSpoolBodyPreview)
+	if err != nil {
+		return nil, err
+	}
+
+	msgLog, err := m.spool.ReadMessageLog(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	queueMsg, err := m.queueMessageFromSpool(messageID, m.retrySchedule())
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := MessageEnvelope{
+		Sender:              header.Sender,
+		Recipients:          queueMsg.Recipients,
+		ReceivedAt:          header.ReceivedAt,
+		Size:                header.HeaderSize + bodySize,
+		ReceivedProtocol:    header.ReceivedProtocol,
+		HostName:            header.HostName,
+		HostAddress:         header.HostAddress,
+		InterfaceAddress:    header.InterfaceAddress,
+		Ident:               header.Ident,
+		AuthenticatedID:     header.AuthenticatedID,
+		AuthenticatedSender: header.AuthenticatedSender,
+		TLSCipher:           header.TLSCipher,
+		BodyLineCount:       header.BodyLineCount,
+		WarningCount:        header.WarningCount,
+		Frozen:              header.Frozen,
+		ACLVariables:        header.ACLVariables,
+		RecipientStatus:     header.Recipients,
+	}
+	if header.Frozen && !header.FrozenAt.IsZero() {
+		frozenAt := header.FrozenAt
+		envelope.FrozenAt = &frozenAt
+	}
+
+	details := &MessageDetails{
+		ID:               messageID,
+		Status:           queueMsg.Status,
+		RetryCount:       queueMsg.RetryCount,
+		LastAttempt:      queueMsg.LastAttempt,
+		NextRetry:        queueMsg.NextRetry,
+		Envelope:         envelope,
+		Headers:          header.HeaderMap(),
+		ContentPreview:   string(body),
+		SMTPLogs:         make([]SMTPLogEntry, 0, len(msgLog)),
+		DeliveryAttempts: make([]DeliveryAttempt, 0),
+	}
+
+	for _, line := range msgLog {
+		entry := SMTPLogEntry{
+			Timestamp: line.Timestamp.Format(time.RFC3339),
+			Event:     messageLogEvent(line.Text),
+			Message:   line.Text,
+		}
+		entry.Host, entry.IPAddress = extractLogHost(line.Text)
+		details.SMTPLogs = append(details.SMTPLogs, entry)
+
+		if attempt := deliveryAttemptFromLog(line); attempt != nil {
+			attempt.ID = len(details.DeliveryAttempts) + 1
+			details.DeliveryAttempts = append(details.DeliveryAttempts, *attempt)
+		}
+	}
+
+	return details, nil
+}
+
+// retrySchedule maps the hosts, domains and addresses in retry backoff to their next try,
+// keyed the way Exim keys its retry records
+type retrySchedule map[string]time.Time
+
+// retrySchedule reads the retry hints database. Messages are listed without next retry
+// times when it cannot be read.
+func (m *Manager) retrySchedule() retrySchedule {
+	if m.hints == nil {
+		return nil
+	}
+
+	records, _, err := m.hints.readRetry(time.Now())
+	if err != nil {
+		log.Printf("Failed to read retry hints: %v", err)
+		return nil
+	}
+	return newRetrySchedule(records)
+}
+
+// newRetrySchedule indexes the retry records that are still in backoff
+func newRetrySchedule(records []RetryRecord) retrySchedule {
+	schedule := make(retrySchedule)
+	for _, record := range records {
+		if !record.InBackoff {
+			continue
+		}
+
+		var key string
+		switch record.Type {
+		case "host":
+			key = "T:" + record.Host + ":" + record.IPAddress
+			if record.MessageID != "" {
+				key += "+" + record.MessageID
+			}
+		case "address":
+			key = "R:" + strings.ToLower(record.Address)
+		default:
+			key = "R:" + strings.ToLower(record.Domain)
+		}
+
+		if next, ok := schedule[key]; !ok || record.NextTry.Before(next) {
+			schedule[key] = record.NextTry
+		}
+	}
+	return schedule
+}
+
+// nextRetry returns the earliest next try among the records holding back the pending
+// recipients of a message and the hosts it was deferred on, or the zero time if none is
+func (s retrySchedule) nextRetry(messageID string, header *SpoolHeader, msgLog []SpoolLogLine) time.Time {
+	if len(s) == 0 {
+		return time.Time{}
+	}
+
+	var keys []string
+	for _, address := range header.PendingRecipients() {
+		address = strings.ToLower(address)
+		keys = append(keys, "R:"+address)
+		if at := strings.LastIndex(address, "@"); at >= 0 {
+			keys = append(keys, "R:"+address[at+1:])
+		}
+	}
+	for _, line := range msgLog {
+		if !isDeferLogLine(line.Text) {
+			continue
+		}
+		if host, ip := extractLogHost(line.Text); host != "" {
+			keys = append(keys, "T:"+host+":"+ip, "T:"+host+":"+ip+"+"+messageID)
+		}
+	}
+
+	var next time.Time
+	for _, key := range keys {
+		if t, ok := s[key]; ok && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// msglogHostRegex extracts "H=host [ip]" from a log line
+var msglogHostRegex = regexp.MustCompile(`H=(\S+)(?: \([^)]*\))? \[([^\]]+)\]`)
+
+// msglogCodeRegex extracts an SMTP response code from a log line
+var msglogCodeRegex = regexp.MustCompile(`: ([2-5]\d\d)[ -]`)
+
+// isDeferLogLine reports whether a msglog line records a deferral
+func isDeferLogLine(text string) bool {
+	return strings.HasPrefix(text, "== ")
+}
+
+// isDeliveryLogLine reports whether a msglog line records a delivery outcome
+func isDeliveryLogLine(text string) bool {
+	return strings.HasPrefix(text, "=> ") || strings.HasPrefix(text, "-> ") ||
+		strings.HasPrefix(text, "** ") || strings.HasPrefix(text, "*> ")
+}
+
+// messageLogEvent classifies a msglog line
+func messageLogEvent(text string) string {
+	switch {
+	case strings.HasPrefix(text, "=> "), strings.HasPrefix(text, "-> "):
+		return "delivery"
+	case strings.HasPrefix(text, "*> "):
+		return "suppressed"
+	case strings.HasPrefix(text, "== "):
+		return "defer"
+	case strings.HasPrefix(text, "** "):
+		return "bounce"
+	case strings.HasPrefix(text, "Frozen"):
+		return "frozen"
+	case strings.HasPrefix(text, "Unfrozen"):
+		return "unfrozen"
+	case strings.HasPrefix(text, "Completed"):
+		return "completed"
+	case strings.Contains(text, "SMTP"):
+		return "smtp"
+	default:
+		return "info"
+	}
+}
+
+// extractLogHost returns the host name and IP address from an "H=" field
+func extractLogHost(text string) (string, string) {
+	if matches := msglogHostRegex.FindStringSubmatch(text); matches != nil {
+		return matches[1], matches[2]
+	}
+	return "", ""
+}
+
+// deliveryAttemptFromLog converts a delivery outcome line into a delivery attempt
+func deliveryAttemptFromLog(line SpoolLogLine) *DeliveryAttempt {
+	var status string
+	switch messageLogEvent(line.Text) {
+	case "delivery":
+		status = "success"
+	case "defer":
+		status = "defer"
+	case "bounce":
+		status = "bounce"
+	default:
+		return nil
+	}
+
+	fields := strings.Fields(line.Text)
+	if len(fields) < 2 {
+		return nil
+	}
+
+	attempt := &DeliveryAttempt{
+		Timestamp: line.Timestamp,
+		Recipient: fields[1],
+		Status:    status,
+	}
+	attempt.Host, attempt.IPAddress = extractLogHost(line.Text)
+
+	if matches := msglogCodeRegex.FindStringSubmatch(line.Text); matches != nil {
+		attempt.SMTPCode = matches[1]
+	}
+
+	if status != "success" {
+		if i := strings.Index(line.Text, ": "); i != -1 {
+			attempt.ErrorMessage = strings.TrimSpace(line.Text[i+2:])
+		}
+	}
+
+	return attempt
+}
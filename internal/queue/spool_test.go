@@ -0,0 +1,406 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// spoolHeaderRecord formats a header record as Exim writes it after the envelope
+func spoolHeaderRecord(headerType byte, text string) string {
+	return fmt.Sprintf("%03d%c %s", len(text), headerType, text)
+}
+
+// frozenSpoolHeader is a -H file for a frozen message with ACL variables, delivered
+// recipients and a deleted header
+func frozenSpoolHeader(messageID string) string {
+	return messageID + "-H\n" +
+		"Debian-exim 101 103\n" +
+		"<sender@example.com>\n" +
+		"1705312800 2\n" +
+		"-received_protocol esmtps\n" +
+		"-aclc _spam 3\n" +
+		"yes\n" +
+		"-aclm _note 11\n" +
+		"line1\nline2\n" +
+		"-acl 11 2\n" +
+		"42\n" +
+		"-acl 2 0\n" +
+		"\n" +
+		"-host_address 192.0.2.10.54321\n" +
+		"-host_name mail.example.net\n" +
+		"-interface_address [2001:db8::1].25\n" +
+		"-ident relay\n" +
+		"-auth_id alice\n" +
+		"-auth_sender alice@example.com\n" +
+		"-tls_cipher TLS1.3:TLS_AES_256_GCM_SHA384:256\n" +
+		"-body_linecount 12\n" +
+		"-frozen 1705316400\n" +
+		"-deliver_firsttime\n" +
+		"YY b@example.com\n" +
+		"NN a@example.com\n" +
+		"NN c@example.com\n" +
+		"4\n" +
+		"a@example.com\n" +
+		"B@Example.com\n" +
+		"pending@example.org bounces@example.com 19,1#1\n" +
+		"other@example.net  0,0  0,-1#3\n" +
+		"\n" +
+		spoolHeaderRecord('P', "Received: from mail.example.net ([192.0.2.10])\n\tby mx.example.com with esmtps\n") +
+		spoolHeaderRecord('F', "From: Sender <sender@example.com>\n") +
+		spoolHeaderRecord('T', "To: a@example.com, b@example.com\n") +
+		spoolHeaderRecord(' ', "Subject: Quarterly\n report\n") +
+		spoolHeaderRecord('*', "X-Spam-Flag: YES\n") +
+		spoolHeaderRecord(' ', "X-Note: one\n") +
+		spoolHeaderRecord(' ', "X-Note: two\n")
+}
+
+// thawedSpoolHeader is a -H file for a message thawed by an administrator
+func thawedSpoolHeader(messageID string) string {
+	return messageID + "-H\n" +
+		"mailnull 47 47\n" +
+		"<>\n" +
+		"1705320000 0\n" +
+		"-local\n" +
+		"-manual_thaw\n" +
+		"XX\n" +
+		"1\n" +
+		"postmaster@example.org\n" +
+		"\n" +
+		spoolHeaderRecord(' ', "Subject: Bounce\n")
+}
+
+// writeSpoolFile writes a file below the spool directory, creating its parent directories
+func writeSpoolFile(t *testing.T, spoolDir, path, content string) {
+	t.Helper()
+
+	path = filepath.Join(spoolDir, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestParseSpoolHeader(t *testing.T) {
+	header, err := ParseSpoolHeader(strings.NewReader(frozenSpoolHeader("1rABCD-0001ab-CD")))
+	if err != nil {
+		t.Fatalf("ParseSpoolHeader failed: %v", err)
+	}
+
+	if header.MessageID != "1rABCD-0001ab-CD" || header.OriginatorLogin != "Debian-exim" || header.OriginatorUID != 101 || header.OriginatorGID != 103 {
+		t.Errorf("Unexpected originator %+v", header)
+	}
+	if header.Sender != "sender@example.com" || !header.ReceivedAt.Equal(time.Unix(1705312800, 0)) || header.WarningCount != 2 {
+		t.Errorf("Unexpected sender line or time line %q %s %d", header.Sender, header.ReceivedAt, header.WarningCount)
+	}
+
+	if header.ReceivedProtocol != "esmtps" || header.HostName != "mail.example.net" || header.Ident != "relay" {
+		t.Errorf("Unexpected connection options %+v", header.Options)
+	}
+	if header.HostAddress != "192.0.2.10" || header.InterfaceAddress != "[2001:db8::1]" {
+		t.Errorf("Addresses not stripped of ports: %q %q", header.HostAddress, header.InterfaceAddress)
+	}
+	if header.AuthenticatedID != "alice" || header.AuthenticatedSender != "alice@example.com" || header.TLSCipher != "TLS1.3:TLS_AES_256_GCM_SHA384:256" {
+		t.Errorf("Unexpected authentication options %+v", header.Options)
+	}
+	if header.BodyLineCount != 12 || !header.DeliverFirstTime {
+		t.Errorf("Unexpected body line count %d or first delivery flag", header.BodyLineCount)
+	}
+
+	if !header.Frozen || !header.FrozenAt.Equal(time.Unix(1705316400, 0)) || header.ManualThaw {
+		t.Errorf("Unexpected freeze state: frozen %v at %s, manual thaw %v", header.Frozen, header.FrozenAt, header.ManualThaw)
+	}
+
+	wantACL := map[string]string{
+		"acl_c_spam": "yes",
+		"acl_m_note": "line1\nline2",
+		"acl_m1":     "42",
+		"acl_c2":     "",
+	}
+	if len(header.ACLVariables) != len(wantACL) {
+		t.Errorf("Unexpected ACL variables %q", header.ACLVariables)
+	}
+	for name, want := range wantACL {
+		if got, ok := header.ACLVariables[name]; !ok || got != want {
+			t.Errorf("ACL variable %s = %q, want %q", name, got, want)
+		}
+	}
+
+	if got := strings.Join(header.NonRecipients, " "); got != "b@example.com a@example.com c@example.com" {
+		t.Errorf("Non-recipients read as %q", got)
+	}
+
+	wantRecipients := []SpoolRecipient{
+		{Address: "a@example.com", Delivered: true},
+		{Address: "B@Example.com", Delivered: true},
+		{Address: "pending@example.org", ErrorsTo: "bounces@example.com"},
+		{Address: "other@example.net"},
+	}
+	if len(header.Recipients) != len(wantRecipients) {
+		t.Fatalf("Parsed %d recipients, want %d", len(header.Recipients), len(wantRecipients))
+	}
+	for i, want := range wantRecipients {
+		if header.Recipients[i] != want {
+			t.Errorf("Recipient %d = %+v, want %+v", i, header.Recipients[i], want)
+		}
+	}
+	if got := strings.Join(header.PendingRecipients(), " "); got != "pending@example.org other@example.net" {
+		t.Errorf("Pending recipients %q", got)
+	}
+
+	if len(header.Headers) != 7 {
+		t.Fatalf("Parsed %d header records, want 7", len(header.Headers))
+	}
+	received := header.Headers[0]
+	if received.Type != 'P' || received.Name != "Received" || received.Value != "from mail.example.net ([192.0.2.10]) by mx.example.com with esmtps" {
+		t.Errorf("Unexpected folded header %+v", received)
+	}
+	if deleted := header.Headers[4]; !deleted.Deleted || deleted.Name != "X-Spam-Flag" {
+		t.Errorf("Expected a deleted header, got %+v", deleted)
+	}
+
+	headers := header.HeaderMap()
+	if headers["Subject"] != "Quarterly report" || headers["X-Note"] != "one\ntwo" || headers["From"] != "Sender <sender@example.com>" {
+		t.Errorf("Unexpected header map %q", headers)
+	}
+	if _, ok := headers["X-Spam-Flag"]; ok {
+		t.Error("Deleted header included in the header map")
+	}
+}
+
+func TestParseSpoolHeaderManualThaw(t *testing.T) {
+	header, err := ParseSpoolHeader(strings.NewReader(thawedSpoolHeader("1rABCE-0002cd-EF")))
+	if err != nil {
+		t.Fatalf("ParseSpoolHeader failed: %v", err)
+	}
+
+	if header.Frozen || !header.ManualThaw {
+		t.Errorf("Expected a thawed message, got frozen %v, manual thaw %v", header.Frozen, header.ManualThaw)
+	}
+	if header.Sender != "" || len(header.NonRecipients) != 0 || len(header.Recipients) != 1 {
+		t.Errorf("Unexpected envelope %+v", header)
+	}
+	if _, ok := header.Options["local"]; !ok {
+		t.Errorf("Flag option not recorded: %q", header.Options)
+	}
+	if header.HeaderSize != int64(len("Subject: Bounce\n")) {
+		t.Errorf("HeaderSize = %d", header.HeaderSize)
+	}
+}
+
+func TestParseSpoolHeaderErrors(t *testing.T) {
+	valid := thawedSpoolHeader("1rABCE-0002cd-EF")
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"bad identification", strings.Replace(valid, "-0002cd-EF-H", "-0002cd-EF", 1)},
+		{"bad time", strings.Replace(valid, "1705320000 0", "yesterday", 1)},
+		{"truncated options", strings.Join(strings.SplitAfter(valid, "\n")[:5], "")},
+		{"bad recipient count", strings.Replace(valid, "XX\n1\n", "XX\none\n", 1)},
+		{"missing recipient", strings.Replace(valid[:strings.Index(valid, "postmaster")], "XX\n1\n", "XX\n2\n", 1) + "postmaster@example.org\n"},
+		{"truncated ACL variable", strings.Replace(valid, "-local\n", "-aclc _x 50\nshort\n", 1)},
+		{"bad tree node", strings.Replace(valid, "XX\n", "Y\n", 1)},
+		{"truncated tree", strings.Replace(valid, "XX\n1\npostmaster@example.org\n\n", "YN a@example.com\n", 1)},
+		{"truncated header record", strings.Replace(valid, "016  Subject", "099  Subject", 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseSpoolHeader(strings.NewReader(tt.input)); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestParseSpoolRecipient(t *testing.T) {
+	tests := []struct {
+		line string
+		want SpoolRecipient
+	}{
+		{"user@example.com", SpoolRecipient{Address: "user@example.com"}},
+		{"user@example.com 0,-1#0", SpoolRecipient{Address: "user@example.com"}},
+		{"user@example.com 0,3#2", SpoolRecipient{Address: "user@example.com"}},
+		{"user@example.com errors@example.org 18,-1#1", SpoolRecipient{Address: "user@example.com", ErrorsTo: "errors@example.org"}},
+		{"user@example.com  0,0  0,-1#3", SpoolRecipient{Address: "user@example.com"}},
+		{"user@example.com  0,14 errors@example.org 18,2#3", SpoolRecipient{Address: "user@example.com", ErrorsTo: "errors@example.org"}},
+		{"user@example.com rfc822;user@example.com 23,10  0,-1#3", SpoolRecipient{Address: "user@example.com"}},
+		{"user@example.com rfc822;user@example.com 23,10 errors@example.org 18,0#3", SpoolRecipient{Address: "user@example.com", ErrorsTo: "errors@example.org"}},
+		{"odd#name@example.com", SpoolRecipient{Address: "odd#name@example.com"}},
+	}
+
+	for _, tt := range tests {
+		if got := parseSpoolRecipient(tt.line); got != tt.want {
+			t.Errorf("parseSpoolRecipient(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseSpoolMessageLog(t *testing.T) {
+	lines, err := parseSpoolMessageLog(strings.NewReader(
+		"2024-01-15 10:00:00 Received from sender@example.com H=mail.example.net [192.0.2.10]\n" +
+			"\n" +
+			"2024-01-15 10:05:00.123 +0000 pending@example.org R=dnslookup T=remote_smtp defer (111): Connection refused\n" +
+			"  continued text\n"))
+	if err != nil {
+		t.Fatalf("parseSpoolMessageLog failed: %v", err)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("Parsed %d lines, want 2", len(lines))
+	}
+	if want := time.Date(2024, 1, 15, 10, 5, 0, 0, time.Local); !lines[1].Timestamp.Equal(want) {
+		t.Errorf("Timestamp = %s, want %s", lines[1].Timestamp, want)
+	}
+	if !strings.HasSuffix(lines[1].Text, "Connection refused continued text") {
+		t.Errorf("Continuation line not joined: %q", lines[1].Text)
+	}
+}
+
+func TestSpoolReaderLayouts(t *testing.T) {
+	spoolDir := t.TempDir()
+	reader := NewSpoolReader(spoolDir)
+	if reader.Available() {
+		t.Fatal("Expected a spool without an input directory to be unavailable")
+	}
+
+	// split_spool_directory keeps files in a subdirectory named after the sixth character
+	split := "1rABCD-0001ab-CD"
+	writeSpoolFile(t, spoolDir, "input/D/"+split+"-H", frozenSpoolHeader(split))
+	writeSpoolFile(t, spoolDir, "input/D/"+split+"-D", split+"-D\nHello world\n")
+	writeSpoolFile(t, spoolDir, "msglog/D/"+split, "2024-01-15 10:00:00 Frozen by ACL\n")
+
+	flat := "1rABCE-0002cd-EF"
+	writeSpoolFile(t, spoolDir, "input/"+flat+"-H", thawedSpoolHeader(flat))
+	writeSpoolFile(t, spoolDir, "input/"+flat+"-D", flat+"-D\nBounce body\n")
+
+	// Files that are not -H files, and longer subdirectories, are not messages
+	writeSpoolFile(t, spoolDir, "input/"+flat+"-J", "")
+	writeSpoolFile(t, spoolDir, "input/hdr.12345", "")
+	writeSpoolFile(t, spoolDir, "input/old/1rABCF-0003ef-GH-H", "")
+
+	if !reader.Available() {
+		t.Fatal("Expected the spool to be available")
+	}
+
+	ids, err := reader.ListMessageIDs()
+	if err != nil {
+		t.Fatalf("ListMessageIDs failed: %v", err)
+	}
+	if strings.Join(ids, " ") != split+" "+flat {
+		t.Errorf("ListMessageIDs() = %v", ids)
+	}
+
+	path, err := reader.findSpoolFile("input", split+"-H")
+	if err != nil || path != filepath.Join(spoolDir, "input", split[5:6], split+"-H") {
+		t.Errorf("findSpoolFile() = %q, %v", path, err)
+	}
+	if _, err := reader.findSpoolFile("input", "../../etc/passwd"); err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected an invalid message ID error, got %v", err)
+	}
+	if _, err := reader.findSpoolFile("input", "1rABCZ-9999zz-ZZ-H"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist for a missing message, got %v", err)
+	}
+
+	header, err := reader.ReadHeader(split)
+	if err != nil || !header.Frozen {
+		t.Fatalf("ReadHeader(%s) = %+v, %v", split, header, err)
+	}
+	if _, err := reader.ReadHeader(flat); err != nil {
+		t.Errorf("ReadHeader(%s) failed: %v", flat, err)
+	}
+
+	size, err := reader.BodySize(split)
+	if err != nil || size != int64(len("Hello world\n")) {
+		t.Errorf("BodySize() = %d, %v", size, err)
+	}
+	body, total, truncated, err := reader.ReadBody(split, 5)
+	if err != nil || string(body) != "Hello" || total != size || !truncated {
+		t.Errorf("ReadBody() = %q, %d, %v, %v", body, total, truncated, err)
+	}
+
+	raw, _, _, err := reader.ReadRawMessage(split, 0)
+	if err != nil {
+		t.Fatalf("ReadRawMessage failed: %v", err)
+	}
+	if !strings.Contains(string(raw), "Subject: Quarterly report\n") || strings.Contains(string(raw), "X-Spam-Flag") ||
+		!strings.HasSuffix(string(raw), "\n\nHello world\n") {
+		t.Errorf("Unexpected raw message %q", raw)
+	}
+
+	msglog, err := reader.ReadMessageLog(split)
+	if err != nil || len(msglog) != 1 {
+		t.Errorf("ReadMessageLog() = %+v, %v", msglog, err)
+	}
+	if msglog, err := reader.ReadMessageLog(flat); err != nil || msglog != nil {
+		t.Errorf("Expected no msglog for %s, got %+v, %v", flat, msglog, err)
+	}
+
+	// A -H file stored under another message's name is rejected
+	writeSpoolFile(t, spoolDir, "input/1rABCG-0004gh-IJ-H", thawedSpoolHeader(flat))
+	if _, err := reader.ReadHeader("1rABCG-0004gh-IJ"); err == nil {
+		t.Error("Expected a mismatched header file to be rejected")
+	}
+}
+
+func TestListQueueFromSpool(t *testing.T) {
+	spoolDir := t.TempDir()
+
+	deferred := "1rABCD-0001ab-CD"
+	writeSpoolFile(t, spoolDir, "input/"+deferred+"-H", strings.Replace(frozenSpoolHeader(deferred), "-frozen 1705316400\n", "", 1))
+	writeSpoolFile(t, spoolDir, "input/"+deferred+"-D", deferred+"-D\nHello world\n")
+	writeSpoolFile(t, spoolDir, "msglog/"+deferred,
+		"2024-01-15 10:00:00 Received from sender@example.com H=mail.example.net [192.0.2.10]\n"+
+			"2024-01-15 10:05:00 == pending@example.org R=dnslookup T=remote_smtp defer (111): Connection refused H=mx.example.org [192.0.2.20]\n")
+
+	frozen := "1rABCE-0002cd-EF"
+	writeSpoolFile(t, spoolDir, "input/"+frozen+"-H", frozenSpoolHeader(frozen))
+	writeSpoolFile(t, spoolDir, "input/"+frozen+"-D", frozen+"-D\n")
+
+	now := time.Now().Truncate(time.Second)
+	createHintsDB(t, spoolDir, "retry", map[string][]byte{
+		"T:mx.example.org:192.0.2.20": encodeRetryRecord(now, now.Add(30*time.Minute), 111, "Connection refused"),
+		"R:example.org":               encodeRetryRecord(now, now.Add(time.Hour), -44, "host lookup did not complete"),
+		"R:example.net":               encodeRetryRecord(now, now.Add(-time.Minute), -44, "retry time passed"),
+	})
+
+	manager := NewManager("/usr/sbin/exim4", nil)
+	manager.SetSpoolDir(spoolDir)
+
+	status, err := manager.listQueueFromSpool()
+	if err != nil {
+		t.Fatalf("listQueueFromSpool failed: %v", err)
+	}
+	if status.TotalMessages != 2 || status.DeferredMessages != 1 || status.FrozenMessages != 1 {
+		t.Fatalf("Unexpected queue counts %+v", status)
+	}
+
+	msg := status.Messages[0]
+	if msg.ID != deferred || msg.Status != "deferred" || msg.RetryCount != 1 || msg.Sender != "sender@example.com" {
+		t.Errorf("Unexpected deferred message %+v", msg)
+	}
+	if !msg.NextRetry.Equal(now.Add(30 * time.Minute)) {
+		t.Errorf("NextRetry = %s, want the host retry time %s", msg.NextRetry, now.Add(30*time.Minute))
+	}
+
+	// Only the domain record applies to a message that was never tried
+	if msg := status.Messages[1]; msg.Status != "frozen" || !msg.NextRetry.Equal(now.Add(time.Hour)) {
+		t.Errorf("Unexpected frozen message %s, next retry %s", msg.Status, msg.NextRetry)
+	}
+
+	details, err := manager.inspectMessageFromSpool(deferred)
+	if err != nil {
+		t.Fatalf("inspectMessageFromSpool failed: %v", err)
+	}
+	if !details.NextRetry.Equal(msg.NextRetry) || details.Envelope.HostAddress != "192.0.2.10" || len(details.DeliveryAttempts) != 1 {
+		t.Errorf("Unexpected message details %+v", details)
+	}
+}
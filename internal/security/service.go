@@ -46,6 +46,18 @@ func NewService() *Service {
 	}
 }
 
+// AllowReadOnlyPath permits read-only access below path, such as a configured log directory
+// outside the Debian defaults. Restricted paths stay restricted.
+func (s *Service) AllowReadOnlyPath(path string) {
+	cleanPath := filepath.Clean(path)
+	if resolved, err := filepath.EvalSymlinks(cleanPath); err == nil {
+		cleanPath = resolved
+	}
+
+	s.allowedPaths = append(s.allowedPaths, cleanPath)
+	s.readOnlyPaths = append(s.readOnlyPaths, cleanPath)
+}
+
 // FileAccessType represents the type of file access requested
 type FileAccessType int
 
@@ -340,6 +352,12 @@ func (s *Service) ValidateSystemCommand(command string, args []string) error {
 		"exim4":           true,
 		"/usr/sbin/exim":  true,
 		"/usr/sbin/exim4": true,
+
+		// Hints database utilities
+		"exim_dumpdb":           true,
+		"exim_fixdb":            true,
+		"/usr/sbin/exim_dumpdb": true,
+		"/usr/sbin/exim_fixdb":  true,
 	}
 
 	if !allowedCommands[command] {
@@ -39,8 +39,10 @@ func (c *Client) readPump() {
 // writePump pumps messages from the hub to the websocket connection
 func (c *Client) writePump() {
 	ticker := time.NewTicker(pingPeriod)
+	revalidateTicker := time.NewTicker(revalidatePeriod)
 	defer func() {
 		ticker.Stop()
+		revalidateTicker.Stop()
 		c.conn.Close()
 	}()
 
@@ -54,20 +56,8 @@ func (c *Client) writePump() {
 				return
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			// Add queued chat messages to the current websocket message
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.send)
-			}
-
-			if err := w.Close(); err != nil {
+			// One JSON message per frame, which is what clients parse
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
 
@@ -76,7 +66,33 @@ func (c *Client) writePump() {
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+
+		case <-revalidateTicker.C:
+			if err := c.authorizer.Revalidate(); err != nil {
+				// Closing the connection ends readPump, which unregisters the client
+				log.Printf("Closing WebSocket connection: %v", err)
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				c.conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "authentication expired"))
+				return
+			}
+			c.dropUnauthorizedSubscriptions()
+		}
+	}
+}
+
+// dropUnauthorizedSubscriptions ends subscriptions the client lost access to, e.g.
+// after its user's role changed
+func (c *Client) dropUnauthorizedSubscriptions() {
+	for _, endpoint := range c.GetSubscriptions() {
+		if c.authorizer.Authorize(endpoint) {
+			continue
 		}
+		c.hub.Unsubscribe(c, endpoint)
+		c.sendResponse("unsubscribed", map[string]interface{}{
+			"endpoint": endpoint,
+			"status":   "forbidden",
+		})
 	}
 }
 
@@ -91,6 +107,35 @@ func (c *Client) handleMessage(data []byte) {
 	switch msg.Type {
 	case "subscribe":
 		if msg.Endpoint != "" {
+			if !c.authorizer.Authorize(msg.Endpoint) {
+				c.sendResponse("subscribed", map[string]interface{}{
+					"endpoint": msg.Endpoint,
+					"status":   "forbidden",
+					"error":    "not permitted to subscribe to this endpoint",
+				})
+				return
+			}
+			if msg.Endpoint == LogTailEndpoint {
+				matcher, err := compileLogFilter(msg.Filter)
+				if err != nil {
+					c.sendResponse("subscribed", map[string]interface{}{
+						"endpoint": msg.Endpoint,
+						"status":   "error",
+						"error":    err.Error(),
+					})
+					return
+				}
+				c.hub.subscribeLogTail(c, matcher, msg.LastEventID)
+				return
+			}
+			if msg.Filter != nil || msg.LastEventID != nil {
+				c.sendResponse("subscribed", map[string]interface{}{
+					"endpoint": msg.Endpoint,
+					"status":   "error",
+					"error":    "filters and resuming are only supported on " + LogTailEndpoint,
+				})
+				return
+			}
 			c.hub.Subscribe(c, msg.Endpoint)
 			c.sendResponse("subscribed", map[string]interface{}{
 				"endpoint": msg.Endpoint,
@@ -119,17 +164,27 @@ func (c *Client) handleMessage(data []byte) {
 
 // sendResponse sends a response message to the client
 func (c *Client) sendResponse(messageType string, data interface{}) {
-	response := Message{
+	c.sendMessage(Message{
 		Type: messageType,
 		Data: data,
-	}
+	})
+}
 
-	jsonData, err := json.Marshal(response)
+// sendMessage queues a message for the client without blocking
+func (c *Client) sendMessage(message Message) {
+	jsonData, err := json.Marshal(message)
 	if err != nil {
 		log.Printf("Error marshaling response: %v", err)
 		return
 	}
 
+	// The hub closes the channel of clients it dropped, under its lock
+	c.hub.mu.RLock()
+	defer c.hub.mu.RUnlock()
+	if c.removed {
+		return
+	}
+
 	select {
 	case c.send <- jsonData:
 	default:
@@ -0,0 +1,62 @@
+package websocket
+
+import (
+	"errors"
+)
+
+// ErrNotAuthorized is returned when a feed subscribes to an endpoint its authorizer refuses
+var ErrNotAuthorized = errors.New("not authorized to subscribe to this endpoint")
+
+// Feed receives hub messages without a WebSocket connection, for Server-Sent Events. It
+// gets the broadcasts its authorizer allows and the updates of the endpoints it
+// subscribes to, as the same JSON messages WebSocket clients receive.
+type Feed struct {
+	client *Client
+}
+
+// OpenFeed registers a feed with the hub. Close must be called once the reader is gone.
+func (h *Hub) OpenFeed(authorizer Authorizer) *Feed {
+	client := &Client{
+		hub:           h,
+		send:          make(chan []byte, 256),
+		authorizer:    authorizer,
+		subscriptions: make(map[string]bool),
+	}
+	h.register <- client
+	return &Feed{client: client}
+}
+
+// Messages returns the channel of JSON encoded messages. The hub closes it when the
+// reader does not keep up.
+func (f *Feed) Messages() <-chan []byte {
+	return f.client.send
+}
+
+// Subscribe subscribes the feed to the updates of an endpoint
+func (f *Feed) Subscribe(endpoint string) error {
+	if !f.client.authorizer.Authorize(endpoint) {
+		return ErrNotAuthorized
+	}
+	f.client.hub.Subscribe(f.client, endpoint)
+	return nil
+}
+
+// SubscribeLogTail subscribes the feed to the log entries matching filter. It first
+// receives a "subscribed" message and, with lastEventID, a "replay" message, exactly
+// like a WebSocket client.
+func (f *Feed) SubscribeLogTail(filter *LogFilter, lastEventID *uint64) error {
+	if !f.client.authorizer.Authorize(LogTailEndpoint) {
+		return ErrNotAuthorized
+	}
+	matcher, err := compileLogFilter(filter)
+	if err != nil {
+		return err
+	}
+	f.client.hub.subscribeLogTail(f.client, matcher, lastEventID)
+	return nil
+}
+
+// Close unregisters the feed
+func (f *Feed) Close() {
+	f.client.hub.unregister <- f.client
+}
@@ -0,0 +1,152 @@
+package websocket
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// maxFilterPatternLength limits globs and regular expressions sent by clients
+const maxFilterPatternLength = 256
+
+// LogFilter selects the log tail entries sent to a subscriber. Every set field must
+// match; an empty filter matches all entries.
+type LogFilter struct {
+	LogTypes  []string `json:"log_types,omitempty"`
+	Events    []string `json:"events,omitempty"`
+	MessageID string   `json:"message_id,omitempty"`
+
+	// Sender and Recipient are case-insensitive globs such as "*@example.com". Recipient
+	// matches when any recipient of the entry matches.
+	Sender    string `json:"sender,omitempty"`
+	Recipient string `json:"recipient,omitempty"`
+
+	// Host is a case-insensitive glob matched against the remote host
+	Host string `json:"host,omitempty"`
+
+	// PID limits entries to one Exim process, for logs written with +pid or via syslog
+	PID *int64 `json:"pid,omitempty"`
+
+	// Keywords must all appear in the raw line, ignoring case
+	Keywords []string `json:"keywords,omitempty"`
+
+	// Regex is an RE2 expression matched against the raw line
+	Regex string `json:"regex,omitempty"`
+}
+
+// logMatcher is a validated LogFilter ready to match entries
+type logMatcher struct {
+	filter   LogFilter
+	keywords []string
+	regex    *regexp.Regexp
+}
+
+// compileLogFilter validates a filter. A nil filter matches everything.
+func compileLogFilter(filter *LogFilter) (*logMatcher, error) {
+	if filter == nil {
+		return nil, nil
+	}
+
+	m := &logMatcher{filter: *filter}
+
+	globs := map[string]string{"sender": filter.Sender, "recipient": filter.Recipient, "host": filter.Host}
+	for field, pattern := range globs {
+		if len(pattern) > maxFilterPatternLength {
+			return nil, fmt.Errorf("%s pattern is too long", field)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid %s pattern: %w", field, err)
+		}
+	}
+
+	for _, keyword := range filter.Keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			m.keywords = append(m.keywords, strings.ToLower(keyword))
+		}
+	}
+
+	if filter.Regex != "" {
+		if len(filter.Regex) > maxFilterPatternLength {
+			return nil, fmt.Errorf("regex is too long")
+		}
+		regex, err := regexp.Compile(filter.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+		m.regex = regex
+	}
+
+	return m, nil
+}
+
+// Match reports whether the entry passes the filter. A nil matcher matches everything.
+func (m *logMatcher) Match(entry *database.LogEntry) bool {
+	if m == nil {
+		return true
+	}
+	f := &m.filter
+
+	if len(f.LogTypes) > 0 && !contains(f.LogTypes, entry.LogType) {
+		return false
+	}
+	if len(f.Events) > 0 && !contains(f.Events, entry.Event) {
+		return false
+	}
+	if f.MessageID != "" && (entry.MessageID == nil || *entry.MessageID != f.MessageID) {
+		return false
+	}
+	if f.Sender != "" && (entry.Sender == nil || !globMatch(f.Sender, *entry.Sender)) {
+		return false
+	}
+	if f.Recipient != "" && !anyGlobMatch(f.Recipient, entry.Recipients) {
+		return false
+	}
+	if f.Host != "" && (entry.Host == nil || !globMatch(f.Host, *entry.Host)) {
+		return false
+	}
+	if f.PID != nil && (entry.PID == nil || *entry.PID != *f.PID) {
+		return false
+	}
+
+	if len(m.keywords) > 0 {
+		line := strings.ToLower(entry.RawLine)
+		for _, keyword := range m.keywords {
+			if !strings.Contains(line, keyword) {
+				return false
+			}
+		}
+	}
+	if m.regex != nil && !m.regex.MatchString(entry.RawLine) {
+		return false
+	}
+
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch matches a case-insensitive shell pattern. Addresses and host names contain
+// no slashes, so path.Match behaves like a plain glob.
+func globMatch(pattern, value string) bool {
+	matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+	return matched
+}
+
+func anyGlobMatch(pattern string, values []string) bool {
+	for _, value := range values {
+		if globMatch(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
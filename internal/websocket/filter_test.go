@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+func TestLogFilterMatch(t *testing.T) {
+	stringPtr := func(s string) *string { return &s }
+	pid := int64(4242)
+	otherPID := int64(1)
+
+	entry := &database.LogEntry{
+		LogType:    database.LogTypeMain,
+		Event:      database.EventDelivery,
+		MessageID:  stringPtr("1rABCD-123456-78"),
+		Sender:     stringPtr("Alice@Example.com"),
+		Recipients: []string{"bob@example.net", "carol@example.org"},
+		Host:       stringPtr("mx.example.net"),
+		PID:        &pid,
+		RawLine:    "2024-01-15 10:00:00 [4242] 1rABCD-123456-78 => bob@example.net R=dnslookup T=remote_smtp H=mx.example.net",
+	}
+
+	tests := []struct {
+		name   string
+		filter *LogFilter
+		want   bool
+	}{
+		{"no filter", nil, true},
+		{"empty filter", &LogFilter{}, true},
+		{"log type", &LogFilter{LogTypes: []string{database.LogTypeReject, database.LogTypeMain}}, true},
+		{"other log type", &LogFilter{LogTypes: []string{database.LogTypeReject}}, false},
+		{"event", &LogFilter{Events: []string{database.EventDelivery}}, true},
+		{"other event", &LogFilter{Events: []string{database.EventDefer}}, false},
+		{"message id", &LogFilter{MessageID: "1rABCD-123456-78"}, true},
+		{"sender glob ignores case", &LogFilter{Sender: "*@example.COM"}, true},
+		{"other sender", &LogFilter{Sender: "*@example.net"}, false},
+		{"any recipient", &LogFilter{Recipient: "carol@*"}, true},
+		{"no recipient", &LogFilter{Recipient: "dave@*"}, false},
+		{"host", &LogFilter{Host: "mx.*.net"}, true},
+		{"pid", &LogFilter{PID: &pid}, true},
+		{"other pid", &LogFilter{PID: &otherPID}, false},
+		{"keywords", &LogFilter{Keywords: []string{"REMOTE_SMTP", "dnslookup"}}, true},
+		{"missing keyword", &LogFilter{Keywords: []string{"remote_smtp", "defer"}}, false},
+		{"regex", &LogFilter{Regex: `T=remote_\w+`}, true},
+		{"combined", &LogFilter{Events: []string{database.EventDelivery}, Regex: `^nothing`}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher, err := compileLogFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("compileLogFilter failed: %v", err)
+			}
+			if got := matcher.Match(entry); got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileLogFilterRejectsInvalidPatterns(t *testing.T) {
+	for _, filter := range []*LogFilter{
+		{Sender: "[a-"},
+		{Host: "["},
+		{Regex: "(unclosed"},
+	} {
+		if _, err := compileLogFilter(filter); err == nil {
+			t.Errorf("Expected %+v to be rejected", filter)
+		}
+	}
+}
+
+func TestLogStreamSince(t *testing.T) {
+	stream := newLogStream(3)
+	if events, gap := stream.since(0); len(events) != 0 || gap {
+		t.Fatalf("Expected nothing from an empty stream, got %v, gap %v", events, gap)
+	}
+
+	for i := 0; i < 5; i++ {
+		stream.append(&database.LogEntry{})
+	}
+
+	ids := func(events []streamEvent) []uint64 {
+		var result []uint64
+		for _, event := range events {
+			result = append(result, event.ID)
+		}
+		return result
+	}
+
+	tests := []struct {
+		lastID uint64
+		want   []uint64
+		gap    bool
+	}{
+		{4, []uint64{5}, false},
+		{2, []uint64{3, 4, 5}, false},
+		{5, nil, false},
+		// Event 2 was overwritten
+		{1, []uint64{3, 4, 5}, true},
+		// An ID from before a restart
+		{9, []uint64{3, 4, 5}, true},
+	}
+
+	for _, tt := range tests {
+		events, gap := stream.since(tt.lastID)
+		if got := ids(events); len(got) != len(tt.want) || (len(got) > 0 && (got[0] != tt.want[0] || got[len(got)-1] != tt.want[len(tt.want)-1])) {
+			t.Errorf("since(%d) = %v, want %v", tt.lastID, got, tt.want)
+		}
+		if gap != tt.gap {
+			t.Errorf("since(%d) gap = %v, want %v", tt.lastID, gap, tt.gap)
+		}
+	}
+}
@@ -7,16 +7,17 @@ import (
 	"sync"
 	"time"
 
+	"github.com/andreitelteu/exim-pilot/internal/database"
 	"github.com/gorilla/websocket"
 )
 
 // Hub maintains the set of active clients and broadcasts messages to the clients
 type Hub struct {
-	// Registered clients
+	// Registered clients. Only Run changes it, holding mu, so Run reads it without the lock.
 	clients map[*Client]bool
 
-	// Inbound messages from the clients
-	broadcast chan []byte
+	// Messages for every client allowed to receive their type
+	broadcast chan broadcastMessage
 
 	// Register requests from the clients
 	register chan *Client
@@ -24,9 +25,16 @@ type Hub struct {
 	// Unregister requests from clients
 	unregister chan *Client
 
-	// Subscription management
-	subscriptions map[string]map[*Client]bool
+	// Subscription management. Log tail subscriptions carry their filter, other
+	// subscriptions a nil matcher.
+	subscriptions map[string]map[*Client]*logMatcher
 	mu            sync.RWMutex
+
+	// Numbered recent log tail events. tailMu is held while an event is numbered and sent,
+	// and while a client subscribes and is sent the events it missed, so every client
+	// sees the events in order and none twice. It is taken before mu.
+	logTail *logStream
+	tailMu  sync.Mutex
 }
 
 // Client is a middleman between the websocket connection and the hub
@@ -39,9 +47,35 @@ type Client struct {
 	// Buffered channel of outbound messages
 	send chan []byte
 
+	// Decides what the client may receive and whether its credentials are still valid
+	authorizer Authorizer
+
 	// Client subscriptions
 	subscriptions map[string]bool
 	mu            sync.RWMutex
+
+	// Set once the hub dropped the client, guarded by the hub's mu
+	removed bool
+}
+
+// Authorizer is the identity behind a connection. The API server creates one for each
+// connection after authenticating the handshake.
+type Authorizer interface {
+	// Authorize reports whether the connection may subscribe to an endpoint or receive
+	// broadcasts of a message type
+	Authorize(topic string) bool
+
+	// Revalidate checks that the session or API token used for the handshake is still
+	// valid and picks up changes to the user's permissions. Connections are closed when
+	// it fails.
+	Revalidate() error
+}
+
+// broadcastMessage is a message for all clients together with its type, which clients
+// must be authorized for
+type broadcastMessage struct {
+	topic string
+	data  []byte
 }
 
 // Message represents a WebSocket message
@@ -49,6 +83,14 @@ type Message struct {
 	Type     string      `json:"type"`
 	Data     interface{} `json:"data,omitempty"`
 	Endpoint string      `json:"endpoint,omitempty"`
+
+	// ID numbers log tail events
+	ID uint64 `json:"id,omitempty"`
+
+	// Sent by clients subscribing to the log tail: the entries to receive and the ID of
+	// the last event seen before reconnecting
+	Filter      *LogFilter `json:"filter,omitempty"`
+	LastEventID *uint64    `json:"last_event_id,omitempty"`
 }
 
 const (
@@ -61,14 +103,16 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer
-	maxMessageSize = 512
+	// Maximum message size allowed from peer, enough for a subscribe message with a filter
+	maxMessageSize = 4096
 )
 
+// How often the credentials of a connection are checked again
+var revalidatePeriod = time.Minute
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
-		// Allow connections from any origin in development
-		// In production, you should check the origin properly
+		// ServeWS callers check the origin against the allowed origins before upgrading
 		return true
 	},
 }
@@ -76,11 +120,12 @@ var upgrader = websocket.Upgrader{
 // NewHub creates a new WebSocket hub
 func NewHub() *Hub {
 	return &Hub{
-		broadcast:     make(chan []byte),
+		broadcast:     make(chan broadcastMessage, 256),
 		register:      make(chan *Client),
 		unregister:    make(chan *Client),
 		clients:       make(map[*Client]bool),
-		subscriptions: make(map[string]map[*Client]bool),
+		subscriptions: make(map[string]map[*Client]*logMatcher),
+		logTail:       newLogStream(logTailBufferSize),
 	}
 }
 
@@ -89,42 +134,54 @@ func (h *Hub) Run() {
 	for {
 		select {
 		case client := <-h.register:
+			h.mu.Lock()
 			h.clients[client] = true
+			h.mu.Unlock()
 			log.Printf("WebSocket client connected. Total clients: %d", len(h.clients))
 
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-
-				// Remove client from all subscriptions
-				h.mu.Lock()
-				for endpoint, subscribers := range h.subscriptions {
-					delete(subscribers, client)
-					if len(subscribers) == 0 {
-						delete(h.subscriptions, endpoint)
-					}
-				}
-				h.mu.Unlock()
-
+				h.removeClient(client)
 				log.Printf("WebSocket client disconnected. Total clients: %d", len(h.clients))
 			}
 
 		case message := <-h.broadcast:
 			for client := range h.clients {
+				if !client.authorizer.Authorize(message.topic) {
+					continue
+				}
 				select {
-				case client.send <- message:
+				case client.send <- message.data:
 				default:
-					close(client.send)
-					delete(h.clients, client)
+					h.removeClient(client)
 				}
 			}
 		}
 	}
 }
 
-// ServeWS handles websocket requests from the peer
-func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
+// removeClient drops a client and its subscriptions and closes its send channel. It must
+// be called from Run.
+func (h *Hub) removeClient(client *Client) {
+	// Remove the subscriptions first so BroadcastToSubscribers cannot send on the closed channel
+	h.mu.Lock()
+	delete(h.clients, client)
+	for endpoint, subscribers := range h.subscriptions {
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(h.subscriptions, endpoint)
+		}
+	}
+	client.removed = true
+	h.mu.Unlock()
+
+	close(client.send)
+}
+
+// ServeWS upgrades an authenticated request to a WebSocket connection. The caller must
+// have authenticated the request and checked its origin; authorizer decides what the
+// connection may receive from then on.
+func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request, authorizer Authorizer) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
@@ -135,6 +192,7 @@ func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
 		hub:           h,
 		conn:          conn,
 		send:          make(chan []byte, 256),
+		authorizer:    authorizer,
 		subscriptions: make(map[string]bool),
 	}
 
@@ -159,7 +217,7 @@ func (h *Hub) BroadcastToAll(messageType string, data interface{}) {
 	}
 
 	select {
-	case h.broadcast <- jsonData:
+	case h.broadcast <- broadcastMessage{topic: messageType, data: jsonData}:
 	default:
 		log.Printf("Broadcast channel full, dropping message")
 	}
@@ -167,20 +225,6 @@ func (h *Hub) BroadcastToAll(messageType string, data interface{}) {
 
 // BroadcastToSubscribers sends a message to clients subscribed to a specific endpoint
 func (h *Hub) BroadcastToSubscribers(endpoint string, data interface{}) {
-	h.mu.RLock()
-	subscribers, exists := h.subscriptions[endpoint]
-	if !exists || len(subscribers) == 0 {
-		h.mu.RUnlock()
-		return
-	}
-
-	// Create a copy of subscribers to avoid holding the lock during broadcast
-	clientList := make([]*Client, 0, len(subscribers))
-	for client := range subscribers {
-		clientList = append(clientList, client)
-	}
-	h.mu.RUnlock()
-
 	message := Message{
 		Type:     "subscription_update",
 		Data:     data,
@@ -193,7 +237,12 @@ func (h *Hub) BroadcastToSubscribers(endpoint string, data interface{}) {
 		return
 	}
 
-	for _, client := range clientList {
+	// The read lock is held while sending so Run cannot close a subscriber's channel
+	// meanwhile; sends never block
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.subscriptions[endpoint] {
 		select {
 		case client.send <- jsonData:
 		default:
@@ -203,15 +252,99 @@ func (h *Hub) BroadcastToSubscribers(endpoint string, data interface{}) {
 	}
 }
 
+// BroadcastLogEntry numbers a new log entry and sends it to the log tail subscribers
+// whose filter matches it
+func (h *Hub) BroadcastLogEntry(entry *database.LogEntry) {
+	h.tailMu.Lock()
+	defer h.tailMu.Unlock()
+
+	id := h.logTail.append(entry)
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	subscribers := h.subscriptions[LogTailEndpoint]
+	if len(subscribers) == 0 {
+		return
+	}
+
+	jsonData, err := json.Marshal(Message{
+		Type:     "subscription_update",
+		Data:     entry,
+		Endpoint: LogTailEndpoint,
+		ID:       id,
+	})
+	if err != nil {
+		log.Printf("Error marshaling log tail message: %v", err)
+		return
+	}
+
+	for client, matcher := range subscribers {
+		if !matcher.Match(entry) {
+			continue
+		}
+		select {
+		case client.send <- jsonData:
+		default:
+			log.Printf("Client send channel full, skipping message")
+		}
+	}
+}
+
+// subscribeLogTail subscribes a client to the log tail with a filter, replacing an
+// earlier subscription. With lastEventID the matching events after it that are still
+// buffered are sent in a single "replay" message before any new event.
+func (h *Hub) subscribeLogTail(client *Client, matcher *logMatcher, lastEventID *uint64) {
+	h.tailMu.Lock()
+	defer h.tailMu.Unlock()
+
+	h.subscribe(client, LogTailEndpoint, matcher)
+
+	response := map[string]interface{}{
+		"endpoint":      LogTailEndpoint,
+		"status":        "success",
+		"last_event_id": h.logTail.lastID,
+	}
+	if lastEventID == nil {
+		client.sendResponse("subscribed", response)
+		return
+	}
+
+	events, gap := h.logTail.since(*lastEventID)
+	replay := make([]streamEvent, 0, len(events))
+	for _, event := range events {
+		if matcher.Match(event.Entry) {
+			replay = append(replay, event)
+		}
+	}
+
+	// A gap means some events were missed for good; clients reload them from the logs API
+	response["gap"] = gap
+	response["replayed"] = len(replay)
+	client.sendResponse("subscribed", response)
+
+	if len(replay) > 0 {
+		client.sendMessage(Message{Type: "replay", Data: replay, Endpoint: LogTailEndpoint})
+	}
+}
+
 // Subscribe adds a client to an endpoint subscription
 func (h *Hub) Subscribe(client *Client, endpoint string) {
+	h.subscribe(client, endpoint, nil)
+}
+
+func (h *Hub) subscribe(client *Client, endpoint string, matcher *logMatcher) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	if client.removed {
+		return
+	}
+
 	if h.subscriptions[endpoint] == nil {
-		h.subscriptions[endpoint] = make(map[*Client]bool)
+		h.subscriptions[endpoint] = make(map[*Client]*logMatcher)
 	}
-	h.subscriptions[endpoint][client] = true
+	h.subscriptions[endpoint][client] = matcher
 
 	client.mu.Lock()
 	client.subscriptions[endpoint] = true
@@ -252,5 +385,8 @@ func (h *Hub) GetSubscriberCount(endpoint string) int {
 
 // GetClientCount returns the total number of connected clients
 func (h *Hub) GetClientCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
 	return len(h.clients)
 }
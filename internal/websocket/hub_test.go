@@ -0,0 +1,204 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+	"github.com/gorilla/websocket"
+)
+
+// testAuthorizer allows a fixed set of topics until it is revoked
+type testAuthorizer struct {
+	mu      sync.Mutex
+	allowed map[string]bool
+	revoked bool
+}
+
+func (a *testAuthorizer) Authorize(topic string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.allowed[topic]
+}
+
+func (a *testAuthorizer) Revalidate() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.revoked {
+		return errors.New("session revoked")
+	}
+	return nil
+}
+
+func (a *testAuthorizer) revoke() {
+	a.mu.Lock()
+	a.revoked = true
+	a.mu.Unlock()
+}
+
+// dial connects a client to the hub that is served with authorizer
+func dial(t *testing.T, hub *Hub, authorizer Authorizer) *websocket.Conn {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hub.ServeWS(w, r, authorizer)
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readMessage reads the next message, failing the test after a second
+func readMessage(t *testing.T, conn *websocket.Conn) Message {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("Failed to decode %s: %v", data, err)
+	}
+	return msg
+}
+
+func subscribe(t *testing.T, conn *websocket.Conn, endpoint string) Message {
+	t.Helper()
+
+	if err := conn.WriteJSON(Message{Type: "subscribe", Endpoint: endpoint}); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	return readMessage(t, conn)
+}
+
+func TestHubAuthorizesSubscriptionsAndBroadcasts(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	conn := dial(t, hub, &testAuthorizer{allowed: map[string]bool{
+		LogTailEndpoint:    true,
+		SystemAlertMessage: true,
+	}})
+
+	denied := subscribe(t, conn, MessageUpdatesPrefix+"1rABCD-123456-78"+MessageUpdatesSuffix)
+	if status := denied.Data.(map[string]interface{})["status"]; denied.Type != "subscribed" || status != "forbidden" {
+		t.Fatalf("Expected the subscription to be refused, got %+v", denied)
+	}
+
+	accepted := subscribe(t, conn, LogTailEndpoint)
+	if status := accepted.Data.(map[string]interface{})["status"]; status != "success" {
+		t.Fatalf("Expected the subscription to succeed, got %+v", accepted)
+	}
+	if count := hub.GetSubscriberCount(MessageUpdatesPrefix + "1rABCD-123456-78" + MessageUpdatesSuffix); count != 0 {
+		t.Errorf("Expected no subscribers to the refused endpoint, got %d", count)
+	}
+
+	// Broadcasts the client may not see are skipped
+	hub.BroadcastToAll(QueueUpdateMessage, "queue")
+	hub.BroadcastToAll(SystemAlertMessage, "alert")
+	if msg := readMessage(t, conn); msg.Type != SystemAlertMessage {
+		t.Errorf("Expected only the system alert, got %+v", msg)
+	}
+
+	hub.BroadcastToSubscribers(LogTailEndpoint, "entry")
+	if msg := readMessage(t, conn); msg.Type != "subscription_update" || msg.Endpoint != LogTailEndpoint {
+		t.Errorf("Expected a log tail update, got %+v", msg)
+	}
+}
+
+func TestHubClosesRevokedConnections(t *testing.T) {
+	previous := revalidatePeriod
+	revalidatePeriod = 50 * time.Millisecond
+	t.Cleanup(func() { revalidatePeriod = previous })
+
+	hub := NewHub()
+	go hub.Run()
+
+	authorizer := &testAuthorizer{allowed: map[string]bool{LogTailEndpoint: true}}
+	conn := dial(t, hub, authorizer)
+	subscribe(t, conn, LogTailEndpoint)
+
+	authorizer.revoke()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Fatalf("Expected the connection to be closed, got %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for hub.GetSubscriberCount(LogTailEndpoint) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the closed connection to be unsubscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHubFiltersAndResumesLogTail(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	authorizer := &testAuthorizer{allowed: map[string]bool{LogTailEndpoint: true}}
+	rejects := &LogFilter{LogTypes: []string{database.LogTypeReject}}
+
+	conn := dial(t, hub, authorizer)
+	if err := conn.WriteJSON(Message{Type: "subscribe", Endpoint: LogTailEndpoint, Filter: rejects}); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	readMessage(t, conn)
+
+	hub.BroadcastLogEntry(&database.LogEntry{LogType: database.LogTypeMain, RawLine: "main 1"})
+	hub.BroadcastLogEntry(&database.LogEntry{LogType: database.LogTypeReject, RawLine: "reject 2"})
+
+	msg := readMessage(t, conn)
+	if msg.ID != 2 || msg.Data.(map[string]interface{})["raw_line"] != "reject 2" {
+		t.Fatalf("Expected only the reject entry with ID 2, got %+v", msg)
+	}
+	conn.Close()
+
+	// Entries logged while the client was away are replayed on resume
+	hub.BroadcastLogEntry(&database.LogEntry{LogType: database.LogTypeReject, RawLine: "reject 3"})
+	hub.BroadcastLogEntry(&database.LogEntry{LogType: database.LogTypeMain, RawLine: "main 4"})
+
+	conn = dial(t, hub, authorizer)
+	lastEventID := msg.ID
+	if err := conn.WriteJSON(Message{Type: "subscribe", Endpoint: LogTailEndpoint, Filter: rejects, LastEventID: &lastEventID}); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	subscribed := readMessage(t, conn).Data.(map[string]interface{})
+	if subscribed["replayed"] != float64(1) || subscribed["gap"] != false || subscribed["last_event_id"] != float64(4) {
+		t.Fatalf("Unexpected subscribe response: %+v", subscribed)
+	}
+
+	replay := readMessage(t, conn)
+	events, ok := replay.Data.([]interface{})
+	if replay.Type != "replay" || !ok || len(events) != 1 {
+		t.Fatalf("Expected one replayed event, got %+v", replay)
+	}
+	if event := events[0].(map[string]interface{}); event["id"] != float64(3) {
+		t.Errorf("Expected event 3 to be replayed, got %+v", event)
+	}
+
+	// Filters are validated
+	if err := conn.WriteJSON(Message{Type: "subscribe", Endpoint: LogTailEndpoint, Filter: &LogFilter{Regex: "("}}); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	if status := readMessage(t, conn).Data.(map[string]interface{})["status"]; status != "error" {
+		t.Errorf("Expected an invalid regex to be refused, got %v", status)
+	}
+}
@@ -5,6 +5,22 @@ import (
 	"log"
 	"sync"
 	"time"
+
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// Endpoints clients subscribe to and types of messages broadcast to every client. The
+// API server authorizes each of them separately.
+const (
+	LogTailEndpoint = "/api/v1/logs/tail"
+
+	// Message updates are published on MessageUpdatesPrefix + message ID + MessageUpdatesSuffix
+	MessageUpdatesPrefix = "/api/v1/messages/"
+	MessageUpdatesSuffix = "/updates"
+
+	QueueUpdateMessage     = "queue_update"
+	DashboardUpdateMessage = "dashboard_update"
+	SystemAlertMessage     = "system_alert"
 )
 
 // Service manages WebSocket connections and real-time updates
@@ -65,28 +81,28 @@ func (s *Service) GetHub() *Hub {
 
 // BroadcastQueueUpdate broadcasts queue update to all connected clients
 func (s *Service) BroadcastQueueUpdate(data interface{}) {
-	s.hub.BroadcastToAll("queue_update", data)
+	s.hub.BroadcastToAll(QueueUpdateMessage, data)
 }
 
 // BroadcastLogEntry broadcasts new log entry to subscribers
-func (s *Service) BroadcastLogEntry(entry interface{}) {
-	s.hub.BroadcastToSubscribers("/api/v1/logs/tail", entry)
+func (s *Service) BroadcastLogEntry(entry *database.LogEntry) {
+	s.hub.BroadcastLogEntry(entry)
 }
 
 // BroadcastDashboardUpdate broadcasts dashboard metrics update
 func (s *Service) BroadcastDashboardUpdate(metrics interface{}) {
-	s.hub.BroadcastToAll("dashboard_update", metrics)
+	s.hub.BroadcastToAll(DashboardUpdateMessage, metrics)
 }
 
 // BroadcastMessageUpdate broadcasts message-specific updates
 func (s *Service) BroadcastMessageUpdate(messageID string, data interface{}) {
-	endpoint := "/api/v1/messages/" + messageID + "/updates"
+	endpoint := MessageUpdatesPrefix + messageID + MessageUpdatesSuffix
 	s.hub.BroadcastToSubscribers(endpoint, data)
 }
 
 // BroadcastSystemAlert broadcasts system alerts
 func (s *Service) BroadcastSystemAlert(alert interface{}) {
-	s.hub.BroadcastToAll("system_alert", alert)
+	s.hub.BroadcastToAll(SystemAlertMessage, alert)
 }
 
 // GetStats returns WebSocket service statistics
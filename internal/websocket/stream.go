@@ -0,0 +1,69 @@
+package websocket
+
+import (
+	"github.com/andreitelteu/exim-pilot/internal/database"
+)
+
+// logTailBufferSize is the number of recent log tail events kept for clients that
+// resume after reconnecting
+const logTailBufferSize = 1000
+
+// streamEvent is a numbered log tail event
+type streamEvent struct {
+	ID    uint64             `json:"id"`
+	Entry *database.LogEntry `json:"data"`
+}
+
+// logStream numbers log tail events and keeps the newest ones in a ring buffer. IDs
+// start at 1 and restart with the process. Callers serialize access.
+type logStream struct {
+	lastID uint64
+	events []streamEvent
+	size   int
+
+	// Index of the oldest event once the buffer is full
+	next int
+}
+
+func newLogStream(size int) *logStream {
+	return &logStream{events: make([]streamEvent, 0, size), size: size}
+}
+
+// append numbers an entry, stores it and returns its ID
+func (s *logStream) append(entry *database.LogEntry) uint64 {
+	s.lastID++
+	event := streamEvent{ID: s.lastID, Entry: entry}
+
+	if len(s.events) < s.size {
+		s.events = append(s.events, event)
+	} else {
+		s.events[s.next] = event
+		s.next = (s.next + 1) % s.size
+	}
+	return s.lastID
+}
+
+// since returns the buffered events after lastID, oldest first. gap reports that some
+// events after lastID are no longer buffered, or that lastID was handed out before a
+// restart, in which case all buffered events are returned.
+func (s *logStream) since(lastID uint64) (events []streamEvent, gap bool) {
+	if lastID > s.lastID {
+		lastID, gap = 0, true
+	}
+
+	oldestID := s.lastID + 1
+	if len(s.events) > 0 {
+		oldestID = s.events[s.next].ID
+	}
+	if lastID+1 < oldestID {
+		gap = true
+	}
+
+	for i := range s.events {
+		event := s.events[(s.next+i)%len(s.events)]
+		if event.ID > lastID {
+			events = append(events, event)
+		}
+	}
+	return events, gap
+}
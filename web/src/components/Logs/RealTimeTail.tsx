@@ -57,7 +57,7 @@ const RealTimeTail: React.FC<RealTimeTailProps> = () => {
   }, [isPaused, filterLogEntry, maxLines]);
 
   const handleWebSocketMessage = useCallback((message: any) => {
-    if (message.type === 'log_entry' && message.data) {
+    if (message.type === 'subscription_update' && message.data) {
       addLogEntry(message.data);
     }
   }, [addLogEntry]);
@@ -1,7 +1,11 @@
 import { APIResponse } from '@/types/api';
 
+const SAFE_METHODS = ['GET', 'HEAD', 'OPTIONS'];
+
 class APIService {
   private baseURL: string;
+  // CSRF token of the current session, sent back on state-changing requests
+  private csrfToken: string | null = null;
 
   constructor(baseURL: string = '/api') {
     this.baseURL = baseURL;
@@ -12,18 +16,26 @@ class APIService {
     options: RequestInit = {}
   ): Promise<APIResponse<T>> {
     const url = `${this.baseURL}${endpoint}`;
-    
+    const method = (options.method || 'GET').toUpperCase();
+
     const config: RequestInit = {
+      credentials: 'include', // Always include cookies for authentication
+      ...options,
       headers: {
         'Content-Type': 'application/json',
+        ...(this.csrfToken && !SAFE_METHODS.includes(method) ? { 'X-CSRF-Token': this.csrfToken } : {}),
         ...options.headers,
       },
-      credentials: 'include', // Always include cookies for authentication
-      ...options,
     };
 
     try {
       const response = await fetch(url, config);
+
+      // The login and /auth/me responses carry the session's CSRF token
+      const csrfToken = response.headers.get('X-CSRF-Token');
+      if (csrfToken) {
+        this.csrfToken = csrfToken;
+      }
       
       // Check if response has content before trying to parse JSON
       const contentType = response.headers.get('content-type');
@@ -93,10 +105,14 @@ class APIService {
   }
 
   async logout(): Promise<APIResponse<any>> {
-    return this.request('/v1/auth/logout', {
-      method: 'POST',
-      credentials: 'include',
-    });
+    try {
+      return await this.request('/v1/auth/logout', {
+        method: 'POST',
+        credentials: 'include',
+      });
+    } finally {
+      this.csrfToken = null;
+    }
   }
 
   async getCurrentUser(): Promise<APIResponse<any>> {
@@ -1,6 +1,19 @@
 type WebSocketEventHandler = (data: any) => void;
 type ConnectionStatusCallback = (status: 'connected' | 'disconnected' | 'connecting') => void;
 
+// Server-side filter for the log tail subscription
+export interface LogTailFilter {
+  log_types?: string[];
+  events?: string[];
+  message_id?: string;
+  sender?: string; // glob, e.g. "*@example.com"
+  recipient?: string; // glob
+  host?: string; // glob
+  pid?: number;
+  keywords?: string[];
+  regex?: string;
+}
+
 class WebSocketService {
   private ws: WebSocket | null = null;
   private url: string;
@@ -9,6 +22,9 @@ class WebSocketService {
   private reconnectInterval = 1000;
   private eventHandlers: Map<string, WebSocketEventHandler[]> = new Map();
   private subscriptions: Map<string, WebSocketEventHandler[]> = new Map();
+  private subscriptionFilters: Map<string, LogTailFilter> = new Map();
+  // Last event ID seen per endpoint, sent when resubscribing after a reconnect
+  private lastEventIds: Map<string, number> = new Map();
   private connectionStatusCallback: ConnectionStatusCallback | null = null;
   private isConnecting = false;
   private reconnectTimeout: NodeJS.Timeout | null = null;
@@ -63,6 +79,7 @@ class WebSocketService {
           this.isConnecting = false;
           this.reconnectAttempts = 0;
           this.connectionStatusCallback?.('connected');
+          this.resubscribe();
           resolve();
         };
 
@@ -105,7 +122,16 @@ class WebSocketService {
     // Handle subscription-based messages
     if (endpoint) {
       const handlers = this.subscriptions.get(endpoint) || [];
-      handlers.forEach(handler => handler(message));
+      // Events missed while disconnected arrive together and are delivered one by one
+      const messages = type === 'replay'
+        ? (data || []).map((event: any) => ({ type: 'subscription_update', endpoint, id: event.id, data: event.data }))
+        : [message];
+      messages.forEach((m: any) => {
+        if (m.id) {
+          this.lastEventIds.set(endpoint, m.id);
+        }
+        handlers.forEach(handler => handler(m));
+      });
     } else {
       // Handle event-based messages
       const handlers = this.eventHandlers.get(type) || [];
@@ -191,17 +217,34 @@ class WebSocketService {
     return this.ws?.readyState === WebSocket.OPEN;
   }
 
-  // Subscription-based methods for endpoints like log tailing
-  subscribe(endpoint: string, handler: WebSocketEventHandler) {
+  // Subscription-based methods for endpoints like log tailing. The log tail accepts a
+  // server-side filter; subscribing again replaces it.
+  subscribe(endpoint: string, handler: WebSocketEventHandler, filter?: LogTailFilter) {
     if (!this.subscriptions.has(endpoint)) {
       this.subscriptions.set(endpoint, []);
     }
     this.subscriptions.get(endpoint)!.push(handler);
+    if (filter) {
+      this.subscriptionFilters.set(endpoint, filter);
+    }
 
     // Send subscription message to server
     this.send({
       type: 'subscribe',
-      endpoint: endpoint
+      endpoint: endpoint,
+      filter: this.subscriptionFilters.get(endpoint)
+    });
+  }
+
+  // Subscribes again after a reconnect, resuming from the last event seen
+  private resubscribe() {
+    this.subscriptions.forEach((_, endpoint) => {
+      this.send({
+        type: 'subscribe',
+        endpoint: endpoint,
+        filter: this.subscriptionFilters.get(endpoint),
+        last_event_id: this.lastEventIds.get(endpoint)
+      });
     });
   }
 
@@ -217,6 +260,8 @@ class WebSocketService {
         // If no more handlers, remove the subscription entirely
         if (handlers.length === 0) {
           this.subscriptions.delete(endpoint);
+          this.subscriptionFilters.delete(endpoint);
+          this.lastEventIds.delete(endpoint);
           this.send({
             type: 'unsubscribe',
             endpoint: endpoint
@@ -226,6 +271,8 @@ class WebSocketService {
     } else {
       // Remove all handlers for this endpoint
       this.subscriptions.delete(endpoint);
+      this.subscriptionFilters.delete(endpoint);
+      this.lastEventIds.delete(endpoint);
       this.send({
         type: 'unsubscribe',
         endpoint: endpoint